@@ -0,0 +1,230 @@
+// Package client 调用JsRpc服务http接口的Go客户端
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTimeout 浏览器端在服务端的超时时间内没有返回
+	ErrTimeout = errors.New("jsrpc: timeout")
+	// ErrNoClient 没有找到对应的group或clientId
+	ErrNoClient = errors.New("jsrpc: client not found")
+)
+
+const timeoutMsg = "黑脸怪：timeout"
+
+// APIError 服务端返回的其它错误
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("jsrpc: status %d: %s", e.StatusCode, e.Message)
+}
+
+// Client JsRpc服务的调用端
+type Client struct {
+	baseURL       string
+	httpClient    *http.Client
+	token         string
+	retry         int
+	retryInterval time.Duration
+}
+
+type Option func(*Client)
+
+// WithToken 设置管理类接口需要的AdminToken
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTimeout 设置单次http请求的超时时间，应该比服务端的DefaultTimeOut长
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.httpClient.Timeout = timeout }
+}
+
+// WithRetry 网络错误、5xx和没有客户端时重试，超时不重试
+func WithRetry(times int, interval time.Duration) Option {
+	return func(c *Client) { c.retry, c.retryInterval = times, interval }
+}
+
+// WithHTTPClient 使用自定义的http.Client
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Result 一次调用的结果
+type Result struct {
+	Group    string `json:"group"`
+	ClientId string `json:"clientId"`
+	Data     string `json:"data"` // 字符串结果原样返回，json结果是json文本
+}
+
+// Call 调用浏览器里注册的action，clientId为空时由服务端随机选择
+func (c *Client) Call(ctx context.Context, group, action, param string) (*Result, error) {
+	return c.CallClient(ctx, group, "", action, param)
+}
+
+// CallClient 调用指定clientId的action
+func (c *Client) CallClient(ctx context.Context, group, clientId, action, param string) (*Result, error) {
+	form := url.Values{"group": {group}, "action": {action}, "param": {param}}
+	if clientId != "" {
+		form.Set("clientId", clientId)
+	}
+	return c.call(ctx, "/go", form)
+}
+
+// Execjs 让浏览器执行一段js代码
+func (c *Client) Execjs(ctx context.Context, group, code string) (*Result, error) {
+	return c.ExecjsClient(ctx, group, "", code)
+}
+
+// ExecjsClient 让指定clientId的浏览器执行一段js代码
+func (c *Client) ExecjsClient(ctx context.Context, group, clientId, code string) (*Result, error) {
+	form := url.Values{"group": {group}, "code": {code}}
+	if clientId != "" {
+		form.Set("clientId", clientId)
+	}
+	return c.call(ctx, "/execjs", form)
+}
+
+// List 返回 group -> clientId列表
+func (c *Client) List(ctx context.Context) (map[string][]string, error) {
+	var data map[string][]string
+	err := c.get(ctx, "/list", &data)
+	return data, err
+}
+
+// ClientDetail /details里的客户端信息
+type ClientDetail struct {
+	ClientId    string `json:"clientId"`
+	ClientIp    string `json:"clientIp"`
+	PageUrl     string `json:"pageUrl"`
+	PageTitle   string `json:"pageTitle"`
+	Compression bool   `json:"compression"`
+	FailCount   int64  `json:"failCount"`
+}
+
+// Details 返回 group -> 客户端详细信息
+func (c *Client) Details(ctx context.Context) (map[string][]ClientDetail, error) {
+	var data map[string][]ClientDetail
+	err := c.get(ctx, "/details", &data)
+	return data, err
+}
+
+// response 兼容各个接口的返回格式，execjs的status是字符串、clientId放在name里
+type response struct {
+	Status   json.RawMessage `json:"status"`
+	Group    string          `json:"group"`
+	ClientId string          `json:"clientId"`
+	Name     string          `json:"name"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func (c *Client) call(ctx context.Context, path string, form url.Values) (*Result, error) {
+	resp, err := c.do(ctx, http.MethodPost, path, form)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Group: resp.Group, ClientId: resp.ClientId, Data: dataString(resp.Data)}
+	if result.ClientId == "" {
+		result.ClientId = resp.Name
+	}
+	if result.Data == timeoutMsg {
+		return result, ErrTimeout
+	}
+	return result, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.Data, v)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, form url.Values) (*response, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var resp *response
+		var retryable bool
+		resp, retryable, err = c.doOnce(ctx, method, path, form)
+		if err == nil || !retryable || attempt >= c.retry {
+			return resp, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.retryInterval):
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, path string, form url.Values) (*response, bool, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, false, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Admin-Token", c.token)
+	}
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer httpResp.Body.Close()
+	raw, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	var resp response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, httpResp.StatusCode >= 500, &APIError{StatusCode: httpResp.StatusCode, Message: string(raw)}
+	}
+	if httpResp.StatusCode >= 300 {
+		msg := dataString(resp.Data)
+		if strings.Contains(msg, "没有找到对应的group或clientId") {
+			return nil, true, ErrNoClient
+		}
+		return nil, httpResp.StatusCode >= 500, &APIError{StatusCode: httpResp.StatusCode, Message: msg}
+	}
+	return &resp, false, nil
+}
+
+// dataString data是字符串时去掉引号，其它json值原样返回
+func dataString(data json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return s
+	}
+	return string(data)
+}
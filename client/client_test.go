@@ -0,0 +1,172 @@
+package client
+
+import (
+	"JsRpc/config"
+	"JsRpc/core"
+	"JsRpc/mockclient"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// startServer 在随机端口启动JsRpc，返回服务和http地址
+func startServer(t *testing.T) (*core.Server, string) {
+	t.Helper()
+	s, err := core.NewServer(config.ConfStruct{
+		BasicListen:    config.ListenList{"127.0.0.1:0"},
+		DefaultTimeOut: 1,
+		CloseWebLog:    true,
+		Mode:           "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	return s, "http://" + s.ListenAddrs()["http"][0]
+}
+
+// runMock 用mockclient模拟浏览器接入，不等待注册
+func runMock(t *testing.T, baseURL string, group string, clientId string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = mockclient.Run(ctx, mockclient.Options{
+			Server:   baseURL,
+			Group:    group,
+			ClientId: clientId,
+			Actions: map[string]mockclient.Action{
+				"hello":   {Response: "hi {{param}}"},
+				"obj":     {Json: []byte(`{"a":1}`)},
+				"hang":    {NoReply: true},
+				"_execjs": {Response: "ran {{param}}"},
+			},
+		})
+	}()
+}
+
+// startMock 模拟浏览器接入并等到注册完成
+func startMock(t *testing.T, s *core.Server, baseURL string, group string, clientId string) {
+	t.Helper()
+	runMock(t, baseURL, group, clientId)
+	deadline := time.Now().Add(3 * time.Second)
+	for s.Client(group, clientId) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("mock client没有注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestCallAndExecjs(t *testing.T) {
+	s, baseURL := startServer(t)
+	startMock(t, s, baseURL, "g", "c1")
+	c := New(baseURL)
+	ctx := testContext(t)
+
+	res, err := c.Call(ctx, "g", "hello", "中文 & =")
+	if err != nil || res.Data != "hi 中文 & =" || res.Group != "g" || res.ClientId != "c1" {
+		t.Fatalf("Call = %+v, %v", res, err)
+	}
+	res, err = c.CallClient(ctx, "g", "c1", "obj", "")
+	if err != nil || res.Data != `{"a":1}` {
+		t.Fatalf("json结果应该是json文本，得到 %+v, %v", res, err)
+	}
+	res, err = c.Execjs(ctx, "g", "1+1")
+	if err != nil || res.Data != "ran 1+1" || res.ClientId != "c1" {
+		t.Fatalf("Execjs = %+v, %v", res, err)
+	}
+}
+
+func TestListAndDetails(t *testing.T) {
+	s, baseURL := startServer(t)
+	startMock(t, s, baseURL, "g", "c1")
+	c := New(baseURL)
+	list, err := c.List(testContext(t))
+	if err != nil || len(list["g"]) != 1 || list["g"][0] != "c1" {
+		t.Fatalf("List = %v, %v", list, err)
+	}
+	details, err := c.Details(testContext(t))
+	if err != nil || len(details["g"]) != 1 || details["g"][0].ClientId != "c1" || details["g"][0].ClientIp == "" {
+		t.Fatalf("Details = %+v, %v", details, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, baseURL := startServer(t)
+	startMock(t, s, baseURL, "g", "c1")
+	c := New(baseURL)
+	ctx := testContext(t)
+
+	if _, err := c.Call(ctx, "g", "hang", ""); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("客户端不返回时应该是ErrTimeout，得到 %v", err)
+	}
+	if _, err := c.Call(ctx, "none", "hello", ""); !errors.Is(err, ErrNoClient) {
+		t.Fatalf("group不存在时应该是ErrNoClient，得到 %v", err)
+	}
+	if _, err := c.CallClient(ctx, "g", "missing", "hello", ""); !errors.Is(err, ErrNoClient) {
+		t.Fatalf("clientId不存在时应该是ErrNoClient，得到 %v", err)
+	}
+	var apiErr *APIError
+	if _, err := c.Call(ctx, "", "hello", ""); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("缺少group时应该是400的APIError，得到 %v", err)
+	}
+}
+
+func TestRetryUntilClientConnects(t *testing.T) {
+	_, baseURL := startServer(t)
+	time.AfterFunc(200*time.Millisecond, func() { runMock(t, baseURL, "late", "c1") })
+	c := New(baseURL, WithRetry(30, 50*time.Millisecond))
+	res, err := c.Call(testContext(t), "late", "hello", "1")
+	if err != nil || res.Data != "hi 1" {
+		t.Fatalf("重试到客户端上线后应该成功，得到 %+v, %v", res, err)
+	}
+
+	start := time.Now()
+	if _, err := New(baseURL, WithRetry(3, 50*time.Millisecond)).Call(testContext(t), "late", "hang", ""); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("超时应该返回ErrTimeout，得到 %v", err)
+	}
+	if time.Since(start) > 1900*time.Millisecond {
+		t.Fatalf("超时不应该重试，用了%v", time.Since(start))
+	}
+}
+
+func TestTokenAndHttpTimeout(t *testing.T) {
+	var token string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Admin-Token")
+		if r.URL.Path == "/go" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte(`{"status":200,"data":{}}`))
+	}))
+	defer srv.Close()
+
+	if _, err := New(srv.URL, WithToken("secret")).List(testContext(t)); err != nil || token != "secret" {
+		t.Fatalf("没有带上token：%q, %v", token, err)
+	}
+	_, err := New(srv.URL, WithTimeout(50*time.Millisecond)).Call(testContext(t), "g", "hello", "")
+	if err == nil || errors.Is(err, ErrTimeout) {
+		t.Fatalf("http超时应该返回网络错误，得到 %v", err)
+	}
+}
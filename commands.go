@@ -0,0 +1,308 @@
+package main
+
+import (
+	"JsRpc/client"
+	"JsRpc/mockclient"
+	"JsRpc/protocol"
+	"JsRpc/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// subCommands 子命令，不带子命令时按原来的方式启动服务
+var subCommands = map[string]func(args []string) int{
+	"mock-client": runMockClient,
+	"selftest":    runSelfTest,
+	"call":        runCall,
+	"bench-codec": runBenchCodec,
+	"install":     serviceCommand("install", installService),
+	"uninstall":   serviceCommand("uninstall", uninstallService),
+	"start":       serviceCommand("start", startService),
+	"stop":        serviceCommand("stop", stopService),
+}
+
+func runMockClient(args []string) int {
+	fs := flag.NewFlagSet("mock-client", flag.ExitOnError)
+	configPath := fs.String("c", "", "mock客户端的json配置文件")
+	server := fs.String("server", "", "服务端地址，覆盖配置文件里的server")
+	group := fs.String("group", "", "覆盖配置文件里的group")
+	clientId := fs.String("client-id", "", "覆盖配置文件里的clientId")
+	_ = fs.Parse(args)
+
+	var opts mockclient.Options
+	if *configPath != "" {
+		var err error
+		if opts, err = mockclient.LoadOptions(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, "读取配置文件错误:", err)
+			return 1
+		}
+	}
+	if *server != "" {
+		opts.Server = *server
+	}
+	if opts.Server == "" {
+		opts.Server = "ws://127.0.0.1:12080/ws"
+	}
+	if *group != "" {
+		opts.Group = *group
+	}
+	if *clientId != "" {
+		opts.ClientId = *clientId
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := mockclient.Run(ctx, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func runSelfTest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:12080", "服务端http地址")
+	token := fs.String("token", "", "AdminToken")
+	skipTimeout := fs.Bool("skip-timeout", false, "跳过超时检查，超时检查要等待服务端的DefaultTimeOut")
+	_ = fs.Parse(args)
+
+	group, clientId := "_selftest_"+utils.GetUUID()[:8], "selftest"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockDone := make(chan struct{})
+	go func() {
+		defer close(mockDone)
+		_ = mockclient.Run(ctx, mockclient.Options{Server: *server, Group: group, ClientId: clientId,
+			Actions: map[string]mockclient.Action{
+				"echo":    {Echo: true},
+				"_execjs": {Response: "execjs ok"},
+				"hang":    {NoReply: true},
+			}})
+	}()
+	c := client.New(*server, client.WithToken(*token), client.WithTimeout(5*time.Minute))
+
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Println("[FAIL]", name+":", err)
+			return
+		}
+		fmt.Println("[PASS]", name)
+	}
+	check("客户端上线", waitClient(c, group, clientId, true))
+	check("/go 调用", func() error {
+		param := "自检 " + group
+		res, err := c.CallClient(ctx, group, clientId, "echo", param)
+		if err != nil {
+			return err
+		}
+		if res.Data != param {
+			return fmt.Errorf("返回内容不一致: %q", res.Data)
+		}
+		return nil
+	}())
+	check("/execjs 调用", func() error {
+		res, err := c.Execjs(ctx, group, "1+1")
+		if err != nil {
+			return err
+		}
+		if res.Data != "execjs ok" {
+			return fmt.Errorf("返回内容不一致: %q", res.Data)
+		}
+		return nil
+	}())
+	check("/details 客户端信息", func() error {
+		details, err := c.Details(ctx)
+		if err != nil {
+			return err
+		}
+		for _, d := range details[group] {
+			if d.ClientId == clientId {
+				return nil
+			}
+		}
+		return errors.New("没有找到自检客户端")
+	}())
+	if *skipTimeout {
+		fmt.Println("[SKIP] 超时调用")
+	} else {
+		check("超时调用", func() error {
+			_, err := c.CallClient(ctx, group, clientId, "hang", "")
+			if !errors.Is(err, client.ErrTimeout) {
+				return fmt.Errorf("期望超时，实际返回: %v", err)
+			}
+			return nil
+		}())
+	}
+	cancel()
+	<-mockDone
+	check("客户端下线", waitClient(c, group, clientId, false))
+
+	if failed > 0 {
+		fmt.Printf("自检失败，%d项未通过\n", failed)
+		return 1
+	}
+	fmt.Println("自检通过")
+	return 0
+}
+
+// waitClient 等待客户端出现在/list里或者从/list里消失
+func waitClient(c *client.Client, group, clientId string, online bool) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		var list map[string][]string
+		if list, err = c.List(context.Background()); err == nil {
+			found := false
+			for _, id := range list[group] {
+				found = found || id == clientId
+			}
+			if found == online {
+				return nil
+			}
+			err = errors.New("5秒内客户端状态没有变化")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// call子命令的退出码，方便在shell脚本里判断
+const (
+	exitCallError    = 1
+	exitCallTimeout  = 2
+	exitCallNoClient = 3
+)
+
+func runCall(args []string) int {
+	fs := flag.NewFlagSet("call", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:12080", "服务端http地址")
+	group := fs.String("group", "", "客户端分组")
+	clientId := fs.String("client-id", "", "指定客户端，不填随机选择")
+	action := fs.String("action", "", "调用的action")
+	param := fs.String("param", "", "参数，@文件名 从文件读取，- 从标准输入读取")
+	code := fs.String("code", "", "执行js代码的文件，- 从标准输入读取，指定后调用/execjs")
+	timeout := fs.Int("timeout", 60, "http请求超时秒数")
+	token := fs.String("token", "", "AdminToken")
+	asJson := fs.Bool("json", false, "输出完整的json结果")
+	_ = fs.Parse(args)
+
+	if *group == "" || (*action == "" && *code == "") {
+		fmt.Fprintln(os.Stderr, "需要 --group 以及 --action 或 --code")
+		fs.Usage()
+		return exitCallError
+	}
+	c := client.New(*server, client.WithToken(*token), client.WithTimeout(time.Duration(*timeout)*time.Second))
+	var res *client.Result
+	var err error
+	if *code != "" {
+		var js string
+		if js, err = readInput(*code); err == nil {
+			res, err = c.ExecjsClient(context.Background(), *group, *clientId, js)
+		}
+	} else {
+		p := *param
+		if p == "-" || strings.HasPrefix(p, "@") {
+			p, err = readInput(strings.TrimPrefix(p, "@"))
+		}
+		if err == nil {
+			res, err = c.CallClient(context.Background(), *group, *clientId, *action, p)
+		}
+	}
+	switch {
+	case errors.Is(err, client.ErrTimeout):
+		fmt.Fprintln(os.Stderr, "调用超时")
+		return exitCallTimeout
+	case errors.Is(err, client.ErrNoClient):
+		fmt.Fprintln(os.Stderr, "没有找到对应的group或clientId")
+		return exitCallNoClient
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return exitCallError
+	}
+	if *asJson {
+		data, _ := json.Marshal(res)
+		fmt.Println(string(data))
+	} else {
+		fmt.Println(res.Data)
+	}
+	return 0
+}
+
+// readInput 读取文件内容，- 表示标准输入
+func readInput(path string) (string, error) {
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		return string(data), err
+	}
+	data, err := os.ReadFile(path)
+	return string(data), err
+}
+
+// runBenchCodec 比较json和msgpack编解码同一个大返回的耗时和大小，决定是否开启Websocket.Msgpack；最后一行是开启Security.Hmac后签名和验证的耗时
+func runBenchCodec(args []string) int {
+	fs := flag.NewFlagSet("bench-codec", flag.ExitOnError)
+	size := fs.Int("size", 500, "返回内容的大小(KB)")
+	count := fs.Int("n", 200, "每种编码编解码的次数")
+	_ = fs.Parse(args)
+
+	// 模拟页面html，带引号、换行和中文，json需要转义
+	unit := "<div class=\"item\" data-id='1'>\n\t价格：¥12.50 & \"库存\"</div>\n"
+	payload := strings.Repeat(unit, *size*1024/len(unit)+1)[:*size*1024]
+	payload = strings.ToValidUTF8(payload, "") // 截断时可能切在中文中间，json会把它换成U+FFFD
+	env := protocol.Response(utils.GetUUID(), "getHtml", payload)
+	fmt.Printf("返回内容 %d 字节，每种编码 %d 次\n", len(payload), *count)
+	for _, encoding := range []string{protocol.EncodingJson, protocol.EncodingMsgpack} {
+		codec := protocol.CodecFor(protocol.VersionTyped, encoding)
+		decode := protocol.Decode
+		if encoding == protocol.EncodingMsgpack {
+			decode = protocol.DecodeMsgpack
+		}
+		var data []byte
+		var encodeTime, decodeTime time.Duration
+		for i := 0; i < *count; i++ {
+			start := time.Now()
+			var err error
+			if data, err = codec.Encode(env); err != nil {
+				fmt.Fprintln(os.Stderr, encoding, "编码失败:", err)
+				return 1
+			}
+			encodeTime += time.Since(start)
+			start = time.Now()
+			if got, err := decode(data); err != nil || got.Data != payload {
+				fmt.Fprintln(os.Stderr, encoding, "解码结果不一致:", err)
+				return 1
+			}
+			decodeTime += time.Since(start)
+		}
+		n := time.Duration(*count)
+		fmt.Printf("%-8s 编码 %10v/次  解码 %10v/次  传输 %d 字节\n", encoding, encodeTime/n, decodeTime/n, len(data))
+	}
+	// 配置了Security.Hmac时每条消息额外的签名和验证
+	secret := utils.GetUUID()
+	var signTime, verifyTime time.Duration
+	for i := 0; i < *count; i++ {
+		signed := env
+		start := time.Now()
+		signed.Sign(secret, start)
+		signTime += time.Since(start)
+		start = time.Now()
+		if err := signed.Verify(secret, start, time.Minute); err != nil {
+			fmt.Fprintln(os.Stderr, "签名验证失败:", err)
+			return 1
+		}
+		verifyTime += time.Since(start)
+	}
+	n := time.Duration(*count)
+	fmt.Printf("%-8s 签名 %10v/次  验证 %10v/次\n", "hmac", signTime/n, verifyTime/n)
+	return 0
+}
@@ -7,10 +7,14 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"os"
+	"path/filepath"
 )
 
 var DefaultTimeout = 30
 
+// Version 编译时可以通过 -ldflags "-X JsRpc/config.Version=xxx" 设置
+var Version = "dev"
+
 func ReadConf() ConfStruct {
 	var ConfigPath string
 	// 定义命令行参数-c，后面跟着的是默认值以及参数说明
@@ -19,18 +23,33 @@ func ReadConf() ConfStruct {
 	flag.Parse()
 
 	conf, err := initConf(ConfigPath)
+	conf.Source = ConfigSource{Path: ConfigPath, Loaded: err == nil}
+	if abs, absErr := filepath.Abs(ConfigPath); absErr == nil {
+		conf.Source.Path = abs
+	}
 	if err != nil {
-		log.Warning("读取配置文件错误，将使用默认配置运行。 ", err.Error())
+		conf.Source.Error = err.Error()
+		log.Warning("读取配置文件 ", conf.Source.Path, " 错误，将使用默认配置运行。 ", err.Error())
+	} else {
+		log.Info("已加载配置文件：", conf.Source.Path)
 	}
 	return conf
 }
 
+// ConfigSource 配置从哪里来，/version里展示
+type ConfigSource struct {
+	Path   string `json:"path"`            // 配置文件的绝对路径，作为库使用时为空
+	Loaded bool   `json:"loaded"`          // false表示使用的是默认配置
+	Error  string `json:"error,omitempty"` // 没有加载成功的原因
+}
+
 func initConf(path string) (ConfStruct, error) {
 	defaultConf := ConfStruct{
-		BasicListen: `:12080`,
+		BasicListen: ListenList{`:12080`},
 		HttpsServices: HttpsConfig{
 			IsEnable:    false,
 			HttpsListen: `:12443`,
+			HttpMode:    HttpModeServe,
 		},
 		DefaultTimeOut: DefaultTimeout,
 	}
@@ -38,36 +57,393 @@ func initConf(path string) (ConfStruct, error) {
 		return defaultConf, errors.New("config path not found")
 	}
 
-	file, _ := os.Open(path) // 因为上面已经判断了 文件是存在的 所以这里不用捕获错误
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-		}
-	}(file)
-	conf := ConfStruct{}
-	decoder := yaml.NewDecoder(file)
-	err := decoder.Decode(&conf)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return defaultConf, err
 	}
+	conf := defaultConf // 配置文件里没有写的字段保留默认值
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return defaultConf, err
+	}
+	for _, key := range unknownKeys(data) {
+		log.Warning("配置文件里有不认识的字段，检查是否拼写错误：", key)
+	}
 	DefaultTimeout = conf.DefaultTimeOut
 	return conf, nil
 }
 
 type ConfStruct struct {
-	BasicListen    string      `yaml:"BasicListen"`
-	HttpsServices  HttpsConfig `yaml:"HttpsServices"`
-	DefaultTimeOut int         `yaml:"DefaultTimeOut"`
-	CloseLog       bool        `yaml:"CloseLog"`
-	CloseWebLog    bool        `yaml:"CloseWebLog"`
-	Mode           string      `yaml:"Mode"`
-	Cors           bool        `yaml:"Cors"`
+	BasicListen ListenList `yaml:"BasicListen"`
+	// BasicListen里的地址 -> 这个地址提供的路由类别(client、caller、admin、dashboard)，没有配置的地址提供全部
+	ListenRoutes   map[string][]string `yaml:"ListenRoutes"`
+	HttpsServices  HttpsConfig         `yaml:"HttpsServices"`
+	DefaultTimeOut int                 `yaml:"DefaultTimeOut"`
+	ListenRetry    ListenRetryConfig   `yaml:"ListenRetry"`    // BasicListen的地址被占用时重试
+	FallbackListen ListenList          `yaml:"FallbackListen"` // 重试后仍然失败时依次改用这里没用过的地址
+	// /execjs带awaitPromise=true时超时时间是DefaultTimeOut的几倍，默认2
+	AwaitTimeoutMultiplier int        `yaml:"AwaitTimeoutMultiplier"`
+	CloseLog               bool       `yaml:"CloseLog"`
+	CloseWebLog            bool       `yaml:"CloseWebLog"`
+	Mode                   string     `yaml:"Mode"`
+	Cors                   CorsConfig `yaml:"Cors"`
+	// 反向代理部署时信任的代理地址(CIDR或IP)，只有来自这些地址的请求才会读取RemoteIPHeaders
+	TrustedProxies  []string `yaml:"TrustedProxies"`
+	RemoteIPHeaders []string `yaml:"RemoteIPHeaders"`
+	// 路由改名，原路径 -> 新路径，新路径为空表示禁用该路由
+	RouterReplace map[string]string `yaml:"RouterReplace"`
+	Security      SecurityConfig    `yaml:"Security"`
+	// 命名的代码片段，通过/snippet?name=xx调用，{{param}}会替换成转义后的参数
+	Snippets map[string]string `yaml:"Snippets"`
+	// 管理类接口的token，为空时不校验
+	AdminToken string `yaml:"AdminToken"`
+	// 只能管理部分group的token，token -> group列表，需要同时配置AdminToken
+	GroupAdminTokens map[string][]string `yaml:"GroupAdminTokens"`
+	Websocket        WebsocketConfig     `yaml:"Websocket"`
+	Poll             PollConfig          `yaml:"Poll"`
+	Grpc             GrpcConfig          `yaml:"Grpc"`
+	// 启用的内置hook，目前支持 logging
+	Hooks   []string      `yaml:"Hooks"`
+	History HistoryConfig `yaml:"History"`
+	Pending PendingConfig `yaml:"Pending"`
+	// 用固定数量的goroutine执行请求，默认不启用，每个请求直接在自己的goroutine里执行
+	WorkerPool WorkerPoolConfig `yaml:"WorkerPool"`
+	Routing    RoutingConfig    `yaml:"Routing"`
+	Throttle   ThrottleConfig   `yaml:"Throttle"`
+	Limits     LimitsConfig     `yaml:"Limits"`
+	Bans       BansConfig       `yaml:"Bans"`
+	Events     EventsConfig     `yaml:"Events"`
+	Schedules  []ScheduleConfig `yaml:"Schedules"`
+	Template   TemplateConfig   `yaml:"Template"`
+	Sessions   SessionsConfig   `yaml:"Sessions"`
+	// 多租户隔离，客户端和调用方只能看到同一个namespace的客户端
+	Namespaces []NamespaceConfig `yaml:"Namespaces"`
+	Readyz     ReadyzConfig      `yaml:"Readyz"`
+	Debug      DebugConfig       `yaml:"Debug"`
+	Gzip       GzipConfig        `yaml:"Gzip"`
+	AccessLog  AccessLogConfig   `yaml:"AccessLog"`
+	// 发生panic时把堆栈、请求信息和版本POST到这个地址
+	PanicWebhook string        `yaml:"PanicWebhook"`
+	Log          LogConfig     `yaml:"Log"`
+	Restart      RestartConfig `yaml:"Restart"`
+	Source       ConfigSource  `yaml:"-"` // ReadConf填写，不在配置文件里
+}
+
+// NamespaceConfig 客户端用ClientTokens里的token连接(token参数)，调用方用ApiKeys里的key调用(X-Api-Key头或apiKey参数)
+// 没有token/key的客户端和调用方在默认namespace里
+type NamespaceConfig struct {
+	Name         string   `yaml:"Name"`
+	ClientTokens []string `yaml:"ClientTokens"`
+	ApiKeys      []string `yaml:"ApiKeys"`
+}
+
+// LogConfig 日志里参数、代码和返回内容的记录方式
+type LogConfig struct {
+	// 参数和返回最多记录多少个字符，不配置时是100，0不记录内容
+	PreviewLength *int `yaml:"PreviewLength"`
+	// 不配置时是true，false时任何日志里都不记录参数和返回的内容，适合有合规要求的部署
+	LogBodies *bool `yaml:"LogBodies"`
+	// 日志写到这个文件而不是终端，作为windows服务运行时不配置也会写到程序目录下的jsrpc.log
+	File string `yaml:"File"`
+}
+
+// AccessLogConfig 代替gin默认日志的访问日志，带上group、action等字段
+type AccessLogConfig struct {
+	IsEnable bool `yaml:"IsEnable"`
+	// json、text(默认)或者模板，模板里可以用{method} {path} {status} {latency} {ip} {size} {group} {action}
+	Format     string   `yaml:"Format"`
+	SampleRate float64  `yaml:"SampleRate"` // 0-1之间的抽样比例，0或1全部记录，5xx总是记录
+	Exclude    []string `yaml:"Exclude"`    // 不记录的路径，支持以*结尾的前缀
+}
+
+// GzipConfig 调用方支持时用gzip压缩http返回，/details、/page/html这类大的返回能小很多
+type GzipConfig struct {
+	IsEnable     bool     `yaml:"IsEnable"`
+	MinSize      int      `yaml:"MinSize"`      // 小于这么多字节的返回不压缩，默认1024
+	ContentTypes []string `yaml:"ContentTypes"` // 压缩哪些Content-Type，默认json、js、html、纯文本和csv
+}
+
+// DebugConfig 排查内存和goroutine问题用的/debug/pprof和/debug/stats，默认关闭
+type DebugConfig struct {
+	Pprof     bool `yaml:"Pprof"`
+	LocalOnly bool `yaml:"LocalOnly"` // 只允许本机访问，没有配置AdminToken时总是只允许本机
+}
+
+// RestartConfig 收到SIGUSR2时启动新进程接管监听，旧进程排空请求后通知客户端重连再退出
+type RestartConfig struct {
+	DrainTimeout    int `yaml:"DrainTimeout"`    // 最多等待正在执行的请求多少秒，默认30
+	ReconnectSpread int `yaml:"ReconnectSpread"` // 在多少秒内分批通知客户端重连，默认5
+}
+
+// ReadyzConfig /readyz?probe=true 通过客户端执行一次1+1，确认页面里的js还能正常响应
+type ReadyzConfig struct {
+	ProbeTimeout  int `yaml:"ProbeTimeout"`  // 探测的超时秒数，默认3
+	ProbeInterval int `yaml:"ProbeInterval"` // 同一个group两次探测的最小间隔秒数，间隔内返回上次的结果，默认5
+}
+
+// SessionsConfig 请求带session参数时固定发给同一个客户端
+type SessionsConfig struct {
+	TTL        int    `yaml:"TTL"`        // 多少秒没用过就删除绑定，默认600
+	MaxEntries int    `yaml:"MaxEntries"` // 最多保存的绑定数，满了删除最久没用的，默认10000
+	OnLost     string `yaml:"OnLost"`     // 绑定的客户端断开后：repin重新选择(默认)，error返回409
+}
+
+// TemplateConfig 参数里的服务端占位符，开启后/go的param和/execjs的code会在发送前展开
+type TemplateConfig struct {
+	IsEnable bool     `yaml:"IsEnable"`
+	EnvAllow []string `yaml:"EnvAllow"` // {{env:FOO}}允许读取的环境变量
+}
+
+// ScheduleConfig 定时调用客户端的方法，结果通过/schedules查看
+type ScheduleConfig struct {
+	Name      string `yaml:"Name"`
+	Namespace string `yaml:"Namespace"` // 配置了Namespaces时在哪个namespace里选择客户端，默认为空
+	Group     string `yaml:"Group"`
+	ClientId  string `yaml:"ClientId"` // 为空时和普通请求一样随机选择
+	Action    string `yaml:"Action"`
+	Code      string `yaml:"Code"` // 执行js代码，和Action二选一
+	Param     string `yaml:"Param"`
+	Interval  int    `yaml:"Interval"` // 执行间隔秒数
+	Timeout   int    `yaml:"Timeout"`  // 超时秒数，默认使用DefaultTimeOut
+}
+
+// EventsConfig 页面通过_event主动推送的事件
+type EventsConfig struct {
+	IsEnable  bool     `yaml:"IsEnable"`
+	Groups    []string `yaml:"Groups"`    // 只接收这些group的事件，为空时全部接收
+	QueueSize int      `yaml:"QueueSize"` // 每个客户端保留的事件数，默认100
+	Retention int      `yaml:"Retention"` // 事件保留的秒数，默认600
+}
+
+// BansConfig ip黑名单和自动封禁
+type BansConfig struct {
+	Deny            []string `yaml:"Deny"`            // 静态黑名单，支持CIDR和单个ip
+	MaxMalformed    int      `yaml:"MaxMalformed"`    // Window内发送多少条格式错误的消息后封禁，0不封禁
+	MaxAuthFailures int      `yaml:"MaxAuthFailures"` // Window内adminToken错误多少次后封禁，0不封禁
+	Window          int      `yaml:"Window"`          // 统计窗口秒数，默认60
+	Duration        int      `yaml:"Duration"`        // 封禁秒数，默认600
+	Path            string   `yaml:"Path"`            // 封禁列表保存的json文件，为空时只在内存里
+	AdminAllow      []string `yaml:"AdminAllow"`      // 被封禁后还能访问/bans解除封禁的ip，支持CIDR，本机总是可以
+}
+
+// LimitsConfig 客户端连接数上限，超过时拒绝新连接，0不限制
+type LimitsConfig struct {
+	MaxClientsPerGroup int `yaml:"MaxClientsPerGroup"`
+	MaxClientsTotal    int `yaml:"MaxClientsTotal"`
+	// 请求体的字节数上限，默认10MB，负数不限制
+	MaxBodySize int64 `yaml:"MaxBodySize"`
+	// code和param的长度上限，0不限制
+	MaxCodeLength  int `yaml:"MaxCodeLength"`
+	MaxParamLength int `yaml:"MaxParamLength"`
+}
+
+// ThrottleConfig 限制发给每个客户端的qps，页面有频率检测时使用
+type ThrottleConfig struct {
+	MaxQps  float64            `yaml:"MaxQps"`  // 每个客户端的qps，0不限制
+	Groups  map[string]float64 `yaml:"Groups"`  // 按group单独设置，覆盖MaxQps
+	MaxWait int                `yaml:"MaxWait"` // 超过qps时最多等待的毫秒数，再久就返回429，默认1000
+}
+
+// 客户端上报忙碌时的处理方式
+const (
+	BusyModeWeight = "weight" // 按负载加权随机，忙碌的客户端很少被选中
+	BusyModeSkip   = "skip"   // 跳过忙碌的客户端，全都忙时不跳过
+)
+
+// RoutingConfig 没有指定clientId时怎么选择客户端
+type RoutingConfig struct {
+	BusyMode     string `yaml:"BusyMode"`     // weight(默认)或skip
+	StatusMaxAge int    `yaml:"StatusMaxAge"` // 客户端上报的状态多少秒后失效，默认30
+	// 请求带queueIfEmpty时，每个group最多有多少个请求在等待客户端上线，默认100
+	EmptyQueueSize int `yaml:"EmptyQueueSize"`
+	// group不存在时在返回的groups里列出同一namespace里现有的group，方便排查拼错的group名，但会暴露其它group的名字
+	ExposeGroups bool `yaml:"ExposeGroups"`
+	// 这些group(可以是通配符)里，客户端上报过方法列表但没有注册请求的action时直接返回404，不用等到超时
+	RejectUnregisteredActions []string `yaml:"RejectUnregisteredActions"`
+}
+
+// WorkerPoolConfig 工作池配置，队列满时接口直接返回503
+type WorkerPoolConfig struct {
+	Size      int `yaml:"Size"`      // 工作goroutine数量，0不启用
+	QueueSize int `yaml:"QueueSize"` // 排队的任务数上限，0为Size的10倍
+}
+
+// PendingConfig 每个客户端同时等待返回的请求数上限，超过时直接返回429
+type PendingConfig struct {
+	MaxPerClient int            `yaml:"MaxPerClient"` // 0不限制
+	Groups       map[string]int `yaml:"Groups"`       // 按group单独设置，覆盖MaxPerClient
+	// 同时发给一个客户端的请求数，多出来的按priority排队，0不限制
+	MaxConcurrent int `yaml:"MaxConcurrent"`
+	AgingSeconds  int `yaml:"AgingSeconds"` // 排队每过多少秒提升一级优先级，默认10
+}
+
+// HistoryConfig 内存里保留的最近请求记录，通过/history查看
+type HistoryConfig struct {
+	Disable    bool `yaml:"Disable"`
+	Size       int  `yaml:"Size"`       // 全局保留的条数，默认1000
+	ClientSize int  `yaml:"ClientSize"` // 每个客户端保留的条数，默认100
+	// 持久化到sqlite，启用后/history从数据库查询
+	Sqlite SqliteConfig `yaml:"Sqlite"`
+}
+
+type SqliteConfig struct {
+	IsEnable      bool   `yaml:"IsEnable"`
+	Path          string `yaml:"Path"`
+	RetentionDays int    `yaml:"RetentionDays"` // 保留天数，默认7天
+	QueueSize     int    `yaml:"QueueSize"`     // 等待写入的记录数上限，超过时丢弃，默认10000
+}
+
+// GrpcConfig gRPC服务，和http使用不同的监听地址
+type GrpcConfig struct {
+	IsEnable bool   `yaml:"IsEnable"`
+	Listen   string `yaml:"Listen"`
+}
+
+// PollConfig 不能使用ws的客户端改用http长轮询
+type PollConfig struct {
+	IdleTimeout int `yaml:"IdleTimeout"` // 多少秒没有拉取就视为下线，默认60
 }
 
+// WebsocketConfig 客户端ws连接相关配置
+type WebsocketConfig struct {
+	ChunkMaxSize int `yaml:"ChunkMaxSize"` // 分片消息重组后的大小上限(字节)
+	ChunkTimeout int `yaml:"ChunkTimeout"` // 分片多少秒没收齐就丢弃
+	// 开启permessage-deflate压缩，cookie、html这类文本能压缩好几倍，代价是两端多花一些cpu
+	EnableCompression bool `yaml:"EnableCompression"`
+	// 关闭/wst回显测试接口，默认开启
+	DisableTest     bool `yaml:"DisableTest"`
+	ReadBufferSize  int  `yaml:"ReadBufferSize"`  // 读缓冲区字节数，0使用默认值
+	WriteBufferSize int  `yaml:"WriteBufferSize"` // 写缓冲区字节数，0使用默认值
+	// 请求头和ws握手必须在多少秒内完成，防止只建连接不发完请求的慢速攻击占住连接，0不限制
+	HandshakeTimeout int `yaml:"HandshakeTimeout"`
+	// 单条ws消息的大小上限(字节)，超过时断开连接；http接口的param/code超过时返回413，0不限制
+	MaxMessageSize int64 `yaml:"MaxMessageSize"`
+	// /ws/caller每个调用端连接最多同时等待的请求数，超过时直接返回429，默认100
+	CallerMaxPending int `yaml:"CallerMaxPending"`
+	// 客户端_hello上报的协议版本不兼容时断开连接，默认只记录日志
+	RejectProtocolMismatch bool `yaml:"RejectProtocolMismatch"`
+	// ws断开后保留客户端多少秒等待重连，期间带resendOnReconnect的请求不会失败，重连后重新发送；0断开后直接下线
+	ReconnectGrace int `yaml:"ReconnectGrace"`
+	// 等待重连期间，指定clientId的请求最多缓存多少个，重连后发送，超过时返回503；0不缓存
+	OfflineQueueSize int `yaml:"OfflineQueueSize"`
+	// 每隔多少秒ping一次ws客户端，/details里显示最近的ping/pong，0使用默认30秒，负数不发送
+	PingInterval int `yaml:"PingInterval"`
+	// 允许客户端在_hello里请求msgpack编码，协商后用二进制帧发送，大参数和返回的编解码更快
+	Msgpack bool `yaml:"Msgpack"`
+	// 客户端流式返回多个部分而调用方没有要求stream时怎么合并：concat按顺序拼接(默认)，last只用最后一部分
+	StreamJoin string `yaml:"StreamJoin"`
+}
+
+// 流式返回给非流式调用方时的合并方式
+const (
+	StreamJoinConcat = "concat"
+	StreamJoinLast   = "last"
+)
+
+// SecurityConfig 限制调用端能让浏览器执行的内容
+type SecurityConfig struct {
+	DisableExecjs  bool                `yaml:"DisableExecjs"`  // 禁用/execjs，page接口改用客户端内置的方法
+	AllowedActions map[string][]string `yaml:"AllowedActions"` // group -> 允许调用的action，没配置的group不限制
+	// /go、/page/cookie、/list支持callback参数返回JSONP，只在必须用script标签请求时开启
+	EnableJsonp bool       `yaml:"EnableJsonp"`
+	Hmac        HmacConfig `yaml:"Hmac"`
+}
+
+// HmacConfig 服务端和客户端之间的消息签名，页面所在的网络不可信时用来发现被篡改的消息
+type HmacConfig struct {
+	Secrets     map[string]string `yaml:"Secrets"`     // group -> 共享密钥，没有配置的group不签名
+	MaxSkew     int               `yaml:"MaxSkew"`     // 签名时间戳允许的偏差秒数，超过的当作重放，默认30
+	MaxFailures int               `yaml:"MaxFailures"` // Bans.Window内验证失败多少次后断开并封禁ip，默认3
+}
+
+// ListenRoutes里的路由类别
+const (
+	RouteClient    = "client"    // 浏览器客户端连接：/ws、/poll/*、/jsrpc.js等
+	RouteCaller    = "caller"    // 调用方接口：/go、/execjs、/pipeline等
+	RouteAdmin     = "admin"     // 管理接口：/navigate、/bans、/admin/settings、/debug等
+	RouteDashboard = "dashboard" // 查看状态：/list、/details、/history、/events、/version等
+)
+
+// 启用https后，明文http监听的处理方式
+const (
+	HttpModeServe    = "serve"    // 正常提供全部接口
+	HttpModeRedirect = "redirect" // 普通请求跳转到https，ws升级请求不跳转
+	HttpModeHealthz  = "healthz"  // 只提供/healthz
+)
+
 // HttpsConfig 代表HTTPS相关配置的结构体
 type HttpsConfig struct {
-	IsEnable    bool   `yaml:"IsEnable"`
-	HttpsListen string `yaml:"HttpsListen"`
-	PemPath     string `yaml:"PemPath"`
-	KeyPath     string `yaml:"KeyPath"`
+	IsEnable    bool           `yaml:"IsEnable"`
+	HttpsListen string         `yaml:"HttpsListen"`
+	PemPath     string         `yaml:"PemPath"`
+	KeyPath     string         `yaml:"KeyPath"`
+	HttpMode    string         `yaml:"HttpMode"`
+	AutoCert    AutoCertConfig `yaml:"AutoCert"`
+}
+
+// AutoCertConfig 通过Let's Encrypt自动申请证书，启用后忽略PemPath/KeyPath
+type AutoCertConfig struct {
+	IsEnable bool     `yaml:"IsEnable"`
+	Domains  []string `yaml:"Domains"`
+	CacheDir string   `yaml:"CacheDir"`
+}
+
+// ListenRetryConfig 监听失败时的重试，Attempts为0或1时只尝试一次
+type ListenRetryConfig struct {
+	Attempts int `yaml:"Attempts"` // 每个地址最多尝试的次数
+	Interval int `yaml:"Interval"` // 两次尝试间隔的秒数，默认1
+}
+
+// ListenList 明文http的监听地址，可以写一个地址或者地址列表，每个地址一个http服务，共用同一套路由
+type ListenList []string
+
+func (l *ListenList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var addr string
+		if err := value.Decode(&addr); err != nil {
+			return err
+		}
+		*l = ListenList{addr}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// CorsConfig 跨域配置，兼容旧版的 Cors: true 写法(等同于允许所有来源)
+type CorsConfig struct {
+	IsEnable         bool     `yaml:"IsEnable"`
+	AllowOrigins     []string `yaml:"AllowOrigins"` // 支持完整匹配和通配符，如 https://*.example.com，"*"表示全部
+	AllowMethods     []string `yaml:"AllowMethods"`
+	AllowHeaders     []string `yaml:"AllowHeaders"`
+	AllowCredentials bool     `yaml:"AllowCredentials"`
+	MaxAge           int      `yaml:"MaxAge"` // 预检请求缓存秒数，0不设置
+}
+
+func (c *CorsConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var enable bool
+		if err := value.Decode(&enable); err != nil {
+			return err
+		}
+		*c = CorsConfig{}
+		if enable {
+			*c = CorsConfig{
+				IsEnable:         true,
+				AllowOrigins:     []string{"*"},
+				AllowCredentials: true,
+			}
+		}
+		return nil
+	}
+	type plain CorsConfig
+	conf := plain{IsEnable: true} // 写了详细配置的默认就是启用
+	if err := value.Decode(&conf); err != nil {
+		return err
+	}
+	*c = CorsConfig(conf)
+	return nil
 }
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IsGroupPattern group参数是~开头的正则或者带*?[的通配符
+func IsGroupPattern(group string) bool {
+	return strings.HasPrefix(group, "~") || strings.ContainsAny(group, "*?[")
+}
+
+// MatchGroup 返回判断group是否匹配的函数，没有通配符时和原来一样完全匹配。
+// 接口的group参数和配置里的group列表都按这个规则解析
+func MatchGroup(pattern string) (func(group string) bool, error) {
+	if !IsGroupPattern(pattern) {
+		return func(group string) bool { return group == pattern }, nil
+	}
+	if expr, ok := strings.CutPrefix(pattern, "~"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("group正则错误：%v", err)
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("group通配符错误：%s", pattern)
+	}
+	return func(group string) bool {
+		ok, _ := path.Match(pattern, group)
+		return ok
+	}, nil
+}
+
+// CheckGroupPattern group是通配符或正则时检查格式
+func CheckGroupPattern(group string) error {
+	_, err := MatchGroup(group)
+	return err
+}
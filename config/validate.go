@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const maxTimeoutSeconds = 3600
+
+const maxListenAttempts = 100
+
+const (
+	DefaultAwaitTimeoutMultiplier = 2
+	maxAwaitTimeoutMultiplier     = 10
+)
+
+// Validate 启动前检查配置，一次返回所有问题，每条以字段路径开头
+func (c *ConfStruct) Validate() error {
+	var errs []error
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	listen := func(field, addr string) {
+		if err := checkListenAddr(addr); err != nil {
+			add(field, "%v", err)
+		}
+	}
+
+	if len(c.BasicListen) == 0 {
+		add("BasicListen", "不能为空")
+	}
+	for i, addr := range c.BasicListen {
+		field := "BasicListen[" + strconv.Itoa(i) + "]"
+		listen(field, addr)
+		for _, other := range c.BasicListen[:i] {
+			if sameListenPort(other, addr) {
+				add(field, "和%q使用了同一个端口", other)
+			}
+		}
+	}
+	for i, addr := range c.FallbackListen {
+		field := "FallbackListen[" + strconv.Itoa(i) + "]"
+		listen(field, addr)
+		if c.listensOn(addr) {
+			add(field, "和BasicListen使用了同一个端口%q", addr)
+		}
+	}
+	if c.ListenRetry.Attempts < 0 || c.ListenRetry.Attempts > maxListenAttempts {
+		add("ListenRetry.Attempts", "需要在0-%d之间，当前为%d", maxListenAttempts, c.ListenRetry.Attempts)
+	}
+	addrs := make([]string, 0, len(c.ListenRoutes))
+	for addr := range c.ListenRoutes {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs) // 错误按固定顺序输出
+	for _, addr := range addrs {
+		field := "ListenRoutes[" + addr + "]"
+		categories := c.ListenRoutes[addr]
+		if !slices.Contains(c.BasicListen, addr) {
+			add(field, "地址不在BasicListen里")
+		}
+		for _, category := range categories {
+			switch category {
+			case RouteClient, RouteCaller, RouteAdmin, RouteDashboard:
+			default:
+				add(field, "路由类别只能是client、caller、admin或dashboard，当前为%q", category)
+			}
+		}
+	}
+	switch c.Mode {
+	case "", "release", "debug", "test":
+	default:
+		add("Mode", "只能是release、debug或test，当前为%q", c.Mode)
+	}
+	if c.DefaultTimeOut <= 0 || c.DefaultTimeOut > maxTimeoutSeconds {
+		add("DefaultTimeOut", "需要在1-%d秒之间，当前为%d(为0时所有请求都会立即超时)", maxTimeoutSeconds, c.DefaultTimeOut)
+	}
+	if c.AwaitTimeoutMultiplier < 0 || c.AwaitTimeoutMultiplier > maxAwaitTimeoutMultiplier {
+		add("AwaitTimeoutMultiplier", "需要在0-%d之间，0表示默认的%d倍，当前为%d", maxAwaitTimeoutMultiplier, DefaultAwaitTimeoutMultiplier, c.AwaitTimeoutMultiplier)
+	}
+
+	if https := c.HttpsServices; https.IsEnable {
+		listen("HttpsServices.HttpsListen", https.HttpsListen)
+		if c.listensOn(https.HttpsListen) {
+			add("HttpsServices.HttpsListen", "和BasicListen使用了同一个端口%q", https.HttpsListen)
+		}
+		switch https.HttpMode {
+		case "", HttpModeServe, HttpModeRedirect, HttpModeHealthz:
+		default:
+			add("HttpsServices.HttpMode", "只能是serve、redirect或healthz，当前为%q", https.HttpMode)
+		}
+		// 启用AutoCert时忽略PemPath/KeyPath，默认配置里两者都有值，启动时只打印警告
+		if !https.AutoCert.IsEnable {
+			if https.PemPath == "" || https.KeyPath == "" {
+				add("HttpsServices.PemPath", "启用https需要配置PemPath和KeyPath，或者启用AutoCert")
+			} else if _, err := tls.LoadX509KeyPair(https.PemPath, https.KeyPath); err != nil {
+				add("HttpsServices.PemPath", "证书加载失败：%v", err)
+			}
+		}
+	}
+	if c.Grpc.IsEnable {
+		listen("Grpc.Listen", c.Grpc.Listen)
+		if c.listensOn(c.Grpc.Listen) ||
+			c.HttpsServices.IsEnable && sameListenPort(c.HttpsServices.HttpsListen, c.Grpc.Listen) {
+			add("Grpc.Listen", "和http/https使用了同一个端口%q", c.Grpc.Listen)
+		}
+	}
+
+	switch c.Routing.BusyMode {
+	case "", BusyModeWeight, BusyModeSkip:
+	default:
+		add("Routing.BusyMode", "只能是weight或skip，当前为%q", c.Routing.BusyMode)
+	}
+	for i, group := range c.Routing.RejectUnregisteredActions {
+		field := "Routing.RejectUnregisteredActions[" + strconv.Itoa(i) + "]"
+		if group == "" {
+			add(field, "不能为空")
+		} else if err := CheckGroupPattern(group); err != nil {
+			add(field, "%v", err)
+		}
+	}
+	switch c.Websocket.StreamJoin {
+	case "", StreamJoinConcat, StreamJoinLast:
+	default:
+		add("Websocket.StreamJoin", "只能是concat或last，当前为%q", c.Websocket.StreamJoin)
+	}
+	seconds := []struct {
+		field string
+		value int
+	}{
+		{"Routing.StatusMaxAge", c.Routing.StatusMaxAge},
+		{"Readyz.ProbeTimeout", c.Readyz.ProbeTimeout},
+		{"Readyz.ProbeInterval", c.Readyz.ProbeInterval},
+		{"Restart.DrainTimeout", c.Restart.DrainTimeout},
+		{"Restart.ReconnectSpread", c.Restart.ReconnectSpread},
+		{"Poll.IdleTimeout", c.Poll.IdleTimeout},
+		{"ListenRetry.Interval", c.ListenRetry.Interval},
+		{"Websocket.HandshakeTimeout", c.Websocket.HandshakeTimeout},
+		{"Security.Hmac.MaxSkew", c.Security.Hmac.MaxSkew},
+	}
+	for _, item := range seconds {
+		if item.value < 0 || item.value > maxTimeoutSeconds {
+			add(item.field, "需要在0-%d秒之间，当前为%d", maxTimeoutSeconds, item.value)
+		}
+	}
+	if c.Websocket.PingInterval > maxTimeoutSeconds { // 负数表示不ping
+		add("Websocket.PingInterval", "不能超过%d秒，当前为%d", maxTimeoutSeconds, c.Websocket.PingInterval)
+	}
+	groups := make([]string, 0, len(c.Security.Hmac.Secrets))
+	for group := range c.Security.Hmac.Secrets {
+		groups = append(groups, group)
+	}
+	slices.Sort(groups)
+	for _, group := range groups {
+		if c.Security.Hmac.Secrets[group] == "" {
+			add("Security.Hmac.Secrets["+group+"]", "密钥不能为空")
+		}
+	}
+	if c.Security.Hmac.MaxFailures < 0 {
+		add("Security.Hmac.MaxFailures", "不能小于0")
+	}
+	if c.AccessLog.SampleRate < 0 || c.AccessLog.SampleRate > 1 {
+		add("AccessLog.SampleRate", "需要在0-1之间，当前为%v", c.AccessLog.SampleRate)
+	}
+	if c.Log.PreviewLength != nil && *c.Log.PreviewLength < 0 {
+		add("Log.PreviewLength", "不能小于0")
+	}
+	for i, sc := range c.Schedules {
+		if sc.Action != "" && sc.Code != "" {
+			add("Schedules["+strconv.Itoa(i)+"]", "Action和Code只能配置一个")
+		}
+		if sc.Timeout < 0 || sc.Timeout > maxTimeoutSeconds {
+			add("Schedules["+strconv.Itoa(i)+"].Timeout", "需要在0-%d秒之间，当前为%d", maxTimeoutSeconds, sc.Timeout)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkListenAddr 检查 host:port 格式，host可以为空
+func checkListenAddr(addr string) error {
+	if addr == "" {
+		return errors.New("不能为空")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("格式应该是host:port或:port，当前为%q", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("端口%q无效", port)
+	}
+	if strings.ContainsAny(host, " /") {
+		return fmt.Errorf("地址%q无效", host)
+	}
+	return nil
+}
+
+// listensOn BasicListen里有地址和addr冲突
+func (c *ConfStruct) listensOn(addr string) bool {
+	for _, basic := range c.BasicListen {
+		if sameListenPort(basic, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameListenPort 两个地址监听同一个端口，并且地址相同或者有一个监听全部地址
+func sameListenPort(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB || portA == "0" {
+		return false
+	}
+	wildcard := func(h string) bool { return h == "" || h == "0.0.0.0" || h == "::" }
+	return hostA == hostB || wildcard(hostA) || wildcard(hostB)
+}
+
+// unknownKeys 配置文件里结构体没有的字段，通常是拼错了
+func unknownKeys(data []byte) []string {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var conf ConfStruct
+	var typeErr *yaml.TypeError
+	if err := decoder.Decode(&conf); !errors.As(err, &typeErr) {
+		return nil
+	}
+	var keys []string
+	for _, msg := range typeErr.Errors {
+		if strings.Contains(msg, "not found in type") {
+			keys = append(keys, msg)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validConf 能通过检查的最小配置
+func validConf() ConfStruct {
+	return ConfStruct{
+		BasicListen:    ListenList{":12080"},
+		DefaultTimeOut: 30,
+		HttpsServices:  HttpsConfig{HttpsListen: ":12443", HttpMode: HttpModeServe},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	garbage := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(garbage, []byte("not a pem"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	https := func(pem, key string) func(c *ConfStruct) {
+		return func(c *ConfStruct) {
+			c.HttpsServices.IsEnable = true
+			c.HttpsServices.PemPath, c.HttpsServices.KeyPath = pem, key
+		}
+	}
+
+	tests := []struct {
+		name string
+		edit func(c *ConfStruct)
+		want []string // 错误里应该包含的内容，每项以字段路径开头
+	}{
+		{"监听为空", func(c *ConfStruct) { c.BasicListen = nil }, []string{"BasicListen: 不能为空"}},
+		{"缺少端口", func(c *ConfStruct) { c.BasicListen = ListenList{"127.0.0.1"} }, []string{"BasicListen[0]: 格式应该是host:port"}},
+		{"端口超出范围", func(c *ConfStruct) { c.BasicListen = ListenList{":70000"} }, []string{`BasicListen[0]: 端口"70000"无效`}},
+		{"端口不是数字", func(c *ConfStruct) { c.BasicListen = ListenList{":http"} }, []string{`BasicListen[0]: 端口"http"无效`}},
+		{"地址带路径", func(c *ConfStruct) { c.BasicListen = ListenList{"localhost/x:80"} }, []string{"BasicListen[0]: 地址"}},
+		{"监听重复", func(c *ConfStruct) { c.BasicListen = ListenList{":12080", "127.0.0.1:12080"} }, []string{`BasicListen[1]: 和":12080"使用了同一个端口`}},
+		{"备用端口冲突", func(c *ConfStruct) { c.FallbackListen = ListenList{"0.0.0.0:12080"} }, []string{"FallbackListen[0]: 和BasicListen使用了同一个端口"}},
+		{"重试次数", func(c *ConfStruct) { c.ListenRetry.Attempts = 101 }, []string{"ListenRetry.Attempts: 需要在0-100之间"}},
+		{"路由地址不存在", func(c *ConfStruct) { c.ListenRoutes = map[string][]string{":1": {RouteClient}} }, []string{"ListenRoutes[:1]: 地址不在BasicListen里"}},
+		{"路由类别拼错", func(c *ConfStruct) { c.ListenRoutes = map[string][]string{":12080": {"clients"}} }, []string{`ListenRoutes[:12080]: 路由类别只能是`, `当前为"clients"`}},
+		{"模式拼错", func(c *ConfStruct) { c.Mode = "relase" }, []string{`Mode: 只能是release、debug或test，当前为"relase"`}},
+		{"超时为0", func(c *ConfStruct) { c.DefaultTimeOut = 0 }, []string{"DefaultTimeOut: 需要在1-3600秒之间，当前为0"}},
+		{"超时过大", func(c *ConfStruct) { c.DefaultTimeOut = 3601 }, []string{"DefaultTimeOut: 需要在1-3600秒之间"}},
+		{"await倍数", func(c *ConfStruct) { c.AwaitTimeoutMultiplier = 11 }, []string{"AwaitTimeoutMultiplier: 需要在0-10之间"}},
+		{"https端口冲突", func(c *ConfStruct) {
+			https(garbage, garbage)(c)
+			c.HttpsServices.HttpsListen = ":12080"
+		}, []string{"HttpsServices.HttpsListen: 和BasicListen使用了同一个端口"}},
+		{"https模式", func(c *ConfStruct) {
+			https(garbage, garbage)(c)
+			c.HttpsServices.HttpMode = "redirct"
+		}, []string{`HttpsServices.HttpMode: 只能是serve、redirect或healthz，当前为"redirct"`}},
+		{"缺少证书", https("", ""), []string{"HttpsServices.PemPath: 启用https需要配置PemPath和KeyPath"}},
+		{"证书不存在", https(filepath.Join(dir, "none.pem"), filepath.Join(dir, "none.key")), []string{"HttpsServices.PemPath: 证书加载失败", "no such file"}},
+		{"证书无法解析", https(garbage, garbage), []string{"HttpsServices.PemPath: 证书加载失败"}},
+		{"grpc端口冲突", func(c *ConfStruct) {
+			c.Grpc.IsEnable = true
+			c.Grpc.Listen = ":12080"
+		}, []string{"Grpc.Listen: 和http/https使用了同一个端口"}},
+		{"忙碌模式", func(c *ConfStruct) { c.Routing.BusyMode = "wait" }, []string{"Routing.BusyMode: 只能是weight或skip"}},
+		{"拒绝列表为空串", func(c *ConfStruct) { c.Routing.RejectUnregisteredActions = []string{"g", ""} }, []string{"Routing.RejectUnregisteredActions[1]: 不能为空"}},
+		{"流式拼接", func(c *ConfStruct) { c.Websocket.StreamJoin = "join" }, []string{"Websocket.StreamJoin: 只能是concat或last"}},
+		{"秒数为负", func(c *ConfStruct) { c.Restart.DrainTimeout = -1 }, []string{"Restart.DrainTimeout: 需要在0-3600秒之间，当前为-1"}},
+		{"ping间隔", func(c *ConfStruct) { c.Websocket.PingInterval = 7200 }, []string{"Websocket.PingInterval: 不能超过3600秒"}},
+		{"空密钥", func(c *ConfStruct) { c.Security.Hmac.Secrets = map[string]string{"g": ""} }, []string{"Security.Hmac.Secrets[g]: 密钥不能为空"}},
+		{"采样率", func(c *ConfStruct) { c.AccessLog.SampleRate = 1.5 }, []string{"AccessLog.SampleRate: 需要在0-1之间"}},
+		{"预览长度", func(c *ConfStruct) {
+			n := -1
+			c.Log.PreviewLength = &n
+		}, []string{"Log.PreviewLength: 不能小于0"}},
+		{"定时任务互斥", func(c *ConfStruct) {
+			c.Schedules = []ScheduleConfig{{}, {Action: "a", Code: "1+1", Timeout: -1}}
+		}, []string{"Schedules[1]: Action和Code只能配置一个", "Schedules[1].Timeout: 需要在0-3600秒之间"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConf()
+			tt.edit(&conf)
+			err := conf.Validate()
+			if err == nil {
+				t.Fatal("应该检查出错误")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("错误里没有%q：\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateOk(t *testing.T) {
+	conf := validConf()
+	// 没有启用https时不检查证书
+	conf.HttpsServices.PemPath = "/none.pem"
+	conf.ListenRoutes = map[string][]string{":12080": {RouteClient, RouteCaller}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// 启用AutoCert时忽略PemPath/KeyPath
+	conf.HttpsServices.IsEnable = true
+	conf.HttpsServices.AutoCert.IsEnable = true
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 一次返回所有问题，每个问题一行
+func TestValidateReportsAll(t *testing.T) {
+	conf := validConf()
+	conf.Mode = "prod"
+	conf.DefaultTimeOut = 0
+	conf.BasicListen = ListenList{":12080", ":bad"}
+	err := conf.Validate()
+	if err == nil {
+		t.Fatal("应该检查出错误")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("应该有3个问题，得到：\n%v", err)
+	}
+	for i, prefix := range []string{"BasicListen[1]: ", "Mode: ", "DefaultTimeOut: "} {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("第%d个问题应该以%q开头：%s", i+1, prefix, lines[i])
+		}
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	keys := unknownKeys([]byte("BasicListen: \":12080\"\nDefaultTimeout: 30\nRouting:\n  BusyMod: skip\n"))
+	if len(keys) != 2 || !strings.Contains(keys[0], "DefaultTimeout") || !strings.Contains(keys[1], "BusyMod") {
+		t.Fatalf("unknownKeys = %q", keys)
+	}
+	if keys := unknownKeys([]byte("BasicListen: \":12080\"\nDefaultTimeOut: 30\n")); len(keys) != 0 {
+		t.Fatalf("字段都存在时不应该有警告：%q", keys)
+	}
+}
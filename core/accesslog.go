@@ -0,0 +1,96 @@
+package core
+
+import (
+	"JsRpc/config"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ctxApiParam 处理函数解析出的ApiParam，访问日志从这里取group和action
+const ctxApiParam = "jsrpc.apiParam"
+
+// bindParam 解析请求参数，同时保存下来给访问日志使用
+func bindParam(c *gin.Context, p *ApiParam) error {
+	err := c.ShouldBind(p)
+	c.Set(ctxApiParam, p)
+	return err
+}
+
+// AccessLog 用logrus记录访问日志，按SampleRate抽样，5xx总是记录
+// Format为json时输出json，为空或text时是logrus的key=value，其它值作为模板，如 "{status} {method} {path} {latency}"
+func AccessLog(conf config.AccessLogConfig) gin.HandlerFunc {
+	logger := log.StandardLogger()
+	if conf.Format == "json" {
+		logger = log.New()
+		logger.SetOutput(log.StandardLogger().Out)
+		logger.SetFormatter(&log.JSONFormatter{TimestampFormat: "2006-01-02 15:04:05"})
+	}
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		status := c.Writer.Status()
+		if excludedPath(conf.Exclude, c.Request.URL.Path) ||
+			status < 500 && conf.SampleRate > 0 && conf.SampleRate < 1 && rand.Float64() >= conf.SampleRate {
+			return
+		}
+		fields := log.Fields{
+			"method":  c.Request.Method,
+			"path":    c.Request.URL.Path,
+			"status":  status,
+			"latency": time.Since(start).Milliseconds(),
+			"ip":      c.ClientIP(),
+			"size":    c.Writer.Size(),
+		}
+		if value, ok := c.Get(ctxApiParam); ok {
+			p := value.(*ApiParam)
+			fields["group"], fields["action"] = p.GroupName, p.Action
+		} else {
+			fields["group"], fields["action"] = c.Query("group"), c.Query("action")
+		}
+		level := log.InfoLevel
+		switch {
+		case status >= 500:
+			level = log.ErrorLevel
+		case status >= 400:
+			level = log.WarnLevel
+		}
+		if conf.Format == "" || conf.Format == "text" || conf.Format == "json" {
+			logger.WithFields(fields).Log(level, "access")
+			return
+		}
+		logger.Log(level, formatAccessLog(conf.Format, fields))
+	}
+}
+
+// formatAccessLog 把模板里的{字段名}换成对应的值，latency的单位是毫秒
+func formatAccessLog(format string, fields log.Fields) string {
+	pairs := make([]string, 0, len(fields)*2)
+	for k, v := range fields {
+		var value string
+		switch v := v.(type) {
+		case string:
+			value = v
+		case int:
+			value = strconv.Itoa(v)
+		case int64:
+			value = strconv.FormatInt(v, 10)
+		}
+		pairs = append(pairs, "{"+k+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(format)
+}
+
+// excludedPath 支持完整路径和以*结尾的前缀，如 /debug/*
+func excludedPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(path, prefix) || pattern == path {
+			return true
+		}
+	}
+	return false
+}
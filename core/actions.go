@@ -0,0 +1,151 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	actionRegisterActions   = "_registerActions"   // 客户端主动上报新注册的方法
+	actionUnregisterActions = "_unregisterActions" // 客户端主动上报移除的方法
+	actionListActions       = "_listActions"       // 服务端让客户端重新上报全部方法
+
+	listActionsTimeout = 3 * time.Second
+)
+
+// setActions 替换客户端注册的方法列表
+func (c *Clients) setActions(names []string) {
+	c.mu.Lock()
+	c.actions = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		c.actions[name] = struct{}{}
+	}
+	c.mu.Unlock()
+	c.actionsChanged()
+}
+
+// updateActions 增加或移除客户端注册的方法
+func (c *Clients) updateActions(names []string, remove bool) {
+	c.mu.Lock()
+	if c.actions == nil {
+		if remove { // 还不知道客户端有哪些方法，没法移除
+			c.mu.Unlock()
+			return
+		}
+		c.actions = make(map[string]struct{}, len(names))
+	}
+	for _, name := range names {
+		if remove {
+			delete(c.actions, name)
+		} else {
+			c.actions[name] = struct{}{}
+		}
+	}
+	c.mu.Unlock()
+	c.actionsChanged()
+}
+
+func (c *Clients) actionsChanged() {
+	if c.server != nil {
+		c.server.publish(EventActions, c)
+	}
+}
+
+// hasAction 客户端还没上报过方法列表时(旧版客户端)认为什么都支持
+func (c *Clients) hasAction(action string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.actions == nil {
+		return true
+	}
+	_, ok := c.actions[action]
+	return ok
+}
+
+// actionList 客户端注册的方法，还没上报过时返回nil
+func (c *Clients) actionList() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.actions == nil {
+		return nil
+	}
+	names := make([]string, 0, len(c.actions))
+	for name := range c.actions {
+		names = append(names, name)
+	}
+	return names
+}
+
+// handleActions 处理客户端主动发来的_registerActions和_unregisterActions
+func (c *Clients) handleActions(action string, raw string) {
+	var names []string
+	if err := json.Unmarshal([]byte(raw), &names); err != nil {
+		log.Error(action, "格式错误，需要方法名数组:", err)
+		return
+	}
+	c.updateActions(names, action == actionUnregisterActions)
+}
+
+// refreshActions 让客户端重新上报全部方法
+func (c *Clients) refreshActions(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, listActionsTimeout)
+	defer cancel()
+	res, err := c.roundTrip(ctx, Message{Action: actionListActions})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(res), &names); err != nil {
+		return nil, err // 旧版客户端会返回action not found
+	}
+	c.setActions(names)
+	return names, nil
+}
+
+// loadActions 客户端上线时获取方法列表
+func (c *Clients) loadActions() {
+	if _, err := c.refreshActions(context.Background()); err != nil {
+		log.Debug(c.clientGroup+"->"+c.clientId, " 获取方法列表失败(可能是旧版客户端):", err)
+	}
+}
+
+// getActionClient 没有指定clientId时，只在注册了action的客户端里选，
+// 选不到时返回*NoClientError说明原因，action不允许调用时返回ErrActionForbidden
+func (s *Server) getActionClient(ns string, group string, clientId string, action string) (*Clients, error) {
+	client, err := s.selectClient(ns, group, clientId, "", action, nil)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, s.noClient(ns, group, clientId, action)
+	}
+	return client, nil
+}
+
+// refreshActionsApi 让客户端重新上报注册的方法，页面后来又注册了方法时不用重连
+func (s *Server) refreshActionsApi(c *gin.Context) {
+	group, clientId := c.Query("group"), c.Query("clientId")
+	if group == "" || clientId == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入group和clientId")
+		return
+	}
+	if !requireAdminGroup(c, group) {
+		return
+	}
+	client := s.getRandomClient(namespace(c), group, clientId)
+	if client == nil {
+		s.noClientResponse(c, group, clientId)
+		return
+	}
+	names, err := client.refreshActions(c.Request.Context())
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadGateway, "客户端没有返回方法列表："+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": group, "clientId": clientId, "data": names})
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ctxAdminGroups group管理token能管理的group，全局adminToken不设置
+const ctxAdminGroups = "jsrpc.adminGroups"
+
+func (s *Server) checkGroupAdminTokens() error {
+	if len(s.conf.GroupAdminTokens) > 0 && s.conf.AdminToken == "" {
+		return errors.New("配置GroupAdminTokens时需要同时配置AdminToken")
+	}
+	for token, groups := range s.conf.GroupAdminTokens {
+		if token == "" || token == s.conf.AdminToken || len(groups) == 0 {
+			return errors.New("GroupAdminTokens的token不能为空、不能和AdminToken相同，并且至少要有一个group")
+		}
+	}
+	return nil
+}
+
+// adminGroups 请求使用的group管理token能管理的group，返回nil表示全局管理员
+func adminGroups(c *gin.Context) []string {
+	groups, _ := c.Get(ctxAdminGroups)
+	list, _ := groups.([]string)
+	return list
+}
+
+// canAdminGroup 全局管理员或者group管理token包含这个group
+func canAdminGroup(c *gin.Context, group string) bool {
+	groups := adminGroups(c)
+	return groups == nil || slices.Contains(groups, group)
+}
+
+// requireAdminGroup 没有这个group的管理权限时写403，说明需要的权限
+func requireAdminGroup(c *gin.Context, group string) bool {
+	if canAdminGroup(c, group) {
+		return true
+	}
+	GinJsonMsg(c, http.StatusForbidden, "需要group "+group+" 的管理token或全局adminToken，当前token只能管理："+strings.Join(adminGroups(c), ","))
+	return false
+}
+
+// requireGlobalAdmin 只有全局adminToken可以调用的接口
+func requireGlobalAdmin(c *gin.Context) bool {
+	if adminGroups(c) == nil {
+		return true
+	}
+	GinJsonMsg(c, http.StatusForbidden, "需要全局adminToken，group管理token不能调用这个接口")
+	return false
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"JsRpc/config"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGroupAdminTokens(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.AdminToken = "admin"
+		conf.GroupAdminTokens = map[string][]string{"scoped": {"a"}}
+	})
+	for _, group := range []string{"a", "b"} {
+		startFake(t, s, wsPeer{group: group, clientId: "c"}, map[string]func(string) string{"_listActions": actionList("x")})
+	}
+	groupRoutes := []string{
+		"/refreshActions?group=%s&clientId=c",
+		"/tags?group=%s&clientId=c",
+		"/throttle?group=%s&clientId=c&maxQps=5",
+		"/navigate?group=%s&url=http://example.com",
+	}
+	globalRoutes := []string{"/bans", "/admin/settings", "/stats"}
+
+	tests := []struct {
+		name    string
+		routes  []string
+		group   string
+		token   string
+		code    int
+		message string // 403时说明需要的权限
+	}{
+		{"group管理token管理自己的group", groupRoutes, "a", "scoped", http.StatusOK, ""},
+		{"group管理token不能管理其它group", groupRoutes, "b", "scoped", http.StatusForbidden, "需要group b 的管理token或全局adminToken，当前token只能管理：a"},
+		{"全局adminToken可以管理所有group", groupRoutes, "b", "admin", http.StatusOK, ""},
+		{"没有token", groupRoutes, "a", "", http.StatusUnauthorized, ""},
+		{"group管理token不能调用全局接口", globalRoutes, "", "scoped", http.StatusForbidden, "需要全局adminToken"},
+		{"全局adminToken调用全局接口", globalRoutes, "", "admin", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, route := range tt.routes {
+				target := strings.ReplaceAll(route, "%s", tt.group)
+				w := serveRequest(s, http.MethodGet, target, "", "X-Admin-Token", tt.token)
+				if w.Code != tt.code {
+					t.Fatalf("%s返回%d，期望%d：%s", target, w.Code, tt.code, w.Body.String())
+				}
+				if tt.message != "" && !strings.Contains(decodeBody(t, w)["data"].(string), tt.message) {
+					t.Fatalf("%s的403没有说明需要的权限：%s", target, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGroupAdminTokensConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		admin  string
+		scoped map[string][]string
+	}{
+		{"没有AdminToken", "", map[string][]string{"scoped": {"a"}}},
+		{"和AdminToken相同", "admin", map[string][]string{"admin": {"a"}}},
+		{"没有group", "admin", map[string][]string{"scoped": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServer(config.ConfStruct{DefaultTimeOut: 5, CloseWebLog: true, AdminToken: tt.admin, GroupAdminTokens: tt.scoped})
+			if err == nil {
+				t.Fatal("GroupAdminTokens配置错误时应该返回错误")
+			}
+		})
+	}
+}
@@ -2,29 +2,78 @@ package core
 
 import (
 	"JsRpc/config"
+	"JsRpc/protocol"
 	"JsRpc/utils"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 	"github.com/unrolled/secure"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var (
-	upGrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+// defaultWsBufferSize 缓冲区配置为0时gorilla使用的大小
+const defaultWsBufferSize = 4096
+
+// newUpgrader 按配置创建ws升级器，缓冲区为0时使用gorilla默认的4096字节
+func newUpgrader(conf config.WebsocketConfig) websocket.Upgrader {
+	return websocket.Upgrader{
+		CheckOrigin:       func(r *http.Request) bool { return true },
+		ReadBufferSize:    conf.ReadBufferSize,
+		WriteBufferSize:   conf.WriteBufferSize,
+		EnableCompression: conf.EnableCompression,
+		HandshakeTimeout:  time.Duration(conf.HandshakeTimeout) * time.Second,
 	}
-	gm        = &sync.Mutex{}
-	hlSyncMap sync.Map
-)
+}
+
+// upgraderSummary 启动日志里的ws实际参数，每个连接大约占用读写缓冲区之和的内存
+func upgraderSummary(u websocket.Upgrader) string {
+	size := func(n int) int {
+		if n <= 0 {
+			return defaultWsBufferSize
+		}
+		return n
+	}
+	timeout := "不限制"
+	if u.HandshakeTimeout > 0 {
+		timeout = u.HandshakeTimeout.String()
+	}
+	return fmt.Sprintf("ws读缓冲区：%d字节 写缓冲区：%d字节 压缩：%v 握手超时：%s",
+		size(u.ReadBufferSize), size(u.WriteBufferSize), u.EnableCompression, timeout)
+}
 
 // Message 请求和传递请求
 type Message struct {
-	Action string `json:"action"`
-	Param  string `json:"param"`
+	Action    string          `json:"action"`
+	MessageId string          `json:"message_id"`
+	Param     string          `json:"param"`
+	Args      json.RawMessage `json:"args,omitempty"` // 多个参数时的json数组，客户端按位置传给方法
+	// 结果是Promise时客户端等待它完成再返回，结果是 {"ok":true,"data":...} 或 {"ok":false,"error":"..."}
+	AwaitPromise bool `json:"awaitPromise,omitempty"`
+	// 为base64时Param是base64编码的二进制/要求客户端把二进制结果编码成base64返回
+	ParamEncoding    string `json:"paramEncoding,omitempty"`
+	ResponseEncoding string `json:"responseEncoding,omitempty"`
+}
+
+func (m Message) envelope() protocol.Envelope {
+	env := protocol.Request(m.MessageId, m.Action, m.Param, m.Args)
+	env.Await = m.AwaitPromise
+	env.ParamEncoding, env.ResponseEncoding = m.ParamEncoding, m.ResponseEncoding
+	return env
 }
 
 type ApiParam struct {
@@ -32,34 +81,381 @@ type ApiParam struct {
 	ClientId  string `form:"clientId" json:"clientId"`
 	Action    string `form:"action" json:"action"`
 	Param     string `form:"param" json:"param"`
-	Code      string `form:"code" json:"code"` // 直接eval的代码
+	Code      string `form:"code" json:"code"`         // 直接eval的代码
+	Name      string `form:"name" json:"name"`         // 代码片段名/cookie名
+	Format    string `form:"format" json:"format"`     // 返回格式，json为解析后的结构
+	Selector  string `form:"selector" json:"selector"` // css选择器，/go和/execjs里是标签筛选，如 region=us,account=alice
+	All       bool   `form:"all" json:"all"`           // 选择器匹配全部元素
+	Type      string `form:"type" json:"type"`         // storage类型 local/session
+	Key       string `form:"key" json:"key"`           // storage的key
+	Url       string `form:"url" json:"url"`           // 跳转的地址
+	RawJson   bool   `form:"rawJson" json:"rawJson"`   // 客户端返回合法json时按原类型返回
+	Extract   string `form:"extract" json:"extract"`   // 只返回json结果里的某个字段，如 data.token 或 $.data.list[0]
+	// 同时(或hedgeAfterMs毫秒后)再发给同group的另一个客户端，用先返回的结果
+	Hedge        bool `form:"hedge" json:"hedge"`
+	HedgeAfterMs int  `form:"hedgeAfterMs" json:"hedgeAfterMs"`
+	Quorum       int  `form:"quorum" json:"quorum"` // 发给多个客户端，返回超过半数一致的结果
+	// 同一个session的请求固定发给同一个客户端
+	Session string `form:"session" json:"session"`
+	// 没有可用的客户端时最多等待多少秒，期间有客户端上线就发送
+	QueueIfEmpty int `form:"queueIfEmpty" json:"queueIfEmpty"`
+	// 客户端分多次返回时按NDJSON逐行返回每一部分
+	Stream bool `form:"stream" json:"stream"`
+	// /execjs的代码返回Promise时等待它完成，fetch、crypto.subtle等异步接口需要
+	AwaitPromise bool `form:"awaitPromise" json:"awaitPromise"`
+	// /execjs的代码抛出异常时也按旧版把异常的文字作为data返回
+	Legacy bool `form:"legacy" json:"legacy"`
+	// param/code的编码，base64或url，服务端解码后再发给客户端，GET请求里的+不会变成空格
+	Encoding string `form:"encoding" json:"encoding"`
+	// /go传二进制参数和取二进制结果，见README的二进制参数
+	ParamEncoding    string `form:"paramEncoding" json:"paramEncoding"`
+	ResponseEncoding string `form:"responseEncoding" json:"responseEncoding"`
+	// 多个参数，json请求体里直接传数组，表单里传json数组字符串
+	Args     json.RawMessage `form:"-" json:"args"`
+	ArgsForm string          `form:"args" json:"-"`
+}
+
+// parseArgs 取出并校验args，没传时返回nil
+func (p ApiParam) parseArgs() (json.RawMessage, error) {
+	raw := p.Args
+	if len(raw) == 0 && p.ArgsForm != "" {
+		raw = json.RawMessage(p.ArgsForm)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var str string
+	if json.Unmarshal(raw, &str) == nil { // json请求体里把数组当字符串传了
+		raw = json.RawMessage(str)
+	}
+	var args []json.RawMessage
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, errors.New("args必须是json数组")
+	}
+	return raw, nil
+}
+
+// PageInfo 客户端所在页面的信息
+type PageInfo struct {
+	Url          string    `json:"url"`
+	Title        string    `json:"title"`
+	ReadyState   string    `json:"readyState"`
+	UserAgent    string    `json:"userAgent"`
+	ScreenWidth  int       `json:"screenWidth"`
+	ScreenHeight int       `json:"screenHeight"`
+	UpdatedAt    time.Time `json:"updatedAt"`
 }
 
 // Clients 客户端信息
 type Clients struct {
-	clientGroup string
-	clientId    string
-	actionData  map[string]chan string
-	clientWs    *websocket.Conn
+	namespace     string // 多租户时客户端token对应的namespace，默认为空
+	clientGroup   string
+	clientId      string
+	clientWs      wsConn       // 由mu保护，重连时会换成新连接
+	clientIp      string       // 由mu保护，新连接接管或者重连后换成新连接的ip
+	transport     string       // ws或poll
+	lastSeen      atomic.Int64 // 长轮询客户端最后一次请求的时间(unix纳秒)
+	closeOnce     sync.Once
+	history       *historyRing // 这个客户端最近的请求记录
+	compression   bool         // 是否协商了permessage-deflate压缩
+	failCount     atomic.Int64 // 出错次数
+	suspectCount  atomic.Int64 // quorum模式下和多数结果不一致的次数
+	connectedAt   atomic.Int64 // 当前连接建立的时间(unix纳秒)，重连后更新
+	lastPing      atomic.Int64 // 最近一次发送ping的时间(unix纳秒)
+	lastPong      atomic.Int64 // 最近一次收到pong的时间(unix纳秒)
+	requests      atomic.Int64 // 处理过的请求数
+	successes     atomic.Int64 // 成功返回的请求数
+	timeouts      atomic.Int64 // 超时的请求数
+	unhealthy     atomic.Bool  // 上次检查时的健康状态，变化时增加版本号
+	probes        atomic.Int64 // /readyz探测的次数，不算在requests里
+	probeFailures atomic.Int64 // 探测失败的次数
+	traffic       trafficCounter
+	groupTraffic  *trafficCounter    // 所在group的累计，attach时设置
+	closed        chan struct{}      // ws断开后关闭
+	outbound      chan outboundFrame // 待发送给客户端的消息，由writeLoop写入ws
+	server        *Server            // 所属的服务
+	protoVersion  atomic.Int32       // _hello里协商的协议版本，收到之前按旧版格式发送
+	msgpack       atomic.Bool        // _hello里协商了msgpack编码，只用于ws连接
+
+	mu             sync.Mutex
+	actionData     []*pendingRequest       // 等待客户端返回的请求，按发送顺序排列
+	pageInfo       *PageInfo               // 最近一次获取到的页面信息
+	meta           *ClientMeta             // 客户端_hello上报的信息
+	tags           map[string]string       // 连接参数或_hello里的标签，请求可以用selector筛选
+	actions        map[string]struct{}     // 客户端注册的方法，nil表示还没上报过
+	status         *ClientStatus           // 客户端_status上报的负载
+	lane           clientLane              // 配置了Pending.MaxConcurrent时按优先级排队
+	bucket         tokenBucket             // 按Throttle配置的qps控制发送速度
+	throttledCount atomic.Int64            // 因为超过qps被拒绝的次数
+	chunks         map[string]*chunkBuffer // 正在重组的分片消息
+	writer         *connWriter             // 当前ws连接的写循环
+	graceUntil     time.Time               // ws断开后等待重连的截止时间，零值表示在线
+	graceGen       int                     // 每次断开或重连加一，过期的等待不再处理
+}
+
+// deliverResult 把客户端的返回交给等待中的请求
+func (c *Clients) deliverResult(messageId string, action string, data string, jsErr *protocol.JsError) {
+	if action == actionRegistered { // 旧版客户端不认识注册回执，会返回action not found
+		return
+	}
+	if !c.deliver(messageId, action, data, jsErr) {
+		log.Warning(c.clientGroup+"->"+c.clientId, " 收到的返回没有对应的请求(可能已超时) action:", action)
+		return
+	}
+	c.server.logBody(data, "get_message:")
+}
+
+// previewLength 日志里记录参数和返回的字符数，0表示不记录
+func (s *Server) previewLength() int {
+	if s == nil {
+		return defaultPreviewLength
+	}
+	conf := s.conf.Log
+	if conf.LogBodies != nil && !*conf.LogBodies {
+		return 0
+	}
+	if conf.PreviewLength == nil {
+		return defaultPreviewLength
+	}
+	return max(*conf.PreviewLength, 0)
+}
+
+// logBody 按Log配置记录参数、代码或返回，超过长度的部分截断
+func (s *Server) logBody(data string, prefix ...interface{}) {
+	if n := s.previewLength(); n > 0 {
+		utils.LogPrint(append(prefix, utils.Preview(data, n))...)
+	}
+}
+
+// handleMessage 处理客户端发来的一条消息，ws和轮询两种连接方式共用，binary是协商了msgpack后的ws二进制帧
+func (c *Clients) handleMessage(msg []byte, binary bool) {
+	var env protocol.Envelope
+	var err error
+	if binary {
+		env, err = protocol.DecodeMsgpack(msg)
+	} else {
+		env, err = protocol.Decode(msg)
+	}
+	if err != nil || env.Type == protocol.TypeRequest {
+		log.Error(utils.Preview(string(msg), 200), "message error")
+		if c.server != nil && c.server.strike(c.getIp(), strikeMalformed) {
+			c.close()
+		}
+		return
+	}
+	switch {
+	case !c.verifyResponse(env): // 上报和控制消息也要先验证签名，不能伪造标签、状态和方法列表
+	case env.Type == protocol.TypeControl:
+		if !c.handleReport(env.Control.Name, env.Control.Data) {
+			log.Warning(c.clientGroup+"->"+c.clientId, " 未知的控制消息:", env.Control.Name)
+		}
+	case env.Id == "" && c.handleReport(env.Action, env.Data): // 旧版客户端的上报和返回格式相同
+	case env.Part != nil:
+		if !c.deliverPart(env.Id, *env.Part, env.Data) {
+			log.Warning(c.clientGroup+"->"+c.clientId, " 收到的流式返回没有对应的请求(可能已超时) action:", env.Action)
+		}
+	case env.Chunk != nil:
+		data, done, err := c.addChunk(env.Id, env.Action, env.Chunk)
+		if err != nil {
+			log.Error(err)
+		} else if done {
+			c.deliverResult(env.Id, env.Action, data, nil)
+		}
+	default:
+		c.deliverResult(env.Id, env.Action, env.Data, env.Error)
+	}
+}
+
+// handleReport 处理客户端主动上报的消息，不是上报类的action时返回false
+func (c *Clients) handleReport(action string, data string) bool {
+	switch action {
+	case "_pageInfo": // 客户端连接后主动上报的页面信息
+		if _, err := c.setPageInfo(data); err != nil {
+			log.Error("页面信息格式错误:", err)
+		}
+	case actionHello:
+		c.handleHello(data)
+	case actionRegisterActions, actionUnregisterActions:
+		c.handleActions(action, data)
+	case actionStatus:
+		c.handleStatus(data)
+	case actionEvent:
+		c.handleEvent(data)
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *Clients) setPageInfo(raw string) (*PageInfo, error) {
+	info := &PageInfo{}
+	if err := json.Unmarshal([]byte(raw), info); err != nil {
+		return nil, err
+	}
+	info.UpdatedAt = time.Now()
+	c.mu.Lock()
+	c.pageInfo = info
+	c.mu.Unlock()
+	return info, nil
+}
+
+func (c *Clients) getPageInfo() *PageInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pageInfo
 }
 
 // NewClient  initializes a new Clients instance
-func NewClient(group string, uid string, ws *websocket.Conn) *Clients {
+func NewClient(group string, uid string, ws wsConn, ip string) *Clients {
 	return &Clients{
 		clientGroup: group,
 		clientId:    uid,
-		actionData:  make(map[string]chan string, 1), // action有消息后就保存到chan里
 		clientWs:    ws,
+		clientIp:    ip,
+		transport:   transportWs,
+		closed:      make(chan struct{}),
+		outbound:    make(chan outboundFrame, outboundQueueSize),
 	}
 }
 
+// getIp 当前连接的ip，新连接接管或者重连后会变化
+func (c *Clients) getIp() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clientIp
+}
+
+// responseData rawJson为true且客户端返回的是合法json时，原样嵌入响应，否则还是字符串
+func responseData(raw string, rawJson bool) interface{} {
+	if rawJson && json.Valid([]byte(raw)) {
+		return json.RawMessage(raw)
+	}
+	return raw
+}
+
+// checkPayloadSize 参数和代码超过ws消息大小上限时返回413，避免转发给客户端
+func (s *Server) checkPayloadSize(c *gin.Context, payloads ...string) bool {
+	limit := s.conf.Websocket.MaxMessageSize
+	if limit <= 0 {
+		return true
+	}
+	size := 0
+	for _, p := range payloads {
+		size += len(p)
+	}
+	if int64(size) > limit {
+		GinJsonMsg(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("参数大小%d超过上限%d", size, limit))
+		return false
+	}
+	return true
+}
+
+// expandTemplate 开启Template后展开参数里的占位符
+func (s *Server) expandTemplate(param string) (string, error) {
+	if !s.conf.Template.IsEnable {
+		return param, nil
+	}
+	return s.expandVars(param, nil)
+}
+
+// expandVars 展开内置占位符和vars，env只能读取EnvAllow里的变量
+func (s *Server) expandVars(param string, vars map[string]string) (string, error) {
+	return utils.ExpandPlaceholders(param, vars, func(name string) (string, bool) {
+		if !slices.Contains(s.conf.Template.EnvAllow, name) {
+			return "", false
+		}
+		return os.Getenv(name), true
+	})
+}
+
+// expandParam 展开失败时直接返回400
+func (s *Server) expandParam(c *gin.Context, param string) (string, bool) {
+	res, err := s.expandTemplate(param)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return res, true
+}
+
+// resultData 按extract/rawJson参数处理客户端返回的结果，提取失败时直接写错误响应并返回false
+func resultData(c *gin.Context, raw string, p ApiParam) (interface{}, bool) {
+	if p.Extract == "" {
+		return responseData(raw, p.RawJson), true
+	}
+	data, err := utils.ExtractJson(raw, p.Extract)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return data, true
+}
+
+// request 调用客户端并等待结果，超时和其它错误按旧版的格式放在结果里，被hook拒绝时直接返回403
+func (s *Server) request(c *gin.Context, client *Clients, msg Message) (string, bool) {
+	ctx, ok := requestContext(c)
+	if !ok {
+		return "", false
+	}
+	res, err := s.runQuery(ctx, client, msg)
+	return queryResult(c, res, err)
+}
+
+// requestContext 把调用方信息、priority和resendOnReconnect参数放进ctx
+func requestContext(c *gin.Context) (context.Context, bool) {
+	priority, err := parsePriority(c.DefaultQuery("priority", c.PostForm("priority")))
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	resend, _ := strconv.ParseBool(c.DefaultQuery("resendOnReconnect", c.PostForm("resendOnReconnect")))
+	ctx := withPriority(withCaller(c.Request.Context(), httpCaller(c)), priority)
+	return withResend(ctx, resend), true
+}
+
+func queryResult(c *gin.Context, res string, err error) (string, bool) {
+	switch {
+	case err == nil:
+		return res, true
+	case errors.Is(err, ErrRejected):
+		GinJsonMsg(c, http.StatusForbidden, err.Error())
+		return "", false
+	case errors.Is(err, ErrTooManyPending):
+		var le *PendingLimitError
+		errors.As(err, &le)
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(le.RetryAfter)))
+		c.JSON(http.StatusTooManyRequests, gin.H{"status": http.StatusTooManyRequests, "data": err.Error(), "pending": le.Depth})
+		return "", false
+	case errors.Is(err, ErrThrottled):
+		GinJsonMsg(c, http.StatusTooManyRequests, err.Error())
+		return "", false
+	case errors.Is(err, ErrPoolFull), errors.Is(err, ErrOfflineQueueFull):
+		GinJsonMsg(c, http.StatusServiceUnavailable, err.Error())
+		return "", false
+	case errors.Is(err, ErrTimeout):
+		return TimeoutMsg, true
+	case errors.Is(err, context.Canceled): // 调用方已经断开，不用再返回
+		return "", false
+	}
+	return "黑脸怪：" + err.Error(), true
+}
+
+// retryAfterSeconds Retry-After头的秒数，至少1秒
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
 func GinJsonMsg(c *gin.Context, code int, msg string) {
 	c.JSON(code, gin.H{"status": code, "data": msg})
 	return
 }
 
 // ws, provides inject function for a job
-func ws(c *gin.Context) {
+func (s *Server) ws(c *gin.Context) {
 	group, clientId := c.Query("group"), c.Query("clientId")
 	//必须要group名字，不然不让它连接ws
 	if group == "" {
@@ -69,70 +465,203 @@ func ws(c *gin.Context) {
 	if clientId == "" {
 		clientId = utils.GetUUID()
 	}
-	wsClient, err := upGrader.Upgrade(c.Writer, c.Request, nil)
+	tags, err := parseTags(c.Query("tags"))
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	ns, ok := s.clientNamespace(c)
+	if !ok {
+		return
+	}
+	wsClient, err := s.upGrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Error("websocket err:", err)
 		return
 	}
-	client := NewClient(group, clientId, wsClient)
-	hlSyncMap.Store(group+"->"+clientId, client)
-	utils.LogPrint("新上线group:" + group + ",clientId:->" + clientId)
+	// 开启压缩并且客户端也支持时gorilla会协商permessage-deflate
+	compression := s.upGrader.EnableCompression &&
+		strings.Contains(c.GetHeader("Sec-WebSocket-Extensions"), "permessage-deflate")
+	s.serveWs(wsPeer{namespace: ns, group: group, clientId: clientId, ip: c.ClientIP(), tags: tags, compression: compression}, wsClient)
+}
+
+// wsPeer 升级前从请求里取出的客户端信息
+type wsPeer struct {
+	namespace   string
+	group       string
+	clientId    string
+	ip          string
+	tags        map[string]string
+	compression bool // 是否协商了permessage-deflate压缩
+}
+
+// serveWs 在已经升级的连接上注册客户端(或者接上等待重连、被刷新的旧客户端)，然后一直读消息直到断开
+func (s *Server) serveWs(peer wsPeer, wsClient wsConn) {
+	ns, group, clientId := peer.namespace, peer.group, peer.clientId
+	if reason := s.checkClientLimit(ns, group, clientId); reason != "" {
+		logReject(group, clientId, peer.ip, reason)
+		rejectWs(wsClient, s.conf.Security.Hmac.Secrets[group], reason)
+		return
+	}
+	if s.conf.Websocket.MaxMessageSize > 0 {
+		wsClient.SetReadLimit(s.conf.Websocket.MaxMessageSize)
+	}
+	client, rebound := s.rebindClient(ns, group, clientId, peer.ip, wsClient, peer.compression)
+	if !rebound { // 页面刷新时旧连接可能还没断开
+		client, rebound = s.takeover(ns, group, clientId, peer.ip, wsClient, peer.compression)
+	}
+	if !rebound {
+		client = NewClient(group, clientId, wsClient, peer.ip)
+		client.namespace = ns
+		client.compression = peer.compression
+		s.attach(client)
+	}
+	client.setTags(peer.tags)
+	client.watchPong(wsClient)
+	done := make(chan struct{})
+	client.startWriter(wsClient, done)
+	if rebound {
+		utils.LogPrint(group+"->"+clientId, client.getIp(), "重新连接")
+		s.updateHealth(client)
+		client.resendPending()
+	} else {
+		s.supersedeOther(client.key(), transportWs)
+		s.hlSyncMap.Store(client.key(), client)
+		s.publish(EventConnect, client)
+		utils.LogPrint("新上线group:" + group + ",clientId:->" + clientId + ",ip:" + client.getIp())
+	}
+	client.sendRegistered()
+	go client.loadActions()
 	for {
 		//等待数据
-		_, message, err := wsClient.ReadMessage()
+		kind, message, err := wsClient.ReadMessage()
 		if err != nil {
-			break
-		}
-		msg := string(message)
-		check := []uint8{104, 108, 94, 95, 94}
-		strIndex := strings.Index(msg, string(check))
-		if strIndex >= 1 {
-			action := msg[:strIndex]
-			client.actionData[action] <- msg[strIndex+5:]
-			if len(msg) > 100 {
-				utils.LogPrint("get_message:", msg[strIndex+5:101]+"......")
-			} else {
-				utils.LogPrint("get_message:", msg[strIndex+5:])
+			if errors.Is(err, websocket.ErrReadLimit) { // gorilla已经发送了1009关闭帧
+				client.failCount.Add(1)
+				log.Error(group+"->"+clientId, " ip:", client.getIp(), " 消息超过大小上限，断开连接")
 			}
-
-		} else {
-			log.Error(msg, "message error")
+			break
 		}
+		client.countIn(len(message))
+		client.handleMessage(message, kind == websocket.BinaryMessage)
+	}
+	close(done)
+	_ = wsClient.Close()
+	s.dropClient(client, wsClient)
+}
 
+func (s *Server) wsTest(c *gin.Context) {
+	testClient, err := s.upGrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Error("websocket err:", err)
+		return
 	}
 	defer func(ws *websocket.Conn) {
 		_ = ws.Close()
-		utils.LogPrint(group+"->"+clientId, "下线了")
-		hlSyncMap.Range(func(key, value interface{}) bool {
-			//client, _ := value.(*Clients)
-			if key == group+"->"+clientId {
-				hlSyncMap.Delete(key)
-			}
-			return true
-		})
-	}(wsClient)
-}
-
-func wsTest(c *gin.Context) {
-	testClient, _ := upGrader.Upgrade(c.Writer, c.Request, nil)
+	}(testClient)
+	if s.conf.Websocket.MaxMessageSize > 0 {
+		testClient.SetReadLimit(s.conf.Websocket.MaxMessageSize)
+	}
+	withTime := c.Query("timestamp") == "true"
+	count := 0
 	for {
 		//等待数据
-		_, message, err := testClient.ReadMessage()
+		msgType, message, err := testClient.ReadMessage()
 		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				log.Error("测试连接 ip:", c.ClientIP(), " 消息超过大小上限，断开连接")
+			}
 			break
 		}
-		msg := string(message)
-		utils.LogPrint("接收到测试消息", msg)
-		_ = testClient.WriteMessage(websocket.BinaryMessage, []byte(msg))
+		if withTime {
+			message = appendRecvTime(msgType, message, time.Now())
+		}
+		if testClient.WriteMessage(msgType, message) != nil {
+			break
+		}
+		count++
 	}
-	defer func(ws *websocket.Conn) {
-		_ = ws.Close()
-	}(testClient)
+	// 压测时消息很多，只记录数量
+	utils.LogPrint("测试连接 ip:", c.ClientIP(), "断开，共回显", count, "条消息")
+}
+
+// appendRecvTime timestamp=true时在回显里带上服务端收到消息的时间，客户端可以算单程延迟
+// 文本消息返回 {"data":原消息,"recvAt":unix微秒}，二进制消息在末尾追加8字节大端的unix微秒
+func appendRecvTime(msgType int, message []byte, recvAt time.Time) []byte {
+	if msgType == websocket.BinaryMessage {
+		return binary.BigEndian.AppendUint64(message, uint64(recvAt.UnixMicro()))
+	}
+	data, _ := json.Marshal(gin.H{"data": string(message), "recvAt": recvAt.UnixMicro()})
+	return data
+}
+
+// pageAction 禁用execjs时使用客户端内置的方法获取页面信息，否则直接执行代码
+func (s *Server) pageAction(action string, code string) (string, string) {
+	if s.conf.Security.DisableExecjs {
+		return action, ""
+	}
+	return "_execjs", utils.NewJsCode(code).String()
+}
+
+// jsResult 解析JsCode在页面执行的结果，页面抛出异常时返回错误信息；
+// 不是JsCode的结果时(超时、禁用execjs时客户端内置方法的返回)原样返回
+func jsResult(c *gin.Context, client *Clients, raw string) (string, bool) {
+	res, ok := utils.ParseJsResult(raw)
+	if !ok {
+		return raw, true
+	}
+	if !res.Ok {
+		c.JSON(http.StatusOK, gin.H{"status": 500, "group": client.clientGroup, "clientId": client.clientId, "error": res.Error})
+		return "", false
+	}
+	return res.Text(), true
+}
+
+// ErrActionForbidden action不在group的白名单里，或者禁用execjs后直接调用下划线开头的内置action
+var ErrActionForbidden = errors.New("action forbidden")
+
+// checkAction 所有调用入口选客户端前都要检查的action：禁用execjs后不能直接调用_execjs等内置action，
+// 否则/go、/ws/caller和gRPC都能绕开被去掉的/execjs
+func (s *Server) checkAction(group string, action string) error {
+	if s.conf.Security.DisableExecjs && strings.HasPrefix(action, "_") {
+		return &queryError{"execjs已禁用，不能调用内置action:" + action, ErrActionForbidden}
+	}
+	if !s.isActionAllowed(group, action) {
+		return &queryError{"该group不允许调用action:" + action, ErrActionForbidden}
+	}
+	return nil
+}
+
+// isActionAllowed group没有配置白名单时全部放行
+// group是通配符时，匹配到的group里只要有一个不允许就拒绝
+func (s *Server) isActionAllowed(group string, action string) bool {
+	if config.IsGroupPattern(group) {
+		match, err := config.MatchGroup(group)
+		if err != nil {
+			return false
+		}
+		for name := range s.conf.Security.AllowedActions {
+			if match(name) && !s.isActionAllowed(name, action) {
+				return false
+			}
+		}
+		return true
+	}
+	allowed := s.conf.Security.AllowedActions[group]
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == action {
+			return true
+		}
+	}
+	return false
 }
 
-func GetCookie(c *gin.Context) {
+func (s *Server) GetCookie(c *gin.Context) {
 	var RequestParam ApiParam
-	if err := c.ShouldBind(&RequestParam); err != nil {
+	if err := bindParam(c, &RequestParam); err != nil {
 		GinJsonMsg(c, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -143,20 +672,50 @@ func GetCookie(c *gin.Context) {
 	}
 
 	clientId := RequestParam.ClientId
-	client := getRandomClient(group, clientId)
+	client := s.getRandomClient(namespace(c), group, clientId)
 	if client == nil {
-		GinJsonMsg(c, http.StatusBadRequest, "没有找到对应的group或clientId,请通过list接口查看现有的注入")
+		s.noClientResponse(c, group, clientId)
 		return
 	}
 
-	c3 := make(chan string, 1)
-	go client.GQueryFunc("_execjs", utils.ConcatCode("document.cookie"), c3)
-	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": <-c3})
+	action, code := s.pageAction("_getCookie", "document.cookie")
+	raw, ok := s.request(c, client, Message{Action: action, Param: code})
+	if !ok {
+		return
+	}
+	if raw, ok = jsResult(c, client, raw); !ok {
+		return
+	}
+	// 默认返回原始字符串，传了name、extract或format=json才解析
+	if raw == TimeoutMsg || (RequestParam.Name == "" && RequestParam.Extract == "" && RequestParam.Format != "json") {
+		c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": raw})
+		return
+	}
+	cookies := utils.ParseCookie(raw)
+	if RequestParam.Extract != "" {
+		data, err := utils.ExtractValue(cookies, RequestParam.Extract, raw)
+		if err != nil {
+			GinJsonMsg(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": data})
+		return
+	}
+	if RequestParam.Name == "" {
+		c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": cookies})
+		return
+	}
+	value, ok := cookies[RequestParam.Name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": 404, "group": client.clientGroup, "clientId": client.clientId, "data": ""})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": value})
 }
 
-func GetHtml(c *gin.Context) {
+func (s *Server) GetHtml(c *gin.Context) {
 	var RequestParam ApiParam
-	if err := c.ShouldBind(&RequestParam); err != nil {
+	if err := bindParam(c, &RequestParam); err != nil {
 		GinJsonMsg(c, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -167,21 +726,176 @@ func GetHtml(c *gin.Context) {
 	}
 
 	clientId := RequestParam.ClientId
-	client := getRandomClient(group, clientId)
+	client := s.getRandomClient(namespace(c), group, clientId)
 	if client == nil {
-		GinJsonMsg(c, http.StatusBadRequest, "没有找到对应的group或clientId,请通过list接口查看现有的注入")
+		s.noClientResponse(c, group, clientId)
 		return
 	}
 
-	c3 := make(chan string, 1)
-	go client.GQueryFunc("_execjs", utils.ConcatCode("document.documentElement.outerHTML"), c3)
-	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": <-c3})
+	if RequestParam.Selector == "" {
+		action, code := s.pageAction("_getHtml", "document.documentElement.outerHTML")
+		raw, ok := s.request(c, client, Message{Action: action, Param: code})
+		if ok {
+			raw, ok = jsResult(c, client, raw)
+		}
+		if ok {
+			c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": raw})
+		}
+		return
+	}
+
+	msg := Message{Action: "_execjs", Param: utils.SelectorCode(RequestParam.Selector, RequestParam.All)}
+	if s.conf.Security.DisableExecjs {
+		param, _ := json.Marshal(gin.H{"selector": RequestParam.Selector, "all": RequestParam.All})
+		msg = Message{Action: "_getHtml", Param: string(param)}
+	}
+	raw, ok := s.request(c, client, msg)
+	if ok {
+		raw, ok = jsResult(c, client, raw)
+	}
+	if !ok {
+		return
+	}
+	var elements []string
+	if err := json.Unmarshal([]byte(raw), &elements); err != nil {
+		// 超时或者选择器写错了在页面上抛了异常，和其它接口一样原样返回
+		c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": raw})
+		return
+	}
+	if len(elements) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"status": 404, "group": client.clientGroup, "clientId": client.clientId, "data": "没有找到元素:" + RequestParam.Selector})
+		return
+	}
+	var data interface{} = elements[0]
+	if RequestParam.All {
+		data = elements
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": data})
+}
+
+// GetStorage 获取页面的localStorage/sessionStorage，不传key时返回全部
+func (s *Server) GetStorage(c *gin.Context) {
+	var RequestParam ApiParam
+	if err := bindParam(c, &RequestParam); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	group := RequestParam.GroupName
+	if group == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入group")
+		return
+	}
+	storageType := RequestParam.Type
+	if storageType == "" {
+		storageType = "local"
+	}
+	if storageType != "local" && storageType != "session" {
+		GinJsonMsg(c, http.StatusBadRequest, "type只能是local或session")
+		return
+	}
+	client := s.getRandomClient(namespace(c), group, RequestParam.ClientId)
+	if client == nil {
+		s.noClientResponse(c, group, RequestParam.ClientId)
+		return
+	}
+
+	msg := Message{Action: "_execjs", Param: utils.StorageCode(storageType, RequestParam.Key)}
+	if s.conf.Security.DisableExecjs {
+		param, _ := json.Marshal(gin.H{"type": storageType, "key": RequestParam.Key})
+		msg = Message{Action: "_getStorage", Param: string(param)}
+	}
+	raw, ok := s.request(c, client, msg)
+	if !ok {
+		return
+	}
+	var result struct {
+		Ok    bool            `json:"ok"`
+		Data  json.RawMessage `json:"data"`
+		Error string          `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": raw})
+		return
+	}
+	if !result.Ok {
+		c.JSON(http.StatusOK, gin.H{"status": 500, "group": client.clientGroup, "clientId": client.clientId, "error": result.Error})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": result.Data})
+}
+
+// GetPageInfo 获取客户端当前页面的url、标题等信息，并缓存到客户端上
+func (s *Server) GetPageInfo(c *gin.Context) {
+	var RequestParam ApiParam
+	if err := bindParam(c, &RequestParam); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	group := RequestParam.GroupName
+	if group == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入group")
+		return
+	}
+	client := s.getRandomClient(namespace(c), group, RequestParam.ClientId)
+	if client == nil {
+		s.noClientResponse(c, group, RequestParam.ClientId)
+		return
+	}
+
+	action, code := s.pageAction("_getPageInfo", utils.PageInfoCode)
+	raw, ok := s.request(c, client, Message{Action: action, Param: code})
+	if !ok {
+		return
+	}
+	info, err := client.setPageInfo(raw)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": raw})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": info})
+}
+
+// navigate 让客户端页面跳转到指定url，跳转会销毁页面，所以返回前客户端断开也算成功
+func (s *Server) navigate(c *gin.Context) {
+	var RequestParam ApiParam
+	if err := bindParam(c, &RequestParam); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	group := RequestParam.GroupName
+	if group == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入group")
+		return
+	}
+	if !requireAdminGroup(c, group) {
+		return
+	}
+	target, err := url.Parse(RequestParam.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "url只支持http/https地址")
+		return
+	}
+	client := s.getRandomClient(namespace(c), group, RequestParam.ClientId)
+	if client == nil {
+		s.noClientResponse(c, group, RequestParam.ClientId)
+		return
+	}
+	ctx := withCaller(c.Request.Context(), httpCaller(c))
+	res, err := client.query(ctx, Message{Action: "_navigate", Param: target.String()})
+	if errors.Is(err, errClientClosed) {
+		res, err = "页面已跳转，客户端断开", nil
+	}
+	data, ok := queryResult(c, res, err)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": data})
 }
 
 // GetResult 接收web请求参数，并发给客户端获取结果
-func getResult(c *gin.Context) {
+func (s *Server) getResult(c *gin.Context) {
 	var RequestParam ApiParam
-	if err := c.ShouldBind(&RequestParam); err != nil {
+	if err := bindParam(c, &RequestParam); err != nil {
 		GinJsonMsg(c, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -191,30 +905,149 @@ func getResult(c *gin.Context) {
 		GinJsonMsg(c, http.StatusBadRequest, "需要传入group")
 		return
 	}
+	if err := config.CheckGroupPattern(group); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	action := RequestParam.Action
 	if action == "" {
-		GinJsonMsg(c, http.StatusOK, "请传入action来调用客户端方法")
+		code := http.StatusOK // 旧接口缺少action时返回200，v2按参数错误处理
+		if isV2(c) {
+			code = http.StatusBadRequest
+		}
+		GinJsonMsg(c, code, "请传入action来调用客户端方法")
+		return
+	}
+	selector, err := parseTags(RequestParam.Selector)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	client, err := s.waitForClient(c.Request.Context(), namespace(c), group, RequestParam.QueueIfEmpty, func() (*Clients, error) {
+		return s.selectClient(namespace(c), group, RequestParam.ClientId, RequestParam.Session, action, selector)
+	})
+	if emptyQueueFailed(c, err) {
+		return
+	}
+	if err != nil {
+		selectFailed(c, err)
 		return
 	}
-	clientId := RequestParam.ClientId
-	client := getRandomClient(group, clientId)
 	if client == nil {
-		GinJsonMsg(c, http.StatusBadRequest, "没有找到对应的group或clientId,请通过list接口查看现有的注入")
+		noClientJson(c, s.noClient(namespace(c), group, RequestParam.ClientId, action))
+		return
+	}
+	args, err := RequestParam.parseArgs()
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	param, ok := decodeParam(c, RequestParam.Encoding, "param", RequestParam.Param)
+	if !ok {
+		return
+	}
+	if !s.checkFieldLength(c, "param", param) || !s.checkPayloadSize(c, param, string(args)) {
+		return
+	}
+	param, ok = s.expandParam(c, param)
+	if !ok {
+		return
+	}
+	if param, ok = binaryParam(c, RequestParam, param); !ok {
+		return
+	}
+	msg := Message{Action: action, Param: param, ParamEncoding: RequestParam.ParamEncoding, ResponseEncoding: RequestParam.ResponseEncoding}
+	if args != nil {
+		if msg.Param != "" {
+			log.Warning("同时传了param和args，使用args")
+		}
+		// 旧版客户端不认识args，param里也放一份
+		msg.Param, msg.Args = string(args), args
+	}
+	if RequestParam.Stream {
+		if RequestParam.Quorum > 1 || RequestParam.Hedge || RequestParam.HedgeAfterMs > 0 || isV2(c) {
+			GinJsonMsg(c, http.StatusBadRequest, "stream不能和quorum、hedge一起使用，也不支持/api/v2")
+			return
+		}
+		s.streamResult(c, client, msg)
+		return
+	}
+	if RequestParam.Quorum > 1 {
+		s.quorumResult(c, group, msg, RequestParam.Quorum, RequestParam)
+		return
+	}
+	if RequestParam.Hedge || RequestParam.HedgeAfterMs > 0 {
+		s.hedgedResult(c, client, msg, RequestParam)
+		return
+	}
+	raw, ok := s.request(c, client, msg)
+	if !ok {
+		return
+	}
+	if msg.ResponseEncoding != "" {
+		binaryResult(c, client, raw)
+		return
+	}
+	data, ok := resultData(c, raw, RequestParam)
+	if !ok {
 		return
 	}
-	c2 := make(chan string, 1)
-	go client.GQueryFunc(action, RequestParam.Param, c2)
-	//把管道传过去，获得值就返回了
-	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": <-c2})
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": data})
 
 }
 
-func execjs(c *gin.Context) {
+// hedgedResult /go的hedge模式，返回里带上实际返回结果的客户端和是否发了第二个请求
+func (s *Server) hedgedResult(c *gin.Context, client *Clients, msg Message, p ApiParam) {
+	if p.ClientId != "" {
+		GinJsonMsg(c, http.StatusBadRequest, "指定clientId时不能使用hedge")
+		return
+	}
+	ctx := withCaller(c.Request.Context(), httpCaller(c))
+	delay := time.Duration(p.HedgeAfterMs) * time.Millisecond
+	winner, res, hedged, err := s.hedgedQuery(ctx, client, msg, delay)
+	raw, ok := queryResult(c, res, err)
+	if !ok {
+		return
+	}
+	if msg.ResponseEncoding != "" {
+		c.Header("X-JsRpc-Hedged", strconv.FormatBool(hedged))
+		binaryResult(c, winner, raw)
+		return
+	}
+	data, ok := resultData(c, raw, p)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": winner.clientGroup, "clientId": winner.clientId, "data": data, "hedged": hedged})
+}
+
+// rawCodeBodyTypes 这些Content-Type的请求体原样作为代码，不会有表单编码里+变成空格的问题
+var rawCodeBodyTypes = []string{"text/javascript", "application/javascript", "text/plain"}
+
+// rawCodeBody 请求体是js代码时返回代码，group、clientId等参数放在url里。请求体大小已经由BodyLimit限制
+func rawCodeBody(c *gin.Context) (string, bool, error) {
+	if c.Request.Method != http.MethodPost || !slices.Contains(rawCodeBodyTypes, c.ContentType()) {
+		return "", false, nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", false, err
+	}
+	return string(body), true, nil
+}
+
+func (s *Server) execjs(c *gin.Context) {
 	var RequestParam ApiParam
-	if err := c.ShouldBind(&RequestParam); err != nil {
+	if err := bindParam(c, &RequestParam); err != nil {
 		GinJsonMsg(c, http.StatusBadRequest, err.Error())
 		return
 	}
+	if code, ok, err := rawCodeBody(c); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	} else if ok {
+		RequestParam.Code = code
+	}
 	Action := "_execjs"
 	//获取参数
 	group := RequestParam.GroupName
@@ -222,112 +1055,285 @@ func execjs(c *gin.Context) {
 		GinJsonMsg(c, http.StatusBadRequest, "需要传入group")
 		return
 	}
+	if err := config.CheckGroupPattern(group); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	JsCode := RequestParam.Code
 	if JsCode == "" {
 		GinJsonMsg(c, http.StatusBadRequest, "请传入代码")
 		return
 	}
-	clientId := RequestParam.ClientId
-	client := getRandomClient(group, clientId)
+	JsCode, ok := decodeParam(c, RequestParam.Encoding, "code", JsCode)
+	if !ok {
+		return
+	}
+	if !s.checkFieldLength(c, "code", JsCode) || !s.checkPayloadSize(c, JsCode) {
+		return
+	}
+	JsCode, ok = s.expandParam(c, JsCode)
+	if !ok {
+		return
+	}
+	selector, err := parseTags(RequestParam.Selector)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	client, err := s.waitForClient(c.Request.Context(), namespace(c), group, RequestParam.QueueIfEmpty, func() (*Clients, error) {
+		return s.selectClient(namespace(c), group, RequestParam.ClientId, RequestParam.Session, "", selector)
+	})
+	if emptyQueueFailed(c, err) {
+		return
+	}
+	if err != nil {
+		GinJsonMsg(c, http.StatusConflict, err.Error())
+		return
+	}
 	if client == nil {
-		GinJsonMsg(c, http.StatusBadRequest, "没有找到对应的group或clientId,请通过list接口查看现有的注入")
+		s.noClientResponse(c, group, RequestParam.ClientId)
+		return
+	}
+	ctx, ok := requestContext(c)
+	if !ok {
 		return
 	}
-	c2 := make(chan string)
-	go client.GQueryFunc(Action, JsCode, c2)
-	c.JSON(200, gin.H{"status": "200", "group": client.clientGroup, "name": client.clientId, "data": <-c2})
+	ctx, scriptErr := withScriptError(ctx)
+	res, err := s.runQuery(ctx, client, Message{Action: Action, Param: JsCode, AwaitPromise: RequestParam.AwaitPromise})
+	raw, ok := queryResult(c, res, err)
+	if ok && scriptErr.err != nil && !RequestParam.Legacy {
+		// 代码在页面上抛出了异常，legacy=true时和以前一样把异常的文字当作结果
+		c.JSON(http.StatusOK, gin.H{"status": "500", "code": 500, "group": client.clientGroup, "name": client.clientId, "error": scriptErr.err})
+		return
+	}
+	if ok && RequestParam.AwaitPromise {
+		raw, ok = jsResult(c, client, raw) // Promise被reject时返回错误信息
+	}
+	if !ok {
+		return
+	}
+	data, ok := resultData(c, raw, RequestParam)
+	if !ok {
+		return
+	}
+	c.JSON(200, gin.H{"status": "200", "group": client.clientGroup, "name": client.clientId, "data": data})
 
 }
 
-func getList(c *gin.Context) {
+// snippet 执行配置好的代码片段，禁用execjs后也可以用
+func (s *Server) snippet(c *gin.Context) {
+	var RequestParam ApiParam
+	if err := bindParam(c, &RequestParam); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	group := RequestParam.GroupName
+	if group == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入group")
+		return
+	}
+	tpl, ok := s.conf.Snippets[RequestParam.Name]
+	if !ok {
+		GinJsonMsg(c, http.StatusNotFound, "没有找到代码片段:"+RequestParam.Name)
+		return
+	}
+	if !s.checkFieldLength(c, "param", RequestParam.Param) {
+		return
+	}
+	code, err := utils.RenderSnippet(tpl, RequestParam.Param)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.checkPayloadSize(c, code) {
+		return
+	}
+	client := s.getRandomClient(namespace(c), group, RequestParam.ClientId)
+	if client == nil {
+		s.noClientResponse(c, group, RequestParam.ClientId)
+		return
+	}
+	raw, ok := s.request(c, client, Message{Action: "_execjs", Param: utils.NewJsCode(code).String()})
+	if ok {
+		raw, ok = jsResult(c, client, raw)
+	}
+	if !ok {
+		return
+	}
+	data, ok := resultData(c, raw, RequestParam)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "data": data})
+}
+
+func (s *Server) getList(c *gin.Context) {
+	f, err := parseListFilter(c)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := s.notModified(c)
+	if ok {
+		return
+	}
+	clients, total := s.listClients(c, f)
 	var data = make(map[string][]string)
-	hlSyncMap.Range(func(_, value interface{}) bool {
-		client, ok := value.(*Clients)
-		if !ok {
-			return true // 继续遍历
-		}
-		group := client.clientGroup
+	for _, client := range clients {
+		group := listGroup(c, client)
 		data[group] = append(data[group], client.clientId)
-		return true
-	})
-	c.JSON(http.StatusOK, gin.H{"status": 200, "data": data})
+	}
+	c.JSON(http.StatusOK, listEnvelope(f, data, total, version))
 }
 
-func index(c *gin.Context) {
-	c.String(200, "你好，我是黑脸怪~")
+// ClientDetail /details里每个客户端的信息
+type ClientDetail struct {
+	Namespace    string            `json:"namespace,omitempty"`
+	ClientId     string            `json:"clientId"`
+	ClientIp     string            `json:"clientIp"`
+	PageUrl      string            `json:"pageUrl"`
+	PageTitle    string            `json:"pageTitle"`
+	Compression  bool              `json:"compression"`
+	FailCount    int64             `json:"failCount"`
+	Transport    string            `json:"transport"`
+	Suspect      int64             `json:"suspect"`
+	Pending      int               `json:"pending"`        // 当前等待返回的请求数
+	Meta         *ClientMeta       `json:"meta,omitempty"` // 客户端_hello上报的信息
+	Tags         map[string]string `json:"tags"`
+	Actions      []string          `json:"actions"`          // 客户端注册的方法，null表示还没上报过
+	Status       *ClientStatus     `json:"status,omitempty"` // 客户端上报的负载
+	MaxQps       float64           `json:"maxQps"`           // 生效的qps限制，0不限制
+	Throttled    int64             `json:"throttled"`        // 因为超过qps被拒绝的次数
+	State        string            `json:"state"`            // online或grace(断开了在等待重连)
+	GraceUntil   *time.Time        `json:"graceUntil,omitempty"`
+	Offline      int               `json:"offline"`      // 等待重连期间缓存的请求数
+	OfflineAgeMs int64             `json:"offlineAgeMs"` // 最早缓存的请求等了多久
+	ConnectedAt  *time.Time        `json:"connectedAt,omitempty"`
+	ConnectedSec int64             `json:"connectedSec"` // 当前连接已经连了多少秒
+	LastPing     *time.Time        `json:"lastPing,omitempty"`
+	// 距离上次收到pong的秒数，没有收到过时为空
+	LastPongAgoSec *int64        `json:"lastPongAgoSec,omitempty"`
+	Requests       int64         `json:"requests"`  // 处理过的请求数
+	Successes      int64         `json:"successes"` // 成功返回的请求数
+	Timeouts       int64         `json:"timeouts"`  // 超时的请求数
+	Probes         int64         `json:"probes"`    // /readyz探测的次数
+	ProbeFailures  int64         `json:"probeFailures"`
+	Traffic        TrafficDetail `json:"traffic"` // 当前客户端收发的消息数和字节数
 }
 
-func tlsHandler(HttpsHost string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		secureMiddleware := secure.New(secure.Options{
-			SSLRedirect: true,
-			SSLHost:     HttpsHost,
-		})
-		err := secureMiddleware.Process(c.Writer, c.Request)
-		if err != nil {
-			c.Abort()
-			return
-		}
-		c.Next()
+func (c *Clients) detail() ClientDetail {
+	d := ClientDetail{
+		Namespace:   c.namespace,
+		ClientId:    c.clientId,
+		ClientIp:    c.getIp(),
+		Compression: c.compression,
+		FailCount:   c.failCount.Load(),
+		Transport:   c.transport,
+		Suspect:     c.suspectCount.Load(),
+		Pending:     c.pendingCount(),
+		Meta:        c.getMeta(),
+		Tags:        c.getTags(),
+		Actions:     c.actionList(),
+		Status:      c.getStatus(),
+		MaxQps:      c.maxQps(),
+		Throttled:   c.throttledCount.Load(),
 	}
-}
-
-func getGinMode(mode string) string {
-	switch mode {
-	case "release":
-		return gin.ReleaseMode
-	case "debug":
-		return gin.DebugMode
-	case "test":
-		return gin.TestMode
+	d.State, d.GraceUntil = c.state()
+	d.Offline, d.OfflineAgeMs = c.offlineQueue()
+	c.fillStats(&d)
+	if info := c.getPageInfo(); info != nil {
+		d.PageUrl, d.PageTitle = info.Url, info.Title
 	}
-	return gin.ReleaseMode // 默认就是release模式
+	return d
 }
 
-func setupRouters(conf config.ConfStruct) *gin.Engine {
-	router := gin.Default()
-	if conf.Cors { // 是否开启cors中间件
-		router.Use(CorsMiddleWare())
+// getClientDetails 比list多返回客户端ip和页面信息，方便区分同一个group下的客户端
+func (s *Server) getClientDetails(c *gin.Context) {
+	f, err := parseListFilter(c)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
 	}
-	return router
-}
-
-func InitAPI(conf config.ConfStruct) {
-	if conf.CloseWebLog {
-		// 将默认的日志输出器设置为空
-		gin.DefaultWriter = utils.LogWriter{}
+	format := detailsFormat(c)
+	if !slices.Contains(detailsFormats, format) {
+		GinJsonMsg(c, http.StatusBadRequest, "format只支持json、flat、csv")
+		return
 	}
-	gin.SetMode(getGinMode(conf.Mode))
-	router := setupRouters(conf)
-
-	setJsRpcRouters(router) // 核心路由
+	version := s.currentVersion()
+	clients, total := s.listClients(c, f)
+	var res gin.H
+	switch format {
+	case "csv":
+		writeDetailsCsv(c, clients)
+		return
+	case "flat":
+		data := make([]flatDetail, 0, len(clients))
+		for _, client := range clients {
+			data = append(data, flatDetail{Group: listGroup(c, client), ClientDetail: client.detail()})
+		}
+		res = listEnvelope(f, data, total, version)
+	case "json":
+		var data = make(map[string][]ClientDetail)
+		for _, client := range clients {
+			group := listGroup(c, client)
+			data[group] = append(data[group], client.detail())
+		}
+		res = listEnvelope(f, data, total, version)
+	}
+	if len(s.conf.Namespaces) == 0 || c.GetBool(ctxAllNamespaces) { // 连接数统计包含所有namespace
+		res["counts"] = s.clientCounts()
+	}
+	c.JSON(http.StatusOK, res)
+}
 
-	var sb strings.Builder
-	sb.WriteString("当前监听地址：")
-	sb.WriteString(conf.BasicListen)
+func index(c *gin.Context) {
+	c.String(200, "你好，我是黑脸怪~")
+}
 
-	sb.WriteString(" ssl启用状态：")
-	sb.WriteString(strconv.FormatBool(conf.HttpsServices.IsEnable))
+func (s *Server) getVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": gin.H{
+		"version": config.Version,
+		"routes":  s.activeRoutes,
+		"listen":  s.ListenAddrs(),
+		// BasicListen的地址被占用时实际使用的备用地址
+		"listenFallbacks": s.ListenFallbacks(),
+		"config":          s.conf.Source,
+		"limits": gin.H{ // 0表示不限制
+			"maxBodySize":    s.maxBodySize(),
+			"maxCodeLength":  s.conf.Limits.MaxCodeLength,
+			"maxParamLength": s.conf.Limits.MaxParamLength,
+		},
+	}})
+}
 
-	if conf.HttpsServices.IsEnable {
-		sb.WriteString(" https监听地址：")
-		sb.WriteString(conf.HttpsServices.HttpsListen)
-		router.Use(tlsHandler(conf.HttpsServices.HttpsListen))
-		go func() {
-			err := router.RunTLS(
-				conf.HttpsServices.HttpsListen,
-				conf.HttpsServices.PemPath,
-				conf.HttpsServices.KeyPath,
-			)
-			if err != nil {
-				log.Error(err)
-			}
-		}()
-	}
-	log.Infoln(sb.String())
+func healthz(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
 
-	err := router.Run(conf.BasicListen)
-	if err != nil {
-		log.Errorln("服务启动失败..")
+// tlsHandler 明文请求跳转到https，websocket客户端没法跟随跳转 所以升级请求直接放行
+func tlsHandler(HttpsListen string) gin.HandlerFunc {
+	_, httpsPort, _ := net.SplitHostPort(HttpsListen)
+	hostFunc := secure.SSLHostFunc(func(host string) string {
+		hostname, _, err := net.SplitHostPort(host)
+		if err != nil {
+			hostname = host
+		}
+		return net.JoinHostPort(hostname, httpsPort)
+	})
+	secureMiddleware := secure.New(secure.Options{
+		SSLRedirect: true,
+		SSLHostFunc: &hostFunc,
+	})
+	return func(c *gin.Context) {
+		if c.IsWebsocket() {
+			c.Next()
+			return
+		}
+		err := secureMiddleware.Process(c.Writer, c.Request)
+		if err != nil {
+			c.Abort()
+			return
+		}
+		c.Next()
 	}
 }
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"JsRpc/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// echoHandlers 回显param的hello和_execjs
+func echoHandlers() map[string]func(string) string {
+	return map[string]func(string) string{
+		"hello":   func(param string) string { return param },
+		"other":   func(param string) string { return param },
+		"_execjs": func(string) string { return "executed" },
+	}
+}
+
+func TestAllowedActions(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.Security.AllowedActions = map[string][]string{"g": {"hello"}}
+	})
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+	startFake(t, s, wsPeer{group: "open", clientId: "c"}, echoHandlers())
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"白名单里的action", "/go?group=g&action=hello&param=1", http.StatusOK},
+		{"不在白名单里", "/go?group=g&action=other&param=1", http.StatusForbidden},
+		{"没有配置白名单的group", "/go?group=open&action=other&param=1", http.StatusOK},
+		{"通配符匹配到有白名单的group", "/go?group=*&action=other", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(s, http.MethodGet, tt.target, "")
+			if w.Code != tt.want {
+				t.Fatalf("状态码%d，期望%d：%s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+	if _, err := s.Call(testContext(t), "g", "", "other", ""); !errors.Is(err, ErrActionForbidden) {
+		t.Fatalf("Call调用不在白名单里的action应该返回ErrActionForbidden，得到 %v", err)
+	}
+	w := serveRequest(s, http.MethodPost, "/pipeline", `{"group":"g","steps":[{"action":"hello"},{"action":"other"}]}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("pipeline里有不在白名单的步骤时返回%d，期望403", w.Code)
+	}
+}
+
+func TestDisableExecjs(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.Security.DisableExecjs = true })
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"/execjs路由被去掉", http.MethodGet, "/execjs?group=g&code=1", "", http.StatusNotFound},
+		{"/go不能调用_execjs", http.MethodGet, "/go?group=g&action=_execjs&param=1", "", http.StatusForbidden},
+		{"/go不能调用其它内置action", http.MethodGet, "/go?group=g&action=_getCookie", "", http.StatusForbidden},
+		{"pipeline不能执行代码", http.MethodPost, "/pipeline", `{"group":"g","steps":[{"code":"1"}]}`, http.StatusForbidden},
+		{"pipeline不能调用内置action", http.MethodPost, "/pipeline", `{"group":"g","steps":[{"action":"_execjs","param":"1"}]}`, http.StatusForbidden},
+		{"普通action不受影响", http.MethodGet, "/go?group=g&action=hello&param=1", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(s, tt.method, tt.target, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("状态码%d，期望%d：%s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+	if _, err := s.Call(testContext(t), "g", "", "_execjs", "1"); !errors.Is(err, ErrActionForbidden) {
+		t.Fatalf("Call调用_execjs应该返回ErrActionForbidden，得到 %v", err)
+	}
+	res := s.callerQuery(testContext(t), "", callerRequest{Id: "1", Group: "g", Action: "_execjs", Param: "1"})
+	if res.Status != http.StatusForbidden {
+		t.Fatalf("/ws/caller调用_execjs返回%d，期望403", res.Status)
+	}
+}
+
+func TestExecjsEnabled(t *testing.T) {
+	s := newTestServer(t, nil)
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+	w := serveRequest(s, http.MethodGet, "/go?group=g&action=_execjs&param=1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("没有禁用execjs时/go调用_execjs返回%d：%s", w.Code, w.Body.String())
+	}
+}
+
+func TestResponseDataRawJson(t *testing.T) {
+	tests := []struct {
+		raw     string
+		rawJson bool
+		want    string // 编码进响应后的json
+	}{
+		{`{"a":{"b":[1,2,{"c":"中文"}]}}`, true, `{"a":{"b":[1,2,{"c":"中文"}]}}`},
+		{`[1,"二",null]`, true, `[1,"二",null]`},
+		{`123`, true, `123`},
+		{`"带引号的字符串"`, true, `"带引号的字符串"`},
+		{`not json`, true, `"not json"`},
+		{`{"a":1`, true, `"{\"a\":1"`},
+		{`{"a":1}`, false, `"{\"a\":1}"`},
+		{`😀`, true, `"😀"`},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(responseData(tt.raw, tt.rawJson))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("responseData(%q, %v) = %s，期望%s", tt.raw, tt.rawJson, data, tt.want)
+		}
+	}
+}
+
+func TestGoRawJson(t *testing.T) {
+	s := newTestServer(t, nil)
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"obj": func(string) string { return `{"name":"张三","tags":["a","😀"],"nested":{"n":1.5,"ok":true}}` },
+		"str": func(string) string { return `123` },
+	})
+	w := serveRequest(s, http.MethodGet, "/go?group=g&action=obj&rawJson=true", "")
+	body := decodeBody(t, w)
+	data, ok := body["data"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("rawJson=true时data应该是对象：%s", w.Body.String())
+	}
+	nested, _ := data["nested"].(map[string]interface{})
+	if data["name"] != "张三" || nested["n"] != 1.5 || nested["ok"] != true {
+		t.Fatalf("嵌套对象或中文不对：%s", w.Body.String())
+	}
+
+	w = serveRequest(s, http.MethodGet, "/go?group=g&action=obj", "")
+	if _, ok := decodeBody(t, w)["data"].(string); !ok {
+		t.Fatalf("默认data应该还是字符串：%s", w.Body.String())
+	}
+	// 看起来像json的字符串在rawJson=true时会变成数字，README里说明了这种情况不要加rawJson
+	w = serveRequest(s, http.MethodGet, "/go?group=g&action=str&rawJson=true", "")
+	if decodeBody(t, w)["data"] != float64(123) {
+		t.Fatalf("rawJson=true时\"123\"应该按数字返回：%s", w.Body.String())
+	}
+}
+
+// countingConn 统计读写的字节数，用来比较压缩前后线路上的大小
+type countingConn struct {
+	net.Conn
+	n *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// testHtml 大约size字节的页面，结构重复，和真实页面一样容易压缩
+func testHtml(size int) []byte {
+	var sb strings.Builder
+	sb.WriteString("<html><body>")
+	for i := 0; sb.Len() < size; i++ {
+		fmt.Fprintf(&sb, `<div class="item" data-id="%d"><a href="/goods/%d">商品%d</a><span class="price">%d.00</span></div>`, i, i, i, i%997)
+	}
+	sb.WriteString("</body></html>")
+	return []byte(sb.String())
+}
+
+// BenchmarkWsTestCompression 通过/wst回显1MB的html，对比开启permessage-deflate前后的耗时和线路上的字节数
+func BenchmarkWsTestCompression(b *testing.B) {
+	payload := testHtml(1 << 20)
+	for _, compress := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compression=%v", compress), func(b *testing.B) {
+			s := newTestServer(b, func(conf *config.ConfStruct) { conf.Websocket.EnableCompression = compress })
+			srv := httptest.NewServer(s.Handler())
+			defer srv.Close()
+			var wire atomic.Int64
+			dialer := websocket.Dialer{
+				EnableCompression: compress,
+				NetDial: func(network, addr string) (net.Conn, error) {
+					conn, err := net.Dial(network, addr)
+					return countingConn{conn, &wire}, err
+				},
+			}
+			ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/wst", nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ws.Close()
+			wire.Store(0)
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := ws.ReadMessage(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(wire.Load())/float64(b.N), "wire-B/op")
+		})
+	}
+}
+
+func TestParamTemplate(t *testing.T) {
+	t.Setenv("JSRPC_TEST_TOKEN", "令牌")
+	t.Setenv("JSRPC_TEST_SECRET", "secret")
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.Template = config.TemplateConfig{IsEnable: true, EnvAllow: []string{"JSRPC_TEST_TOKEN"}}
+	})
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+	tests := []struct {
+		name  string
+		param string
+		code  int
+		want  string
+	}{
+		{"允许的环境变量", "{{env:JSRPC_TEST_TOKEN}}", http.StatusOK, "令牌"},
+		{"转义", `\{{uuid}}`, http.StatusOK, "{{uuid}}"},
+		{"中文参数", "参数{{date:2006}}", http.StatusOK, "参数" + time.Now().Format("2006")},
+		{"未知占位符", "{{nope}}", http.StatusBadRequest, ""},
+		{"不在白名单的环境变量", "{{env:JSRPC_TEST_SECRET}}", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(s, http.MethodGet, "/go?group=g&action=hello&param="+url.QueryEscape(tt.param), "")
+			if w.Code != tt.code {
+				t.Fatalf("状态码%d，期望%d：%s", w.Code, tt.code, w.Body.String())
+			}
+			if tt.code == http.StatusOK && decodeBody(t, w)["data"] != tt.want {
+				t.Fatalf("客户端收到的参数是%v，期望%q", decodeBody(t, w)["data"], tt.want)
+			}
+		})
+	}
+
+	// 没有开启时原样发送
+	s = newTestServer(t, nil)
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+	w := serveRequest(s, http.MethodGet, "/go?group=g&action=hello&param="+url.QueryEscape("{{nope}}"), "")
+	if decodeBody(t, w)["data"] != "{{nope}}" {
+		t.Fatalf("没有开启Template时应该原样发送：%s", w.Body.String())
+	}
+}
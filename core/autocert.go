@@ -0,0 +1,45 @@
+package core
+
+import (
+	"JsRpc/config"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/acme/autocert"
+	"net"
+	"slices"
+)
+
+// newAutoCertManager 按配置创建Let's Encrypt证书管理器，只给配置的域名签发证书，到期前自动续期
+func newAutoCertManager(conf config.AutoCertConfig) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(conf.Domains...),
+		Cache:      autocert.DirCache(conf.CacheDir),
+	}
+}
+
+// checkAutoCert HTTP-01验证要求明文监听在80端口，https监听在443端口
+func checkAutoCert(conf config.ConfStruct) error {
+	autoConf := conf.HttpsServices.AutoCert
+	if len(autoConf.Domains) == 0 {
+		return errors.New("AutoCert.Domains 不能为空")
+	}
+	if autoConf.CacheDir == "" {
+		return errors.New("AutoCert.CacheDir 不能为空")
+	}
+	if !slices.ContainsFunc(conf.BasicListen, func(addr string) bool { return listenPort(addr) == "80" }) {
+		return fmt.Errorf("HTTP-01验证需要BasicListen监听80端口，当前为：%q", conf.BasicListen)
+	}
+	if port := listenPort(conf.HttpsServices.HttpsListen); port != "443" {
+		return fmt.Errorf("自动证书需要HttpsListen监听443端口，当前为：%q", conf.HttpsServices.HttpsListen)
+	}
+	return nil
+}
+
+func listenPort(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return port
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// 触发自动封禁的行为
+const (
+	strikeMalformed = "malformed" // 发送格式错误的消息
+	strikeAuth      = "auth"      // adminToken错误
+	strikeSignature = "signature" // 返回的签名验证失败
+
+	defaultBanWindow   = 60  // 秒
+	defaultBanDuration = 600 // 秒
+)
+
+// Ban 一条临时封禁
+type Ban struct {
+	Ip      string    `json:"ip"`
+	Reason  string    `json:"reason"`
+	Expires time.Time `json:"expires"`
+}
+
+// banList 静态的CIDR黑名单加上自动的临时封禁，临时封禁可以持久化到json文件
+type banList struct {
+	deny       []*net.IPNet
+	adminAllow []*net.IPNet // 被封禁时还能访问/bans的ip
+
+	mu      sync.Mutex
+	bans    map[string]Ban
+	strikes map[string][]time.Time // kind|ip -> 最近的违规时间
+}
+
+// parseNets 支持CIDR和单个ip，field是出错时提示的配置项
+func parseNets(field string, list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s配置错误：%s", field, item)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (s *Server) initBans() error {
+	deny, err := parseNets("Bans.Deny", s.conf.Bans.Deny)
+	if err != nil {
+		return err
+	}
+	adminAllow, err := parseNets("Bans.AdminAllow", s.conf.Bans.AdminAllow)
+	if err != nil {
+		return err
+	}
+	s.bans = &banList{deny: deny, adminAllow: adminAllow, bans: make(map[string]Ban), strikes: make(map[string][]time.Time)}
+	if s.conf.Bans.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.conf.Bans.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []Ban
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("读取封禁列表%s失败：%w", s.conf.Bans.Path, err)
+	}
+	for _, b := range saved {
+		if time.Now().Before(b.Expires) {
+			s.bans.bans[b.Ip] = b
+		}
+	}
+	return nil
+}
+
+func containsIp(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// banned 返回ip是否被封禁以及原因
+func (s *Server) banned(ip string) (bool, string) {
+	if parsed := net.ParseIP(ip); parsed != nil && containsIp(s.bans.deny, parsed) {
+		return true, "ip在黑名单中"
+	}
+	s.bans.mu.Lock()
+	defer s.bans.mu.Unlock()
+	b, ok := s.bans.bans[ip]
+	if !ok {
+		return false, ""
+	}
+	if time.Now().After(b.Expires) {
+		delete(s.bans.bans, ip)
+		return false, ""
+	}
+	return true, b.Reason
+}
+
+// strike 记录一次违规，窗口内达到上限时临时封禁，返回是否被封禁
+func (s *Server) strike(ip string, kind string) bool {
+	conf := s.conf.Bans
+	limit := conf.MaxMalformed
+	switch kind {
+	case strikeAuth:
+		limit = conf.MaxAuthFailures
+	case strikeSignature:
+		limit = orDefault(s.conf.Security.Hmac.MaxFailures, defaultMaxSignatureFailures)
+	}
+	if limit <= 0 || ip == "" {
+		return false
+	}
+	window := time.Duration(orDefault(conf.Window, defaultBanWindow)) * time.Second
+	now := time.Now()
+	key := kind + "|" + ip
+	s.bans.mu.Lock()
+	recent := s.bans.strikes[key][:0]
+	for _, t := range s.bans.strikes[key] {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < limit {
+		s.bans.strikes[key] = recent
+		s.bans.mu.Unlock()
+		return false
+	}
+	delete(s.bans.strikes, key)
+	duration := time.Duration(orDefault(conf.Duration, defaultBanDuration)) * time.Second
+	b := Ban{Ip: ip, Reason: fmt.Sprintf("%d秒内%s达到%d次", int(window.Seconds()), kind, limit), Expires: now.Add(duration)}
+	s.bans.bans[ip] = b
+	s.bans.mu.Unlock()
+	log.Warning("封禁ip:", ip, " ", b.Reason, " 到期时间:", b.Expires.Format(time.DateTime))
+	s.saveBans()
+	return true
+}
+
+func orDefault(v int, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// Bans 返回还没到期的临时封禁，最晚到期的在前
+func (s *Server) Bans() []Ban {
+	s.bans.mu.Lock()
+	defer s.bans.mu.Unlock()
+	now := time.Now()
+	list := make([]Ban, 0, len(s.bans.bans))
+	for ip, b := range s.bans.bans {
+		if now.After(b.Expires) {
+			delete(s.bans.bans, ip)
+			continue
+		}
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Expires.After(list[j].Expires) })
+	return list
+}
+
+// Unban 解除临时封禁，静态黑名单只能改配置
+func (s *Server) Unban(ip string) bool {
+	s.bans.mu.Lock()
+	_, ok := s.bans.bans[ip]
+	delete(s.bans.bans, ip)
+	s.bans.mu.Unlock()
+	if ok {
+		s.saveBans()
+	}
+	return ok
+}
+
+// saveBans 配置了Bans.Path时把临时封禁写到文件，重启后继续生效
+func (s *Server) saveBans() {
+	if s.conf.Bans.Path == "" {
+		return
+	}
+	data, _ := json.MarshalIndent(s.Bans(), "", "  ")
+	if err := os.WriteFile(s.conf.Bans.Path, data, 0o644); err != nil {
+		log.Error("保存封禁列表失败:", err)
+	}
+}
+
+// canLiftBans 本机和Bans.AdminAllow里的ip被封禁后还能访问/bans，其他ip不能绕过封禁继续猜adminToken
+func (s *Server) canLiftBans(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && (parsed.IsLoopback() || containsIp(s.bans.adminAllow, parsed))
+}
+
+// BanCheck 被封禁的ip直接返回403，ws在升级之前就被拒绝
+// 配置了AdminToken时本机和Bans.AdminAllow访问/bans不检查，管理员被误封后还能自己解除
+func (s *Server) BanCheck() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.conf.AdminToken != "" && c.Request.URL.Path == s.activeRoutes["/bans"] && s.canLiftBans(c.ClientIP()) {
+			c.Next()
+			return
+		}
+		if banned, reason := s.banned(c.ClientIP()); banned {
+			GinJsonMsg(c, http.StatusForbidden, "ip已被封禁："+reason)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// getBans 查看临时封禁，DELETE /bans?ip=xx 解除封禁
+func (s *Server) getBans(c *gin.Context) {
+	if !requireGlobalAdmin(c) {
+		return
+	}
+	if c.Request.Method == http.MethodDelete {
+		ip := c.Query("ip")
+		if ip == "" {
+			GinJsonMsg(c, http.StatusBadRequest, "需要传入ip")
+			return
+		}
+		if !s.Unban(ip) {
+			GinJsonMsg(c, http.StatusNotFound, "没有找到这个ip的封禁")
+			return
+		}
+		GinJsonMsg(c, http.StatusOK, "已解除封禁")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": s.Bans()})
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"JsRpc/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// requestFrom 从remote这个ip调用s的路由，token不为空时带上X-Admin-Token
+func requestFrom(s *Server, remote string, method string, target string, token string) int {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = remote + ":1234"
+	if token != "" {
+		req.Header.Set("X-Admin-Token", token)
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	return w.Code
+}
+
+// 被封禁的ip不能再访问/bans继续猜adminToken，本机和Bans.AdminAllow可以解除封禁
+func TestBannedIpCannotReachBans(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.AdminToken = "secret"
+		conf.Bans.MaxAuthFailures = 2
+		conf.Bans.AdminAllow = []string{"10.0.0.0/8"}
+	})
+	for _, ip := range []string{"192.0.2.1", "10.1.2.3"} {
+		for i := 0; i < 2; i++ {
+			if code := requestFrom(s, ip, http.MethodGet, "/bans", "guess"); code != http.StatusUnauthorized {
+				t.Fatalf("%s第%d次猜错token返回%d", ip, i+1, code)
+			}
+		}
+		if banned, _ := s.banned(ip); !banned {
+			t.Fatalf("%s猜错token达到上限后应该被封禁", ip)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		ip     string
+		method string
+		target string
+		token  string
+		code   int
+	}{
+		{"被封禁的ip猜token", "192.0.2.1", http.MethodGet, "/bans", "guess", http.StatusForbidden},
+		{"被封禁的ip用对的token也不行", "192.0.2.1", http.MethodGet, "/bans", "secret", http.StatusForbidden},
+		{"被封禁的ip访问其它接口", "192.0.2.1", http.MethodGet, "/list", "", http.StatusForbidden},
+		{"AdminAllow里的ip还能访问/bans", "10.1.2.3", http.MethodGet, "/bans", "secret", http.StatusOK},
+		{"AdminAllow只放行/bans", "10.1.2.3", http.MethodGet, "/list", "", http.StatusForbidden},
+		{"本机解除封禁", "127.0.0.1", http.MethodDelete, "/bans?ip=192.0.2.1", "secret", http.StatusOK},
+		{"解除后可以访问", "192.0.2.1", http.MethodGet, "/list", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := requestFrom(s, tt.ip, tt.method, tt.target, tt.token); code != tt.code {
+			t.Fatalf("%s：返回%d，期望%d", tt.name, code, tt.code)
+		}
+	}
+}
+
+func TestBansAdminAllowConfig(t *testing.T) {
+	_, err := NewServer(config.ConfStruct{DefaultTimeOut: 5, Bans: config.BansConfig{AdminAllow: []string{"not-an-ip"}}})
+	if err == nil {
+		t.Fatal("Bans.AdminAllow配置错误时应该启动失败")
+	}
+}
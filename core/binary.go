@@ -0,0 +1,64 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"JsRpc/utils"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mimeOctetStream Accept里有它时二进制结果解码后直接返回
+const mimeOctetStream = "application/octet-stream"
+
+// binaryParam paramEncoding=base64时检查param，统一转换成带=的标准base64，客户端用atob解码
+func binaryParam(c *gin.Context, p ApiParam, param string) (string, bool) {
+	if !checkBinaryEncoding(c, "paramEncoding", p.ParamEncoding) || !checkBinaryEncoding(c, "responseEncoding", p.ResponseEncoding) {
+		return "", false
+	}
+	if p.ResponseEncoding != "" && p.Stream {
+		GinJsonMsg(c, http.StatusBadRequest, "responseEncoding不能和stream一起使用")
+		return "", false
+	}
+	if p.ParamEncoding == "" {
+		return param, true
+	}
+	if len(p.Args) > 0 || p.ArgsForm != "" {
+		GinJsonMsg(c, http.StatusBadRequest, "paramEncoding不能和args一起使用")
+		return "", false
+	}
+	decoded, err := decodeBase64(param)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, "param不是合法的base64："+err.Error())
+		return "", false
+	}
+	return base64.StdEncoding.EncodeToString([]byte(decoded)), true
+}
+
+func checkBinaryEncoding(c *gin.Context, field string, value string) bool {
+	if value != "" && value != protocol.EncodingBase64 {
+		GinJsonMsg(c, http.StatusBadRequest, field+"只支持base64")
+		return false
+	}
+	return true
+}
+
+// binaryResult responseEncoding=base64时客户端返回的是base64，默认原样放在data里，
+// Accept是application/octet-stream时解码后直接返回字节
+func binaryResult(c *gin.Context, client *Clients, raw string) {
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		// 旧版注入脚本不认识responseEncoding，或者方法执行出错
+		c.JSON(http.StatusOK, gin.H{"status": 500, "group": client.clientGroup, "clientId": client.clientId,
+			"error": "客户端返回的不是base64：" + err.Error(), "data": utils.Preview(raw, 200)})
+		return
+	}
+	if strings.Contains(c.GetHeader("Accept"), mimeOctetStream) {
+		c.Data(http.StatusOK, mimeOctetStream, data)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId,
+		"data": raw, "encoding": protocol.EncodingBase64})
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"testing"
+)
+
+// binaryClient 把base64的param解码后反转字节，再编码成base64返回；param不是标准base64、没带编码标记或者是broken时返回错误文字
+func binaryClient(t *testing.T, s *Server) {
+	t.Helper()
+	startReplier(t, s, wsPeer{group: "g", clientId: "c"}, func(req protocol.Envelope) protocol.Envelope {
+		if req.Action != "bin" {
+			return handlerReplies(nil)(req)
+		}
+		if req.ParamEncoding != protocol.EncodingBase64 || req.ResponseEncoding != protocol.EncodingBase64 {
+			return protocol.Response(req.Id, req.Action, "没有编码标记")
+		}
+		data, err := base64.StdEncoding.DecodeString(req.Param)
+		if err != nil {
+			return protocol.Response(req.Id, req.Action, "param不是标准base64："+err.Error())
+		}
+		if string(data) == "broken" {
+			return protocol.Response(req.Id, req.Action, "not base64!")
+		}
+		for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+		return protocol.Response(req.Id, req.Action, base64.StdEncoding.EncodeToString(data))
+	})
+}
+
+// binaryBody 调用bin的json请求体，paramEncoding和responseEncoding默认是base64
+func binaryBody(t *testing.T, values map[string]interface{}) string {
+	t.Helper()
+	values["group"], values["action"] = "g", "bin"
+	for _, field := range []string{"paramEncoding", "responseEncoding"} {
+		if values[field] == nil {
+			values[field] = "base64"
+		}
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func reversed(data []byte) []byte {
+	res := make([]byte, len(data))
+	for i, b := range data {
+		res[len(data)-1-i] = b
+	}
+	return res
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	s := newTestServer(t, nil)
+	binaryClient(t, s)
+	rnd := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rnd.Read(data)
+		return data
+	}
+	payloads := [][]byte{
+		{0},
+		{0, 0, 0xff},
+		[]byte("\xff\xfe\xc3\x28 不是utf8 \xe2\x82"),
+		append([]byte("\x00前缀"), random(257)...),
+		random(4096),
+	}
+	for _, payload := range payloads {
+		want := reversed(payload)
+		// url安全、没有=的base64也可以，转换成标准base64发给客户端
+		for _, param := range []string{base64.StdEncoding.EncodeToString(payload), base64.RawURLEncoding.EncodeToString(payload)} {
+			body := binaryBody(t, map[string]interface{}{"param": param})
+			res := decodeBody(t, serveRequest(s, http.MethodPost, "/go", body, "Content-Type", "application/json"))
+			data, err := base64.StdEncoding.DecodeString(res["data"].(string))
+			if err != nil || res["encoding"] != "base64" || !bytes.Equal(data, want) {
+				t.Fatalf("%d字节的json返回不一致：%v", len(payload), res)
+			}
+
+			w := serveRequest(s, http.MethodPost, "/go", body, "Content-Type", "application/json", "Accept", mimeOctetStream)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != mimeOctetStream || !bytes.Equal(w.Body.Bytes(), want) {
+				t.Fatalf("%d字节的二进制返回不一致：%d %s %x", len(payload), w.Code, w.Header().Get("Content-Type"), w.Body.Bytes())
+			}
+		}
+	}
+}
+
+func TestBinaryErrors(t *testing.T) {
+	s := newTestServer(t, nil)
+	binaryClient(t, s)
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		code   int // http状态码
+	}{
+		{"param不是base64", map[string]interface{}{"param": "不是base64"}, http.StatusBadRequest},
+		{"不支持的编码", map[string]interface{}{"param": "AA==", "paramEncoding": "hex"}, http.StatusBadRequest},
+		{"和args一起使用", map[string]interface{}{"param": "AA==", "args": []int{1}}, http.StatusBadRequest},
+		{"和stream一起使用", map[string]interface{}{"param": "AA==", "stream": true}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body := binaryBody(t, tt.values)
+		w := serveRequest(s, http.MethodPost, "/go", body, "Content-Type", "application/json")
+		if w.Code != tt.code {
+			t.Errorf("%s：期望%d，得到%d %s", tt.name, tt.code, w.Code, w.Body)
+		}
+	}
+
+	// 旧版客户端不认识responseEncoding，返回的不是base64
+	body := binaryBody(t, map[string]interface{}{"param": base64.StdEncoding.EncodeToString([]byte("broken"))})
+	res := decodeBody(t, serveRequest(s, http.MethodPost, "/go", body, "Content-Type", "application/json"))
+	if res["status"] != float64(500) || res["data"] == nil {
+		t.Fatalf("返回不是base64时应该报错：%v", res)
+	}
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMaxBodySize = 10 << 20 // 10MB
+
+// maxBodySize 生效的请求体上限，0表示不限制
+func (s *Server) maxBodySize() int64 {
+	switch limit := s.conf.Limits.MaxBodySize; {
+	case limit < 0:
+		return 0
+	case limit == 0:
+		return defaultMaxBodySize
+	default:
+		return limit
+	}
+}
+
+// BodyLimit 请求体超过Limits.MaxBodySize时返回413，不会把超大的请求体读进内存
+func (s *Server) BodyLimit() gin.HandlerFunc {
+	limit := s.maxBodySize()
+	return func(c *gin.Context) {
+		if limit <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			abortTooLarge(c, limit)
+			return
+		}
+		// 先读完再交给处理函数，超过上限时统一返回413，而不是各个接口的参数解析错误
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortTooLarge(c, limit)
+			return
+		} else if err != nil {
+			GinJsonMsg(c, http.StatusBadRequest, err.Error())
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+func abortTooLarge(c *gin.Context, limit int64) {
+	GinJsonMsg(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("请求体超过上限%d字节", limit))
+	c.Abort()
+}
+
+// fieldTooLong code和param分别按Limits.MaxCodeLength、Limits.MaxParamLength限制长度，没有超过时返回空字符串
+func (s *Server) fieldTooLong(field string, value string) string {
+	limit := s.conf.Limits.MaxParamLength
+	if field == "code" {
+		limit = s.conf.Limits.MaxCodeLength
+	}
+	if limit > 0 && len(value) > limit {
+		return fmt.Sprintf("%s长度%d超过上限%d", field, len(value), limit)
+	}
+	return ""
+}
+
+// checkFieldLength 超过长度上限时返回413
+func (s *Server) checkFieldLength(c *gin.Context, field string, value string) bool {
+	if msg := s.fieldTooLong(field, value); msg != "" {
+		GinJsonMsg(c, http.StatusRequestEntityTooLarge, msg)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"JsRpc/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func limitServer(t *testing.T) *Server {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.Limits = config.LimitsConfig{MaxBodySize: 1024, MaxParamLength: 10, MaxCodeLength: 5}
+	})
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+	return s
+}
+
+func formBody(values map[string]string) string {
+	form := url.Values{}
+	for k, v := range values {
+		form.Set(k, v)
+	}
+	return form.Encode()
+}
+
+const formType = "application/x-www-form-urlencoded"
+
+func TestBodyTooLarge(t *testing.T) {
+	s := limitServer(t)
+	big := formBody(map[string]string{"group": "g", "action": "hello", "param": strings.Repeat("x", 2048)})
+	w := serveRequest(s, http.MethodPost, "/go", big, "Content-Type", formType)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(decodeBody(t, w)["data"].(string), "1024") {
+		t.Fatalf("超过MaxBodySize时返回%d：%s", w.Code, w.Body.String())
+	}
+
+	// 不知道长度的请求体读到上限时停止
+	req := httptest.NewRequest(http.MethodPost, "/go", io.NopCloser(strings.NewReader(big)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", formType)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("没有Content-Length的超大请求体返回%d", rec.Code)
+	}
+
+	small := formBody(map[string]string{"group": "g", "action": "hello", "param": "ok"})
+	if w := serveRequest(s, http.MethodPost, "/go", small, "Content-Type", formType); w.Code != http.StatusOK {
+		t.Fatalf("没有超过上限的请求返回%d：%s", w.Code, w.Body.String())
+	}
+}
+
+func TestFieldTooLong(t *testing.T) {
+	s := limitServer(t)
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		code   int
+	}{
+		{"param超长", "/go", formBody(map[string]string{"group": "g", "action": "hello", "param": "12345678901"}), http.StatusRequestEntityTooLarge},
+		{"param刚好", "/go", formBody(map[string]string{"group": "g", "action": "hello", "param": "1234567890"}), http.StatusOK},
+		{"GET的param超长", "/go?group=g&action=hello&param=12345678901", "", http.StatusRequestEntityTooLarge},
+		{"param按字节计算", "/go", formBody(map[string]string{"group": "g", "action": "hello", "param": "中文字符"}), http.StatusRequestEntityTooLarge},
+		{"code超长", "/execjs", formBody(map[string]string{"group": "g", "code": "123456"}), http.StatusRequestEntityTooLarge},
+		{"code刚好", "/execjs", formBody(map[string]string{"group": "g", "code": "12345"}), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodPost
+			if tt.body == "" {
+				method = http.MethodGet
+			}
+			w := serveRequest(s, method, tt.target, tt.body, "Content-Type", formType)
+			if w.Code != tt.code {
+				t.Fatalf("状态码%d，期望%d：%s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLimitsInVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits config.LimitsConfig
+		want   map[string]float64
+	}{
+		{"配置的上限", config.LimitsConfig{MaxBodySize: 1024, MaxParamLength: 10, MaxCodeLength: 5},
+			map[string]float64{"maxBodySize": 1024, "maxParamLength": 10, "maxCodeLength": 5}},
+		{"默认10MB", config.LimitsConfig{}, map[string]float64{"maxBodySize": 10 << 20}},
+		{"负数不限制", config.LimitsConfig{MaxBodySize: -1}, map[string]float64{"maxBodySize": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(conf *config.ConfStruct) { conf.Limits = tt.limits })
+			data := decodeBody(t, serveRequest(s, http.MethodGet, "/version", ""))["data"].(map[string]interface{})
+			limits := data["limits"].(map[string]interface{})
+			for key, want := range tt.want {
+				if limits[key] != want {
+					t.Fatalf("/version的limits.%s = %v，期望%v", key, limits[key], want)
+				}
+			}
+		})
+	}
+}
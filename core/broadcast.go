@@ -0,0 +1,114 @@
+package core
+
+import (
+	"JsRpc/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// broadcastResult 一个客户端的返回
+type broadcastResult struct {
+	client *Clients
+	res    string
+	err    error
+}
+
+// broadcast 把同一个请求并发发给多个客户端，等全部返回或超时，结果顺序和clients一致
+func broadcast(ctx context.Context, clients []*Clients, msg Message) []broadcastResult {
+	results := make([]broadcastResult, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *Clients) {
+			defer wg.Done()
+			res, err := client.query(ctx, msg)
+			results[i] = broadcastResult{client, res, err}
+		}(i, client)
+	}
+	wg.Wait()
+	return results
+}
+
+// controlRequest /admin/broadcastControl 的请求体，data是字符串时原样发送，其它json按原文发送
+type controlRequest struct {
+	Name     string          `json:"name"`
+	Data     json.RawMessage `json:"data"`
+	Group    string          `json:"group"`
+	Selector string          `json:"selector"` // 标签筛选，如 region=us,account=alice
+}
+
+// broadcastControl 给所有客户端(或group、标签匹配的客户端)发送自定义的控制消息，
+// 只管写入发送队列，不等客户端返回；返回每个客户端是否发送成功
+func (s *Server) broadcastControl(c *gin.Context) {
+	var req controlRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Name == "" || strings.HasPrefix(req.Name, "_") {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入name，下划线开头的名字保留给内置的控制消息")
+		return
+	}
+	if req.Group == "" && !requireGlobalAdmin(c) || req.Group != "" && !requireAdminGroup(c, req.Group) {
+		return
+	}
+	match := func(string) bool { return true }
+	if req.Group != "" {
+		var err error
+		if match, err = config.MatchGroup(req.Group); err != nil {
+			GinJsonMsg(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	selector, err := parseTags(req.Selector)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	data := string(req.Data)
+	if text := ""; json.Unmarshal(req.Data, &text) == nil {
+		data = text
+	}
+
+	var clients []*Clients
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		client, ok := value.(*Clients)
+		if ok && visible(c, client.namespace) && match(client.clientGroup) && client.matchTags(selector) && !client.inGrace() {
+			clients = append(clients, client)
+		}
+		return true
+	})
+	results := make([]gin.H, len(clients))
+	failed := 0
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *Clients) {
+			defer wg.Done()
+			item := gin.H{"group": listGroup(c, client), "clientId": client.clientId, "ok": true}
+			if err := client.sendControl(req.Name, data); err != nil {
+				if !errors.Is(err, errSendQueueFull) { // 队列满时send已经记录过
+					client.failCount.Add(1)
+				}
+				item["ok"], item["error"] = false, err.Error()
+			}
+			results[i] = item
+		}(i, client)
+	}
+	wg.Wait()
+	for _, item := range results {
+		if item["ok"] == false {
+			failed++
+		}
+	}
+	log.Warnf("管理接口广播了控制消息 ip:%s name:%s group:%s selector:%s 客户端:%d 失败:%d",
+		c.ClientIP(), req.Name, req.Group, req.Selector, len(clients), failed)
+	c.JSON(http.StatusOK, gin.H{"status": 200, "sent": len(clients) - failed, "failed": failed, "data": results})
+}
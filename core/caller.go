@@ -0,0 +1,184 @@
+package core
+
+import (
+	"JsRpc/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultCallerMaxPending = 100 // 每个调用端连接默认最多同时等待的请求数
+
+// callerRequest 调用端通过/ws/caller发送的请求，id由调用端生成，原样带回
+type callerRequest struct {
+	Id       string          `json:"id"`
+	Group    string          `json:"group"`
+	ClientId string          `json:"clientId"`
+	Action   string          `json:"action"`
+	Param    string          `json:"param"`
+	Args     json.RawMessage `json:"args"`
+	Priority string          `json:"priority"` // high、normal、low
+	// 客户端断开重连后重新发送，需要配置Websocket.ReconnectGrace
+	ResendOnReconnect bool `json:"resendOnReconnect"`
+}
+
+// callerResponse status和http接口一致，超时为504，同时等待的请求太多为429
+type callerResponse struct {
+	Id        string   `json:"id"`
+	Status    int      `json:"status"`
+	ClientId  string   `json:"clientId,omitempty"`
+	Data      string   `json:"data"`
+	ElapsedMs int64    `json:"elapsed_ms"`
+	Reason    string   `json:"reason,omitempty"` // 选不到客户端的原因，和http接口的reason一致
+	Groups    []string `json:"groups,omitempty"`
+	Actions   []string `json:"actions,omitempty"`
+}
+
+// callerConn 一个调用端连接，多个请求的结果并发写回
+type callerConn struct {
+	ws      wsConn
+	writeMu sync.Mutex
+}
+
+func (cc *callerConn) reply(res callerResponse) {
+	data, _ := json.Marshal(res)
+	cc.writeMu.Lock()
+	defer cc.writeMu.Unlock()
+	_ = cc.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := cc.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+		_ = cc.ws.Close()
+	}
+}
+
+// wsCaller 调用端的长连接接口，一个连接上可以同时发起多个请求，减少http每次调用的开销
+func (s *Server) wsCaller(c *gin.Context) {
+	ws, err := s.upGrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Error("websocket err:", err)
+		return
+	}
+	if s.conf.Websocket.MaxMessageSize > 0 {
+		ws.SetReadLimit(s.conf.Websocket.MaxMessageSize)
+	}
+	maxPending := s.conf.Websocket.CallerMaxPending
+	if maxPending <= 0 {
+		maxPending = defaultCallerMaxPending
+	}
+	cc := &callerConn{ws: ws}
+	ns := namespace(c) // 升级请求里的apiKey决定整个连接的namespace
+	slots := make(chan struct{}, maxPending)
+	ctx, cancel := context.WithCancel(withCaller(context.Background(), httpCaller(c)))
+	var wg sync.WaitGroup
+	defer func() {
+		cancel() // 连接断开后还没返回的请求直接放弃
+		wg.Wait()
+		_ = ws.Close()
+	}()
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req callerRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			cc.reply(callerResponse{Status: http.StatusBadRequest, Data: "消息格式错误:" + err.Error()})
+			continue
+		}
+		if status, msg := s.checkCallerRequest(req); status != http.StatusOK {
+			cc.reply(callerResponse{Id: req.Id, Status: status, Data: msg})
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			cc.reply(callerResponse{Id: req.Id, Status: http.StatusTooManyRequests, Data: "同时等待的请求太多"})
+			continue
+		}
+		wg.Add(1)
+		go func(start time.Time) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			res := s.callerQuery(ctx, ns, req)
+			res.ElapsedMs = time.Since(start).Milliseconds()
+			cc.reply(res)
+		}(time.Now())
+	}
+}
+
+func (s *Server) checkCallerRequest(req callerRequest) (int, string) {
+	switch {
+	case req.Id == "":
+		return http.StatusBadRequest, "需要传入id"
+	case req.Group == "":
+		return http.StatusBadRequest, "需要传入group"
+	case config.CheckGroupPattern(req.Group) != nil:
+		return http.StatusBadRequest, config.CheckGroupPattern(req.Group).Error()
+	case req.Action == "":
+		return http.StatusBadRequest, "需要传入action"
+	case s.fieldTooLong("param", req.Param) != "":
+		return http.StatusRequestEntityTooLarge, s.fieldTooLong("param", req.Param)
+	}
+	return http.StatusOK, ""
+}
+
+func (s *Server) callerQuery(ctx context.Context, ns string, req callerRequest) callerResponse {
+	res := callerResponse{Id: req.Id, Status: http.StatusOK}
+	client, err := s.getActionClient(ns, req.Group, req.ClientId, req.Action)
+	if err != nil {
+		res.Status, res.Data = errorStatus(err)
+		var e *NoClientError
+		if errors.As(err, &e) {
+			res.Reason, res.Groups, res.Actions = e.Reason, e.Groups, e.Actions
+		}
+		return res
+	}
+	res.ClientId = client.clientId
+	args, err := ApiParam{Args: req.Args}.parseArgs()
+	if err != nil {
+		res.Status, res.Data = http.StatusBadRequest, err.Error()
+		return res
+	}
+	priority, err := parsePriority(req.Priority)
+	if err != nil {
+		res.Status, res.Data = http.StatusBadRequest, err.Error()
+		return res
+	}
+	msg := Message{Action: req.Action, Param: req.Param}
+	if args != nil {
+		msg.Param, msg.Args = string(args), args
+	}
+	data, err := s.runQuery(withResend(withPriority(ctx, priority), req.ResendOnReconnect), client, msg)
+	if err != nil {
+		res.Status, res.Data = errorStatus(err)
+		return res
+	}
+	res.Data = data
+	return res
+}
+
+// errorStatus 调用出错时对应的状态码和返回内容，超时为504
+func errorStatus(err error) (int, string) {
+	var e *NoClientError
+	switch {
+	case errors.As(err, &e):
+		return e.StatusCode(), e.Error()
+	case errors.Is(err, ErrActionForbidden):
+		return http.StatusForbidden, err.Error()
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, TimeoutMsg
+	case errors.Is(err, ErrRejected):
+		return http.StatusForbidden, err.Error()
+	case errors.Is(err, ErrTooManyPending), errors.Is(err, ErrThrottled):
+		return http.StatusTooManyRequests, err.Error()
+	}
+	return http.StatusServiceUnavailable, err.Error()
+}
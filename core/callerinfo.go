@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	headerCallerName     = "X-Caller-Name"
+	callerNameLimit      = 64  // X-Caller-Name最多保留的字节数
+	callerUserAgentLimit = 256 // User-Agent最多保留的字节数
+	maxCallerNames       = 256 // 按调用方统计时最多区分这么多个名字，之后的都算在callerOther里
+	callerAnonymous      = "-" // 没有带X-Caller-Name的调用方
+	callerOther          = "(other)"
+)
+
+// CallerInfo 调用方的信息，多个团队共用一个JsRpc时用来区分请求来源
+type CallerInfo struct {
+	Ip        string `json:"ip"`
+	Name      string `json:"name,omitempty"` // X-Caller-Name头
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+type callerKey struct{}
+
+// withCaller 把调用方信息放进ctx，/inflight、请求记录和日志里展示
+func withCaller(ctx context.Context, caller CallerInfo) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// withCallerIp 只知道ip的调用方，如gRPC和定时任务
+func withCallerIp(ctx context.Context, ip string) context.Context {
+	return withCaller(ctx, CallerInfo{Ip: ip})
+}
+
+func callerFromContext(ctx context.Context) CallerInfo {
+	caller, _ := ctx.Value(callerKey{}).(CallerInfo)
+	return caller
+}
+
+// httpCaller 从http请求里取调用方信息，名字和UA都经过清理，不会在日志和导出里插入换行等内容
+func httpCaller(c *gin.Context) CallerInfo {
+	return CallerInfo{
+		Ip:        c.ClientIP(),
+		Name:      sanitizeCallerName(c.GetHeader(headerCallerName)),
+		UserAgent: sanitizeHeader(c.GetHeader("User-Agent"), callerUserAgentLimit),
+	}
+}
+
+// sanitizeCallerName 名字只保留字母、数字和 -_.@/: ，其它字符换成_
+func sanitizeCallerName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.@/:", r) {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+	return truncateBytes(name, callerNameLimit)
+}
+
+// sanitizeHeader 去掉控制字符和非法的utf8，按字节截断
+func sanitizeHeader(value string, limit int) string {
+	value = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value)
+	return truncateBytes(strings.TrimSpace(value), limit)
+}
+
+// CallerStats 一个调用方名字的请求统计，被hook拒绝的也算
+type CallerStats struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	Timeouts int64 `json:"timeouts"`
+}
+
+type callerCounter struct {
+	requests, errors, timeouts atomic.Int64
+}
+
+// callerStats 按X-Caller-Name分开的计数，名字太多时合并到callerOther，防止随便发名字把内存撑大
+type callerStats struct {
+	mu       sync.Mutex
+	counters map[string]*callerCounter
+}
+
+func (cs *callerStats) count(name string, err error) {
+	if name == "" {
+		name = callerAnonymous
+	}
+	cs.mu.Lock()
+	if cs.counters == nil {
+		cs.counters = make(map[string]*callerCounter)
+	}
+	counter := cs.counters[name]
+	if counter == nil {
+		if len(cs.counters) >= maxCallerNames {
+			name = callerOther
+			counter = cs.counters[name]
+		}
+		if counter == nil {
+			counter = &callerCounter{}
+			cs.counters[name] = counter
+		}
+	}
+	cs.mu.Unlock()
+	counter.requests.Add(1)
+	switch {
+	case errors.Is(err, ErrTimeout):
+		counter.timeouts.Add(1)
+	case err != nil:
+		counter.errors.Add(1)
+	}
+}
+
+func (cs *callerStats) snapshot() map[string]CallerStats {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	res := make(map[string]CallerStats, len(cs.counters))
+	for name, counter := range cs.counters {
+		res[name] = CallerStats{Requests: counter.requests.Load(), Errors: counter.errors.Load(), Timeouts: counter.timeouts.Load()}
+	}
+	return res
+}
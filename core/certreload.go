@@ -0,0 +1,93 @@
+package core
+
+import (
+	"crypto/tls"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// certCheckInterval 多久检查一次证书文件的修改时间
+const certCheckInterval = 10 * time.Second
+
+// certReloader 证书文件修改时间变化或收到SIGHUP时重新加载证书，只影响之后新建的连接。
+// 检查在watch的goroutine里定时进行，握手时只读取当前证书，不访问文件也不加锁
+type certReloader struct {
+	pemPath  string
+	keyPath  string
+	interval time.Duration
+
+	cert     atomic.Pointer[tls.Certificate]
+	pemMtime time.Time // 只在newCertReloader和watch的goroutine里访问
+	keyMtime time.Time
+}
+
+func newCertReloader(pemPath, keyPath string) (*certReloader, error) {
+	r := &certReloader{pemPath: pemPath, keyPath: keyPath, interval: certCheckInterval}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload 加载新证书，加载失败时继续使用旧证书
+func (r *certReloader) reload() error {
+	// 不管成功与否都记录修改时间，避免坏证书在每次检查时反复加载和报错
+	r.pemMtime, r.keyMtime = fileMtime(r.pemPath), fileMtime(r.keyPath)
+	cert, err := tls.LoadX509KeyPair(r.pemPath, r.keyPath)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *certReloader) changed() bool {
+	return !fileMtime(r.pemPath).Equal(r.pemMtime) || !fileMtime(r.keyPath).Equal(r.keyMtime)
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+func (r *certReloader) reloadAndLog() {
+	if err := r.reload(); err != nil {
+		log.Error("证书重新加载失败，继续使用旧证书：", err)
+		return
+	}
+	log.Infoln("证书已重新加载")
+}
+
+// watch 定时检查证书文件是否修改，收到SIGHUP时强制重新加载，stop关闭后退出
+func (r *certReloader) watch(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if r.changed() {
+					r.reloadAndLog()
+				}
+			case <-hup:
+				r.reloadAndLog()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func fileMtime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
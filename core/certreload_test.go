@@ -0,0 +1,103 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// handshakeName 和GetCertificate握手，返回对方证书的CommonName
+func handshakeName(t *testing.T, getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: getCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 3 * time.Second}, "tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// touchLater 把文件的修改时间往后调，保证和上次记录的不同
+func touchLater(t *testing.T, paths ...string) {
+	t.Helper()
+	later := time.Now().Add(time.Minute)
+	for _, path := range paths {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertReloadOnFileChange(t *testing.T) {
+	dir := t.TempDir()
+	pemPath, keyPath := writeTestCert(t, dir, "old")
+	r, err := newCertReloader(pemPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.interval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	r.watch(stop)
+	if name := handshakeName(t, r.GetCertificate); name != "old" {
+		t.Fatalf("握手拿到的证书是%q，期望old", name)
+	}
+
+	writeTestCert(t, dir, "new")
+	touchLater(t, pemPath, keyPath)
+	waitFor(t, "证书重新加载", func() bool { return certName(r) == "new" })
+	if name := handshakeName(t, r.GetCertificate); name != "new" {
+		t.Fatalf("换证书后新握手拿到的是%q，期望new", name)
+	}
+}
+
+func TestCertReloadKeepsOldCertOnError(t *testing.T) {
+	dir := t.TempDir()
+	pemPath, keyPath := writeTestCert(t, dir, "old")
+	r, err := newCertReloader(pemPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pemPath, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Fatal("坏证书应该加载失败")
+	}
+	if r.changed() {
+		t.Fatal("加载失败后也要记录修改时间，否则每次检查都会重新加载")
+	}
+	if name := handshakeName(t, r.GetCertificate); name != "old" {
+		t.Fatalf("加载失败后应该继续使用旧证书，拿到的是%q", name)
+	}
+}
+
+func TestNewCertReloaderFailsOnMissingFile(t *testing.T) {
+	if _, err := newCertReloader("/nonexistent/cert.pem", "/nonexistent/key.pem"); err == nil {
+		t.Fatal("证书文件不存在时应该返回错误")
+	}
+}
+
+// certName 当前证书的CommonName
+func certName(r *certReloader) string {
+	leaf, err := x509.ParseCertificate(r.cert.Load().Certificate[0])
+	if err != nil {
+		return ""
+	}
+	return leaf.Subject.CommonName
+}
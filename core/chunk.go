@@ -0,0 +1,80 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultChunkMaxSize = 64 << 20 // 单条分片消息重组后的默认大小上限
+	defaultChunkTimeout = 60       // 分片没收齐时的默认清理时间(秒)
+	maxChunkTotal       = 4096     // 单条消息最多的分片数，避免total过大时直接分配大内存
+)
+
+// chunkBuffer 正在重组的分片消息
+type chunkBuffer struct {
+	action   string
+	parts    []string
+	got      []bool
+	received int
+	size     int
+	created  time.Time
+}
+
+// addChunk 保存一个分片，收齐后返回完整内容；超过大小上限或格式不对时丢弃整条消息
+func (c *Clients) addChunk(messageId string, action string, chunk *protocol.Chunk) (string, bool, error) {
+	maxSize, timeout := c.server.conf.Websocket.ChunkMaxSize, c.server.conf.Websocket.ChunkTimeout
+	if maxSize <= 0 {
+		maxSize = defaultChunkMaxSize
+	}
+	if timeout <= 0 {
+		timeout = defaultChunkTimeout
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chunks == nil {
+		c.chunks = make(map[string]*chunkBuffer)
+	}
+	// 顺便清理超时没收齐的分片
+	for id, buf := range c.chunks {
+		if time.Since(buf.created) > time.Duration(timeout)*time.Second {
+			delete(c.chunks, id)
+		}
+	}
+
+	if chunk.Total > maxChunkTotal {
+		return "", false, fmt.Errorf("分片数量%d超过上限%d message_id:%s", chunk.Total, maxChunkTotal, messageId)
+	}
+	buf, ok := c.chunks[messageId]
+	if !ok {
+		buf = &chunkBuffer{
+			action:  action,
+			parts:   make([]string, chunk.Total),
+			got:     make([]bool, chunk.Total),
+			created: time.Now(),
+		}
+		c.chunks[messageId] = buf
+	}
+	if chunk.Total != len(buf.parts) || chunk.Seq < 0 || chunk.Seq >= len(buf.parts) {
+		delete(c.chunks, messageId)
+		return "", false, fmt.Errorf("分片序号错误 message_id:%s seq:%d total:%d", messageId, chunk.Seq, chunk.Total)
+	}
+	buf.size += len(chunk.Data)
+	if buf.size > maxSize {
+		delete(c.chunks, messageId)
+		return "", false, fmt.Errorf("分片消息超过大小上限%d字节 message_id:%s", maxSize, messageId)
+	}
+	if !buf.got[chunk.Seq] {
+		buf.got[chunk.Seq] = true
+		buf.received++
+	}
+	buf.parts[chunk.Seq] = chunk.Data
+	if buf.received < len(buf.parts) {
+		return "", false, nil
+	}
+	delete(c.chunks, messageId)
+	return strings.Join(buf.parts, ""), true, nil
+}
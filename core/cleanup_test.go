@@ -0,0 +1,64 @@
+package core
+
+import (
+	"JsRpc/config"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 旧连接已经开始下线(closed已关闭，还没从hlSyncMap删除)时新连接注册成新的Clients，
+// 旧连接的清理晚一步执行时不能删掉新连接的记录
+func TestLateCleanupKeepsNewClient(t *testing.T) {
+	s, err := NewServer(config.ConfStruct{DefaultTimeOut: 1, CloseWebLog: true, Mode: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?group=g&clientId=c"
+
+	// registered 等到hlSyncMap里g->c的记录不是except为止
+	registered := func(except *Clients) *Clients {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if value, ok := s.hlSyncMap.Load(clientKey("", "g", "c")); ok && value.(*Clients) != except {
+				return value.(*Clients)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("客户端没有注册")
+		return nil
+	}
+
+	oldWs, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := registered(nil)
+	old.closeOnce.Do(func() { close(old.closed) })
+
+	newWs, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newWs.Close()
+	fresh := registered(old)
+
+	_ = oldWs.Close()
+	s.removeClient(old) // 旧连接的清理晚一步执行
+	time.Sleep(100 * time.Millisecond)
+
+	if value, ok := s.hlSyncMap.Load(fresh.key()); !ok || value.(*Clients) != fresh {
+		t.Fatal("旧连接的清理删掉了新连接的记录")
+	}
+	if details := s.Clients("g"); len(details) != 1 || details[0].ClientId != "c" {
+		t.Fatalf("列表里没有新客户端：%+v", details)
+	}
+}
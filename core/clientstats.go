@@ -0,0 +1,75 @@
+package core
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultPingInterval = 30 // 默认每30秒ping一次ws客户端
+
+// pingInterval 0使用默认值，负数不发送ping
+func (c *Clients) pingInterval() time.Duration {
+	seconds := defaultPingInterval
+	if c.server != nil {
+		seconds = c.server.Settings().PingInterval
+	}
+	if seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// watchPong 记录ws客户端的pong，重连后新连接也要重新设置
+func (c *Clients) watchPong(ws wsConn) {
+	c.connectedAt.Store(time.Now().UnixNano())
+	ws.SetPongHandler(func(string) error {
+		c.lastPong.Store(time.Now().UnixNano())
+		return nil
+	})
+}
+
+// ping 由writeLoop定时调用
+func (c *Clients) ping(ws wsConn) error {
+	c.lastPing.Store(time.Now().UnixNano())
+	return ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+// countRequest 统计客户端处理过的请求，被hook拒绝的不算
+func (c *Clients) countRequest(err error) {
+	if errors.Is(err, ErrRejected) {
+		return
+	}
+	c.requests.Add(1)
+	switch {
+	case err == nil:
+		c.successes.Add(1)
+	case errors.Is(err, ErrTimeout):
+		c.timeouts.Add(1)
+	}
+}
+
+// fillStats 连接时间、ping/pong和请求统计
+func (c *Clients) fillStats(d *ClientDetail) {
+	d.ConnectedAt = nanoTime(c.connectedAt.Load())
+	if d.ConnectedAt != nil {
+		d.ConnectedSec = int64(time.Since(*d.ConnectedAt).Seconds())
+	}
+	d.LastPing = nanoTime(c.lastPing.Load())
+	if pong := nanoTime(c.lastPong.Load()); pong != nil {
+		ago := int64(time.Since(*pong).Seconds())
+		d.LastPongAgoSec = &ago
+	}
+	d.Requests, d.Successes, d.Timeouts = c.requests.Load(), c.successes.Load(), c.timeouts.Load()
+	d.Probes, d.ProbeFailures = c.probes.Load(), c.probeFailures.Load()
+	d.Traffic = c.traffic.snapshot(time.Now())
+}
+
+func nanoTime(v int64) *time.Time {
+	if v == 0 {
+		return nil
+	}
+	t := time.Unix(0, v)
+	return &t
+}
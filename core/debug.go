@@ -0,0 +1,130 @@
+package core
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DebugStats /stats和/debug/stats的返回，内存单位是字节
+type DebugStats struct {
+	UptimeSec  int64     `json:"uptimeSec"`
+	Goroutines int       `json:"goroutines"`
+	Heap       HeapStats `json:"heap"`
+	Clients    int       `json:"clients"` // hlSyncMap里的客户端数，包括等待重连的
+	Pending    int       `json:"pending"` // 所有客户端等待返回的请求数
+	Queries    int64     `json:"queries"` // 正在执行的请求数，包括还在排队没有发出去的
+	// 带queueIfEmpty在等待客户端上线的请求数，key是group(有namespace时带前缀)
+	EmptyQueue map[string]int `json:"emptyQueue"`
+	// 每个group累计收发的消息数和字节数，客户端重新连接后不清零，key同上
+	Traffic map[string]TrafficDetail `json:"traffic"`
+	// 按X-Caller-Name统计的请求数，没带的算在"-"里
+	Callers map[string]CallerStats `json:"callers"`
+}
+
+type HeapStats struct {
+	Alloc   uint64 `json:"alloc"`
+	InUse   uint64 `json:"inUse"`
+	Objects uint64 `json:"objects"`
+	Sys     uint64 `json:"sys"`
+	NumGC   uint32 `json:"numGC"`
+}
+
+// DebugAuth 开启Debug.LocalOnly或者没有配置AdminToken时只允许本机访问，配置了AdminToken时还需要全局adminToken
+func (s *Server) DebugAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if (s.conf.Debug.LocalOnly || s.conf.AdminToken == "") && !isLoopback(c.Request.RemoteAddr) {
+			GinJsonMsg(c, http.StatusForbidden, "debug接口只允许本机访问")
+			c.Abort()
+			return
+		}
+		if s.conf.AdminToken != "" && !s.isAdmin(c) {
+			s.strike(c.ClientIP(), strikeAuth)
+			GinJsonMsg(c, http.StatusUnauthorized, "需要正确的adminToken")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// isLoopback 按连接的地址判断，不看代理头
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// setDebugRouters 开启Debug.Pprof后注册/debug/pprof和/debug/stats
+func (s *Server) setDebugRouters(router *gin.Engine) {
+	if !s.conf.Debug.Pprof {
+		return
+	}
+	debug := router.Group("/debug", s.DebugAuth())
+	debug.GET("/stats", s.debugStats)
+	debug.GET("/pprof/*name", debugPprof)
+	debug.POST("/pprof/*name", debugPprof) // pprof的symbol支持post
+}
+
+func debugPprof(c *gin.Context) {
+	switch c.Param("name") {
+	case "/cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "/profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "/symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "/trace":
+		pprof.Trace(c.Writer, c.Request)
+	default: // 首页和heap、goroutine等命名的profile
+		pprof.Index(c.Writer, c.Request)
+	}
+}
+
+// Stats 运行时和客户端的统计，/stats和/debug/stats返回的内容
+func (s *Server) Stats() DebugStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := DebugStats{
+		UptimeSec:  int64(time.Since(s.started).Seconds()),
+		Goroutines: runtime.NumGoroutine(),
+		Heap: HeapStats{
+			Alloc:   mem.HeapAlloc,
+			InUse:   mem.HeapInuse,
+			Objects: mem.HeapObjects,
+			Sys:     mem.Sys,
+			NumGC:   mem.NumGC,
+		},
+		Queries:    s.queries.Load(),
+		EmptyQueue: s.emptyQueue.snapshot(),
+		Traffic:    s.Traffic(),
+		Callers:    s.callers.snapshot(),
+	}
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		if client, ok := value.(*Clients); ok {
+			stats.Clients++
+			stats.Pending += client.pendingCount()
+		}
+		return true
+	})
+	return stats
+}
+
+func (s *Server) debugStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": s.Stats()})
+}
+
+// getStats 和/debug/stats的内容相同，不需要开启Debug.Pprof，经过AdminAuth，包含所有group的统计，只有全局adminToken能看
+func (s *Server) getStats(c *gin.Context) {
+	if !requireGlobalAdmin(c) {
+		return
+	}
+	s.debugStats(c)
+}
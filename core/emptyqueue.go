@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultEmptyQueueSize = 100
+	maxQueueIfEmpty       = 300 // queueIfEmpty最多等待的秒数
+)
+
+var (
+	// ErrEmptyQueueFull 没有客户端时等待的请求太多
+	ErrEmptyQueueFull = errors.New("没有可用的客户端，等待的请求已满")
+	// ErrEmptyQueueTimeout queueIfEmpty秒内没有等到客户端
+	ErrEmptyQueueTimeout = errors.New("等待客户端上线超时")
+)
+
+// emptyQueue queueIfEmpty的请求，group里没有客户端时等待有客户端上线或恢复健康
+type emptyQueue struct {
+	mu     sync.Mutex
+	depth  map[string]int // namespace+group -> 正在等待的请求数
+	notify chan struct{}  // 客户端列表有变化时关闭
+}
+
+// clientChanged 唤醒所有等待的请求重新选择客户端，在列表版本号变化时调用
+func (q *emptyQueue) clientChanged() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.notify != nil {
+		close(q.notify)
+		q.notify = nil
+	}
+}
+
+func (q *emptyQueue) wait() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.notify == nil {
+		q.notify = make(chan struct{})
+	}
+	return q.notify
+}
+
+func (q *emptyQueue) enter(key string, limit int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.depth == nil {
+		q.depth = make(map[string]int)
+	}
+	if q.depth[key] >= limit {
+		return false
+	}
+	q.depth[key]++
+	return true
+}
+
+func (q *emptyQueue) leave(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.depth[key]--; q.depth[key] <= 0 {
+		delete(q.depth, key)
+	}
+}
+
+// snapshot 每个group正在等待的请求数，/stats里展示
+func (q *emptyQueue) snapshot() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	depth := make(map[string]int, len(q.depth))
+	for key, n := range q.depth {
+		depth[key] = n
+	}
+	return depth
+}
+
+func (s *Server) emptyQueueSize() int {
+	if s.conf.Routing.EmptyQueueSize > 0 {
+		return s.conf.Routing.EmptyQueueSize
+	}
+	return defaultEmptyQueueSize
+}
+
+// waitForClient pick选不到客户端并且seconds大于0时，排队等待最多seconds秒，有客户端上线或恢复健康时重新选择
+// 调用方断开时返回ctx的错误
+func (s *Server) waitForClient(ctx context.Context, ns, group string, seconds int, pick func() (*Clients, error)) (*Clients, error) {
+	client, err := pick()
+	if client != nil || err != nil || seconds <= 0 {
+		return client, err
+	}
+	key := scopedGroup(ns, group)
+	if !s.emptyQueue.enter(key, s.emptyQueueSize()) {
+		return nil, ErrEmptyQueueFull
+	}
+	defer s.emptyQueue.leave(key)
+	timer := time.NewTimer(time.Duration(min(seconds, maxQueueIfEmpty)) * time.Second)
+	defer timer.Stop()
+	for {
+		notify := s.emptyQueue.wait() // 先拿到chan再选择，选择之后上线的客户端也能唤醒
+		if client, err = pick(); client != nil || err != nil {
+			return client, err
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil, ErrEmptyQueueTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// emptyQueueFailed queueIfEmpty等待失败时写返回，不是等待的错误时返回false
+func emptyQueueFailed(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, ErrEmptyQueueFull), errors.Is(err, ErrEmptyQueueTimeout):
+		GinJsonMsg(c, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		c.Abort() // 调用方已经断开
+	default:
+		return false
+	}
+	return true
+}
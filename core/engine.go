@@ -2,75 +2,275 @@ package core
 
 import (
 	"JsRpc/config"
-	"encoding/json"
-	"fmt"
-	"math/rand"
+	"JsRpc/utils"
+	"context"
+	"errors"
 	"time"
 )
 
-// GQueryFunc 发送请求到客户端
+// TimeoutMsg 客户端在超时时间内没有返回时的结果
+const TimeoutMsg = "黑脸怪：timeout"
+
+const defaultPreviewLength = 100 // 日志里记录参数和返回的字符数
+
+var (
+	// ErrTimeout 客户端在超时时间内没有返回
+	ErrTimeout = errors.New("timeout")
+	// ErrWriteFailed 请求没能交给客户端：发送队列满了或者编码失败
+	ErrWriteFailed = errors.New("write failed")
+	// ErrNoChannel 客户端已经断开，没有可以发送请求的连接
+	ErrNoChannel = errors.New("no channel")
+
+	errInternal = errors.New("internal error")
+)
+
+// queryError 内部的错误保留原来的文字，http接口的返回不变，同时可以用errors.Is匹配公开的错误
+type queryError struct {
+	msg  string
+	kind error
+}
+
+func (e *queryError) Error() string { return e.msg }
+
+func (e *queryError) Is(target error) bool { return target == e.kind }
+
+// Query 发送请求并等待客户端返回，和http接口一样经过hook、排队和限速，记录统计和历史。
+// 超时返回ErrTimeout，没能发送返回ErrWriteFailed，客户端已经断开返回ErrNoChannel，ctx结束时返回ctx.Err()
+func (c *Clients) Query(ctx context.Context, action string, param string) (string, error) {
+	return c.QueryMessage(ctx, Message{Action: action, Param: param})
+}
+
+// QueryMessage Query的完整版本，可以带args和message_id
+func (c *Clients) QueryMessage(ctx context.Context, msg Message) (res string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(ctx, r)
+			res, err = "", errInternal
+		}
+	}()
+	return c.query(ctx, msg)
+}
+
+// GQueryFunc 发送请求到客户端，结果写入resChan后关闭。新代码使用Query，可以区分错误类型
 func (c *Clients) GQueryFunc(funcName string, param string, resChan chan<- string) {
-	WriteData := Message{Param: param, Action: funcName}
-	data, _ := json.Marshal(WriteData)
-	clientWs := c.clientWs
-	if c.actionData[funcName] == nil {
-		c.actionData[funcName] = make(chan string, 1) //此次action初始化1个消息
-	}
-	gm.Lock()
-	err := clientWs.WriteMessage(1, data)
-	gm.Unlock()
+	c.GQueryMessage(Message{Param: param, Action: funcName}, resChan)
+}
+
+// GQueryMessage 发送完整的Message到客户端，出错时写入"黑脸怪："开头的文字
+func (c *Clients) GQueryMessage(WriteData Message, resChan chan<- string) {
+	defer close(resChan)
+	res, err := c.QueryMessage(context.Background(), WriteData)
+	switch {
+	case errors.Is(err, ErrTimeout):
+		resChan <- TimeoutMsg
+	case err != nil:
+		resChan <- "黑脸怪：" + err.Error()
+	default:
+		resChan <- res
+	}
+}
+
+// query 经过hook后发送请求并等待客户端返回，超时返回ErrTimeout
+func (c *Clients) query(ctx context.Context, WriteData Message) (string, error) {
+	if c.server == nil { // NewClient创建后没有attach，没有hook、统计和历史
+		return c.roundTrip(ctx, WriteData)
+	}
+	c.server.queries.Add(1)
+	defer c.server.queries.Add(-1)
+	info := &RequestInfo{
+		Group:     c.clientGroup,
+		ClientId:  c.clientId,
+		Action:    WriteData.Action,
+		Param:     WriteData.Param,
+		Args:      WriteData.Args,
+		StartTime: time.Now(),
+	}
+	caller := callerFromContext(ctx)
+	info.CallerIp, info.CallerName, info.UserAgent = caller.Ip, caller.Name, caller.UserAgent
+	err := c.server.beforeRequest(ctx, info)
+	var res string
+	if err == nil {
+		WriteData.Param, WriteData.Args = info.Param, info.Args
+		c.server.logBody(WriteData.Param, "send_message:", c.clientGroup+"->"+c.clientId, WriteData.Action)
+		res, err = c.roundTrip(ctx, WriteData)
+	}
+	c.server.afterResponse(ctx, info, res, err)
+	c.countRequest(err)
+	c.server.callers.count(info.CallerName, err)
+	c.recordHistory(info, res, err)
+	return res, err
+}
+
+// roundTrip 发送请求并等待客户端返回，客户端断开时不用等到超时
+func (c *Clients) roundTrip(ctx context.Context, WriteData Message) (string, error) {
+	if WriteData.MessageId == "" {
+		WriteData.MessageId = utils.GetUUID()
+	}
+	timer := time.NewTimer(c.requestTimeout(WriteData))
+	defer timer.Stop()
+	// 配置了Pending.MaxConcurrent时按优先级排队，排队时间也算在超时里
+	waiter := &laneWaiter{messageId: WriteData.MessageId, action: WriteData.Action, caller: callerFromContext(ctx), priority: requestPriority(ctx)}
+	if err := c.acquire(ctx, waiter, timer.C); err != nil {
+		return "", err
+	}
+	defer c.releaseLane()
+	if err := c.pace(ctx); err != nil {
+		return "", err
+	}
+	req, err := c.addPending(&pendingRequest{
+		messageId: WriteData.MessageId,
+		action:    WriteData.Action,
+		caller:    waiter.caller,
+		priority:  waiter.priority,
+		resend:    resendOnReconnect(ctx),
+		request:   WriteData.envelope(),
+	})
 	if err != nil {
-		fmt.Println(err, "写入数据失败")
-	}
-	resultFlag := false
-	for i := 0; i < config.DefaultTimeout*10; i++ {
-		if len(c.actionData[funcName]) > 0 {
-			res := <-c.actionData[funcName]
-			resChan <- res
-			resultFlag = true
-			break
+		return "", err
+	}
+	// 客户端在等待重连时先缓存，重连后由resendPending发送
+	if !req.offline {
+		if err := c.sendEnvelope(req.request); err != nil {
+			// 发送队列满了或者客户端已经断开，直接返回不用等超时
+			c.removePending(req)
+			return "", err
 		}
-		time.Sleep(time.Millisecond * 100)
 	}
-	// 循环完了还是没有数据，那就超时退出
-	if true != resultFlag {
-		resChan <- "黑脸怪：timeout"
+	// 客户端可能分多次流式返回，调用方要求流式时每一部分都转发出去，否则最后合并成一个结果
+	stream := streamFromContext(ctx)
+	var parts []StreamPart
+	forward := func(part StreamPart) {
+		if stream != nil {
+			select {
+			case stream <- part:
+			case <-ctx.Done():
+			}
+		}
+	}
+	receive := func(part StreamPart) bool {
+		parts = append(parts, part)
+		if c.server != nil {
+			c.server.logBody(part.Data, "get_part:")
+		}
+		forward(part)
+		return part.Final
+	}
+	result := func(res string) (string, error) {
+		if req.jsErr != nil {
+			reportScriptError(ctx, req.jsErr)
+		}
+		if req.err == nil {
+			forward(StreamPart{Seq: len(parts), Data: res, Final: true})
+		}
+		return res, req.err
+	}
+wait:
+	for {
+		select {
+		case res := <-req.result:
+			return result(res)
+		case part := <-req.parts:
+			if receive(part) {
+				return c.joinParts(parts), nil
+			}
+		case <-timer.C:
+			if c.removePending(req) {
+				return "", ErrTimeout
+			}
+			break wait
+		case <-ctx.Done():
+			if c.removePending(req) {
+				return "", ctx.Err()
+			}
+			break wait
+		case <-c.closed:
+			if c.removePending(req) {
+				return "", errClientClosed
+			}
+			break wait
+		}
+	}
+	// 超时的同时结果或者最后一部分刚好交付了，交付方已经占住请求，马上会写入chan
+	drain := time.NewTimer(enqueueTimeout)
+	defer drain.Stop()
+	for {
+		select {
+		case res := <-req.result:
+			return result(res)
+		case part := <-req.parts:
+			if receive(part) {
+				return c.joinParts(parts), nil
+			}
+		case <-drain.C:
+			return "", ErrTimeout
+		}
 	}
-	defer func() {
-		close(resChan)
-	}()
 }
 
-func getRandomClient(group string, clientId string) *Clients {
+func (c *Clients) timeout() time.Duration {
+	seconds := config.DefaultTimeout
+	if c.server != nil {
+		seconds = c.server.Settings().DefaultTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// requestTimeout awaitPromise的请求要等异步操作完成，超时按AwaitTimeoutMultiplier延长
+func (c *Clients) requestTimeout(msg Message) time.Duration {
+	timeout := c.timeout()
+	if !msg.AwaitPromise {
+		return timeout
+	}
+	multiplier := config.DefaultAwaitTimeoutMultiplier
+	if c.server != nil && c.server.conf.AwaitTimeoutMultiplier > 0 {
+		multiplier = c.server.conf.AwaitTimeoutMultiplier
+	}
+	return timeout * time.Duration(multiplier)
+}
+
+func (s *Server) getRandomClient(ns string, group string, clientId string) *Clients {
 	var client *Clients
 	// 不传递clientId时候，从group分组随便拿一个
+	if clientId != "" && config.IsGroupPattern(group) { // 在匹配的group里找这个clientId
+		for _, c := range s.groupClients(ns, group, "") {
+			if c.clientId == clientId {
+				return c
+			}
+		}
+		return nil
+	}
 	if clientId != "" {
-		clientName, ok := hlSyncMap.Load(group + "->" + clientId)
+		clientName, ok := s.hlSyncMap.Load(clientKey(ns, group, clientId))
 		if ok == false {
 			return nil
 		}
 		client, _ = clientName.(*Clients)
+		if client != nil && client.inGrace() && client.offlineQueueSize() <= 0 { // 断开了在等待重连，没有开启缓存
+			return nil
+		}
 		return client
 	}
+	return s.pickClient(s.groupClients(ns, group, ""))
+}
+
+// groupClients 返回namespace里group下的所有客户端，exclude不为空时排除这个clientId
+// group可以是通配符(taobao-*)或者~开头的正则，返回所有匹配的group下的客户端
+func (s *Server) groupClients(ns string, group string, exclude string) []*Clients {
 	groupClients := make([]*Clients, 0)
+	match, err := config.MatchGroup(group)
+	if err != nil {
+		return groupClients
+	}
 	//循环读取syncMap 获取group名字的
-	hlSyncMap.Range(func(_, value interface{}) bool {
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
 		tmpClients, ok := value.(*Clients)
 		if !ok {
 			return true
 		}
-		if tmpClients.clientGroup == group {
+		if tmpClients.namespace == ns && match(tmpClients.clientGroup) && tmpClients.clientId != exclude && !tmpClients.inGrace() {
 			groupClients = append(groupClients, tmpClients)
 		}
 		return true
 	})
-	if len(groupClients) == 0 {
-		return nil
-	}
-	// 使用随机数发生器
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	randomIndex := r.Intn(len(groupClients))
-	client = groupClients[randomIndex]
-	return client
-
+	return groupClients
 }
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"JsRpc/config"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueryResultAndStats(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHello())
+	res, err := fc.client.Query(testContext(t), "hello", "世界")
+	if err != nil || res != "hi 世界" {
+		t.Fatalf("Query = %q, %v", res, err)
+	}
+	if fc.client.requests.Load() != 1 || fc.client.successes.Load() != 1 || fc.client.pendingCount() != 0 {
+		t.Fatalf("统计不对：请求%d 成功%d 等待%d", fc.client.requests.Load(), fc.client.successes.Load(), fc.client.pendingCount())
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.DefaultTimeOut = 1 })
+	hangingClient(t, s, "g")
+	client := s.Client("g", "c")
+	start := time.Now()
+	_, err := client.Query(testContext(t), "hang", "")
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("期望ErrTimeout，得到 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("应该在DefaultTimeOut后超时，用了%v", elapsed)
+	}
+	if client.timeouts.Load() != 1 || client.pendingCount() != 0 {
+		t.Fatalf("超时%d次，还有%d个请求在等待", client.timeouts.Load(), client.pendingCount())
+	}
+	// 旧的chan接口超时时仍然返回TimeoutMsg
+	resChan := make(chan string, 1)
+	go client.GQueryFunc("hang", "", resChan)
+	if res := <-resChan; res != TimeoutMsg {
+		t.Fatalf("GQueryFunc = %q", res)
+	}
+}
+
+// ctx结束时马上返回ctx.Err()，不算客户端超时
+func TestQueryContextDone(t *testing.T) {
+	s := newTestServer(t, nil)
+	hangingClient(t, s, "g")
+	client := s.Client("g", "c")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := client.Query(ctx, "hang", "")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Fatalf("期望context.Canceled，得到 %v", err)
+	}
+	if client.timeouts.Load() != 0 || client.pendingCount() != 0 {
+		t.Fatalf("超时%d次，还有%d个请求在等待", client.timeouts.Load(), client.pendingCount())
+	}
+}
+
+func TestQueryWriteFailed(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHello())
+
+	// 没有action的请求编码失败
+	if _, err := fc.client.Query(testContext(t), "", "x"); !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("编码失败应该返回ErrWriteFailed，得到 %v", err)
+	}
+
+	// 写ws卡住后发送队列满了
+	block := make(chan struct{})
+	defer close(block)
+	fc.ws.set(func(f *fakeWs) { f.block = block })
+	for len(fc.client.outbound) < cap(fc.client.outbound) {
+		fc.client.outbound <- outboundFrame{data: []byte("x")}
+	}
+	start := time.Now()
+	if _, err := fc.client.Query(testContext(t), "hello", "x"); !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("队列满时应该返回ErrWriteFailed，得到 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > enqueueTimeout+time.Second {
+		t.Fatalf("队列满时应该在%v后返回，用了%v", enqueueTimeout, elapsed)
+	}
+	if fc.client.pendingCount() != 0 {
+		t.Fatal("没有发出去的请求应该移除")
+	}
+}
+
+func TestQueryNoChannel(t *testing.T) {
+	s := newTestServer(t, nil)
+	hangingClient(t, s, "g")
+	client := s.Client("g", "c")
+	failed := make(chan error, 1)
+	go func() {
+		_, err := client.Query(testContext(t), "hang", "")
+		failed <- err
+	}()
+	waitFor(t, "请求发出", func() bool { return client.pendingCount() == 1 })
+
+	// 连接断开时等待中的请求马上失败，不用等到超时
+	client.mu.Lock()
+	ws := client.clientWs
+	client.mu.Unlock()
+	_ = ws.Close()
+	select {
+	case err := <-failed:
+		if !errors.Is(err, ErrNoChannel) {
+			t.Fatalf("期望ErrNoChannel，得到 %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("连接断开后请求没有结束")
+	}
+	waitFor(t, "客户端下线", func() bool { return s.Client("g", "c") == nil })
+	if _, err := client.Query(testContext(t), "hang", ""); !errors.Is(err, ErrNoChannel) {
+		t.Fatalf("下线后的客户端应该返回ErrNoChannel，得到 %v", err)
+	}
+}
+
+// NewClient创建后没有attach到Server的客户端也能查询，不会因为server为nil而panic
+func TestQueryDetachedClient(t *testing.T) {
+	c := NewClient("g", "c", newFakeWs(), "127.0.0.1")
+	// 没有写循环，直接按action交付旧版格式的返回
+	reply := func(data string) {
+		waitFor(t, "请求加入等待", func() bool { return c.pendingCount() == 1 })
+		c.deliver("", "a", data, nil)
+	}
+
+	go reply("ok")
+	if res, err := c.Query(testContext(t), "a", ""); err != nil || res != "ok" {
+		t.Fatalf("Query = %q, %v", res, err)
+	}
+
+	go reply("streamed")
+	parts := make(chan StreamPart, streamBufferSize)
+	if err := c.GQueryStream(Message{Action: "a"}, parts); err != nil {
+		t.Fatalf("GQueryStream = %v", err)
+	}
+	if part, ok := <-parts; !ok || part.Data != "streamed" || !part.Final {
+		t.Fatalf("GQueryStream的结果 = %+v", part)
+	}
+	if _, ok := <-parts; ok {
+		t.Fatal("GQueryStream结束后应该关闭parts")
+	}
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"JsRpc/utils"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiV2Prefix = "/api/v2"
+	apiV2Key    = "jsrpc.apiV2" // gin.Context里标记是否是v2接口
+)
+
+// v2Paths 同时提供/api/v2版本的路由，旧路由的返回格式保持不变
+var v2Paths = map[string]bool{
+	"/page/cookie":  true,
+	"/page/html":    true,
+	"/page/storage": true,
+	"/page/info":    true,
+	"/go":           true,
+	"/execjs":       true,
+	"/snippet":      true,
+	"/navigate":     true,
+	"/list":         true,
+	"/details":      true,
+	"/inflight":     true,
+	"/history":      true,
+}
+
+// EnvelopeV2 v2接口统一的返回格式，出错时code和http状态码一致，message是错误信息
+type EnvelopeV2 struct {
+	Code      int             `json:"code"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data"`
+	Group     string          `json:"group,omitempty"`
+	ClientId  string          `json:"clientId,omitempty"`
+	Total     *int            `json:"total,omitempty"` // /list、/details的总数和分页
+	Page      int             `json:"page,omitempty"`
+	PageSize  int             `json:"pageSize,omitempty"`
+	Version   int64           `json:"version,omitempty"` // /list、/details的版本号，/list的ETag和它一起变化
+	ElapsedMs int64           `json:"elapsedMs"`
+	RequestId string          `json:"requestId"`
+}
+
+// legacyResponse 旧接口的各种返回格式，execjs的status是字符串、clientId放在name里
+type legacyResponse struct {
+	Status   json.RawMessage `json:"status"`
+	Group    string          `json:"group"`
+	ClientId string          `json:"clientId"`
+	Name     string          `json:"name"`
+	Data     json.RawMessage `json:"data"`
+	Error    json.RawMessage `json:"error"` // 一般是字符串，/execjs的页面异常是 {name, message, stack}
+	Total    *int            `json:"total"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"pageSize"`
+	Version  int64           `json:"version"`
+}
+
+func isV2(c *gin.Context) bool {
+	return c.GetBool(apiV2Key)
+}
+
+// toEnvelopeV2 把旧格式的返回转换成v2格式，不是旧格式的json时返回false
+func toEnvelopeV2(body []byte) (EnvelopeV2, bool) {
+	var legacy legacyResponse
+	if err := json.Unmarshal(body, &legacy); err != nil || len(legacy.Status) == 0 {
+		return EnvelopeV2{}, false
+	}
+	var statusText string
+	if json.Unmarshal(legacy.Status, &statusText) != nil {
+		statusText = string(legacy.Status)
+	}
+	code, err := strconv.Atoi(statusText)
+	if err != nil {
+		return EnvelopeV2{}, false
+	}
+	env := EnvelopeV2{Code: code, Message: "ok", Data: legacy.Data, Group: legacy.Group, ClientId: legacy.ClientId,
+		Total: legacy.Total, Page: legacy.Page, PageSize: legacy.PageSize, Version: legacy.Version}
+	if env.ClientId == "" {
+		env.ClientId = legacy.Name
+	}
+	var text string
+	isText := json.Unmarshal(legacy.Data, &text) == nil
+	if code == http.StatusOK && isText && text == TimeoutMsg {
+		env.Code = http.StatusGatewayTimeout
+	}
+	if env.Code != http.StatusOK {
+		env.Message, env.Data = "", nil
+		var jsErr protocol.JsError
+		if json.Unmarshal(legacy.Error, &env.Message) != nil && json.Unmarshal(legacy.Error, &jsErr) == nil {
+			env.Message = strings.TrimPrefix(jsErr.Name+": "+jsErr.Message, ": ")
+			env.Data = legacy.Error // 页面异常的详细信息放在data里
+		}
+		if env.Message == "" && isText {
+			env.Message = text
+		}
+	}
+	if env.Data == nil {
+		env.Data = json.RawMessage("null")
+	}
+	return env, true
+}
+
+// bufferedWriter 先把旧接口的返回缓存下来，转换格式后再写出
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *bufferedWriter) WriteHeader(code int) { w.status = code }
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) { return w.body.Write(data) }
+
+func (w *bufferedWriter) WriteString(s string) (int, error) { return w.body.WriteString(s) }
+
+func (w *bufferedWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int { return w.body.Len() }
+
+func (w *bufferedWriter) Written() bool { return w.status != 0 || w.body.Len() > 0 }
+
+// ApiV2 复用旧接口的处理函数，把返回统一成EnvelopeV2
+func ApiV2() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestId := utils.GetUUID()
+		c.Set(apiV2Key, true)
+		w := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		c.Header("X-Request-Id", requestId)
+		env, ok := toEnvelopeV2(w.body.Bytes())
+		if !ok { // 不是旧接口格式的返回，原样输出
+			c.Writer.WriteHeader(w.Status())
+			_, _ = c.Writer.Write(w.body.Bytes())
+			return
+		}
+		env.ElapsedMs = time.Since(start).Milliseconds()
+		env.RequestId = requestId
+		c.JSON(env.Code, env)
+	}
+}
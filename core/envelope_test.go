@@ -0,0 +1,91 @@
+package core
+
+import (
+	"JsRpc/config"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "重新生成testdata里的快照")
+
+// snapshot 状态码和格式化后的响应，v2的耗时和requestId每次都不同，固定成0和空
+func snapshot(t *testing.T, code int, body []byte) []byte {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("响应不是json：%s", body)
+	}
+	if _, ok := v["requestId"]; ok {
+		v["requestId"], v["elapsedMs"] = "", 0
+	}
+	pretty, _ := json.MarshalIndent(v, "", "  ")
+	return []byte(fmt.Sprintf("HTTP %d\n%s\n", code, pretty))
+}
+
+// 旧接口和v2接口的返回格式都和testdata/envelope里的快照比较，改了格式要用-update重新生成并检查差异
+func TestEnvelopeSnapshots(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.DefaultTimeOut = 1 })
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"hello":   func(param string) string { return "hi " + param },
+		"obj":     func(string) string { return `{"a":[1,"二"]}` },
+		"_execjs": func(code string) string { return "ran " + code },
+		"slow":    func(string) string { time.Sleep(2 * time.Second); return "late" },
+	})
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"go", http.MethodGet, "/go?group=g&action=hello&param=1", ""},
+		{"go_rawjson", http.MethodGet, "/go?group=g&action=obj&rawJson=true", ""},
+		{"go_no_group", http.MethodGet, "/go?action=hello", ""},
+		{"go_no_client", http.MethodGet, "/go?group=none&action=hello", ""},
+		{"go_unknown_client", http.MethodGet, "/go?group=g&clientId=x&action=hello", ""},
+		{"execjs", http.MethodPost, "/execjs", "group=g&code=1%2B1"},
+		{"execjs_no_client", http.MethodPost, "/execjs", "group=none&code=1"},
+		{"list", http.MethodGet, "/list", ""},
+		// 放在最后，fake客户端按顺序回复，慢的请求会挡住后面的
+		{"go_timeout", http.MethodGet, "/go?group=g&action=slow", ""},
+	}
+	for _, tt := range tests {
+		for _, version := range []string{"v1", "v2"} {
+			t.Run(tt.name+"_"+version, func(t *testing.T) {
+				target := tt.target
+				if version == "v2" {
+					target = apiV2Prefix + target
+				}
+				headers := []string{}
+				if tt.body != "" {
+					headers = append(headers, "Content-Type", "application/x-www-form-urlencoded")
+				}
+				w := serveRequest(s, tt.method, target, tt.body, headers...)
+				got := snapshot(t, w.Code, w.Body.Bytes())
+				path := filepath.Join("testdata", "envelope", tt.name+"_"+version+".txt")
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, got, 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("没有快照，用 go test ./core -run TestEnvelopeSnapshots -update 生成：%v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("%s的返回和快照不一致\n得到:\n%s\n快照:\n%s", target, got, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// 客户端事件类型
+const (
+	EventConnect    = "connect"
+	EventDisconnect = "disconnect"
+	EventActions    = "actions" // 客户端注册的方法有变化
+	EventPage       = "page"    // 页面通过_event推送的事件
+)
+
+// ClientEvent 客户端上线、下线、方法列表变化、页面推送的事件或者定时任务失败
+type ClientEvent struct {
+	Type      string       `json:"type"`
+	Namespace string       `json:"namespace,omitempty"`
+	Group     string       `json:"group"`
+	Client    ClientDetail `json:"client"`
+	Time      time.Time    `json:"time"`
+	Page      *PageEvent   `json:"page,omitempty"` // Type为page时的事件内容
+	// Type为schedule_failed时定时任务的状态
+	Schedule *ScheduleStatus `json:"schedule,omitempty"`
+}
+
+// eventBus 把客户端事件推送给订阅者，订阅者处理不过来时丢弃事件，不会阻塞连接
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan ClientEvent]struct{}
+}
+
+// Subscribe 订阅客户端事件，不再需要时调用返回的cancel
+func (s *Server) Subscribe(buffer int) (<-chan ClientEvent, func()) {
+	ch := make(chan ClientEvent, buffer)
+	s.events.mu.Lock()
+	if s.events.subs == nil {
+		s.events.subs = make(map[chan ClientEvent]struct{})
+	}
+	s.events.subs[ch] = struct{}{}
+	s.events.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.events.mu.Lock()
+			delete(s.events.subs, ch)
+			s.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (s *Server) publish(eventType string, client *Clients) {
+	s.bumpVersion()
+	ev := ClientEvent{Type: eventType, Namespace: client.namespace, Group: client.clientGroup, Client: client.detail(), Time: time.Now()}
+	s.clientHooks(eventType, ev.Group, ev.Client)
+	s.broadcastEvent(ev)
+}
+
+// broadcastEvent 发给所有订阅者，处理不过来的订阅者丢弃这个事件
+func (s *Server) broadcastEvent(ev ClientEvent) {
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	for ch := range s.events.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package core_test
+
+import (
+	"JsRpc/config"
+	"JsRpc/core"
+	"JsRpc/mockclient"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// 把JsRpc挂到自己的http服务上，用mockclient模拟浏览器接入后调用它的action
+func ExampleServer_Call() {
+	s, err := core.NewServer(config.ConfStruct{DefaultTimeOut: 5, CloseWebLog: true})
+	if err != nil {
+		panic(err)
+	}
+	defer s.Shutdown(context.Background())
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mockclient.Run(ctx, mockclient.Options{
+		Server:   srv.URL,
+		Group:    "demo",
+		ClientId: "page1",
+		Actions:  map[string]mockclient.Action{"hello": {Response: "hi {{param}}"}},
+	})
+	for s.Client("demo", "page1") == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res, err := s.Call(ctx, "demo", "", "hello", "jsrpc")
+	fmt.Println(res, err)
+	_, err = s.Call(ctx, "other", "", "hello", "")
+	fmt.Println(errors.Is(err, core.ErrNoClient))
+	// Output:
+	// hi jsrpc <nil>
+	// true
+}
+
+// 和自己的接口共用一个端口，JsRpc的接口放在/rpc/下
+func ExampleServer_Handler() {
+	s, err := core.NewServer(config.ConfStruct{DefaultTimeOut: 5, CloseWebLog: true})
+	if err != nil {
+		panic(err)
+	}
+	defer s.Shutdown(context.Background())
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/", http.StripPrefix("/rpc", s.Handler()))
+	mux.HandleFunc("/mine", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "mine") })
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rpc/list", nil))
+	fmt.Println(w.Code, len(s.Clients("")))
+	// Output: 200 0
+}
@@ -0,0 +1,238 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"JsRpc/utils"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrReconnectTimeout 客户端断开后在ReconnectGrace内没有重连
+var ErrReconnectTimeout = errors.New("client reconnect timeout")
+
+// ErrOfflineQueueFull 等待重连的客户端缓存的请求太多
+var ErrOfflineQueueFull = errors.New("客户端正在等待重连，缓存的请求已满")
+
+// 客户端状态，/details里展示
+const (
+	clientOnline = "online"
+	clientGrace  = "grace" // ws断开了，在等待重连
+)
+
+type resendKey struct{}
+
+// withResend 客户端断开重连后重新发送这个请求，只适合幂等的读操作
+func withResend(ctx context.Context, resend bool) context.Context {
+	return context.WithValue(ctx, resendKey{}, resend)
+}
+
+func resendOnReconnect(ctx context.Context) bool {
+	resend, _ := ctx.Value(resendKey{}).(bool)
+	return resend
+}
+
+// conn 当前的ws连接，长轮询客户端和等待重连时为nil
+func (c *Clients) conn() wsConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clientWs
+}
+
+// goingAway 服务退出时先发close帧(1001)再断开，客户端能知道是服务端主动关闭的
+func (c *Clients) goingAway() {
+	if ws := c.conn(); ws != nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+		_ = ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+	c.close()
+}
+
+// inGrace 是否断开了在等待重连
+func (c *Clients) inGrace() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.graceUntil.IsZero()
+}
+
+func (c *Clients) state() (string, *time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.graceUntil.IsZero() {
+		return clientOnline, nil
+	}
+	until := c.graceUntil
+	return clientGrace, &until
+}
+
+// rebindClient 同一个group->clientId在等待重连时连上来，把新连接绑定到原来的Clients上，
+// ip换成新连接的，注册的方法等新页面重新上报
+func (s *Server) rebindClient(ns string, group string, clientId string, ip string, ws wsConn, compression bool) (*Clients, bool) {
+	value, ok := s.hlSyncMap.Load(clientKey(ns, group, clientId))
+	if !ok {
+		return nil, false
+	}
+	client, _ := value.(*Clients)
+	if client == nil {
+		return nil, false
+	}
+	client.mu.Lock()
+	if client.graceUntil.IsZero() {
+		client.mu.Unlock()
+		return nil, false
+	}
+	select {
+	case <-client.closed: // 刚好到期下线了
+		client.mu.Unlock()
+		return nil, false
+	default:
+	}
+	client.clientWs = ws
+	client.clientIp = ip
+	client.compression = compression
+	client.protoVersion.Store(0) // 新页面的脚本版本可能不同，等它重新发_hello
+	client.msgpack.Store(false)
+	client.actions = nil
+	client.graceUntil = time.Time{}
+	client.graceGen++
+	client.mu.Unlock()
+	client.actionsChanged()
+	return client, true
+}
+
+// offlineQueueSize 等待重连期间每个客户端最多缓存的请求数，0不缓存
+func (c *Clients) offlineQueueSize() int {
+	if c.server == nil {
+		return 0
+	}
+	return c.server.conf.Websocket.OfflineQueueSize
+}
+
+func (c *Clients) offlineCountLocked() int {
+	n := 0
+	for _, req := range c.actionData {
+		if req.offline {
+			n++
+		}
+	}
+	return n
+}
+
+// offlineQueue 缓存的请求数和最早的一个等了多久
+func (c *Clients) offlineQueue() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, oldest := 0, int64(0)
+	for _, req := range c.actionData {
+		if req.offline {
+			if n == 0 {
+				oldest = time.Since(req.created).Milliseconds()
+			}
+			n++
+		}
+	}
+	return n, oldest
+}
+
+// resendPending 重连后发送缓存的请求，并重新发送标记了resendOnReconnect的请求
+func (c *Clients) resendPending() {
+	c.mu.Lock()
+	requests := make([]protocol.Envelope, 0)
+	for _, req := range c.actionData {
+		if req.resend || req.offline {
+			req.offline = false
+			requests = append(requests, req.request)
+		}
+	}
+	c.mu.Unlock()
+	for _, env := range requests {
+		_ = c.sendEnvelope(env)
+	}
+	if len(requests) > 0 {
+		utils.LogPrint(c.clientGroup+"->"+c.clientId, "重连后重新发送了", len(requests), "个请求")
+	}
+}
+
+// failPending 让等待中的请求返回err，keepResend为true时保留标记了resendOnReconnect的请求
+func (c *Clients) failPending(err error, keepResend bool) {
+	c.mu.Lock()
+	failed := make([]*pendingRequest, 0)
+	kept := c.actionData[:0]
+	for _, req := range c.actionData {
+		if keepResend && (req.resend || req.offline) {
+			kept = append(kept, req)
+			continue
+		}
+		req.done = true
+		req.err = err
+		failed = append(failed, req)
+	}
+	c.actionData = kept
+	c.mu.Unlock()
+	for _, req := range failed {
+		req.result <- ""
+	}
+}
+
+// dropClient ws连接断开，配置了ReconnectGrace时先等待重连，否则直接下线
+func (s *Server) dropClient(client *Clients, ws wsConn) {
+	grace := time.Duration(s.conf.Websocket.ReconnectGrace) * time.Second
+	client.mu.Lock()
+	if client.clientWs != ws { // 已经被新连接接管
+		client.mu.Unlock()
+		return
+	}
+	select {
+	case <-client.closed:
+		grace = 0
+	default:
+	}
+	if grace <= 0 {
+		client.mu.Unlock()
+		s.removeClient(client)
+		return
+	}
+	client.clientWs = nil
+	client.graceUntil = time.Now().Add(grace)
+	client.graceGen++
+	gen := client.graceGen
+	client.mu.Unlock()
+	s.updateHealth(client)
+
+	client.failPending(errClientClosed, true)
+	// 发送队列里的消息属于已经失败或者重连后会重新发送的请求
+	for len(client.outbound) > 0 {
+		<-client.outbound
+	}
+	utils.LogPrint(client.clientGroup+"->"+client.clientId, client.getIp(), "断开，等待重连")
+	go s.expireGrace(client, gen, grace)
+}
+
+// expireGrace 到期还没有重连就下线，还在等待的请求返回ErrReconnectTimeout
+func (s *Server) expireGrace(client *Clients, gen int, grace time.Duration) {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-client.closed:
+	}
+	client.mu.Lock()
+	if client.graceGen != gen {
+		client.mu.Unlock()
+		return
+	}
+	client.mu.Unlock()
+	client.failPending(ErrReconnectTimeout, false)
+	s.removeClient(client)
+}
+
+// removeClient 客户端下线
+func (s *Server) removeClient(client *Clients) {
+	client.closeOnce.Do(func() { close(client.closed) })
+	s.publish(EventDisconnect, client)
+	utils.LogPrint(client.clientGroup+"->"+client.clientId, client.getIp(), "下线了")
+	// 同一个key可能已经是新连接的客户端了，只删除自己
+	s.hlSyncMap.CompareAndDelete(client.key(), client)
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"JsRpc/jsrpcpb"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService 通过gRPC提供和http接口相同的调用能力
+type grpcService struct {
+	jsrpcpb.UnimplementedJsRpcServer
+	s *Server
+}
+
+func (g *grpcService) Call(ctx context.Context, req *jsrpcpb.CallRequest) (*jsrpcpb.CallResponse, error) {
+	if req.Group == "" || req.Action == "" {
+		return nil, status.Error(codes.InvalidArgument, "需要传入group和action")
+	}
+	client, err := g.s.getActionClient("", req.Group, req.ClientId, req.Action)
+	var e *NoClientError
+	switch {
+	case errors.Is(err, ErrActionForbidden):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.As(err, &e):
+		return nil, noClientStatus(e)
+	}
+	if req.TimeoutMs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutMs)*time.Millisecond)
+		defer cancel()
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, _ := net.SplitHostPort(p.Addr.String())
+		ctx = withCallerIp(ctx, host)
+	}
+	start := time.Now()
+	data, err := g.s.runQuery(ctx, client, Message{Action: req.Action, Param: req.Param})
+	switch {
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return nil, status.Error(codes.DeadlineExceeded, TimeoutMsg)
+	case errors.Is(err, ErrRejected):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrTooManyPending), errors.Is(err, ErrThrottled):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled):
+		return nil, status.Error(codes.Canceled, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &jsrpcpb.CallResponse{Data: data, ClientId: client.clientId, ElapsedMs: time.Since(start).Milliseconds()}, nil
+}
+
+func (g *grpcService) ListClients(_ context.Context, req *jsrpcpb.ListClientsRequest) (*jsrpcpb.ListClientsResponse, error) {
+	res := &jsrpcpb.ListClientsResponse{}
+	g.s.hlSyncMap.Range(func(_, value interface{}) bool {
+		client, ok := value.(*Clients)
+		// grpc没有apiKey，只能看到默认namespace
+		if ok && client.namespace == "" && (req.Group == "" || client.clientGroup == req.Group) {
+			res.Clients = append(res.Clients, clientInfo(client.clientGroup, client.detail()))
+		}
+		return true
+	})
+	return res, nil
+}
+
+func (g *grpcService) WatchEvents(req *jsrpcpb.WatchEventsRequest, stream grpc.ServerStreamingServer[jsrpcpb.ClientEvent]) error {
+	events, cancel := g.s.Subscribe(64)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-events:
+			if ev.Namespace != "" || req.Group != "" && ev.Group != req.Group {
+				continue
+			}
+			var eventType jsrpcpb.ClientEvent_Type
+			switch ev.Type {
+			case EventConnect:
+				eventType = jsrpcpb.ClientEvent_CONNECTED
+			case EventDisconnect:
+				eventType = jsrpcpb.ClientEvent_DISCONNECTED
+			default:
+				continue
+			}
+			err := stream.Send(&jsrpcpb.ClientEvent{
+				Type:       eventType,
+				Client:     clientInfo(ev.Group, ev.Client),
+				TimeUnixMs: ev.Time.UnixMilli(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func clientInfo(group string, d ClientDetail) *jsrpcpb.ClientInfo {
+	return &jsrpcpb.ClientInfo{
+		Group:     group,
+		ClientId:  d.ClientId,
+		ClientIp:  d.ClientIp,
+		PageUrl:   d.PageUrl,
+		PageTitle: d.PageTitle,
+		Transport: d.Transport,
+	}
+}
+
+// serveGrpc 在单独的地址上提供gRPC服务，ln由Start监听
+func (s *Server) serveGrpc(ln net.Listener) {
+	srv := grpc.NewServer()
+	jsrpcpb.RegisterJsRpcServer(srv, &grpcService{s: s})
+	s.mu.Lock()
+	s.grpcServer = srv
+	s.mu.Unlock()
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			log.Error(err)
+		}
+	}()
+}
+
+// stopGrpc 等待进行中的调用结束，ctx结束时强制关闭
+func (s *Server) stopGrpc(ctx context.Context) {
+	s.mu.Lock()
+	srv := s.grpcServer
+	s.grpcServer = nil
+	s.mu.Unlock()
+	if srv == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+// noClientStatus 和http接口的状态码对应：404为NotFound，409为FailedPrecondition，503为Unavailable，
+// reason放在ErrorInfo里，调用方可以用status.FromError取出来判断
+func noClientStatus(e *NoClientError) error {
+	code := codes.FailedPrecondition
+	switch e.StatusCode() {
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	st := status.New(code, e.Error())
+	info := &errdetails.ErrorInfo{Reason: e.Reason, Domain: "jsrpc", Metadata: map[string]string{"group": e.Group}}
+	if e.ClientId != "" {
+		info.Metadata["clientId"] = e.ClientId
+	}
+	if e.Action != "" {
+		info.Metadata["action"] = e.Action
+	}
+	if detailed, err := st.WithDetails(info); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"JsRpc/config"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultGzipMinSize = 1024
+
+var defaultGzipTypes = []string{"application/json", "application/javascript", "text/javascript", "text/html", "text/plain", "text/csv"}
+
+// Gzip 调用方支持gzip时压缩返回，小于MinSize或者类型不在ContentTypes里的原样返回
+// websocket升级和SSE不经过压缩
+func Gzip(conf config.GzipConfig) gin.HandlerFunc {
+	minSize := conf.MinSize
+	if minSize <= 0 {
+		minSize = defaultGzipMinSize
+	}
+	types := conf.ContentTypes
+	if len(types) == 0 {
+		types = defaultGzipTypes
+	}
+	return func(c *gin.Context) {
+		if c.IsWebsocket() || !acceptsGzip(c.GetHeader("Accept-Encoding")) ||
+			strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			c.Next()
+			return
+		}
+		w := &gzipWriter{ResponseWriter: c.Writer, minSize: minSize, types: types}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// acceptsGzip Accept-Encoding里有gzip或*，并且q不是0
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter 先缓存返回，够MinSize后再决定是否压缩；Flush时说明是流式返回，不再压缩
+type gzipWriter struct {
+	gin.ResponseWriter
+	minSize int
+	types   []string
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.passThrough()
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide 缓存够大时决定是否压缩，并把缓存写出去
+func (w *gzipWriter) decide() error {
+	if !w.compressible() {
+		return w.passThrough()
+	}
+	w.decided = true
+	header := w.Header()
+	header.Set("Content-Encoding", "gzip")
+	header.Add("Vary", "Accept-Encoding")
+	header.Del("Content-Length")
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *gzipWriter) passThrough() error {
+	w.decided = true
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *gzipWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || w.Status() == http.StatusNoContent || w.Status() == http.StatusNotModified {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && slices.Contains(w.types, mediaType)
+}
+
+// finish 请求处理完后写出还没达到MinSize的缓存，或者结束gzip流
+func (w *gzipWriter) finish() {
+	if !w.decided {
+		_ = w.passThrough()
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+	}
+}
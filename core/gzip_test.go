@@ -0,0 +1,100 @@
+package core
+
+import (
+	"JsRpc/config"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func gzipServer(t *testing.T) *Server {
+	return newTestServer(t, func(conf *config.ConfStruct) { conf.Gzip = config.GzipConfig{IsEnable: true} })
+}
+
+func TestGzipDetailsRoundTrip(t *testing.T) {
+	s := gzipServer(t)
+	for i := 0; i < 50; i++ {
+		fc := startFake(t, s, wsPeer{group: "g", clientId: fmt.Sprintf("client-%02d", i)}, nil)
+		fc.pushReport("_pageInfo", fmt.Sprintf(`{"url":"https://example.com/page/%d","title":"页面标题%d"}`, i, i))
+	}
+	waitFor(t, "页面信息", func() bool {
+		return strings.Contains(serveRequest(s, http.MethodGet, "/details", "").Body.String(), "页面标题49")
+	})
+
+	plain := serveRequest(s, http.MethodGet, "/details", "")
+	w := serveRequest(s, http.MethodGet, "/details", "", "Accept-Encoding", "gzip, deflate")
+	if w.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+		t.Fatalf("大的/details应该压缩：%v", w.Header())
+	}
+	if w.Header().Get("Content-Length") != "" && w.Header().Get("Content-Length") != fmt.Sprint(w.Body.Len()) {
+		t.Fatalf("Content-Length %s和压缩后的长度%d不一致", w.Header().Get("Content-Length"), w.Body.Len())
+	}
+	if w.Body.Len() >= plain.Body.Len()/3 {
+		t.Fatalf("压缩后%d字节，原来%d字节", w.Body.Len(), plain.Body.Len())
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want struct {
+		Data map[string][]ClientDetail `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("解压后不是json：%v", err)
+	}
+	_ = json.Unmarshal(plain.Body.Bytes(), &want)
+	if len(got.Data["g"]) != 50 || len(got.Data["g"]) != len(want.Data["g"]) {
+		t.Fatalf("解压后有%d个客户端，期望50", len(got.Data["g"]))
+	}
+	for i, client := range got.Data["g"] {
+		if client.ClientId != want.Data["g"][i].ClientId || client.PageTitle == "" || client.PageTitle != want.Data["g"][i].PageTitle {
+			t.Fatalf("第%d个客户端解压后不一致：%+v", i, client)
+		}
+	}
+}
+
+func TestGzipSkipped(t *testing.T) {
+	s := gzipServer(t)
+	for i := 0; i < 50; i++ {
+		startFake(t, s, wsPeer{group: "g", clientId: fmt.Sprintf("client-%02d", i)}, nil)
+	}
+	tests := []struct {
+		name    string
+		target  string
+		headers []string
+	}{
+		{"调用方不支持gzip", "/details", nil},
+		{"q=0表示不接受", "/details", []string{"Accept-Encoding", "gzip;q=0"}},
+		{"小于MinSize", "/healthz", []string{"Accept-Encoding", "gzip"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(s, http.MethodGet, tt.target, "", tt.headers...)
+			if w.Header().Get("Content-Encoding") != "" {
+				t.Fatalf("不应该压缩：%v", w.Header())
+			}
+		})
+	}
+
+	// websocket升级不经过压缩
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	header := http.Header{"Accept-Encoding": {"gzip"}}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?group=g&clientId=ws", header)
+	if err != nil {
+		t.Fatalf("开启gzip后ws连接失败：%v", err)
+	}
+	ws.Close()
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"JsRpc/config"
+	"JsRpc/protocol"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ProtocolVersion 当前的通信协议版本，2开始请求带message_id、返回支持json和分片，
+	// 3开始消息带type字段，控制消息放在control里，见protocol包
+	ProtocolVersion = protocol.VersionTyped
+	// MinProtocolVersion 还兼容的最低版本，1是只有 action+"hl^_^"+data 的旧版客户端
+	MinProtocolVersion = protocol.VersionLegacy
+
+	actionRegistered = "_registered" // 连接后服务端发给客户端的注册回执
+	actionHello      = "_hello"      // 客户端连接后上报的协议版本和元数据
+	actionEncoding   = "_encoding"   // 回复客户端在_hello里请求的编码，之后按这个编码发送
+)
+
+// RegisterReceipt 注册回执，客户端据此调整心跳、消息大小等，不用写死
+type RegisterReceipt struct {
+	ClientId          string `json:"clientId"`
+	Group             string `json:"group"`
+	ProtocolVersion   int    `json:"protocolVersion"`
+	ServerVersion     string `json:"serverVersion"`
+	HeartbeatInterval int    `json:"heartbeatInterval"` // 秒，0表示服务端不要求心跳
+	MaxMessageSize    int64  `json:"maxMessageSize"`    // 字节，0不限制
+	Compression       bool   `json:"compression"`
+	Auth              bool   `json:"auth"`    // 客户端连接是否需要认证
+	Msgpack           bool   `json:"msgpack"` // 可以在_hello里请求msgpack编码
+	// 秒，group配置了共享密钥时请求带签名，客户端验证签名和时间戳，返回也要签名
+	SignMaxSkew int `json:"signMaxSkew,omitempty"`
+}
+
+// ClientMeta 客户端在_hello里上报的信息
+type ClientMeta struct {
+	ProtocolVersion int               `json:"protocolVersion"`
+	UserAgent       string            `json:"userAgent,omitempty"`
+	PageUrl         string            `json:"pageUrl,omitempty"`
+	ScriptVersion   string            `json:"scriptVersion,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`     // 和连接参数里的tags合并
+	Encoding        string            `json:"encoding,omitempty"` // 请求的编码，msgpack需要协议版本3
+	ReceivedAt      time.Time         `json:"receivedAt"`
+}
+
+func (c *Clients) registerReceipt() RegisterReceipt {
+	r := RegisterReceipt{
+		ClientId:        c.clientId,
+		Group:           c.clientGroup,
+		ProtocolVersion: ProtocolVersion,
+		ServerVersion:   config.Version,
+		Compression:     c.compression,
+		Auth:            c.namespace != "",
+	}
+	if c.server != nil {
+		r.MaxMessageSize = c.server.conf.Websocket.MaxMessageSize
+		r.Msgpack = c.server.conf.Websocket.Msgpack && c.transport == transportWs
+		if c.signSecret() != "" {
+			r.SignMaxSkew = int(c.server.signSkew().Seconds())
+		}
+	}
+	return r
+}
+
+// sendRegistered 连接建立后把注册回执发给客户端
+func (c *Clients) sendRegistered() {
+	receipt, _ := json.Marshal(c.registerReceipt())
+	if err := c.sendControl(actionRegistered, string(receipt)); err != nil {
+		log.Error(c.clientGroup+"->"+c.clientId, " 发送注册回执失败:", err)
+	}
+}
+
+// handleHello 记录客户端上报的协议版本和元数据，版本不兼容时按配置断开
+func (c *Clients) handleHello(raw string) {
+	meta := &ClientMeta{}
+	if err := json.Unmarshal([]byte(raw), meta); err != nil {
+		log.Error("_hello格式错误:", err)
+		return
+	}
+	meta.ReceivedAt = time.Now()
+	c.mu.Lock()
+	c.meta = meta
+	c.mu.Unlock()
+	if len(meta.Tags) > 0 {
+		if err := c.mergeTags(meta.Tags); err != nil {
+			log.Error(c.clientGroup+"->"+c.clientId, " _hello里的标签无效:", err)
+		}
+	}
+	if meta.ProtocolVersion >= MinProtocolVersion && meta.ProtocolVersion <= ProtocolVersion {
+		// 之后发给这个客户端的消息按它支持的版本编码
+		c.protoVersion.Store(int32(meta.ProtocolVersion))
+		if meta.Encoding != "" {
+			c.negotiateEncoding(meta)
+		}
+		return
+	}
+	msg := fmt.Sprintf("客户端协议版本%d不在支持的范围%d-%d内", meta.ProtocolVersion, MinProtocolVersion, ProtocolVersion)
+	if c.server != nil && c.server.conf.Websocket.RejectProtocolMismatch {
+		log.Error(c.clientGroup+"->"+c.clientId, " ", msg, "，断开连接")
+		c.close()
+		return
+	}
+	log.Warning(c.clientGroup+"->"+c.clientId, " ", msg)
+}
+
+func (c *Clients) getMeta() *ClientMeta {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.meta
+}
+
+// negotiateEncoding 回复客户端请求的编码，msgpack要服务端开启、协议版本3并且是ws连接，否则回复json
+func (c *Clients) negotiateEncoding(meta *ClientMeta) {
+	encoding := protocol.EncodingJson
+	if meta.Encoding == protocol.EncodingMsgpack && c.registerReceipt().Msgpack && meta.ProtocolVersion >= protocol.VersionTyped {
+		encoding = protocol.EncodingMsgpack
+	}
+	// 回复还用原来的编码，客户端收到后再切换；切换前后两种帧客户端都要能解析
+	if err := c.sendControl(actionEncoding, encoding); err != nil {
+		log.Error(c.clientGroup+"->"+c.clientId, " 回复编码失败:", err)
+		return
+	}
+	c.msgpack.Store(encoding == protocol.EncodingMsgpack)
+}
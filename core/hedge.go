@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type hedgeAnswer struct {
+	client *Clients
+	res    string
+	err    error
+}
+
+// hedgedQuery 先发给first，delay后还没有返回就再发给同group的另一个客户端，delay<=0时同时发送
+// 返回最先成功的结果，另一个请求被取消，不算客户端出错
+func (s *Server) hedgedQuery(ctx context.Context, first *Clients, msg Message, delay time.Duration) (*Clients, string, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	answers := make(chan hedgeAnswer, 2)
+	launch := func(client *Clients) {
+		go func() {
+			res, err := client.query(ctx, msg)
+			answers <- hedgeAnswer{client, res, err}
+		}()
+	}
+
+	var second *Clients
+	if others := s.groupClients(first.namespace, first.clientGroup, first.clientId); len(others) > 0 {
+		second = others[rand.Intn(len(others))]
+	}
+	launch(first)
+	running, hedged := 1, false
+	var hedgeTimer <-chan time.Time
+	if second != nil {
+		if delay <= 0 {
+			launch(second)
+			running, hedged = 2, true
+		} else {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			hedgeTimer = timer.C
+		}
+	}
+	var last hedgeAnswer
+	for {
+		select {
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			launch(second)
+			running, hedged = running+1, true
+		case last = <-answers:
+			running--
+			if last.err == nil {
+				return last.client, last.res, hedged, nil
+			}
+			if running == 0 && hedgeTimer == nil {
+				// 都失败了，返回最后一个错误
+				return last.client, last.res, hedged, last.err
+			}
+			if hedgeTimer != nil { // 第一个已经失败，不用再等delay
+				hedgeTimer = nil
+				launch(second)
+				running, hedged = running+1, true
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHistorySize       = 1000 // 全局保留的请求记录数
+	defaultClientHistorySize = 100  // 每个客户端保留的请求记录数
+	historyParamLimit        = 256  // 记录里param最多保留的字节数
+)
+
+// 请求记录的状态
+const (
+	HistoryOk       = "ok"
+	HistoryTimeout  = "timeout"
+	HistoryRejected = "rejected"
+	HistoryReleased = "released"
+	HistoryError    = "error"
+)
+
+// HistoryRecord 一次已完成的请求
+type HistoryRecord struct {
+	Group    string `json:"group"`
+	ClientId string `json:"clientId"`
+	Action   string `json:"action"`
+	Param    string `json:"param"`
+	Response string `json:"response,omitempty"` // 只有写入sqlite的记录保留返回内容
+	// 调用方的ip、X-Caller-Name和User-Agent
+	CallerIp   string    `json:"callerIp,omitempty"`
+	CallerName string    `json:"callerName,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	LatencyMs  int64     `json:"latencyMs"`
+	Time       time.Time `json:"time"`
+}
+
+// historyRing 固定大小的环形缓冲区，写满后覆盖最旧的记录
+type historyRing struct {
+	mu      sync.Mutex
+	records []HistoryRecord
+	next    int
+	full    bool
+}
+
+func newHistoryRing(size int) *historyRing {
+	return &historyRing{records: make([]HistoryRecord, size)}
+}
+
+func (r *historyRing) add(rec HistoryRecord) {
+	r.mu.Lock()
+	r.records[r.next] = rec
+	r.next++
+	if r.next == len(r.records) {
+		r.next, r.full = 0, true
+	}
+	r.mu.Unlock()
+}
+
+// list 按从新到旧返回符合条件的一页记录，以及符合条件的总数
+func (r *historyRing) list(q HistoryQuery) ([]HistoryRecord, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.records)
+	}
+	res := make([]HistoryRecord, 0, min(q.Limit, count))
+	total := 0
+	for i := 1; i <= count; i++ {
+		rec := &r.records[(r.next-i+len(r.records))%len(r.records)]
+		if !q.match(rec) {
+			continue
+		}
+		if total >= q.Offset && len(res) < q.Limit {
+			res = append(res, *rec)
+		}
+		total++
+	}
+	return res, total
+}
+
+// HistoryQuery /history的查询条件，空值表示不过滤
+type HistoryQuery struct {
+	Group    string
+	ClientId string
+	Action   string
+	Status   string
+	Caller   string // X-Caller-Name
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+func (q HistoryQuery) match(rec *HistoryRecord) bool {
+	return (q.Group == "" || rec.Group == q.Group) &&
+		(q.ClientId == "" || rec.ClientId == q.ClientId) &&
+		(q.Action == "" || rec.Action == q.Action) &&
+		(q.Status == "" || rec.Status == q.Status) &&
+		(q.Caller == "" || rec.CallerName == q.Caller) &&
+		(q.From.IsZero() || !rec.Time.Before(q.From)) &&
+		(q.To.IsZero() || !rec.Time.After(q.To))
+}
+
+func historySize(size, def int) int {
+	if size > 0 {
+		return size
+	}
+	return def
+}
+
+// truncateBytes 按字节截断，不会截断在多字节字符中间
+// 截断后复制一份，避免记录引用着整个大参数导致内存无法回收
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return strings.Clone(s[:n])
+}
+
+// attach 把客户端绑定到服务上，按配置创建客户端自己的请求记录
+func (s *Server) attach(c *Clients) {
+	c.server = s
+	c.groupTraffic = s.groupTraffic(c.namespace, c.clientGroup)
+	if s.history != nil {
+		c.history = newHistoryRing(historySize(s.conf.History.ClientSize, defaultClientHistorySize))
+	}
+}
+
+// recordHistory 请求完成后记录到全局和客户端各自的缓冲区
+func (c *Clients) recordHistory(info *RequestInfo, res string, err error) {
+	s := c.server
+	if s == nil || s.history == nil || c.history == nil {
+		return
+	}
+	rec := HistoryRecord{
+		Group:      info.Group,
+		ClientId:   info.ClientId,
+		Action:     info.Action,
+		Param:      truncateBytes(info.Param, historyParamLimit),
+		Status:     HistoryOk,
+		LatencyMs:  time.Since(info.StartTime).Milliseconds(),
+		Time:       info.StartTime,
+		CallerIp:   info.CallerIp,
+		CallerName: info.CallerName,
+		UserAgent:  info.UserAgent,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		switch {
+		case errors.Is(err, ErrTimeout):
+			rec.Status = HistoryTimeout
+		case errors.Is(err, ErrRejected):
+			rec.Status = HistoryRejected
+		case errors.Is(err, ErrReleased):
+			rec.Status = HistoryReleased
+		default:
+			rec.Status = HistoryError
+		}
+	}
+	s.history.add(rec)
+	c.history.add(rec)
+	if s.historyDB != nil {
+		rec.Response = truncateBytes(res, historyResponseLimit)
+		s.historyDB.add(rec)
+	}
+}
+
+// History 返回符合条件的请求记录(从新到旧)和总数
+// 启用了sqlite时从数据库查询，否则查内存；只按clientId查在线客户端时使用客户端自己的记录
+func (s *Server) History(q HistoryQuery) ([]HistoryRecord, int, error) {
+	if s.historyDB != nil {
+		return s.historyDB.query(q)
+	}
+	if s.history == nil {
+		return []HistoryRecord{}, 0, nil
+	}
+	if q.ClientId != "" {
+		if value, ok := s.hlSyncMap.Load(clientKey("", q.Group, q.ClientId)); ok {
+			if client, _ := value.(*Clients); client != nil && client.history != nil {
+				records, total := client.history.list(q)
+				return records, total, nil
+			}
+		}
+	}
+	records, total := s.history.list(q)
+	return records, total, nil
+}
+
+// parseTime 支持unix毫秒和RFC3339两种格式
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (s *Server) getHistory(c *gin.Context) {
+	if len(s.conf.Namespaces) > 0 && !c.GetBool(ctxAllNamespaces) { // 请求记录没有区分namespace
+		GinJsonMsg(c, http.StatusForbidden, "配置了Namespaces时只有管理员可以查看请求记录")
+		return
+	}
+	q := HistoryQuery{
+		Group:    c.Query("group"),
+		ClientId: c.Query("clientId"),
+		Action:   c.Query("action"),
+		Status:   c.Query("status"),
+		Caller:   c.Query("caller"),
+	}
+	var err error
+	if q.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "50")); err != nil || q.Limit <= 0 {
+		GinJsonMsg(c, http.StatusBadRequest, "limit必须是正整数")
+		return
+	}
+	if q.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0")); err != nil || q.Offset < 0 {
+		GinJsonMsg(c, http.StatusBadRequest, "offset不能小于0")
+		return
+	}
+	if q.From, err = parseTime(c.Query("from")); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, "from格式错误，支持unix毫秒或RFC3339")
+		return
+	}
+	if q.To, err = parseTime(c.Query("to")); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, "to格式错误，支持unix毫秒或RFC3339")
+		return
+	}
+	records, total, err := s.History(q)
+	if err != nil {
+		GinJsonMsg(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": records})
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"JsRpc/config"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	defaultHistoryQueue  = 10000 // 等待写入sqlite的记录数上限，超过后丢弃
+	defaultRetentionDays = 7
+	historyBatchSize     = 200
+	historyFlushInterval = 500 * time.Millisecond
+	historyPruneInterval = time.Hour
+	historyResponseLimit = 1024 // 写入sqlite的返回内容最多保留的字节数
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	time        INTEGER NOT NULL,
+	grp         TEXT NOT NULL,
+	client_id   TEXT NOT NULL,
+	action      TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	error       TEXT NOT NULL,
+	latency_ms  INTEGER NOT NULL,
+	param       TEXT NOT NULL,
+	response    TEXT NOT NULL,
+	caller_ip   TEXT NOT NULL DEFAULT '',
+	caller_name TEXT NOT NULL DEFAULT '',
+	user_agent  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_history_time ON history (time);
+CREATE INDEX IF NOT EXISTS idx_history_client ON history (grp, client_id);
+`
+
+// historyDB 把请求记录异步批量写入sqlite，磁盘慢时丢弃记录而不是拖慢请求
+type historyDB struct {
+	db        *sql.DB
+	queue     chan HistoryRecord
+	retention time.Duration
+	dropped   atomic.Int64
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func openHistoryDB(conf config.SqliteConfig) (*historyDB, error) {
+	path := conf.Path
+	if path == "" {
+		path = "history.db"
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // sqlite同时只能有一个写入
+	if _, err := db.Exec(historySchema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := migrateHistory(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	h := &historyDB{
+		db:        db,
+		queue:     make(chan HistoryRecord, historySize(conf.QueueSize, defaultHistoryQueue)),
+		retention: time.Duration(historySize(conf.RetentionDays, defaultRetentionDays)) * 24 * time.Hour,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go h.writeLoop()
+	return h, nil
+}
+
+// historyColumns 后来加的列，旧版本创建的数据库里补上
+var historyColumns = []string{"caller_ip", "caller_name", "user_agent"}
+
+func migrateHistory(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('history')`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	_ = rows.Close()
+	for _, column := range historyColumns {
+		if !existing[column] {
+			if _, err := db.Exec(`ALTER TABLE history ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *historyDB) add(rec HistoryRecord) {
+	select {
+	case <-h.stop:
+		return
+	default:
+	}
+	select {
+	case h.queue <- rec:
+	default:
+		h.dropped.Add(1)
+	}
+}
+
+func (h *historyDB) writeLoop() {
+	defer close(h.done)
+	flush := time.NewTicker(historyFlushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(historyPruneInterval)
+	defer prune.Stop()
+	h.prune()
+	batch := make([]HistoryRecord, 0, historyBatchSize)
+	var reported int64
+	for {
+		select {
+		case <-h.stop:
+			for len(h.queue) > 0 {
+				batch = append(batch, <-h.queue)
+			}
+			h.insert(batch)
+			return
+		case rec := <-h.queue:
+			batch = append(batch, rec)
+			if len(batch) >= historyBatchSize {
+				h.insert(batch)
+				batch = batch[:0]
+			}
+		case <-flush.C:
+			h.insert(batch)
+			batch = batch[:0]
+			if dropped := h.dropped.Load(); dropped != reported {
+				log.Warning("请求记录写入太慢，已丢弃", dropped, "条")
+				reported = dropped
+			}
+		case <-prune.C:
+			h.prune()
+		}
+	}
+}
+
+func (h *historyDB) insert(batch []HistoryRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		log.Error("写入请求记录失败:", err)
+		return
+	}
+	stmt, err := tx.Prepare(`INSERT INTO history (time, grp, client_id, action, status, error, latency_ms, param, response,
+		caller_ip, caller_name, user_agent) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		_ = tx.Rollback()
+		log.Error("写入请求记录失败:", err)
+		return
+	}
+	defer stmt.Close()
+	for _, rec := range batch {
+		_, err = stmt.Exec(rec.Time.UnixMilli(), rec.Group, rec.ClientId, rec.Action, rec.Status, rec.Error,
+			rec.LatencyMs, rec.Param, rec.Response, rec.CallerIp, rec.CallerName, rec.UserAgent)
+		if err != nil {
+			_ = tx.Rollback()
+			log.Error("写入请求记录失败:", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Error("写入请求记录失败:", err)
+	}
+}
+
+func (h *historyDB) prune() {
+	before := time.Now().Add(-h.retention).UnixMilli()
+	if _, err := h.db.Exec(`DELETE FROM history WHERE time < ?`, before); err != nil {
+		log.Error("清理请求记录失败:", err)
+	}
+}
+
+// query 按条件分页查询，返回这一页的记录和符合条件的总数
+func (h *historyDB) query(q HistoryQuery) ([]HistoryRecord, int, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if q.Group != "" {
+		add("grp = ?", q.Group)
+	}
+	if q.ClientId != "" {
+		add("client_id = ?", q.ClientId)
+	}
+	if q.Action != "" {
+		add("action = ?", q.Action)
+	}
+	if q.Status != "" {
+		add("status = ?", q.Status)
+	}
+	if q.Caller != "" {
+		add("caller_name = ?", q.Caller)
+	}
+	if !q.From.IsZero() {
+		add("time >= ?", q.From.UnixMilli())
+	}
+	if !q.To.IsZero() {
+		add("time <= ?", q.To.UnixMilli())
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM history`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := h.db.Query(`SELECT time, grp, client_id, action, status, error, latency_ms, param, response,
+		caller_ip, caller_name, user_agent FROM history`+
+		cond+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	records := make([]HistoryRecord, 0)
+	for rows.Next() {
+		var rec HistoryRecord
+		var ms int64
+		if err := rows.Scan(&ms, &rec.Group, &rec.ClientId, &rec.Action, &rec.Status, &rec.Error,
+			&rec.LatencyMs, &rec.Param, &rec.Response, &rec.CallerIp, &rec.CallerName, &rec.UserAgent); err != nil {
+			return nil, 0, err
+		}
+		rec.Time = time.UnixMilli(ms)
+		records = append(records, rec)
+	}
+	return records, total, rows.Err()
+}
+
+// close 写完队列里剩下的记录后关闭数据库
+func (h *historyDB) close() error {
+	close(h.stop)
+	<-h.done
+	return h.db.Close()
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"JsRpc/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrRejected 请求被hook拒绝，http接口返回403
+var ErrRejected = errors.New("rejected by hook")
+
+// RequestInfo 一次调用的信息，OnRequest里可以修改Param和Args
+type RequestInfo struct {
+	Group    string
+	ClientId string
+	Action   string
+	Param    string
+	Args     json.RawMessage
+	CallerIp string // http接口的调用方ip，直接调用Server.Call时为空
+	// 调用方的X-Caller-Name和User-Agent，已经去掉了控制字符并截断
+	CallerName string
+	UserAgent  string
+	StartTime  time.Time
+}
+
+// Hook 嵌入JsRpc时观察和修改请求，只需要部分方法时可以嵌入NopHook
+type Hook interface {
+	// OnRequest 发给客户端之前调用，返回错误时拒绝这次请求
+	OnRequest(ctx context.Context, info *RequestInfo) error
+	// OnResponse 客户端返回、超时、出错或者被拒绝后调用
+	OnResponse(ctx context.Context, info *RequestInfo, result string, err error)
+	OnClientConnect(group string, client ClientDetail)
+	OnClientDisconnect(group string, client ClientDetail)
+}
+
+// NopHook 所有方法都不做任何事
+type NopHook struct{}
+
+func (NopHook) OnRequest(context.Context, *RequestInfo) error           { return nil }
+func (NopHook) OnResponse(context.Context, *RequestInfo, string, error) {}
+func (NopHook) OnClientConnect(string, ClientDetail)                    {}
+func (NopHook) OnClientDisconnect(string, ClientDetail)                 {}
+
+// AddHook 注册hook，按注册顺序调用
+func (s *Server) AddHook(h Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks[:len(s.hooks):len(s.hooks)], h)
+}
+
+func (s *Server) getHooks() []Hook {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hooks
+}
+
+// callHook hook里的panic只记录日志并上报，不影响请求
+func callHook(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("hook ", name, " panic: ", r)
+			reportPanic(context.Background(), r)
+		}
+	}()
+	fn()
+}
+
+func (s *Server) beforeRequest(ctx context.Context, info *RequestInfo) error {
+	for _, h := range s.getHooks() {
+		var err error
+		callHook("OnRequest", func() { err = h.OnRequest(ctx, info) })
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrRejected, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) afterResponse(ctx context.Context, info *RequestInfo, result string, err error) {
+	for _, h := range s.getHooks() {
+		callHook("OnResponse", func() { h.OnResponse(ctx, info, result, err) })
+	}
+}
+
+func (s *Server) clientHooks(eventType string, group string, detail ClientDetail) {
+	for _, h := range s.getHooks() {
+		switch eventType {
+		case EventConnect:
+			callHook("OnClientConnect", func() { h.OnClientConnect(group, detail) })
+		case EventDisconnect:
+			callHook("OnClientDisconnect", func() { h.OnClientDisconnect(group, detail) })
+		}
+	}
+}
+
+// LoggingHook 记录每次调用的耗时和结果，PreviewLength大于0时同时记录参数和返回的前这么多个字符
+type LoggingHook struct {
+	NopHook
+	PreviewLength int
+}
+
+func (h LoggingHook) OnResponse(_ context.Context, info *RequestInfo, result string, err error) {
+	elapsed := time.Since(info.StartTime).Milliseconds()
+	var body string
+	if h.PreviewLength > 0 {
+		body = " 参数:" + utils.Preview(info.Param, h.PreviewLength) + " 返回:" + utils.Preview(result, h.PreviewLength)
+	}
+	if info.CallerName != "" {
+		body = " 调用方:" + info.CallerName + "@" + info.CallerIp + body
+	} else if info.CallerIp != "" {
+		body = " 调用方:" + info.CallerIp + body
+	}
+	if err != nil {
+		log.Warning(info.Group, "->", info.ClientId, " action:", info.Action, " 耗时:", elapsed, "ms 出错:", err, body)
+		return
+	}
+	log.Info(info.Group, "->", info.ClientId, " action:", info.Action, " 耗时:", elapsed, "ms", body)
+}
+
+// MetricsHook 统计调用次数，Snapshot返回当前的计数
+type MetricsHook struct {
+	NopHook
+	requests, errors, timeouts, rejected, connects, disconnects atomic.Int64
+}
+
+func (m *MetricsHook) OnResponse(_ context.Context, _ *RequestInfo, _ string, err error) {
+	m.requests.Add(1)
+	switch {
+	case errors.Is(err, ErrTimeout):
+		m.timeouts.Add(1)
+	case errors.Is(err, ErrRejected):
+		m.rejected.Add(1)
+	case err != nil:
+		m.errors.Add(1)
+	}
+}
+
+func (m *MetricsHook) OnClientConnect(string, ClientDetail)    { m.connects.Add(1) }
+func (m *MetricsHook) OnClientDisconnect(string, ClientDetail) { m.disconnects.Add(1) }
+
+func (m *MetricsHook) Snapshot() map[string]int64 {
+	return map[string]int64{
+		"requests":    m.requests.Load(),
+		"errors":      m.errors.Load(),
+		"timeouts":    m.timeouts.Load(),
+		"rejected":    m.rejected.Load(),
+		"connects":    m.connects.Load(),
+		"disconnects": m.disconnects.Load(),
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// testHook 用函数配置各个回调，记录收到的响应和上下线
+type testHook struct {
+	NopHook
+	onRequest func(info *RequestInfo) error
+	onConnect func()
+
+	mu        sync.Mutex
+	responses []string
+	errs      []error
+	connected []string
+	left      []string
+}
+
+func (h *testHook) OnRequest(_ context.Context, info *RequestInfo) error {
+	if h.onRequest == nil {
+		return nil
+	}
+	return h.onRequest(info)
+}
+
+func (h *testHook) OnResponse(_ context.Context, _ *RequestInfo, result string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.responses = append(h.responses, result)
+	h.errs = append(h.errs, err)
+}
+
+func (h *testHook) OnClientConnect(_ string, client ClientDetail) {
+	if h.onConnect != nil {
+		h.onConnect()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connected = append(h.connected, client.ClientId)
+}
+
+func (h *testHook) OnClientDisconnect(_ string, client ClientDetail) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.left = append(h.left, client.ClientId)
+}
+
+func echoHello() map[string]func(string) string {
+	return map[string]func(string) string{"hello": func(param string) string { return "hi " + param }}
+}
+
+func TestHookRejects(t *testing.T) {
+	s := newTestServer(t, nil)
+	hook := &testHook{onRequest: func(info *RequestInfo) error {
+		if info.Param == "bad" {
+			return errors.New("参数不允许")
+		}
+		return nil
+	}}
+	s.AddHook(hook)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHello())
+
+	if _, err := s.Call(testContext(t), "g", "c", "hello", "bad"); !errors.Is(err, ErrRejected) {
+		t.Fatalf("hook拒绝时应该返回ErrRejected，得到 %v", err)
+	}
+	w := serveRequest(s, http.MethodGet, "/go?group=g&action=hello&param=bad", "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("hook拒绝时/go返回%d，期望403", w.Code)
+	}
+	if res, err := s.Call(testContext(t), "g", "c", "hello", "ok"); err != nil || res != "hi ok" {
+		t.Fatalf("没有被拒绝的请求 = %q, %v", res, err)
+	}
+	if n := fc.client.requests.Load(); n != 1 {
+		t.Fatalf("被拒绝的请求不应该计入客户端的请求数，得到%d", n)
+	}
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if len(hook.errs) != 3 || !errors.Is(hook.errs[0], ErrRejected) || hook.errs[2] != nil {
+		t.Fatalf("被拒绝的请求也要调用OnResponse，得到 %v", hook.errs)
+	}
+}
+
+func TestHookMutatesParam(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.AddHook(&testHook{onRequest: func(info *RequestInfo) error {
+		info.Param = "[" + info.Param + "]"
+		return nil
+	}})
+	s.AddHook(&testHook{onRequest: func(info *RequestInfo) error {
+		info.Param += "!" // 后注册的hook看到前一个修改后的参数
+		return nil
+	}})
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHello())
+	if res, err := s.Call(testContext(t), "g", "c", "hello", "1"); err != nil || res != "hi [1]!" {
+		t.Fatalf("hook修改参数后的结果 = %q, %v", res, err)
+	}
+}
+
+func TestHookPanicIsolated(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.AddHook(&testHook{
+		onRequest: func(*RequestInfo) error { panic("OnRequest出错") },
+		onConnect: func() { panic("OnClientConnect出错") },
+	})
+	after := &testHook{}
+	s.AddHook(after)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHello())
+
+	if res, err := s.Call(testContext(t), "g", "c", "hello", "1"); err != nil || res != "hi 1" {
+		t.Fatalf("hook panic后请求应该照常完成，得到 %q, %v", res, err)
+	}
+	_ = fc.ws.Close()
+	<-fc.done
+	waitFor(t, "下线hook", func() bool {
+		after.mu.Lock()
+		defer after.mu.Unlock()
+		return len(after.left) == 1
+	})
+	after.mu.Lock()
+	defer after.mu.Unlock()
+	if len(after.connected) != 1 || len(after.responses) == 0 {
+		t.Fatalf("前一个hook panic后，后面的hook也要被调用：connected=%v responses=%v", after.connected, after.responses)
+	}
+}
+
+func TestMetricsHook(t *testing.T) {
+	s := newTestServer(t, nil)
+	metrics := &MetricsHook{}
+	s.AddHook(metrics)
+	s.AddHook(&testHook{onRequest: func(info *RequestInfo) error {
+		if info.Param == "bad" {
+			return errors.New("no")
+		}
+		return nil
+	}})
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHello())
+	_, _ = s.Call(testContext(t), "g", "c", "hello", "1")
+	_, _ = s.Call(testContext(t), "g", "c", "hello", "bad")
+	_ = fc.ws.Close()
+	<-fc.done
+	waitFor(t, "下线统计", func() bool { return metrics.Snapshot()["disconnects"] == 1 })
+	got := metrics.Snapshot()
+	// startFake里的_listActions不经过hook
+	if got["requests"] != 2 || got["rejected"] != 1 || got["connects"] != 1 {
+		t.Fatalf("MetricsHook统计 = %v", got)
+	}
+}
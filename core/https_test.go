@@ -0,0 +1,163 @@
+package core
+
+import (
+	"JsRpc/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeTestCert 在dir里写入commonName的自签名证书，返回证书和私钥的路径
+func writeTestCert(t testing.TB, dir string, commonName string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return pemPath, keyPath
+}
+
+// startHttps 用自签名证书启动http和https监听，返回实际的http和https地址
+func startHttps(t *testing.T, mode string) (*Server, string, string) {
+	t.Helper()
+	pemPath, keyPath := writeTestCert(t, t.TempDir(), "jsrpc-test")
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.BasicListen = config.ListenList{"127.0.0.1:0"}
+		conf.HttpsServices = config.HttpsConfig{IsEnable: true, HttpsListen: freeAddr(t), HttpMode: mode,
+			PemPath: pemPath, KeyPath: keyPath}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addrs := s.ListenAddrs()
+	return s, addrs[listenHttp][0], addrs[listenHttps][0]
+}
+
+// freeAddr 一个当前空闲的本地地址，跳转地址里的https端口来自配置，不能用0
+func freeAddr(t testing.TB) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// dialWs 连接ws并等到客户端注册
+func dialWs(t *testing.T, s *Server, url string, clientId string) {
+	t.Helper()
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, HandshakeTimeout: 3 * time.Second}
+	ws, _, err := dialer.Dial(url+"/ws?group=g&clientId="+clientId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ws.Close() })
+	waitFor(t, clientId+"注册", func() bool { return s.Client("g", clientId) != nil })
+}
+
+func noRedirectClient() *http.Client {
+	return &http.Client{
+		Timeout:       3 * time.Second,
+		Transport:     &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+func TestWsOverWss(t *testing.T) {
+	s, _, httpsAddr := startHttps(t, config.HttpModeRedirect)
+	dialWs(t, s, "wss://"+httpsAddr, "wss")
+	res, err := noRedirectClient().Get("https://" + httpsAddr + "/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("https上的/list返回%d", res.StatusCode)
+	}
+}
+
+func TestWsOverHttpWithRedirectOff(t *testing.T) {
+	s, httpAddr, _ := startHttps(t, config.HttpModeServe)
+	dialWs(t, s, "ws://"+httpAddr, "plain")
+	res, err := noRedirectClient().Get("http://" + httpAddr + "/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("HttpMode=serve时http上的/list返回%d，不应该跳转", res.StatusCode)
+	}
+}
+
+func TestApiRedirectOn(t *testing.T) {
+	s, httpAddr, httpsAddr := startHttps(t, config.HttpModeRedirect)
+	res, err := noRedirectClient().Get("http://" + httpAddr + "/list?group=g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 300 || res.StatusCode >= 400 {
+		t.Fatalf("HttpMode=redirect时/list返回%d，期望跳转", res.StatusCode)
+	}
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	location := res.Header.Get("Location")
+	if !strings.HasPrefix(location, "https://127.0.0.1:"+httpsPort+"/list") {
+		t.Fatalf("跳转地址是%q，期望https端口%s", location, httpsPort)
+	}
+	// ws升级请求不跳转，页面仍然可以用ws://连接
+	dialWs(t, s, "ws://"+httpAddr, "plain")
+}
+
+func TestHealthzModeOnlyServesHealthz(t *testing.T) {
+	_, httpAddr, _ := startHttps(t, config.HttpModeHealthz)
+	client := noRedirectClient()
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/list": http.StatusNotFound} {
+		res, err := client.Get("http://" + httpAddr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("HttpMode=healthz时%s返回%d，期望%d", path, res.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InflightRequest 一个还在等待客户端返回的请求
+type InflightRequest struct {
+	Group     string `json:"group"`
+	ClientId  string `json:"clientId"`
+	Action    string `json:"action"`
+	MessageId string `json:"messageId"`
+	AgeMs     int64  `json:"ageMs"`
+	CallerIp  string `json:"callerIp"`
+	// 调用方的X-Caller-Name和User-Agent
+	CallerName string `json:"callerName,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	Priority   string `json:"priority"`
+	Queued     bool   `json:"queued"`             // 还在排队，没有发给客户端
+	Position   int    `json:"position,omitempty"` // 排队的位置，从1开始
+}
+
+// Inflight 返回所有等待中的请求，等待最久的在前
+func (s *Server) Inflight() []InflightRequest {
+	now := time.Now()
+	list := make([]InflightRequest, 0)
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		client, ok := value.(*Clients)
+		if !ok {
+			return true
+		}
+		client.mu.Lock()
+		for _, req := range client.actionData {
+			list = append(list, InflightRequest{
+				Group:      client.clientGroup,
+				ClientId:   client.clientId,
+				Action:     req.action,
+				MessageId:  req.messageId,
+				AgeMs:      now.Sub(req.created).Milliseconds(),
+				CallerIp:   req.caller.Ip,
+				CallerName: req.caller.Name,
+				UserAgent:  req.caller.UserAgent,
+				Priority:   priorityName(req.priority),
+			})
+		}
+		client.mu.Unlock()
+		for i, w := range client.queued() {
+			list = append(list, InflightRequest{
+				Group:      client.clientGroup,
+				ClientId:   client.clientId,
+				Action:     w.action,
+				MessageId:  w.messageId,
+				AgeMs:      now.Sub(w.enqueued).Milliseconds(),
+				CallerIp:   w.caller.Ip,
+				CallerName: w.caller.Name,
+				UserAgent:  w.caller.UserAgent,
+				Priority:   priorityName(w.priority),
+				Queued:     true,
+				Position:   i + 1,
+			})
+		}
+		return true
+	})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].AgeMs > list[j].AgeMs })
+	return list
+}
+
+// Release 释放等待中的请求，调用方会立即收到ErrReleased
+func (s *Server) Release(messageId string) bool {
+	return s.release(messageId, func(string) bool { return true })
+}
+
+// release 只释放allow返回true的group里的请求
+func (s *Server) release(messageId string, allow func(group string) bool) bool {
+	released := false
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		if client, ok := value.(*Clients); ok && allow(client.clientGroup) && client.release(messageId) {
+			released = true
+			return false
+		}
+		return true
+	})
+	return released
+}
+
+func (s *Server) getInflight(c *gin.Context) {
+	list := make([]InflightRequest, 0)
+	for _, req := range s.Inflight() {
+		if canAdminGroup(c, req.Group) {
+			list = append(list, req)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": list})
+}
+
+func (s *Server) releaseInflight(c *gin.Context) {
+	messageId := c.Query("messageId")
+	if messageId == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入messageId")
+		return
+	}
+	allow := func(group string) bool { return canAdminGroup(c, group) }
+	if !s.release(messageId, allow) {
+		GinJsonMsg(c, http.StatusNotFound, "没有找到等待中的请求，可能已经返回")
+		return
+	}
+	GinJsonMsg(c, http.StatusOK, "已释放")
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"JsRpc/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hangingClient 接入一个收到hang后一直不返回的客户端，测试结束时才放行
+func hangingClient(t *testing.T, s *Server, group string) {
+	t.Helper()
+	gate := make(chan struct{})
+	startFake(t, s, wsPeer{group: group, clientId: "c"}, map[string]func(string) string{
+		"hang": func(string) string { <-gate; return "late" },
+	})
+	t.Cleanup(func() { close(gate) })
+}
+
+// goAsync 在后台调用/go，返回响应的chan
+func goAsync(s *Server, target string, headers ...string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- serveRequest(s, http.MethodGet, target, "", headers...) }()
+	return done
+}
+
+func TestInflightListAndRelease(t *testing.T) {
+	s := newTestServer(t, nil)
+	hangingClient(t, s, "g")
+	done := goAsync(s, "/go?group=g&action=hang&param=1", "X-Caller-Name", "crawler")
+	waitFor(t, "请求进入等待", func() bool { return len(s.Inflight()) == 1 })
+	time.Sleep(20 * time.Millisecond)
+
+	body := decodeBody(t, serveRequest(s, http.MethodGet, "/inflight", ""))
+	list, _ := body["data"].([]interface{})
+	if len(list) != 1 {
+		t.Fatalf("/inflight = %v", body)
+	}
+	req := list[0].(map[string]interface{})
+	if req["group"] != "g" || req["clientId"] != "c" || req["action"] != "hang" || req["callerIp"] != "192.0.2.1" ||
+		req["callerName"] != "crawler" || req["ageMs"].(float64) < 20 {
+		t.Fatalf("等待中的请求信息不对：%v", req)
+	}
+	messageId := req["messageId"].(string)
+	if messageId == "" {
+		t.Fatal("没有messageId")
+	}
+
+	w := serveRequest(s, http.MethodGet, "/inflight/release?messageId=unknown", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("释放不存在的请求返回%d，期望404", w.Code)
+	}
+	w = serveRequest(s, http.MethodPost, "/inflight/release?messageId="+messageId, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("释放返回%d：%s", w.Code, w.Body.String())
+	}
+	select {
+	case res := <-done:
+		if !strings.Contains(res.Body.String(), ErrReleased.Error()) {
+			t.Fatalf("被释放的调用方应该收到operator released：%d %s", res.Code, res.Body.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("释放后调用方没有立即返回")
+	}
+	if len(s.Inflight()) != 0 {
+		t.Fatalf("释放后还有等待中的请求：%v", s.Inflight())
+	}
+	if w := serveRequest(s, http.MethodGet, "/inflight/release", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("缺少messageId时返回%d，期望400", w.Code)
+	}
+}
+
+func TestInflightRequiresAdminToken(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.AdminToken = "admin"
+		conf.GroupAdminTokens = map[string][]string{"scoped": {"a"}}
+	})
+	hangingClient(t, s, "a")
+	hangingClient(t, s, "b")
+	doneA := goAsync(s, "/go?group=a&action=hang")
+	doneB := goAsync(s, "/go?group=b&action=hang")
+	waitFor(t, "两个请求进入等待", func() bool { return len(s.Inflight()) == 2 })
+
+	if w := serveRequest(s, http.MethodGet, "/inflight", ""); w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+		t.Fatalf("没有token时/inflight返回%d", w.Code)
+	}
+	list := decodeBody(t, serveRequest(s, http.MethodGet, "/inflight", "", "X-Admin-Token", "scoped"))["data"].([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["group"] != "a" {
+		t.Fatalf("group管理token只能看到自己的group：%v", list)
+	}
+	var idB string
+	for _, req := range s.Inflight() {
+		if req.Group == "b" {
+			idB = req.MessageId
+		}
+	}
+	if w := serveRequest(s, http.MethodGet, "/inflight/release?messageId="+idB, "", "X-Admin-Token", "scoped"); w.Code == http.StatusOK {
+		t.Fatal("group管理token不能释放其它group的请求")
+	}
+	for _, req := range s.Inflight() {
+		serveRequest(s, http.MethodGet, "/inflight/release?messageId="+req.MessageId, "", "X-Admin-Token", "admin")
+	}
+	for _, done := range []<-chan *httptest.ResponseRecorder{doneA, doneB} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("管理员释放后调用方没有返回")
+		}
+	}
+}
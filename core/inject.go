@@ -0,0 +1,54 @@
+package core
+
+import (
+	"JsRpc/resouces"
+	"JsRpc/utils"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// injectScript 拼出可以直接在控制台或者油猴@require里加载的注入代码
+func injectScript(wsURL string) string {
+	return resouces.JsEnv + "\n\n// 由服务端生成，重复注入时复用已有的连接\n" +
+		"window.jsrpc = window.jsrpc || new Hlclient(" + utils.JsStringLiteral(wsURL) + ");\n"
+}
+
+// wsURL 按当前请求的host和协议拼出客户端连接的ws地址
+func (s *Server) wsURL(c *gin.Context, group, clientId string) string {
+	u := url.URL{Scheme: "ws", Host: c.Request.Host, Path: s.activeRoutes["/ws"]}
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		u.Scheme = "wss"
+	}
+	q := url.Values{"group": {group}}
+	if clientId != "" {
+		q.Set("clientId", clientId)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (s *Server) jsRpcScript(c *gin.Context) {
+	group, clientId := c.Query("group"), c.Query("clientId")
+	if group == "" {
+		c.String(http.StatusBadRequest, "// 需要group参数")
+		return
+	}
+	if s.activeRoutes["/ws"] == "" {
+		c.String(http.StatusNotFound, "// ws路由已禁用")
+		return
+	}
+	script := injectScript(s.wsURL(c, group, clientId))
+	sum := sha1.Sum([]byte(script))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache") // 每次都用ETag校验，内容没变时返回304
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/javascript; charset=utf-8", []byte(script))
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonpPaths 开启Security.EnableJsonp后，这些只读接口的GET请求可以带callback参数
+var jsonpPaths = map[string]bool{
+	"/go":          true,
+	"/page/cookie": true,
+	"/list":        true,
+}
+
+// 只允许字母、数字和下划线，避免callback里注入脚本
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// Jsonp 带callback参数时把返回包成 callback(json); 没有开启时忽略callback参数
+// 浏览器用script标签请求时看不到http状态码，所以总是返回200，状态看json里的status
+func (s *Server) Jsonp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		callback := c.Query("callback")
+		if !s.conf.Security.EnableJsonp || callback == "" || c.Request.Method != http.MethodGet || isV2(c) {
+			c.Next()
+			return
+		}
+		if !jsonpCallback.MatchString(callback) {
+			GinJsonMsg(c, http.StatusBadRequest, "callback只能包含字母、数字和下划线")
+			c.Abort()
+			return
+		}
+		w := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.Status() == http.StatusNotModified { // 浏览器用缓存里的脚本
+			c.Status(http.StatusNotModified)
+			return
+		}
+		body := w.body.Bytes()
+		if !json.Valid(body) {
+			body, _ = json.Marshal(string(body))
+		}
+		// 处理函数已经设置了application/json，需要覆盖
+		c.Header("Content-Type", "application/javascript; charset=utf-8")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Data(http.StatusOK, "application/javascript; charset=utf-8", []byte("/**/"+callback+"("+string(body)+");"))
+	}
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"JsRpc/config"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func jsonpServer(t *testing.T, enable bool) *Server {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.Security.EnableJsonp = enable })
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+	return s
+}
+
+// unwrapJsonp 去掉 /**/callback( 和 ); 返回里面的json
+func unwrapJsonp(t *testing.T, body string, callback string) map[string]interface{} {
+	t.Helper()
+	prefix := "/**/" + callback + "("
+	if !strings.HasPrefix(body, prefix) || !strings.HasSuffix(body, ");") {
+		t.Fatalf("不是%s的jsonp返回：%s", callback, body)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(body[len(prefix):len(body)-2]), &v); err != nil {
+		t.Fatalf("callback里不是json：%s", body)
+	}
+	return v
+}
+
+func TestJsonpValidCallback(t *testing.T) {
+	s := jsonpServer(t, true)
+	for _, target := range []string{"/go?group=g&action=hello&param=1&callback=cb_1", "/list?callback=cb_1", "/go?group=none&action=hello&callback=cb_1"} {
+		w := serveRequest(s, http.MethodGet, target, "")
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/javascript") ||
+			w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Fatalf("%s返回%d %v", target, w.Code, w.Header())
+		}
+		body := unwrapJsonp(t, w.Body.String(), "cb_1")
+		if strings.Contains(target, "none") {
+			// script标签看不到状态码，出错时也是200，状态在json里
+			if body["status"] != float64(http.StatusNotFound) {
+				t.Fatalf("出错时json里的status应该是404：%v", body)
+			}
+		} else if body["status"] != float64(http.StatusOK) {
+			t.Fatalf("%s的jsonp返回：%v", target, body)
+		}
+	}
+}
+
+func TestJsonpHostileCallback(t *testing.T) {
+	s := jsonpServer(t, true)
+	hostile := []string{
+		"alert(1)//",
+		"a.b",
+		"<script>",
+		"cb;alert(1)",
+		"1abc",
+		"a-b",
+		"回调",
+		strings.Repeat("a", 65),
+	}
+	for _, callback := range hostile {
+		t.Run(callback, func(t *testing.T) {
+			w := serveRequest(s, http.MethodGet, "/go?group=g&action=hello&callback="+url.QueryEscape(callback), "")
+			if w.Code != http.StatusBadRequest || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				t.Fatalf("不合法的callback返回%d %s", w.Code, w.Header().Get("Content-Type"))
+			}
+			if strings.Contains(w.Body.String(), callback) {
+				t.Fatalf("返回里不能回显callback：%s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestJsonpIgnored(t *testing.T) {
+	tests := []struct {
+		name   string
+		enable bool
+		method string
+		target string
+	}{
+		{"没有开启", false, http.MethodGet, "/go?group=g&action=hello&param=1&callback=cb"},
+		{"没有开启时不校验callback", false, http.MethodGet, "/go?group=g&action=hello&param=1&callback=alert(1)"},
+		{"不支持jsonp的接口", true, http.MethodGet, "/details?callback=cb"},
+		{"POST请求", true, http.MethodPost, "/go?group=g&action=hello&param=1&callback=cb"},
+		{"v2接口", true, http.MethodGet, apiV2Prefix + "/go?group=g&action=hello&param=1&callback=cb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := jsonpServer(t, tt.enable)
+			w := serveRequest(s, tt.method, tt.target, "")
+			if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				t.Fatalf("应该按普通json返回：%d %s %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+			}
+			decodeBody(t, w)
+		})
+	}
+}
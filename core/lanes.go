@@ -0,0 +1,191 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 请求优先级，配置了Pending.MaxConcurrent后排队时生效
+const (
+	PriorityLow    = 0
+	PriorityNormal = 1
+	PriorityHigh   = 2
+
+	defaultAgingSeconds = 10
+)
+
+var priorityNames = map[string]int{"low": PriorityLow, "normal": PriorityNormal, "high": PriorityHigh}
+
+// ErrInvalidPriority priority参数不是high、normal、low
+var ErrInvalidPriority = errors.New("priority只能是high、normal、low")
+
+type priorityKey struct{}
+
+// withPriority 把请求优先级放进ctx
+func withPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func requestPriority(ctx context.Context) int {
+	if p, ok := ctx.Value(priorityKey{}).(int); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// parsePriority 空字符串为normal
+func parsePriority(name string) (int, error) {
+	if name == "" {
+		return PriorityNormal, nil
+	}
+	p, ok := priorityNames[name]
+	if !ok {
+		return 0, ErrInvalidPriority
+	}
+	return p, nil
+}
+
+func priorityName(p int) string {
+	for name, v := range priorityNames {
+		if v == p {
+			return name
+		}
+	}
+	return "normal"
+}
+
+// laneWaiter 一个在排队等待发送的请求
+type laneWaiter struct {
+	messageId string
+	action    string
+	caller    CallerInfo
+	priority  int
+	enqueued  time.Time
+	ready     chan struct{} // 轮到它时关闭
+}
+
+// effective 排队时间每过aging提升一级，低优先级的请求不会一直被插队
+func (w *laneWaiter) effective(now time.Time, aging time.Duration) int {
+	p := w.priority + int(now.Sub(w.enqueued)/aging)
+	if p > PriorityHigh {
+		return PriorityHigh
+	}
+	return p
+}
+
+// clientLane 限制同时发给一个客户端的请求数，多出来的按优先级排队，已经发出的请求不会被抢占
+type clientLane struct {
+	mu      sync.Mutex
+	active  int
+	waiting []*laneWaiter
+}
+
+func (c *Clients) maxConcurrent() int {
+	if c.server == nil {
+		return 0
+	}
+	return c.server.conf.Pending.MaxConcurrent
+}
+
+func (c *Clients) agingInterval() time.Duration {
+	seconds := defaultAgingSeconds
+	if c.server != nil && c.server.conf.Pending.AgingSeconds > 0 {
+		seconds = c.server.conf.Pending.AgingSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// acquire 等到可以发送为止，timeout和ctx结束时放弃排队
+func (c *Clients) acquire(ctx context.Context, w *laneWaiter, timeout <-chan time.Time) error {
+	limit := c.maxConcurrent()
+	if limit <= 0 {
+		return nil
+	}
+	l := &c.lane
+	l.mu.Lock()
+	if l.active < limit && len(l.waiting) == 0 {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	// 排队的请求也算在Pending.MaxPerClient里
+	if max := c.maxPending(); max > 0 && l.active+len(l.waiting) >= max {
+		depth := l.active + len(l.waiting)
+		l.mu.Unlock()
+		return &PendingLimitError{Depth: depth, Limit: max, RetryAfter: c.timeout()}
+	}
+	w.enqueued = time.Now()
+	w.ready = make(chan struct{})
+	l.waiting = append(l.waiting, w)
+	l.mu.Unlock()
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-timeout:
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-c.closed:
+		err = errClientClosed
+	}
+	l.mu.Lock()
+	for i, other := range l.waiting {
+		if other == w {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			l.mu.Unlock()
+			return err
+		}
+	}
+	l.mu.Unlock()
+	// 放弃的同时刚好轮到它，把位置让给下一个
+	c.releaseLane()
+	return err
+}
+
+// releaseLane 请求结束，让排在最前面的请求发送
+func (c *Clients) releaseLane() {
+	if c.maxConcurrent() <= 0 {
+		return
+	}
+	l := &c.lane
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if len(l.waiting) == 0 {
+		return
+	}
+	next := l.next(time.Now(), c.agingInterval())
+	w := l.waiting[next]
+	l.waiting = append(l.waiting[:next], l.waiting[next+1:]...)
+	l.active++
+	close(w.ready)
+}
+
+// next 优先级最高的请求，同级的先来先发
+func (l *clientLane) next(now time.Time, aging time.Duration) int {
+	best := 0
+	for i, w := range l.waiting {
+		if w.effective(now, aging) > l.waiting[best].effective(now, aging) {
+			best = i
+		}
+	}
+	return best
+}
+
+// queued 排队中的请求，按发送顺序排列
+func (c *Clients) queued() []*laneWaiter {
+	l := &c.lane
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := append([]*laneWaiter(nil), l.waiting...)
+	now, aging := time.Now(), c.agingInterval()
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].effective(now, aging) > list[j].effective(now, aging)
+	})
+	return list
+}
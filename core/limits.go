@@ -0,0 +1,74 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const actionRejected = "_rejected" // 连接被拒绝时发给客户端的回执
+
+// ClientCounts 当前客户端数和配置的上限，/details里展示
+type ClientCounts struct {
+	Total       int            `json:"total"`
+	MaxTotal    int            `json:"maxTotal"`    // 0不限制
+	MaxPerGroup int            `json:"maxPerGroup"` // 0不限制
+	Groups      map[string]int `json:"groups"`
+}
+
+func (s *Server) clientCounts() ClientCounts {
+	counts := ClientCounts{
+		MaxTotal:    s.conf.Limits.MaxClientsTotal,
+		MaxPerGroup: s.conf.Limits.MaxClientsPerGroup,
+		Groups:      make(map[string]int),
+	}
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		if client, ok := value.(*Clients); ok {
+			counts.Total++
+			counts.Groups[scopedGroup(client.namespace, client.clientGroup)]++
+		}
+		return true
+	})
+	return counts
+}
+
+// checkClientLimit 新客户端会超过上限时返回原因，同一个group->clientId重连不算新客户端
+func (s *Server) checkClientLimit(ns string, group string, clientId string) string {
+	limits := s.conf.Limits
+	if limits.MaxClientsTotal <= 0 && limits.MaxClientsPerGroup <= 0 {
+		return ""
+	}
+	if _, ok := s.hlSyncMap.Load(clientKey(ns, group, clientId)); ok {
+		return ""
+	}
+	counts := s.clientCounts()
+	if limits.MaxClientsTotal > 0 && counts.Total >= limits.MaxClientsTotal {
+		return fmt.Sprintf("客户端总数已达上限%d", limits.MaxClientsTotal)
+	}
+	if limits.MaxClientsPerGroup > 0 && counts.Groups[scopedGroup(ns, group)] >= limits.MaxClientsPerGroup {
+		return fmt.Sprintf("group %s 的客户端数已达上限%d", group, limits.MaxClientsPerGroup)
+	}
+	return ""
+}
+
+// rejectWs 告诉客户端为什么被拒绝，然后正常关闭连接
+func rejectWs(ws wsConn, secret string, reason string) {
+	env := protocol.NewControl(actionRejected, reason)
+	if secret != "" {
+		env.Sign(secret, time.Now())
+	}
+	// 还没收到_hello，按旧版格式发送
+	receipt, _ := protocol.CodecFor(protocol.VersionMessageId, protocol.EncodingJson).Encode(env)
+	_ = ws.WriteMessage(websocket.TextMessage, receipt)
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "rejected")
+	_ = ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	_ = ws.Close()
+}
+
+// logReject 记录被拒绝的连接
+func logReject(group string, clientId string, ip string, reason string) {
+	log.Warning("拒绝连接 ", group, "->", clientId, " ip:", ip, " ", reason)
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"JsRpc/config"
+	"JsRpc/protocol"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialRejected 用真实的ws连接接入，期望收到_rejected回执和1013关闭帧，返回拒绝的原因
+func dialRejected(t *testing.T, url string, group string, clientId string) string {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(url+"/ws?group="+group+"&clientId="+clientId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	_ = ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("超过上限的连接没有收到回执：%v", err)
+	}
+	env, err := protocol.Decode(data)
+	if err != nil || env.Name() != actionRejected {
+		t.Fatalf("回执不是%s：%s", actionRejected, data)
+	}
+	_, _, err = ws.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+		t.Fatalf("期望1013关闭帧，得到 %v", err)
+	}
+	if env.Control != nil {
+		return env.Control.Data
+	}
+	return env.Param // 旧版格式里控制消息的内容在param里
+}
+
+func TestClientLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits config.LimitsConfig
+		groups []string // 依次接入的客户端所在的group，最后一个应该被拒绝
+		reason string
+	}{
+		{"每个group的上限", config.LimitsConfig{MaxClientsPerGroup: 2}, []string{"g", "g", "g"}, "group g 的客户端数已达上限2"},
+		{"总数上限", config.LimitsConfig{MaxClientsTotal: 2}, []string{"a", "b", "c"}, "客户端总数已达上限2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(conf *config.ConfStruct) { conf.Limits = tt.limits })
+			srv := httptest.NewServer(s.Handler())
+			defer srv.Close()
+			url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+			last := len(tt.groups) - 1
+			for i, group := range tt.groups[:last] {
+				startFake(t, s, wsPeer{group: group, clientId: fmt.Sprint(i)}, map[string]func(string) string{
+					"hello": func(param string) string { return "hi " + param },
+				})
+			}
+			if reason := dialRejected(t, url, tt.groups[last], "extra"); reason != tt.reason {
+				t.Fatalf("拒绝原因是%q，期望%q", reason, tt.reason)
+			}
+			if s.Client(tt.groups[last], "extra") != nil {
+				t.Fatal("被拒绝的客户端不应该注册")
+			}
+			for i, group := range tt.groups[:last] {
+				if res, err := s.Call(testContext(t), group, fmt.Sprint(i), "hello", "1"); err != nil || res != "hi 1" {
+					t.Fatalf("上限内的客户端%d应该照常工作：%q, %v", i, res, err)
+				}
+			}
+			// 已经在线的clientId重连不算新客户端
+			startFake(t, s, wsPeer{group: tt.groups[0], clientId: "0"}, nil)
+
+			counts := decodeBody(t, serveRequest(s, http.MethodGet, "/details", ""))["counts"].(map[string]interface{})
+			if counts["total"] != float64(last) || counts["maxTotal"] != float64(tt.limits.MaxClientsTotal) ||
+				counts["maxPerGroup"] != float64(tt.limits.MaxClientsPerGroup) {
+				t.Fatalf("/details的counts = %v", counts)
+			}
+		})
+	}
+}
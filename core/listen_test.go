@@ -0,0 +1,167 @@
+package core
+
+import (
+	"JsRpc/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getJson 通过真实的http请求取接口的data
+func getJson(t *testing.T, url string) map[string]interface{} {
+	t.Helper()
+	client := http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Status int                    `json:"status"`
+		Data   map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s 返回%d：%v", url, resp.StatusCode, err)
+	}
+	return body.Data
+}
+
+func TestMultipleBasicListen(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.BasicListen = config.ListenList{"127.0.0.1:0", "127.0.0.1:0"}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addrs := s.ListenAddrs()[listenHttp]
+	if len(addrs) != 2 || addrs[0] == addrs[1] {
+		t.Fatalf("应该监听两个不同的端口，得到 %v", addrs)
+	}
+	startFake(t, s, wsPeer{group: "g", clientId: "c1"}, nil)
+	for _, addr := range addrs {
+		if list := getJson(t, "http://"+addr+"/list"); list["g"] == nil {
+			t.Fatalf("%s的/list没有客户端：%v", addr, list)
+		}
+	}
+
+	version := getJson(t, "http://"+addrs[0]+"/version")
+	listen, _ := version["listen"].(map[string]interface{})
+	var listed []string
+	for _, addr := range listen[listenHttp].([]interface{}) {
+		listed = append(listed, addr.(string))
+	}
+	slices.Sort(listed)
+	slices.Sort(addrs)
+	if !slices.Equal(listed, addrs) {
+		t.Fatalf("/version应该列出所有监听地址，得到 %v", listen)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range addrs {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			t.Fatalf("关闭后%s还在监听", addr)
+		}
+	}
+}
+
+// 有一个地址监听失败时返回所有失败的地址，已经监听的端口也要关闭
+func TestListenErrorClosesOthers(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	free := freeAddr(t)
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.BasicListen = config.ListenList{free, busy.Addr().String()}
+	})
+	err = s.Start()
+	if !errors.Is(err, ErrListen) || !strings.Contains(err.Error(), busy.Addr().String()) {
+		t.Fatalf("应该返回包含%s的ListenError，得到 %v", busy.Addr(), err)
+	}
+	if len(s.ListenAddrs()) != 0 {
+		t.Fatalf("启动失败后不应该还有监听：%v", s.ListenAddrs())
+	}
+	ln, err := net.Listen("tcp", free)
+	if err != nil {
+		t.Fatalf("%s应该已经关闭：%v", free, err)
+	}
+	ln.Close()
+}
+
+// 端口被占用时依次尝试备用地址，跳过同样被占用的，/version里能看到实际使用的地址
+func TestFallbackListen(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyFallback, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busyFallback.Close()
+	free := freeAddr(t)
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.BasicListen = config.ListenList{busy.Addr().String()}
+		conf.FallbackListen = config.ListenList{busyFallback.Addr().String(), free}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := s.ListenAddrs()[listenHttp]; len(addrs) != 1 || addrs[0] != free {
+		t.Fatalf("应该改用%s，实际监听 %v", free, addrs)
+	}
+	version := getJson(t, "http://"+free+"/version")
+	fallbacks, _ := version["listenFallbacks"].(map[string]interface{})
+	if fallbacks[busy.Addr().String()] != free {
+		t.Fatalf("/version里的listenFallbacks = %v", version["listenFallbacks"])
+	}
+	getJson(t, "http://"+free+"/list")
+}
+
+// 备用地址也都被占用时返回ErrListen，命令行以单独的退出码退出
+func TestFallbackListenExhausted(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.BasicListen = config.ListenList{busy.Addr().String()}
+		conf.FallbackListen = config.ListenList{busy.Addr().String()}
+	})
+	if err := s.Start(); !errors.Is(err, ErrListen) {
+		t.Fatalf("期望ErrListen，得到 %v", err)
+	}
+}
+
+// 重试期间端口被释放时仍然使用原来的地址
+func TestListenRetry(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := busy.Addr().String()
+	time.AfterFunc(200*time.Millisecond, func() { busy.Close() })
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.BasicListen = config.ListenList{addr}
+		conf.FallbackListen = config.ListenList{freeAddr(t)}
+		conf.ListenRetry = config.ListenRetryConfig{Attempts: 3, Interval: 1}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := s.ListenAddrs()[listenHttp]; len(addrs) != 1 || addrs[0] != addr || len(s.ListenFallbacks()) != 0 {
+		t.Fatalf("重试后应该监听%s，实际 %v，备用 %v", addr, addrs, s.ListenFallbacks())
+	}
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrListen BasicListen的地址重试后、备用地址也都监听失败，命令行以单独的退出码退出
+var ErrListen = errors.New("listen failed")
+
+// ListenError 监听失败的地址和原因
+type ListenError struct {
+	Err error
+}
+
+func (e *ListenError) Error() string { return "服务启动失败：" + e.Err.Error() }
+
+func (e *ListenError) Unwrap() error { return e.Err }
+
+func (e *ListenError) Is(target error) bool { return target == ErrListen }
+
+const defaultListenRetryInterval = 1
+
+// listenBasic 监听BasicListen里的一个地址，失败时按ListenRetry重试，仍然失败时从fallbacks里取下一个地址，用过的备用地址会移除
+func (s *Server) listenBasic(addr string, fallbacks *[]string) (net.Listener, error) {
+	retry := s.conf.ListenRetry
+	interval := time.Duration(retry.Interval) * time.Second
+	if retry.Interval == 0 {
+		interval = defaultListenRetryInterval * time.Second
+	}
+	ln, err := s.listen(listenHttp, addr)
+	for attempt := 2; err != nil && attempt <= retry.Attempts; attempt++ {
+		log.Warningf("监听%s失败：%v，%v后第%d次重试", addr, err, interval, attempt)
+		time.Sleep(interval)
+		ln, err = s.listen(listenHttp, addr)
+	}
+	for err != nil && len(*fallbacks) > 0 {
+		fallback := (*fallbacks)[0]
+		*fallbacks = (*fallbacks)[1:]
+		log.Warningf("监听%s失败：%v，改用备用地址%s", addr, err, fallback)
+		var fbErr error
+		if ln, fbErr = s.listen(listenHttp, fallback); fbErr == nil {
+			s.mu.Lock()
+			s.fallbacks[addr] = fallback
+			s.mu.Unlock()
+			return ln, nil
+		}
+		log.Warningf("备用地址%s也监听失败：%v", fallback, fbErr)
+	}
+	return ln, err
+}
+
+// ListenFallbacks 改用了备用地址的BasicListen地址 -> 实际使用的备用地址
+func (s *Server) ListenFallbacks() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]string, len(s.fallbacks))
+	for addr, fallback := range s.fallbacks {
+		res[addr] = fallback
+	}
+	return res
+}
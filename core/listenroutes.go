@@ -0,0 +1,36 @@
+package core
+
+import (
+	"JsRpc/config"
+	"slices"
+)
+
+// dashboardPaths 只读查看状态的路由，其它不是客户端、管理的路由都算调用方接口
+var dashboardPaths = map[string]bool{
+	"/list":      true,
+	"/details":   true,
+	"/history":   true,
+	"/events":    true,
+	"/schedules": true,
+	"/pool":      true,
+}
+
+// routeCategory 路由属于ListenRoutes里的哪个类别，path是改名前的路径
+func routeCategory(path string) string {
+	switch {
+	case clientPaths[path]:
+		return config.RouteClient
+	case adminPaths[path] || path == "/schedules/:name/:op":
+		return config.RouteAdmin
+	case dashboardPaths[path]:
+		return config.RouteDashboard
+	}
+	return config.RouteCaller
+}
+
+// routeFilter 只注册categories里的路由，categories为空时注册全部
+type routeFilter []string
+
+func (f routeFilter) allow(category string) bool {
+	return len(f) == 0 || slices.Contains(f, category)
+}
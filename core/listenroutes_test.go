@@ -0,0 +1,126 @@
+package core
+
+import (
+	"JsRpc/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// routeProbes 每个类别里有代表性的路由
+var routeProbes = []struct {
+	category string
+	path     string
+}{
+	{config.RouteClient, "/ws"},
+	{config.RouteClient, "/jsrpc.js"},
+	{config.RouteClient, "/poll/pull"},
+	{config.RouteCaller, "/go"},
+	{config.RouteCaller, "/execjs"},
+	{config.RouteCaller, "/ws/caller"},
+	{config.RouteCaller, "/page/cookie"},
+	{config.RouteAdmin, "/throttle"},
+	{config.RouteAdmin, "/admin/settings"},
+	{config.RouteAdmin, "/inflight"},
+	{config.RouteAdmin, "/schedules/s/run"},
+	{config.RouteDashboard, "/list"},
+	{config.RouteDashboard, "/details"},
+	{config.RouteDashboard, "/version"},
+	{config.RouteDashboard, "/"},
+}
+
+// notFound 路由没有注册，gin默认的404
+func notFound(w *httptest.ResponseRecorder) bool {
+	return w.Code == http.StatusNotFound && w.Body.String() == "404 page not found"
+}
+
+func TestListenRoutesPartition(t *testing.T) {
+	s := newTestServer(t, nil)
+	for _, categories := range [][]string{
+		{config.RouteClient},
+		{config.RouteCaller},
+		{config.RouteAdmin},
+		{config.RouteDashboard},
+		{config.RouteClient, config.RouteCaller},
+		{config.RouteAdmin, config.RouteDashboard},
+	} {
+		router, err := s.setupHttpRouters(categories)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed := routeFilter(categories)
+		for _, probe := range routeProbes {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, probe.path, nil))
+			if got := !notFound(w); got != allowed.allow(probe.category) {
+				t.Errorf("%v 上 %s(%s) 注册=%v，返回%d %s", categories, probe.path, probe.category, got, w.Code, w.Body)
+			}
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%v 上 /healthz 返回%d，所有监听都应该有", categories, w.Code)
+		}
+	}
+}
+
+// 公开的监听上访问管理接口返回404而不是403，不暴露接口存在
+func TestListenRoutesHidesAdmin(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.AdminToken = "secret" })
+	public, err := s.setupHttpRouters([]string{config.RouteClient, config.RouteCaller})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := s.setupHttpRouters([]string{config.RouteAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/throttle", "/admin/settings", "/stats"} {
+		w := httptest.NewRecorder()
+		public.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if !notFound(w) {
+			t.Errorf("公开监听上%s应该404，得到%d", path, w.Code)
+		}
+		w = httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+			t.Errorf("管理监听上没有token访问%s应该拒绝，得到%d", path, w.Code)
+		}
+	}
+}
+
+// 通过配置启动两个监听，各自只提供配置的类别
+func TestListenRoutesConfig(t *testing.T) {
+	public, local := freeAddr(t), freeAddr(t)
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.BasicListen = config.ListenList{public, local}
+		conf.ListenRoutes = map[string][]string{
+			public: {config.RouteClient, config.RouteCaller},
+			local:  {config.RouteAdmin, config.RouteDashboard},
+		}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	status := func(addr, path string) int {
+		t.Helper()
+		res, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := status(public, "/details"); code != http.StatusNotFound {
+		t.Fatalf("公开监听上/details应该404，得到%d", code)
+	}
+	if code := status(local, "/details"); code != http.StatusOK {
+		t.Fatalf("本地监听上/details应该200，得到%d", code)
+	}
+	if code := status(local, "/jsrpc.js"); code != http.StatusNotFound {
+		t.Fatalf("本地监听上/jsrpc.js应该404，得到%d", code)
+	}
+	if code := status(public, "/jsrpc.js"); code == http.StatusNotFound {
+		t.Fatal("公开监听上应该有/jsrpc.js")
+	}
+}
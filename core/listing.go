@@ -0,0 +1,179 @@
+package core
+
+import (
+	"JsRpc/config"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxListPageSize = 1000
+
+// listFilter /list和/details的筛选和分页参数，pageSize为0时不分页
+type listFilter struct {
+	match    func(group string) bool
+	healthy  *bool
+	q        string
+	page     int
+	pageSize int
+}
+
+func parseListFilter(c *gin.Context) (listFilter, error) {
+	f := listFilter{match: func(string) bool { return true }, q: strings.ToLower(c.Query("q"))}
+	if pattern := c.Query("group"); pattern != "" { // 支持通配符和~正则
+		match, err := config.MatchGroup(pattern)
+		if err != nil {
+			return f, err
+		}
+		f.match = match
+	}
+	if raw := c.Query("healthy"); raw != "" {
+		healthy, err := strconv.ParseBool(raw)
+		if err != nil {
+			return f, errors.New("healthy只能是true或false")
+		}
+		f.healthy = &healthy
+	}
+	if raw := c.Query("page"); raw != "" {
+		page, err := strconv.Atoi(raw)
+		if err != nil || page < 1 {
+			return f, errors.New("page必须是正整数")
+		}
+		f.page, f.pageSize = page, 100
+	}
+	if raw := c.Query("pageSize"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < 1 || size > maxListPageSize {
+			return f, errors.New("pageSize必须是1到" + strconv.Itoa(maxListPageSize) + "之间的整数")
+		}
+		f.pageSize = size
+		if f.page == 0 {
+			f.page = 1
+		}
+	}
+	return f, nil
+}
+
+// healthy 在线(不是在等待重连)并且上报的状态没有过期
+func (c *Clients) healthy() bool {
+	if state, _ := c.state(); state != clientOnline {
+		return false
+	}
+	status := c.getStatus()
+	return status == nil || !status.Stale
+}
+
+// matchQuery q在group、clientId、ip、页面地址或标题里出现(不区分大小写)
+func (c *Clients) matchQuery(q string) bool {
+	if q == "" {
+		return true
+	}
+	fields := []string{c.clientGroup, c.clientId, c.getIp()}
+	if info := c.getPageInfo(); info != nil {
+		fields = append(fields, info.Url, info.Title)
+	}
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// listClients 调用方能看到的、符合条件的客户端，按group、clientId排序后分页，同时返回分页前的总数
+func (s *Server) listClients(c *gin.Context, f listFilter) ([]*Clients, int) {
+	list := make([]*Clients, 0)
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		client, ok := value.(*Clients)
+		if !ok || !visible(c, client.namespace) || !f.match(client.clientGroup) || !client.matchQuery(f.q) {
+			return true
+		}
+		if f.healthy != nil && client.healthy() != *f.healthy {
+			return true
+		}
+		list = append(list, client)
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		gi, gj := listGroup(c, list[i]), listGroup(c, list[j])
+		if gi != gj {
+			return gi < gj
+		}
+		return list[i].clientId < list[j].clientId
+	})
+	total := len(list)
+	if f.pageSize > 0 {
+		start := min((f.page-1)*f.pageSize, total)
+		list = list[start:min(start+f.pageSize, total)]
+	}
+	return list, total
+}
+
+// listEnvelope 在返回里加上总数和版本号，分页时加上page和pageSize
+func listEnvelope(f listFilter, data interface{}, total int, version int64) gin.H {
+	res := gin.H{"status": 200, "data": data, "total": total, "version": version}
+	if f.pageSize > 0 {
+		res["page"], res["pageSize"] = f.page, f.pageSize
+	}
+	return res
+}
+
+var detailsFormats = []string{"json", "flat", "csv"}
+
+// detailsFormat format参数优先，没有时看Accept头，默认是按group分组的json
+func detailsFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+	if strings.Contains(c.GetHeader("Accept"), "text/csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+// flatDetail format=flat时每个客户端一项，group放在里面
+type flatDetail struct {
+	Group string `json:"group"`
+	ClientDetail
+}
+
+var detailsCsvHeader = []string{"group", "clientId", "ip", "healthy", "failCount", "actionCount", "connectedAt", "inflight", "tags"}
+
+// writeDetailsCsv 每个客户端一行，列固定，标签按key排序后用逗号拼接
+func writeDetailsCsv(c *gin.Context, clients []*Clients) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="jsrpc-details.csv"`)
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write(detailsCsvHeader)
+	for _, client := range clients {
+		connectedAt := ""
+		if t := nanoTime(client.connectedAt.Load()); t != nil {
+			connectedAt = t.Format(time.RFC3339)
+		}
+		tags := client.getTags()
+		pairs := make([]string, 0, len(tags))
+		for k, v := range tags {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		_ = w.Write([]string{
+			listGroup(c, client),
+			client.clientId,
+			client.getIp(),
+			strconv.FormatBool(client.healthy()),
+			strconv.FormatInt(client.failCount.Load(), 10),
+			strconv.Itoa(len(client.actionList())),
+			connectedAt,
+			strconv.Itoa(client.pendingCount()),
+			strings.Join(pairs, ","),
+		})
+	}
+	w.Flush()
+}
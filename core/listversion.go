@@ -0,0 +1,63 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bumpVersion 客户端上线、下线、健康状态或注册的方法有变化时加一，/list和/details用它生成ETag
+func (s *Server) bumpVersion() {
+	s.listVersion.Add(1)
+	s.emptyQueue.clientChanged() // 有客户端上线或状态变化，queueIfEmpty的请求重新选择
+}
+
+// updateHealth 和上次记录的健康状态不一样时增加版本号
+func (s *Server) updateHealth(client *Clients) {
+	unhealthy := !client.healthy()
+	if client.unhealthy.Swap(unhealthy) != unhealthy {
+		s.bumpVersion()
+	}
+}
+
+// checkHealth 上报的状态过期不会有事件通知，返回列表前检查一遍所有客户端
+func (s *Server) checkHealth() {
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		s.updateHealth(value.(*Clients))
+		return true
+	})
+}
+
+// currentVersion 检查一遍健康状态后的版本号
+func (s *Server) currentVersion() int64 {
+	s.checkHealth()
+	return s.listVersion.Load()
+}
+
+// notModified 设置ETag并返回当前版本号，和If-None-Match一致时返回304。
+// 版本号只反映客户端的上下线和状态，只用于/list；/details里的计数每次请求都在变，不能用它判断是否修改。
+// ETag里带上启动时间，重启后版本号从0开始也不会和旧的ETag混淆
+func (s *Server) notModified(c *gin.Context) (int64, bool) {
+	version := s.currentVersion()
+	etag := `W/"` + s.listEpoch + "-" + strconv.FormatInt(version, 10) + `"`
+	c.Header("ETag", etag)
+	if matchETag(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return version, true
+	}
+	return version, false
+}
+
+// matchETag If-None-Match可以是*或者逗号分隔的多个ETag，按弱比较忽略W/前缀
+func matchETag(header string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
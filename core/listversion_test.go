@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// listETag 请求/list，返回状态码和ETag
+func listETag(t *testing.T, s *Server, ifNoneMatch string) (int, string, string) {
+	t.Helper()
+	headers := []string{}
+	if ifNoneMatch != "" {
+		headers = append(headers, "If-None-Match", ifNoneMatch)
+	}
+	w := serveRequest(s, http.MethodGet, "/list", "", headers...)
+	return w.Code, w.Header().Get("ETag"), w.Body.String()
+}
+
+// pushReport 模拟客户端主动上报
+func (fc *fakeClient) pushReport(action string, data string) {
+	msg, _ := json.Marshal(map[string]string{"action": action, "message_id": "", "response_data": data})
+	fc.ws.push(string(msg))
+}
+
+func TestListNotModified(t *testing.T) {
+	s := newTestServer(t, nil)
+	a := startFake(t, s, wsPeer{group: "g", clientId: "a"}, nil)
+
+	code, etag, _ := listETag(t, s, "")
+	if code != http.StatusOK || etag == "" {
+		t.Fatalf("/list返回%d，ETag %q", code, etag)
+	}
+	code, again, body := listETag(t, s, etag)
+	if code != http.StatusNotModified || body != "" || again != etag {
+		t.Fatalf("没有变化时应该返回304并且没有body：%d %q %q", code, again, body)
+	}
+	if code, _, _ := listETag(t, s, `"other", `+etag); code != http.StatusNotModified {
+		t.Fatalf("If-None-Match里有多个ETag时返回%d", code)
+	}
+	if code, _, _ := listETag(t, s, "*"); code != http.StatusNotModified {
+		t.Fatalf("If-None-Match: *返回%d", code)
+	}
+
+	// 每种变化都要让旧的ETag失效
+	changes := []struct {
+		name   string
+		change func()
+	}{
+		{"客户端上线", func() { startFake(t, s, wsPeer{group: "g", clientId: "b"}, nil) }},
+		{"注册新方法", func() { a.pushReport(actionRegisterActions, `["new"]`) }},
+		{"状态过期", func() {
+			a.pushReport(actionStatus, `{"busy":true}`)
+			waitFor(t, "状态上报", func() bool { return a.client.getStatus() != nil })
+			etag = currentETag(t, s) // 上报本身不改变健康状态，先记下当前的ETag
+			a.client.mu.Lock()
+			a.client.status.UpdatedAt = time.Now().Add(-time.Hour)
+			a.client.mu.Unlock()
+		}},
+		{"客户端下线", func() {
+			_ = a.ws.Close()
+			<-a.done
+		}},
+	}
+	for _, tt := range changes {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			var code int
+			var newEtag string
+			waitFor(t, "ETag变化", func() bool {
+				code, newEtag, _ = listETag(t, s, etag)
+				return code == http.StatusOK
+			})
+			if newEtag == etag {
+				t.Fatal("变化后ETag应该不同")
+			}
+			etag = newEtag
+		})
+	}
+}
+
+func currentETag(t *testing.T, s *Server) string {
+	_, etag, _ := listETag(t, s, "")
+	return etag
+}
+
+func TestDetailsIncludesVersion(t *testing.T) {
+	s := newTestServer(t, nil)
+	before := decodeBody(t, serveRequest(s, http.MethodGet, "/details", ""))["version"].(float64)
+	startFake(t, s, wsPeer{group: "g", clientId: "a"}, nil)
+	w := serveRequest(s, http.MethodGet, "/details", "")
+	if after := decodeBody(t, w)["version"].(float64); after <= before {
+		t.Fatalf("客户端上线后version应该增加：%v -> %v", before, after)
+	}
+	// /details里的计数每次都在变，不返回304
+	if w := serveRequest(s, http.MethodGet, "/details", "", "If-None-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("/details返回%d，期望200", w.Code)
+	}
+}
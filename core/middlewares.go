@@ -1,33 +1,129 @@
 package core
 
-import "github.com/gin-gonic/gin"
+import (
+	"JsRpc/config"
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
 
-func CorsMiddleWare() gin.HandlerFunc {
+var (
+	defaultCorsMethods = []string{"POST", "GET", "OPTIONS", "PUT", "DELETE", "UPDATE"}
+	defaultCorsHeaders = []string{"Authorization", "Content-Length", "X-CSRF-Token", "Token", "session", "Content-Type"}
+)
+
+func CorsMiddleWare(conf config.CorsConfig) gin.HandlerFunc {
+	methods, headers := conf.AllowMethods, conf.AllowHeaders
+	if len(methods) == 0 {
+		methods = defaultCorsMethods
+	}
+	if len(headers) == 0 {
+		headers = defaultCorsHeaders
+	}
+	allowMethods, allowHeaders := strings.Join(methods, ", "), strings.Join(headers, ", ")
 	return func(context *gin.Context) {
-		method := context.Request.Method
 		origin := context.Request.Header.Get("Origin") //请求头部
-		if origin != "" {
-			//接收客户端发送的origin （重要！）
-			context.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-
-			//服务器支持的所有跨域请求的方法
-			context.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE,UPDATE")
-			//允许跨域设置可以返回其他子段，可以自定义字段
-			context.Header("Access-Control-Allow-Headers", "Authorization, Content-Length, X-CSRF-Token, Token,session")
-			// 允许浏览器（客户端）可以解析的头部 （重要）
-			context.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers")
-			//设置缓存时间
-			//c.Header("Access-Control-Max-Age", "172800")
-			//允许客户端传递校验信息比如 cookie (重要)
+		// 响应内容随Origin变化，告诉缓存不要混用
+		context.Writer.Header().Add("Vary", "Origin")
+		preflight := context.Request.Method == http.MethodOptions &&
+			context.Request.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			context.Next()
+			return
+		}
+		if !matchOrigin(conf.AllowOrigins, origin) {
+			if preflight {
+				context.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			context.Next() // 不带跨域头，浏览器会拦截响应
+			return
+		}
+		allowOrigin := origin
+		if !conf.AllowCredentials && matchAll(conf.AllowOrigins) {
+			allowOrigin = "*"
+		}
+		context.Header("Access-Control-Allow-Origin", allowOrigin)
+		// 允许浏览器（客户端）可以解析的头部
+		context.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers")
+		if conf.AllowCredentials {
+			//允许客户端传递校验信息比如 cookie，此时Allow-Origin不能是*
 			context.Header("Access-Control-Allow-Credentials", "true")
 		}
-
-		//允许类型校验
-		if method == "OPTIONS" {
-			context.AbortWithStatus(200)
-		} else {
+		if !preflight {
 			context.Next()
+			return
 		}
+		context.Header("Access-Control-Allow-Methods", allowMethods)
+		context.Header("Access-Control-Allow-Headers", allowHeaders)
+		if conf.MaxAge > 0 {
+			context.Header("Access-Control-Max-Age", strconv.Itoa(conf.MaxAge))
+		}
+		context.AbortWithStatus(http.StatusNoContent)
 	}
+}
 
+func matchAll(patterns []string) bool {
+	for _, p := range patterns {
+		if p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin 支持完整匹配和带一个*的通配，如 https://*.example.com
+func matchOrigin(patterns []string, origin string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == origin {
+			return true
+		}
+		prefix, suffix, found := strings.Cut(p, "*")
+		if found && len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminAuth 配置了AdminToken时，管理类接口需要在X-Admin-Token头或adminToken参数里带上token
+// 使用GroupAdminTokens里的token时只能管理对应的group，由接口自己检查
+func (s *Server) AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.conf.AdminToken == "" {
+			c.Next()
+			return
+		}
+		if groups, ok := s.conf.GroupAdminTokens[adminToken(c)]; ok {
+			c.Set(ctxAdminGroups, groups)
+			c.Next()
+			return
+		}
+		if !s.isAdmin(c) {
+			s.strike(c.ClientIP(), strikeAuth)
+			GinJsonMsg(c, http.StatusUnauthorized, "需要正确的adminToken")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAdmin 配置了AdminToken并且请求带了正确的token
+func (s *Server) isAdmin(c *gin.Context) bool {
+	if s.conf.AdminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(adminToken(c)), []byte(s.conf.AdminToken)) == 1
+}
+
+func adminToken(c *gin.Context) string {
+	token := c.GetHeader("X-Admin-Token")
+	if token == "" {
+		token = c.Query("adminToken")
+	}
+	return token
 }
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"JsRpc/config"
+	"net/http"
+	"testing"
+)
+
+func corsServer(t *testing.T, cors config.CorsConfig) *Server {
+	cors.IsEnable = true
+	return newTestServer(t, func(conf *config.ConfStruct) { conf.Cors = cors })
+}
+
+func TestCorsHeaders(t *testing.T) {
+	s := corsServer(t, config.CorsConfig{AllowOrigins: []string{"https://app.example.com", "https://*.example.org"}, MaxAge: 600})
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		allowOrigin string
+	}{
+		{"允许的来源", http.MethodGet, "https://app.example.com", false, http.StatusOK, "https://app.example.com"},
+		{"通配符匹配", http.MethodGet, "https://a.example.org", false, http.StatusOK, "https://a.example.org"},
+		{"不允许的来源", http.MethodGet, "https://evil.com", false, http.StatusOK, ""},
+		{"通配符不匹配空的子域名", http.MethodGet, "https://.example.org", false, http.StatusOK, ""},
+		{"没有Origin", http.MethodGet, "", false, http.StatusOK, ""},
+		{"允许的预检", http.MethodOptions, "https://app.example.com", true, http.StatusNoContent, "https://app.example.com"},
+		{"不允许的预检", http.MethodOptions, "https://evil.com", true, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := []string{}
+			if tt.origin != "" {
+				headers = append(headers, "Origin", tt.origin)
+			}
+			if tt.preflight {
+				headers = append(headers, "Access-Control-Request-Method", "POST")
+			}
+			w := serveRequest(s, tt.method, "/list", "", headers...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("状态码%d，期望%d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Fatalf("Access-Control-Allow-Origin = %q，期望%q", got, tt.allowOrigin)
+			}
+			if w.Header().Get("Vary") != "Origin" {
+				t.Fatal("响应需要Vary: Origin")
+			}
+			if tt.preflight && tt.wantStatus == http.StatusNoContent {
+				if w.Header().Get("Access-Control-Allow-Methods") == "" || w.Header().Get("Access-Control-Max-Age") != "600" {
+					t.Fatalf("预检响应缺少Allow-Methods或Max-Age：%v", w.Header())
+				}
+			}
+		})
+	}
+}
+
+func TestCorsWildcardAndCredentials(t *testing.T) {
+	s := corsServer(t, config.CorsConfig{AllowOrigins: []string{"*"}})
+	w := serveRequest(s, http.MethodGet, "/list", "", "Origin", "https://any.com")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("允许全部来源且不带凭证时应该返回*，得到%q", got)
+	}
+
+	s = corsServer(t, config.CorsConfig{AllowOrigins: []string{"*"}, AllowCredentials: true})
+	w = serveRequest(s, http.MethodGet, "/list", "", "Origin", "https://any.com")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://any.com" {
+		t.Fatalf("带凭证时不能返回*，得到%q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatal("缺少Access-Control-Allow-Credentials")
+	}
+}
+
+func TestCorsDisabled(t *testing.T) {
+	s := newTestServer(t, nil)
+	w := serveRequest(s, http.MethodGet, "/list", "", "Origin", "https://app.example.com")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("没有开启cors时不应该带跨域头，得到%q", got)
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ctxNamespace     = "jsrpc.namespace"
+	ctxAllNamespaces = "jsrpc.allNamespaces"
+)
+
+// namespaceIndex token和apiKey对应的namespace，没有配置Namespaces时为空
+type namespaceIndex struct {
+	byClientToken map[string]string
+	byApiKey      map[string]string
+}
+
+func (s *Server) initNamespaces() error {
+	s.namespaces = namespaceIndex{byClientToken: make(map[string]string), byApiKey: make(map[string]string)}
+	names := make(map[string]bool)
+	for _, ns := range s.conf.Namespaces {
+		switch {
+		case ns.Name == "":
+			return fmt.Errorf("Namespaces需要Name")
+		case names[ns.Name]:
+			return fmt.Errorf("namespace %s 重复", ns.Name)
+		case strings.ContainsAny(ns.Name, "/>"):
+			return fmt.Errorf("namespace %s 不能包含/和>", ns.Name)
+		}
+		names[ns.Name] = true
+		for _, token := range ns.ClientTokens {
+			if _, ok := s.namespaces.byClientToken[token]; ok || token == "" {
+				return fmt.Errorf("namespace %s 的ClientTokens为空或者重复", ns.Name)
+			}
+			s.namespaces.byClientToken[token] = ns.Name
+		}
+		for _, key := range ns.ApiKeys {
+			if _, ok := s.namespaces.byApiKey[key]; ok || key == "" {
+				return fmt.Errorf("namespace %s 的ApiKeys为空或者重复", ns.Name)
+			}
+			s.namespaces.byApiKey[key] = ns.Name
+		}
+	}
+	for _, sc := range s.conf.Schedules {
+		if sc.Namespace != "" && !names[sc.Namespace] {
+			return fmt.Errorf("定时任务 %s 的namespace %s 不存在", sc.Name, sc.Namespace)
+		}
+	}
+	return nil
+}
+
+// clientKey hlSyncMap里客户端的key，默认namespace和原来一样是group->clientId
+func clientKey(namespace string, group string, clientId string) string {
+	return scopedGroup(namespace, group) + "->" + clientId
+}
+
+// scopedGroup 带namespace前缀的group，用于按group统计
+func scopedGroup(namespace string, group string) string {
+	if namespace == "" {
+		return group
+	}
+	return namespace + "/" + group
+}
+
+func (c *Clients) key() string {
+	return clientKey(c.namespace, c.clientGroup, c.clientId)
+}
+
+// clientNamespace 浏览器客户端通过token参数确定namespace，没传token时是默认namespace，token错误时写401
+func (s *Server) clientNamespace(c *gin.Context) (string, bool) {
+	token := c.Query("token")
+	if token == "" {
+		return "", true
+	}
+	if ns, ok := s.namespaces.byClientToken[token]; ok {
+		return ns, true
+	}
+	s.strike(c.ClientIP(), strikeAuth)
+	GinJsonMsg(c, http.StatusUnauthorized, "客户端token错误")
+	return "", false
+}
+
+// NamespaceAuth 调用方通过X-Api-Key头或apiKey参数确定namespace，没传时是默认namespace
+// 带了正确adminToken的可以看到所有namespace的客户端，调用时用namespace参数指定
+func (s *Server) NamespaceAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(s.conf.Namespaces) == 0 {
+			c.Next()
+			return
+		}
+		key := c.GetHeader("X-Api-Key")
+		if key == "" {
+			key = c.Query("apiKey")
+		}
+		if key != "" {
+			ns, ok := s.namespaces.byApiKey[key]
+			if !ok {
+				s.strike(c.ClientIP(), strikeAuth)
+				GinJsonMsg(c, http.StatusUnauthorized, "apiKey错误")
+				c.Abort()
+				return
+			}
+			c.Set(ctxNamespace, ns)
+		} else if s.isAdmin(c) {
+			c.Set(ctxNamespace, c.Query("namespace"))
+			c.Set(ctxAllNamespaces, c.Query("namespace") == "")
+		}
+		c.Next()
+	}
+}
+
+// namespace 调用方所在的namespace
+func namespace(c *gin.Context) string {
+	return c.GetString(ctxNamespace)
+}
+
+// listGroup 列表里的group，管理员看全部namespace时带上namespace前缀区分同名的group
+func listGroup(c *gin.Context, client *Clients) string {
+	if c.GetBool(ctxAllNamespaces) {
+		return scopedGroup(client.namespace, client.clientGroup)
+	}
+	return client.clientGroup
+}
+
+// visible 调用方能不能看到这个namespace的客户端，管理员没有指定namespace时能看到全部
+func visible(c *gin.Context, ns string) bool {
+	return c.GetBool(ctxAllNamespaces) || ns == namespace(c)
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"JsRpc/config"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// namespaceServer namespace a和b里各有一个group g的客户端，who返回自己所在的namespace
+func namespaceServer(t *testing.T) *Server {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.AdminToken = "admin"
+		conf.Namespaces = []config.NamespaceConfig{
+			{Name: "a", ClientTokens: []string{"ta"}, ApiKeys: []string{"ka"}},
+			{Name: "b", ClientTokens: []string{"tb"}, ApiKeys: []string{"kb"}},
+		}
+	})
+	for _, ns := range []string{"a", "b"} {
+		ns := ns
+		startFake(t, s, wsPeer{namespace: ns, group: "g", clientId: ns + "1"}, map[string]func(string) string{
+			"who": func(string) string { return ns },
+		})
+	}
+	return s
+}
+
+func TestNamespaceCallIsolation(t *testing.T) {
+	s := namespaceServer(t)
+	tests := []struct {
+		name    string
+		target  string
+		headers []string
+		code    int
+		want    string
+	}{
+		{"a的key只会调用到a", "/go?group=g&action=who", []string{"X-Api-Key", "ka"}, http.StatusOK, "a"},
+		{"b的key只会调用到b", "/go?group=g&action=who&apiKey=kb", nil, http.StatusOK, "b"},
+		{"指定其它namespace的clientId", "/go?group=g&clientId=b1&action=who", []string{"X-Api-Key", "ka"}, http.StatusConflict, ""},
+		{"不能用namespace参数切换", "/go?group=g&action=who&namespace=b", []string{"X-Api-Key", "ka"}, http.StatusOK, "a"},
+		{"默认namespace看不到", "/go?group=g&action=who", nil, http.StatusNotFound, ""},
+		{"错误的key", "/go?group=g&action=who", []string{"X-Api-Key", "bad"}, http.StatusUnauthorized, ""},
+		{"管理员指定namespace", "/go?group=g&action=who&namespace=b", []string{"X-Admin-Token", "admin"}, http.StatusOK, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				w := serveRequest(s, http.MethodGet, tt.target, "", tt.headers...)
+				if w.Code != tt.code {
+					t.Fatalf("状态码%d，期望%d：%s", w.Code, tt.code, w.Body.String())
+				}
+				if tt.want != "" && decodeBody(t, w)["data"] != tt.want {
+					t.Fatalf("调用到了namespace %v，期望%s", decodeBody(t, w)["data"], tt.want)
+				}
+			}
+		})
+	}
+	if _, err := s.Call(testContext(t), "g", "", "who", ""); !errors.Is(err, ErrNoClient) {
+		t.Fatalf("Server.Call在默认namespace里，不应该调用到其它namespace：%v", err)
+	}
+}
+
+func TestNamespaceListingIsolation(t *testing.T) {
+	s := namespaceServer(t)
+	for _, path := range []string{"/list", "/details"} {
+		w := serveRequest(s, http.MethodGet, path, "", "X-Api-Key", "ka")
+		body := decodeBody(t, w)
+		data := body["data"].(map[string]interface{})
+		if len(data) != 1 || len(data["g"].([]interface{})) != 1 || !strings.Contains(w.Body.String(), "a1") ||
+			strings.Contains(w.Body.String(), "b1") {
+			t.Fatalf("%s只能看到自己namespace的客户端：%s", path, w.Body.String())
+		}
+		if _, ok := body["counts"]; ok {
+			t.Fatalf("%s不应该返回所有namespace的连接数", path)
+		}
+	}
+	data := decodeBody(t, serveRequest(s, http.MethodGet, "/list", "", "X-Admin-Token", "admin"))["data"].(map[string]interface{})
+	if len(data) != 2 || data["a/g"] == nil || data["b/g"] == nil {
+		t.Fatalf("管理员应该看到所有namespace，group带上namespace前缀：%v", data)
+	}
+}
+
+func TestNamespaceClientToken(t *testing.T) {
+	s := namespaceServer(t)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?group=g&clientId=x"
+
+	_, res, err := websocket.DefaultDialer.Dial(url+"&token=bad", nil)
+	if err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("错误的客户端token应该返回401，得到 %v", err)
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(url+"&token=tb", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	waitFor(t, "客户端注册到namespace b", func() bool {
+		_, ok := s.hlSyncMap.Load(clientKey("b", "g", "x"))
+		return ok
+	})
+	if s.Client("g", "x") != nil {
+		t.Fatal("带token的客户端不应该注册到默认namespace")
+	}
+	list := serveRequest(s, http.MethodGet, "/list", "", "X-Api-Key", "ka").Body.String()
+	if strings.Contains(list, `"x"`) {
+		t.Fatalf("namespace a能看到b的新客户端：%s", list)
+	}
+}
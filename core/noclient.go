@@ -0,0 +1,204 @@
+package core
+
+import (
+	"JsRpc/config"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 找不到可用客户端的原因
+const (
+	NoClientGroup       = "group_not_found"  // group下没有任何客户端
+	NoClientId          = "client_not_found" // group存在，但没有这个clientId
+	NoClientMatch       = "no_match"         // group里有在线的客户端，但都没有注册action或者标签不匹配
+	NoClientUnavailable = "unavailable"      // 客户端都断开了，在等待重连
+	// 配置了Routing.RejectUnregisteredActions，客户端上报的方法列表里没有这个action
+	NoClientAction = "action_not_registered"
+)
+
+// NoClientError 没有可用客户端时的原因，errors.Is(err, ErrNoClient)成立
+type NoClientError struct {
+	Reason   string
+	Group    string
+	ClientId string
+	Groups   []string // 配置了Routing.ExposeGroups且group不存在时，列出现有的group
+	Action   string
+	Actions  []string // NoClientAction时客户端注册了的方法
+}
+
+func (e *NoClientError) Error() string {
+	switch e.Reason {
+	case NoClientGroup:
+		return "没有找到对应的group或clientId：group " + e.Group + " 没有客户端,请通过list接口查看现有的注入"
+	case NoClientId:
+		return "没有找到对应的group或clientId：group " + e.Group + " 里没有clientId " + e.ClientId
+	case NoClientAction:
+		if e.ClientId != "" {
+			return "客户端" + e.ClientId + "没有注册action:" + e.Action
+		}
+		return "group " + e.Group + " 里的客户端都没有注册action:" + e.Action
+	case NoClientMatch:
+		return "没有找到对应的group或clientId：group " + e.Group + " 里没有注册了这个action或标签匹配的客户端"
+	default:
+		return "没有找到对应的group或clientId：group " + e.Group + " 的客户端都已断开，正在等待重连"
+	}
+}
+
+func (e *NoClientError) Is(target error) bool { return target == ErrNoClient }
+
+// StatusCode group不存在或者action没有注册404，group存在但选不到客户端409，客户端都在等待重连503
+func (e *NoClientError) StatusCode() int {
+	switch e.Reason {
+	case NoClientGroup, NoClientAction:
+		return http.StatusNotFound
+	case NoClientUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusConflict
+	}
+}
+
+// noClient 选不到客户端后查明原因，group可以是通配符，action不为空时检查是不是没有客户端注册它
+func (s *Server) noClient(ns string, group string, clientId string, action string) *NoClientError {
+	e := &NoClientError{Reason: NoClientGroup, Group: group, ClientId: clientId}
+	match, err := config.MatchGroup(group)
+	if err != nil {
+		return e
+	}
+	var total, online, sameId, sameIdOnline int
+	// 在线且开启了RejectUnregisteredActions的客户端注册的方法，有一个没上报过方法列表就不能拒绝
+	actions, reject := make(map[string]bool), action != ""
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		client, ok := value.(*Clients)
+		if !ok || client.namespace != ns || !match(client.clientGroup) {
+			return true
+		}
+		total++
+		grace := client.inGrace()
+		if !grace {
+			online++
+			if reject && (clientId == "" || client.clientId == clientId) {
+				reject = s.collectActions(client, actions)
+			}
+		}
+		if clientId != "" && client.clientId == clientId {
+			sameId++
+			if !grace {
+				sameIdOnline++
+			}
+		}
+		return true
+	})
+	switch {
+	case total == 0:
+		if s.conf.Routing.ExposeGroups {
+			e.Groups = s.groupNames(ns)
+		}
+	case clientId != "" && sameId == 0:
+		e.Reason = NoClientId
+	case clientId != "" && sameIdOnline == 0, clientId == "" && online == 0:
+		e.Reason = NoClientUnavailable
+	case reject && !actions[action]:
+		e.Reason, e.Action, e.Actions = NoClientAction, action, sortedKeys(actions)
+	default:
+		e.Reason = NoClientMatch
+	}
+	return e
+}
+
+// rejectUnregistered group是否配置在Routing.RejectUnregisteredActions里
+func (s *Server) rejectUnregistered(group string) bool {
+	for _, pattern := range s.conf.Routing.RejectUnregisteredActions {
+		if match, err := config.MatchGroup(pattern); err == nil && match(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectActions 把客户端注册的方法加进actions，客户端没开启拒绝或者没上报过方法列表时返回false
+func (s *Server) collectActions(client *Clients, actions map[string]bool) bool {
+	list := client.actionList()
+	if len(list) == 0 || !s.rejectUnregistered(client.clientGroup) {
+		return false
+	}
+	for _, name := range list {
+		actions[name] = true
+	}
+	return true
+}
+
+// unregisteredAction 选中的客户端上报过方法列表但里面没有action时，不发给客户端等超时，直接返回原因
+func (s *Server) unregisteredAction(client *Clients, action string) *NoClientError {
+	if action == "" {
+		return nil
+	}
+	actions := make(map[string]bool)
+	if !s.collectActions(client, actions) || actions[action] {
+		return nil
+	}
+	return &NoClientError{Reason: NoClientAction, Group: client.clientGroup, ClientId: client.clientId,
+		Action: action, Actions: sortedKeys(actions)}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// groupNames namespace里现有的group名，按名字排序
+func (s *Server) groupNames(ns string) []string {
+	seen := make(map[string]bool)
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		if client, ok := value.(*Clients); ok && client.namespace == ns {
+			seen[client.clientGroup] = true
+		}
+		return true
+	})
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// noClientResponse 选不到客户端时按原因返回不同的状态码，reason方便调用方判断
+func (s *Server) noClientResponse(c *gin.Context, group string, clientId string) {
+	noClientJson(c, s.noClient(namespace(c), group, clientId, ""))
+}
+
+// selectFailed selectClient返回错误时写响应，action不允许调用返回403，客户端没有注册action按找不到客户端返回404，
+// 其它是session的错误，返回409
+func selectFailed(c *gin.Context, err error) {
+	var e *NoClientError
+	switch {
+	case errors.As(err, &e):
+		noClientJson(c, e)
+	case errors.Is(err, ErrActionForbidden):
+		GinJsonMsg(c, http.StatusForbidden, err.Error())
+	default:
+		GinJsonMsg(c, http.StatusConflict, err.Error())
+	}
+}
+
+func noClientJson(c *gin.Context, e *NoClientError) {
+	res := gin.H{"status": e.StatusCode(), "data": e.Error(), "reason": e.Reason}
+	if e.Groups != nil {
+		res["groups"] = e.Groups
+	}
+	if e.Reason == NoClientAction {
+		res["action"], res["actions"] = e.Action, e.Actions
+		if e.ClientId != "" {
+			res["clientId"] = e.ClientId
+		}
+	}
+	c.JSON(e.StatusCode(), res)
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"JsRpc/config"
+	"net/http"
+	"testing"
+)
+
+func TestNoClientReasons(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.Websocket.ReconnectGrace = 30 })
+	startFake(t, s, wsPeer{group: "g", clientId: "c1"}, echoHandlers())
+	startFake(t, s, wsPeer{group: "h", clientId: "c1"}, echoHandlers())
+	// gone断开后在等待重连
+	gone := startFake(t, s, wsPeer{group: "gone", clientId: "c1"}, echoHandlers())
+	_ = gone.ws.Close()
+	waitFor(t, "客户端进入等待重连", gone.client.inGrace)
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+		reason string
+	}{
+		{"group不存在", "/go?group=none&action=hello", http.StatusNotFound, NoClientGroup},
+		{"通配符没有匹配", "/go?group=no*&action=hello", http.StatusNotFound, NoClientGroup},
+		{"clientId不存在", "/go?group=g&clientId=c2&action=hello", http.StatusConflict, NoClientId},
+		{"标签不匹配", "/go?group=g&action=hello&selector=region=us", http.StatusConflict, NoClientMatch},
+		{"等待重连", "/go?group=gone&action=hello", http.StatusServiceUnavailable, NoClientUnavailable},
+		{"指定的clientId在等待重连", "/go?group=gone&clientId=c1&action=hello", http.StatusServiceUnavailable, NoClientUnavailable},
+		{"execjs group不存在", "/execjs?group=none&code=1", http.StatusNotFound, NoClientGroup},
+		{"execjs 等待重连", "/execjs?group=gone&code=1", http.StatusServiceUnavailable, NoClientUnavailable},
+		{"cookie clientId不存在", "/page/cookie?group=g&clientId=c2", http.StatusConflict, NoClientId},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(s, http.MethodGet, tt.target, "")
+			body := decodeBody(t, w)
+			if w.Code != tt.code || body["status"] != float64(tt.code) || body["reason"] != tt.reason {
+				t.Fatalf("期望%d %s，得到%d %v", tt.code, tt.reason, w.Code, body)
+			}
+			if _, ok := body["groups"]; ok {
+				t.Fatalf("没有开启ExposeGroups时不能列出group：%v", body)
+			}
+		})
+	}
+}
+
+// 开启ExposeGroups后group不存在时列出同一个namespace里现有的group
+func TestNoClientExposeGroups(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.Routing.ExposeGroups = true })
+	startFake(t, s, wsPeer{group: "b", clientId: "c1"}, nil)
+	startFake(t, s, wsPeer{group: "a", clientId: "c1"}, nil)
+	startFake(t, s, wsPeer{group: "a", clientId: "c2"}, nil)
+	startFake(t, s, wsPeer{namespace: "other", group: "secret", clientId: "c1"}, nil)
+
+	body := decodeBody(t, serveRequest(s, http.MethodGet, "/go?group=none&action=hello", ""))
+	groups, _ := body["groups"].([]interface{})
+	if body["reason"] != NoClientGroup || len(groups) != 2 || groups[0] != "a" || groups[1] != "b" {
+		t.Fatalf("应该按名字列出a和b，得到 %v", body)
+	}
+	// group存在时不需要列出
+	body = decodeBody(t, serveRequest(s, http.MethodGet, "/go?group=a&clientId=c3&action=hello", ""))
+	if body["reason"] != NoClientId || body["groups"] != nil {
+		t.Fatalf("clientId不存在 = %v", body)
+	}
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"fmt"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	outboundQueueSize = 64               // 每个客户端待发送消息的队列长度
+	enqueueTimeout    = time.Second      // 队列满时最多等待多久
+	writeTimeout      = 10 * time.Second // 单条消息的写超时
+)
+
+var (
+	errSendQueueFull = &queryError{"client send queue full", ErrWriteFailed}
+	errClientClosed  = &queryError{"client closed", ErrNoChannel}
+)
+
+// outboundFrame 待发送的一条消息，binary为true时用ws的二进制帧发送
+type outboundFrame struct {
+	data   []byte
+	binary bool
+}
+
+// send 把消息放进客户端的发送队列，由writeLoop统一写入，慢客户端不会阻塞调用方
+func (c *Clients) send(frame outboundFrame) error {
+	select {
+	case <-c.closed:
+		return errClientClosed
+	default:
+	}
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case c.outbound <- frame:
+		return nil
+	case <-c.closed:
+		return errClientClosed
+	case <-timer.C:
+		c.failCount.Add(1)
+		return errSendQueueFull
+	}
+}
+
+// codec 按_hello里协商的协议版本和编码
+func (c *Clients) codec() protocol.Codec {
+	encoding := protocol.EncodingJson
+	if c.msgpack.Load() {
+		encoding = protocol.EncodingMsgpack
+	}
+	return protocol.CodecFor(int(c.protoVersion.Load()), encoding)
+}
+
+// sendEnvelope 按协商的协议版本和编码编码后发送，group配置了共享密钥时请求带上签名
+func (c *Clients) sendEnvelope(env protocol.Envelope) error {
+	if secret := c.signSecret(); secret != "" && env.Type != protocol.TypeResponse {
+		env.Sign(secret, time.Now()) // 请求和控制消息都签名，重发时重新签名
+	}
+	codec := c.codec()
+	data, err := codec.Encode(env)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWriteFailed, err)
+	}
+	return c.send(outboundFrame{data: data, binary: codec.Encoding() == protocol.EncodingMsgpack})
+}
+
+// sendControl 发送注册回执、重连通知等控制消息
+func (c *Clients) sendControl(name string, data string) error {
+	return c.sendEnvelope(protocol.NewControl(name, data))
+}
+
+// writeLoop 每个连接只有这一个goroutine写ws，不再需要加锁，done在这个连接的读循环退出时关闭
+func (c *Clients) writeLoop(w *connWriter, done <-chan struct{}) {
+	defer close(w.exited)
+	ws := w.ws
+	// 间隔可以通过/admin/settings修改，每次ping前重新读取；不ping时也定时检查是否重新开启
+	interval := c.pingInterval()
+	ticker := time.NewTicker(tickInterval(interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if current := c.pingInterval(); current != interval {
+				interval = current
+				ticker.Reset(tickInterval(interval))
+			}
+			if interval <= 0 {
+				continue
+			}
+			if err := c.ping(ws); err != nil {
+				log.Error(c.clientGroup+"->"+c.clientId, " 发送ping失败:", err)
+				_ = ws.Close()
+				return
+			}
+		case frame := <-c.outbound:
+			kind := websocket.TextMessage
+			if frame.binary {
+				kind = websocket.BinaryMessage
+			}
+			_ = ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := ws.WriteMessage(kind, frame.data); err != nil {
+				c.failCount.Add(1)
+				log.Error(c.clientGroup+"->"+c.clientId, " 写入数据失败:", err)
+				_ = ws.Close() // 读循环会随之退出并清理
+				return
+			}
+			c.countOut(len(frame.data))
+		case <-w.supersede: // 新连接接管了，剩下的消息留给它的写循环
+			c.writeSuperseded(ws)
+			return
+		case <-done:
+			return
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func tickInterval(pingInterval time.Duration) time.Duration {
+	if pingInterval <= 0 {
+		return defaultPingInterval * time.Second
+	}
+	return pingInterval
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	actionEvent = "_event" // 客户端主动推送的页面事件
+
+	defaultEventQueueSize = 100
+	defaultEventRetention = 600 // 秒
+	maxEventWait          = 30  // /events/pull最多等待的秒数
+)
+
+// PageEvent 页面推送的事件，seq在整个服务内递增，用于/events/pull的since参数
+type PageEvent struct {
+	Seq       int64     `json:"seq"`
+	Namespace string    `json:"namespace,omitempty"`
+	Group     string    `json:"group"`
+	ClientId  string    `json:"clientId"`
+	Name      string    `json:"name"`
+	Data      string    `json:"data"`
+	Time      time.Time `json:"time"`
+}
+
+// pageEvents 每个客户端最近的事件，客户端下线后在保留时间内还能获取
+// notify在有新事件时关闭并替换，用来唤醒长轮询
+type pageEvents struct {
+	mu       sync.Mutex
+	seq      int64
+	byClient map[string][]PageEvent // group->clientId : 事件
+	notify   chan struct{}
+}
+
+func (s *Server) eventsEnabled(group string) bool {
+	conf := s.conf.Events
+	if !conf.IsEnable {
+		return false
+	}
+	if len(conf.Groups) == 0 {
+		return true
+	}
+	for _, g := range conf.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// handleEvent 处理客户端推送的_event，内容是{"name":"xx","data":...}，不是这个格式时整个作为data
+func (c *Clients) handleEvent(raw string) {
+	s := c.server
+	if s == nil || !s.eventsEnabled(c.clientGroup) {
+		return
+	}
+	var body struct {
+		Name string          `json:"name"`
+		Data json.RawMessage `json:"data"`
+	}
+	ev := PageEvent{Namespace: c.namespace, Group: c.clientGroup, ClientId: c.clientId, Data: raw, Time: time.Now()}
+	if err := json.Unmarshal([]byte(raw), &body); err == nil && body.Name != "" {
+		ev.Name = body.Name
+		ev.Data = string(body.Data)
+		var str string
+		if json.Unmarshal(body.Data, &str) == nil { // data是字符串时不再带引号
+			ev.Data = str
+		}
+	}
+	size := s.conf.Events.QueueSize
+	if size <= 0 {
+		size = defaultEventQueueSize
+	}
+	key := c.key()
+	retention := s.eventRetention()
+	p := &s.pageEvents
+	p.mu.Lock()
+	if p.byClient == nil {
+		p.byClient = make(map[string][]PageEvent)
+	}
+	p.seq++
+	ev.Seq = p.seq
+	list := append(p.byClient[key], ev)
+	if len(list) > size {
+		list = append(list[:0], list[len(list)-size:]...)
+	}
+	p.byClient[key] = list
+	for k, events := range p.byClient { // 清理已经过期的事件
+		if time.Since(events[len(events)-1].Time) > retention {
+			delete(p.byClient, k)
+		}
+	}
+	if p.notify != nil {
+		close(p.notify)
+		p.notify = nil
+	}
+	p.mu.Unlock()
+	s.publishPage(c, ev)
+}
+
+func (s *Server) eventRetention() time.Duration {
+	if s.conf.Events.Retention > 0 {
+		return time.Duration(s.conf.Events.Retention) * time.Second
+	}
+	return defaultEventRetention * time.Second
+}
+
+// PageEvents 返回group下(clientId不为空时只看这个客户端)seq大于since并且没有过期的事件
+func (s *Server) PageEvents(ns string, group string, clientId string, since int64) []PageEvent {
+	retention := s.eventRetention()
+	list := make([]PageEvent, 0)
+	s.pageEvents.mu.Lock()
+	for _, events := range s.pageEvents.byClient {
+		for _, ev := range events {
+			if ev.Namespace == ns && ev.Group == group && (clientId == "" || ev.ClientId == clientId) &&
+				ev.Seq > since && time.Since(ev.Time) <= retention {
+				list = append(list, ev)
+			}
+		}
+	}
+	s.pageEvents.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Seq < list[j].Seq })
+	return list
+}
+
+// waitEvent 返回一个有新事件时会关闭的chan
+func (s *Server) waitEvent() <-chan struct{} {
+	s.pageEvents.mu.Lock()
+	defer s.pageEvents.mu.Unlock()
+	if s.pageEvents.notify == nil {
+		s.pageEvents.notify = make(chan struct{})
+	}
+	return s.pageEvents.notify
+}
+
+// pullEvents 获取页面推送的事件，wait大于0时没有新事件会等待最多wait秒
+func (s *Server) pullEvents(c *gin.Context) {
+	group, clientId := c.Query("group"), c.Query("clientId")
+	if group == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入group")
+		return
+	}
+	if !s.eventsEnabled(group) {
+		GinJsonMsg(c, http.StatusForbidden, "没有开启这个group的事件推送")
+		return
+	}
+	since, _ := strconv.ParseInt(c.Query("since"), 10, 64)
+	wait, _ := strconv.Atoi(c.Query("wait"))
+	if wait > maxEventWait {
+		wait = maxEventWait
+	}
+	deadline := time.After(time.Duration(wait) * time.Second)
+	for {
+		notify := s.waitEvent() // 先拿到chan再查询，查询之后来的事件也能唤醒
+		list := s.PageEvents(namespace(c), group, clientId, since)
+		if len(list) > 0 || wait <= 0 {
+			c.JSON(http.StatusOK, gin.H{"status": 200, "data": list})
+			return
+		}
+		select {
+		case <-notify:
+		case <-deadline:
+			wait = 0
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// streamEvents SSE推送客户端上下线(event为connect、disconnect、actions)和页面事件(event为page)
+func (s *Server) streamEvents(c *gin.Context) {
+	group := c.Query("group")
+	events, cancel := s.Subscribe(64)
+	defer cancel()
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return false
+			}
+			if !visible(c, ev.Namespace) || group != "" && ev.Group != group {
+				return true
+			}
+			if ev.Type == EventPage {
+				c.SSEvent(ev.Type, ev.Page)
+			} else {
+				c.SSEvent(ev.Type, ev)
+			}
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		case <-s.stop: // 服务关闭或平滑重启时结束，调用方重连到新进程
+			return false
+		}
+	})
+}
+
+func (s *Server) publishPage(c *Clients, ev PageEvent) {
+	s.broadcastEvent(ClientEvent{Type: EventPage, Namespace: c.namespace, Group: c.clientGroup, Time: ev.Time, Page: &ev})
+}
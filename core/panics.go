@@ -0,0 +1,120 @@
+package core
+
+import (
+	"JsRpc/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const panicWebhookTimeout = 5 * time.Second
+
+// PanicReporter 收到panic时调用，在单独的goroutine里执行，自己panic也不会影响服务
+type PanicReporter func(ctx context.Context, recovered interface{}, stack []byte)
+
+var panicReporter atomic.Pointer[PanicReporter]
+
+// SetPanicReporter 设置全局的panic上报，传nil取消
+func SetPanicReporter(r PanicReporter) {
+	if r == nil {
+		panicReporter.Store(nil)
+		return
+	}
+	panicReporter.Store(&r)
+}
+
+// PanicRequest panic发生在http请求里时的请求信息
+type PanicRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Ip     string `json:"ip"`
+}
+
+type panicRequestKey struct{}
+
+// PanicRequestFromContext 上报时取出请求信息，不是http请求时返回nil
+func PanicRequestFromContext(ctx context.Context) *PanicRequest {
+	req, _ := ctx.Value(panicRequestKey{}).(*PanicRequest)
+	return req
+}
+
+// reportPanic 记录日志并异步交给PanicReporter
+func reportPanic(ctx context.Context, recovered interface{}) {
+	stack := debug.Stack()
+	log.Error("panic: ", recovered, "\n", string(stack))
+	reporter := panicReporter.Load()
+	if reporter == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx) // 请求结束后还要能用
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("panic上报失败: ", r)
+			}
+		}()
+		(*reporter)(ctx, recovered, stack)
+	}()
+}
+
+// Recovery 代替gin.Recovery，处理函数panic时返回500并上报，客户端断开导致的写入失败只记录日志
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if err, ok := r.(error); ok && (errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)) {
+				log.Warn(c.Request.URL.Path, " 调用方已断开: ", err)
+				c.Abort()
+				return
+			}
+			req := &PanicRequest{Method: c.Request.Method, Path: c.Request.URL.Path, Ip: c.ClientIP()}
+			reportPanic(context.WithValue(c.Request.Context(), panicRequestKey{}, req), r)
+			if c.Writer.Written() { // 已经开始返回或者是websocket连接
+				c.Abort()
+				return
+			}
+			GinJsonMsg(c, http.StatusInternalServerError, "服务器内部错误")
+			c.Abort()
+		}()
+		c.Next()
+	}
+}
+
+// WebhookPanicReporter 把panic信息POST到url，内容是json：recovered、stack、request、version、host、time
+func WebhookPanicReporter(url string) PanicReporter {
+	client := &http.Client{Timeout: panicWebhookTimeout}
+	host, _ := os.Hostname()
+	return func(ctx context.Context, recovered interface{}, stack []byte) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"recovered": fmt.Sprint(recovered),
+			"stack":     string(stack),
+			"request":   PanicRequestFromContext(ctx),
+			"version":   config.Version,
+			"host":      host,
+			"time":      time.Now(),
+		})
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Error("panic上报失败: ", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Error("panic上报失败: ", resp.Status)
+		}
+	}
+}
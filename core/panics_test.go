@@ -0,0 +1,136 @@
+package core
+
+import (
+	"JsRpc/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type reportedPanic struct {
+	recovered interface{}
+	stack     string
+	request   *PanicRequest
+}
+
+// fakeReporter 设置收集panic的PanicReporter，测试结束时取消
+func fakeReporter(t *testing.T) <-chan reportedPanic {
+	reports := make(chan reportedPanic, 10)
+	SetPanicReporter(func(ctx context.Context, recovered interface{}, stack []byte) {
+		reports <- reportedPanic{recovered, string(stack), PanicRequestFromContext(ctx)}
+	})
+	t.Cleanup(func() { SetPanicReporter(nil) })
+	return reports
+}
+
+func waitReport(t *testing.T, reports <-chan reportedPanic) reportedPanic {
+	t.Helper()
+	select {
+	case r := <-reports:
+		return r
+	case <-time.After(3 * time.Second):
+		t.Fatal("PanicReporter没有被调用")
+		return reportedPanic{}
+	}
+}
+
+func TestPanicReporterFiresForHandlerPanic(t *testing.T) {
+	reports := fakeReporter(t)
+	router := gin.New()
+	router.Use(Recovery())
+	router.GET("/boom", func(*gin.Context) { panic("处理函数出错") })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("处理函数panic时返回%d，期望500", w.Code)
+	}
+	r := waitReport(t, reports)
+	if r.recovered != "处理函数出错" || !strings.Contains(r.stack, "panics_test.go") {
+		t.Fatalf("上报的内容不对：%v\n%s", r.recovered, r.stack)
+	}
+	if r.request == nil || r.request.Method != http.MethodGet || r.request.Path != "/boom" || r.request.Ip == "" {
+		t.Fatalf("上报里缺少请求信息：%+v", r.request)
+	}
+}
+
+func TestPanicReporterFiresForHookPanic(t *testing.T) {
+	reports := fakeReporter(t)
+	s := newTestServer(t, nil)
+	s.AddHook(&testHook{onRequest: func(*RequestInfo) error { panic("hook出错") }})
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHello())
+	if w := serveRequest(s, http.MethodGet, "/go?group=g&action=hello&param=1", ""); w.Code != http.StatusOK {
+		t.Fatalf("hook panic后请求应该照常返回：%d", w.Code)
+	}
+	if r := waitReport(t, reports); r.recovered != "hook出错" {
+		t.Fatalf("上报的是%v", r.recovered)
+	}
+}
+
+func TestPanicReporterItselfPanics(t *testing.T) {
+	called := make(chan struct{})
+	SetPanicReporter(func(context.Context, interface{}, []byte) {
+		close(called)
+		panic("上报也出错")
+	})
+	t.Cleanup(func() { SetPanicReporter(nil) })
+	router := gin.New()
+	router.Use(Recovery())
+	router.GET("/boom", func(*gin.Context) { panic("boom") })
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/boom", nil))
+	select {
+	case <-called:
+	case <-time.After(3 * time.Second):
+		t.Fatal("PanicReporter没有被调用")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("返回%d", w.Code)
+	}
+}
+
+func TestRecoveryIgnoresBrokenPipe(t *testing.T) {
+	reports := fakeReporter(t)
+	router := gin.New()
+	router.Use(Recovery())
+	router.GET("/gone", func(*gin.Context) { panic(fmt.Errorf("write: %w", syscall.EPIPE)) })
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/gone", nil))
+	select {
+	case r := <-reports:
+		t.Fatalf("调用方断开不应该上报：%v", r.recovered)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookPanicReporter(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		received <- body
+	}))
+	defer srv.Close()
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.PanicWebhook = srv.URL })
+	t.Cleanup(func() { SetPanicReporter(nil) })
+	s.AddHook(&testHook{onRequest: func(*RequestInfo) error { panic(errors.New("hook出错")) }})
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHello())
+	serveRequest(s, http.MethodGet, "/go?group=g&action=hello", "")
+	select {
+	case body := <-received:
+		if body["recovered"] != "hook出错" || body["version"] != config.Version || body["stack"] == "" {
+			t.Fatalf("webhook收到的内容：%v", body)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("webhook没有收到panic")
+	}
+}
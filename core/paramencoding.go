@@ -0,0 +1,66 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headerParamWarning GET请求的参数里有未编码的+时提示调用方，查询字符串解码时+会变成空格
+const headerParamWarning = "X-JsRpc-Warning"
+
+// decodeParam 按encoding解码param/code，base64和url编码的内容不会被查询字符串或表单解码改掉
+// encoding为空时原样返回，GET请求的参数里有+时在响应头里提示
+func decodeParam(c *gin.Context, encoding string, field string, value string) (string, bool) {
+	switch encoding {
+	case "":
+		if c.Request.Method == http.MethodGet && rawQueryHasPlus(c.Request.URL.RawQuery, field) {
+			c.Header(headerParamWarning, fmt.Sprintf("'+' in query param %q was decoded as space, use %%2B or encoding=base64", field))
+		}
+		return value, true
+	case "base64":
+		decoded, err := decodeBase64(value)
+		if err != nil {
+			GinJsonMsg(c, http.StatusBadRequest, field+"不是合法的base64："+err.Error())
+			return "", false
+		}
+		return decoded, true
+	case "url":
+		// 用PathUnescape，+保持原样不会变成空格
+		decoded, err := url.PathUnescape(value)
+		if err != nil {
+			GinJsonMsg(c, http.StatusBadRequest, field+"不是合法的url编码："+err.Error())
+			return "", false
+		}
+		return decoded, true
+	default:
+		GinJsonMsg(c, http.StatusBadRequest, "encoding只支持base64或url")
+		return "", false
+	}
+}
+
+// decodeBase64 标准和url安全的base64都可以，末尾的=可以省略。GET请求里没编码的+已经变成空格，这里换回来
+func decodeBase64(value string) (string, error) {
+	value = strings.TrimRight(strings.ReplaceAll(value, " ", "+"), "=")
+	encoding := base64.RawStdEncoding
+	if strings.ContainsAny(value, "-_") {
+		encoding = base64.RawURLEncoding
+	}
+	decoded, err := encoding.DecodeString(value)
+	return string(decoded), err
+}
+
+// rawQueryHasPlus 原始查询字符串里field的值是否有+
+func rawQueryHasPlus(rawQuery string, field string) bool {
+	for _, pair := range strings.Split(rawQuery, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		if key == field && strings.Contains(value, "+") {
+			return true
+		}
+	}
+	return false
+}
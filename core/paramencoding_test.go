@@ -0,0 +1,98 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 不同的请求方式和编码，客户端收到的Message.Param都应该相同
+func TestParamEncodingIdentical(t *testing.T) {
+	s := newTestServer(t, nil)
+	received := make(chan string, 1)
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"echo": func(param string) string {
+			received <- param
+			return "ok"
+		},
+	})
+	param := "a+b=c & 中文\n%2B ~>?"
+	std := base64.StdEncoding.EncodeToString([]byte(param))
+	if !strings.Contains(std, "+") {
+		t.Fatal("测试数据的base64里应该有+")
+	}
+	jsonBody := func(values map[string]string) string {
+		data, _ := json.Marshal(values)
+		return string(data)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		contentType string
+		warning     bool // 响应里有X-JsRpc-Warning
+	}{
+		// base64里的+没有编码，查询字符串解码后变成了空格
+		{"GET base64", http.MethodGet, "/go?group=g&action=echo&encoding=base64&param=" + std, "", "", false},
+		{"GET url安全的base64", http.MethodGet, "/go?group=g&action=echo&encoding=base64&param=" +
+			base64.RawURLEncoding.EncodeToString([]byte(param)), "", "", false},
+		// QueryEscape把空格编码成+，分不出是不是忘了编码，也会提示
+		{"GET 正确编码", http.MethodGet, "/go?group=g&action=echo&param=" + url.QueryEscape(param), "", "", true},
+		{"GET encoding=url", http.MethodGet, "/go?group=g&action=echo&encoding=url&param=" +
+			url.QueryEscape(url.PathEscape(param)), "", "", false},
+		{"POST 表单", http.MethodPost, "/go", formBody(map[string]string{"group": "g", "action": "echo", "param": param}), formType, false},
+		{"POST 表单base64", http.MethodPost, "/go", formBody(map[string]string{"group": "g", "action": "echo", "param": std, "encoding": "base64"}), formType, false},
+		{"POST json", http.MethodPost, "/go", jsonBody(map[string]string{"group": "g", "action": "echo", "param": param}), "application/json", false},
+		{"POST json base64", http.MethodPost, "/go", jsonBody(map[string]string{"group": "g", "action": "echo", "param": std, "encoding": "base64"}), "application/json", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(s, tt.method, tt.target, tt.body, "Content-Type", tt.contentType)
+			if body := decodeBody(t, w); body["data"] != "ok" {
+				t.Fatalf("请求失败：%v", body)
+			}
+			if got := <-received; got != param {
+				t.Fatalf("客户端收到%q，期望%q", got, param)
+			}
+			if got := w.Header().Get(headerParamWarning) != ""; got != tt.warning {
+				t.Fatalf("%s = %q", headerParamWarning, w.Header().Get(headerParamWarning))
+			}
+		})
+	}
+}
+
+// GET的param里有没编码的+时提示调用方，参数照常发送
+func TestParamPlusWarning(t *testing.T) {
+	s := newTestServer(t, nil)
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+	w := serveRequest(s, http.MethodGet, "/go?group=g&action=hello&param=a+b", "")
+	if body := decodeBody(t, w); body["data"] != "a b" {
+		t.Fatalf("/go = %v", body)
+	}
+	if warning := w.Header().Get(headerParamWarning); !strings.Contains(warning, "param") {
+		t.Fatalf("应该提示+被解码成了空格，得到%q", warning)
+	}
+	if w := serveRequest(s, http.MethodGet, "/go?group=g&action=a+b&param=x", ""); w.Header().Get(headerParamWarning) != "" {
+		t.Fatal("其它参数里的+不用提示")
+	}
+}
+
+func TestParamEncodingErrors(t *testing.T) {
+	s := newTestServer(t, nil)
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+	for _, target := range []string{
+		"/go?group=g&action=hello&encoding=base64&param=" + url.QueryEscape("不是base64"),
+		"/go?group=g&action=hello&encoding=url&param=" + url.QueryEscape("%zz"),
+		"/go?group=g&action=hello&encoding=hex&param=00",
+		"/execjs?group=g&encoding=base64&code=" + url.QueryEscape("!!"),
+	} {
+		if w := serveRequest(s, http.MethodGet, target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s：期望400，得到%d %s", target, w.Code, w.Body)
+		}
+	}
+}
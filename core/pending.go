@@ -0,0 +1,161 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrReleased 请求被管理员通过/inflight/release手动释放
+var ErrReleased = errors.New("operator released")
+
+// ErrTooManyPending 客户端等待返回的请求数达到上限
+var ErrTooManyPending = errors.New("too many pending requests")
+
+// PendingLimitError 达到上限时的当前排队数和建议的重试时间
+type PendingLimitError struct {
+	Depth      int
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *PendingLimitError) Error() string {
+	return fmt.Sprintf("客户端排队请求数%d已达上限%d", e.Depth, e.Limit)
+}
+
+func (e *PendingLimitError) Is(target error) bool {
+	return target == ErrTooManyPending
+}
+
+// pendingRequest 一个等待客户端返回的请求
+// 读循环交付结果和超时移除都在Clients.mu下完成，done保证结果只交付一次，不会出现向已关闭的chan发送
+type pendingRequest struct {
+	messageId string
+	action    string
+	result    chan string     // 容量为1，交付时不会阻塞
+	parts     chan StreamPart // 客户端流式返回的部分，最后一部分到达时请求结束
+	err       error           // 被释放时的错误，在写入result之前设置
+	done      bool
+	created   time.Time
+	caller    CallerInfo
+	priority  int
+	resend    bool              // 客户端重连后重新发送
+	offline   bool              // 客户端在等待重连，请求先缓存着，重连后再发送
+	request   protocol.Envelope // 发给客户端的消息，重新发送时按当时协商的版本编码
+	jsErr     *protocol.JsError // 客户端执行时抛出的异常，结果里仍是异常的文字
+}
+
+// maxPending 客户端同时等待返回的请求数上限，0不限制
+func (c *Clients) maxPending() int {
+	if c.server == nil {
+		return 0
+	}
+	conf := c.server.conf.Pending
+	if n, ok := conf.Groups[c.clientGroup]; ok {
+		return n
+	}
+	return conf.MaxPerClient
+}
+
+// pendingCount 当前等待返回的请求数
+func (c *Clients) pendingCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.actionData)
+}
+
+// addPending 登记一个等待返回的请求
+// 达到上限时返回*PendingLimitError，RetryAfter是最早的请求超时前剩余的时间
+func (c *Clients) addPending(req *pendingRequest) (*pendingRequest, error) {
+	req.result = make(chan string, 1)
+	req.parts = make(chan StreamPart, streamBufferSize)
+	req.created = time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.graceUntil.IsZero() { // 断开了在等待重连，先缓存
+		if c.offlineCountLocked() >= c.offlineQueueSize() {
+			return nil, ErrOfflineQueueFull
+		}
+		req.offline = true
+	}
+	if limit := c.maxPending(); limit > 0 && len(c.actionData) >= limit {
+		retryAfter := time.Until(c.actionData[0].created.Add(c.timeout()))
+		return nil, &PendingLimitError{Depth: len(c.actionData), Limit: limit, RetryAfter: retryAfter}
+	}
+	c.actionData = append(c.actionData, req)
+	return req, nil
+}
+
+// removePending 超时等情况下撤销请求，返回false说明结果已经交付了
+func (c *Clients) removePending(req *pendingRequest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if req.done {
+		return false
+	}
+	req.done = true
+	c.removePendingLocked(req)
+	return true
+}
+
+// deliver 把客户端的返回交给对应的请求；旧版客户端不带messageId，按action交给最早的请求
+func (c *Clients) deliver(messageId string, action string, data string, jsErr *protocol.JsError) bool {
+	c.mu.Lock()
+	var target *pendingRequest
+	for _, req := range c.actionData {
+		if messageId != "" && req.messageId == messageId {
+			target = req
+			break
+		}
+	}
+	if target == nil {
+		for _, req := range c.actionData {
+			if req.action == action {
+				target = req
+				break
+			}
+		}
+	}
+	if target == nil {
+		c.mu.Unlock()
+		return false
+	}
+	target.done = true
+	target.jsErr = jsErr
+	c.removePendingLocked(target)
+	c.mu.Unlock()
+	target.result <- data
+	return true
+}
+
+func (c *Clients) removePendingLocked(req *pendingRequest) {
+	for i, r := range c.actionData {
+		if r == req {
+			c.actionData = append(c.actionData[:i], c.actionData[i+1:]...)
+			return
+		}
+	}
+}
+
+// release 让等待中的请求立即返回ErrReleased
+func (c *Clients) release(messageId string) bool {
+	c.mu.Lock()
+	var target *pendingRequest
+	for _, req := range c.actionData {
+		if req.messageId == messageId {
+			target = req
+			break
+		}
+	}
+	if target == nil {
+		c.mu.Unlock()
+		return false
+	}
+	target.done = true
+	target.err = ErrReleased
+	c.removePendingLocked(target)
+	c.mu.Unlock()
+	target.result <- ""
+	return true
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 超时和交付同时发生时，每个请求只能有一方成功，成功交付的结果一定能从result读到
+func TestPendingTimeoutRacesDelivery(t *testing.T) {
+	c := &Clients{clientGroup: "g", clientId: "c"}
+	const n = 2000
+	var delivered, removed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		// action各不相同，避免按action交付时落到别的请求上
+		id := fmt.Sprint(i)
+		req, err := c.addPending(&pendingRequest{messageId: id, action: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotDeliver, gotRemove bool
+		var inner sync.WaitGroup
+		inner.Add(2)
+		go func() {
+			defer inner.Done()
+			gotDeliver = c.deliver(id, id, "late", nil)
+		}()
+		go func() {
+			defer inner.Done()
+			gotRemove = c.removePending(req)
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inner.Wait()
+			switch {
+			case gotDeliver == gotRemove:
+				t.Errorf("请求%s交付=%v 移除=%v，只能有一方成功", req.messageId, gotDeliver, gotRemove)
+			case gotDeliver:
+				delivered.Add(1)
+				if res := <-req.result; res != "late" {
+					t.Errorf("交付的结果是%q", res)
+				}
+			default:
+				removed.Add(1)
+				if len(req.result) != 0 {
+					t.Errorf("请求%s已经移除，不应该再收到结果", req.messageId)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if c.pendingCount() != 0 {
+		t.Fatalf("还剩%d个请求没有移除", c.pendingCount())
+	}
+	t.Logf("交付%d 超时%d", delivered.Load(), removed.Load())
+}
+
+// 客户端的返回和调用方的超时几乎同时到达，Query要么拿到结果要么超时，不会panic也不会留下请求
+func TestQueryTimeoutRacesLateResponse(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"slow": func(string) string {
+			time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+			return "ok"
+		},
+	})
+	const n = 200
+	var wg sync.WaitGroup
+	var ok, timedOut atomic.Int64
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(rand.Intn(30))*time.Millisecond)
+			defer cancel()
+			res, err := fc.client.Query(ctx, "slow", "")
+			switch {
+			case err == nil && res == "ok":
+				ok.Add(1)
+			case errors.Is(err, context.DeadlineExceeded):
+				timedOut.Add(1)
+			default:
+				t.Errorf("Query = %q, %v", res, err)
+			}
+		}()
+	}
+	wg.Wait()
+	waitFor(t, "晚到的返回处理完", func() bool { return fc.client.pendingCount() == 0 })
+	t.Logf("成功%d 超时%d", ok.Load(), timedOut.Load())
+}
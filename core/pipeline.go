@@ -0,0 +1,171 @@
+package core
+
+import (
+	"JsRpc/config"
+	"JsRpc/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxPipelineSteps = 20
+
+// pipelineStep 一个步骤，action和code二选一，param里可以用{{prev}}、{{steps.0.data}}引用前面步骤的结果
+type pipelineStep struct {
+	Action  string `json:"action"`
+	Code    string `json:"code"`
+	Param   string `json:"param"`
+	Extract string `json:"extract"`
+	Timeout int    `json:"timeout"` // 秒，只能比DefaultTimeOut短
+}
+
+type pipelineRequest struct {
+	Group           string         `json:"group"`
+	ClientId        string         `json:"clientId"`
+	Session         string         `json:"session"`
+	Selector        string         `json:"selector"`
+	Timeout         int            `json:"timeout"` // 整个pipeline的超时秒数，0不限制
+	ContinueOnError bool           `json:"continueOnError"`
+	Steps           []pipelineStep `json:"steps"`
+}
+
+// pipelineResult 每个步骤的结果，status和/ws/caller一致
+type pipelineResult struct {
+	Action    string `json:"action"`
+	Status    int    `json:"status"`
+	Data      string `json:"data"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+func (s *Server) checkPipeline(req pipelineRequest) (int, string) {
+	switch {
+	case req.Group == "":
+		return http.StatusBadRequest, "需要传入group"
+	case config.CheckGroupPattern(req.Group) != nil:
+		return http.StatusBadRequest, config.CheckGroupPattern(req.Group).Error()
+	case len(req.Steps) == 0:
+		return http.StatusBadRequest, "需要传入steps"
+	case len(req.Steps) > maxPipelineSteps:
+		return http.StatusBadRequest, fmt.Sprintf("steps最多%d个", maxPipelineSteps)
+	}
+	for i, step := range req.Steps {
+		switch {
+		case step.Action == "" && step.Code == "":
+			return http.StatusBadRequest, fmt.Sprintf("第%d步需要action或code", i)
+		case step.Action != "" && step.Code != "":
+			return http.StatusBadRequest, fmt.Sprintf("第%d步action和code只能传一个", i)
+		case step.Code != "" && s.conf.Security.DisableExecjs:
+			return http.StatusForbidden, "execjs已禁用"
+		case step.Action != "" && s.checkAction(req.Group, step.Action) != nil:
+			return http.StatusForbidden, s.checkAction(req.Group, step.Action).Error()
+		case s.fieldTooLong("code", step.Code) != "":
+			return http.StatusRequestEntityTooLarge, fmt.Sprintf("第%d步%s", i, s.fieldTooLong("code", step.Code))
+		case s.fieldTooLong("param", step.Param) != "":
+			return http.StatusRequestEntityTooLarge, fmt.Sprintf("第%d步%s", i, s.fieldTooLong("param", step.Param))
+		}
+	}
+	return http.StatusOK, ""
+}
+
+// pipeline 在同一个客户端上依次执行多个步骤，默认遇到第一个失败就停止
+func (s *Server) pipeline(c *gin.Context) {
+	var req pipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Set(ctxApiParam, &ApiParam{GroupName: req.Group})
+	if status, msg := s.checkPipeline(req); status != http.StatusOK {
+		GinJsonMsg(c, status, msg)
+		return
+	}
+	selector, err := parseTags(req.Selector)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	client, err := s.selectClient(namespace(c), req.Group, req.ClientId, req.Session, req.Steps[0].Action, selector)
+	if err != nil {
+		selectFailed(c, err)
+		return
+	}
+	if client == nil {
+		noClientJson(c, s.noClient(namespace(c), req.Group, req.ClientId, req.Steps[0].Action))
+		return
+	}
+	for _, step := range req.Steps[1:] { // 第一步在selectClient里检查过了
+		if e := s.unregisteredAction(client, step.Action); e != nil {
+			noClientJson(c, e)
+			return
+		}
+	}
+	ctx := withCaller(c.Request.Context(), httpCaller(c))
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
+		defer cancel()
+	}
+	results := make([]pipelineResult, 0, len(req.Steps))
+	vars := make(map[string]string)
+	ok := true
+	for i, step := range req.Steps {
+		res := s.pipelineStep(ctx, client, step, vars)
+		results = append(results, res)
+		vars["prev"] = res.Data
+		vars["steps."+strconv.Itoa(i)+".data"] = res.Data
+		if res.Status != http.StatusOK {
+			ok = false
+			if !req.ContinueOnError {
+				break
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": client.clientGroup, "clientId": client.clientId, "ok": ok, "steps": results})
+}
+
+func (s *Server) pipelineStep(ctx context.Context, client *Clients, step pipelineStep, vars map[string]string) (res pipelineResult) {
+	start := time.Now()
+	msg := Message{Action: step.Action, Param: step.Param}
+	if step.Code != "" {
+		msg = Message{Action: "_execjs", Param: step.Code}
+	}
+	res = pipelineResult{Action: msg.Action, Status: http.StatusOK}
+	defer func() { res.ElapsedMs = time.Since(start).Milliseconds() }()
+
+	param, err := s.expandVars(msg.Param, vars)
+	if err != nil {
+		res.Status, res.Data = http.StatusBadRequest, err.Error()
+		return res
+	}
+	msg.Param = param
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+		defer cancel()
+	}
+	raw, err := s.runQuery(ctx, client, msg)
+	if err != nil {
+		res.Status, res.Data = errorStatus(err)
+		return res
+	}
+	res.Data = raw
+	if step.Extract != "" {
+		data, err := utils.ExtractJson(raw, step.Extract)
+		if err != nil {
+			res.Status, res.Data = http.StatusBadRequest, err.Error()
+			return res
+		}
+		if str, isStr := data.(string); isStr {
+			res.Data = str
+		} else {
+			b, _ := json.Marshal(data)
+			res.Data = string(b)
+		}
+	}
+	return res
+}
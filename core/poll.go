@@ -0,0 +1,181 @@
+package core
+
+import (
+	"JsRpc/utils"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	transportWs   = "ws"
+	transportPoll = "poll"
+
+	pollHoldTime       = 25 * time.Second // 没有消息时pull最多挂起多久
+	pollMaxBatch       = 16               // 一次pull最多返回的消息数
+	defaultIdleTimeout = 60               // 长轮询客户端默认的下线时间(秒)
+)
+
+// close 断开客户端，ws连接由读循环负责清理，长轮询客户端由watchPollClient清理
+func (c *Clients) close() {
+	if ws := c.conn(); ws != nil {
+		_ = ws.Close()
+		return
+	}
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+func (c *Clients) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (s *Server) pollIdleTimeout() time.Duration {
+	if s.conf.Poll.IdleTimeout > 0 {
+		return time.Duration(s.conf.Poll.IdleTimeout) * time.Second
+	}
+	return defaultIdleTimeout * time.Second
+}
+
+// pollClient 取出已注册的长轮询客户端，没有时直接写404
+func (s *Server) pollClient(c *gin.Context) (*Clients, bool) {
+	ns, ok := s.clientNamespace(c)
+	if !ok {
+		return nil, false
+	}
+	value, ok := s.hlSyncMap.Load(clientKey(ns, c.Query("group"), c.Query("clientId")))
+	if client, isClient := value.(*Clients); ok && isClient && client.transport == transportPoll {
+		client.touch()
+		return client, true
+	}
+	GinJsonMsg(c, http.StatusNotFound, "客户端没有注册或已超时下线，请重新注册")
+	return nil, false
+}
+
+// pollRegister 创建一个和ws连接等价的客户端，已经注册过时继续使用原来的
+func (s *Server) pollRegister(c *gin.Context) {
+	group, clientId := c.Query("group"), c.Query("clientId")
+	if group == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要group参数")
+		return
+	}
+	if clientId == "" {
+		clientId = utils.GetUUID()
+	}
+	tags, err := parseTags(c.Query("tags"))
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	ns, ok := s.clientNamespace(c)
+	if !ok {
+		return
+	}
+	key := clientKey(ns, group, clientId)
+	if value, ok := s.hlSyncMap.Load(key); ok {
+		if client, _ := value.(*Clients); client != nil && client.transport == transportPoll {
+			client.touch()
+			c.JSON(http.StatusOK, gin.H{"status": 200, "data": client.registerReceipt()})
+			return
+		}
+	}
+	if reason := s.checkClientLimit(ns, group, clientId); reason != "" {
+		logReject(group, clientId, c.ClientIP(), reason)
+		GinJsonMsg(c, http.StatusServiceUnavailable, reason)
+		return
+	}
+	s.supersedeOther(key, transportPoll)
+	client := NewClient(group, clientId, nil, c.ClientIP())
+	client.namespace = ns
+	client.connectedAt.Store(time.Now().UnixNano())
+	client.setTags(tags)
+	s.attach(client)
+	client.transport = transportPoll
+	client.touch()
+	s.hlSyncMap.Store(key, client)
+	s.publish(EventConnect, client)
+	go s.watchPollClient(key, client)
+	go client.loadActions()
+	utils.LogPrint("新上线group:" + group + ",clientId:->" + clientId + ",ip:" + client.getIp() + "(长轮询)")
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": client.registerReceipt()})
+}
+
+// watchPollClient 长轮询客户端超过IdleTimeout没有请求时视为下线
+func (s *Server) watchPollClient(key string, client *Clients) {
+	idle := s.pollIdleTimeout()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.closed:
+			s.hlSyncMap.CompareAndDelete(key, client)
+			s.publish(EventDisconnect, client)
+			utils.LogPrint(key, client.getIp(), "下线了")
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, client.lastSeen.Load())) > idle {
+				client.close()
+			}
+		}
+	}
+}
+
+// pollPull 挂起直到有待发送的消息或者超过pollHoldTime，返回Message数组
+func (s *Server) pollPull(c *gin.Context) {
+	client, ok := s.pollClient(c)
+	if !ok {
+		return
+	}
+	defer client.touch() // 挂起的这段时间也算在线
+	messages := make([]json.RawMessage, 0)
+	timer := time.NewTimer(pollHoldTime)
+	defer timer.Stop()
+	select {
+	case frame := <-client.outbound:
+		messages = append(messages, frame.data)
+	case <-timer.C:
+	case <-client.closed:
+	case <-c.Request.Context().Done():
+		return
+	}
+drain:
+	for len(messages) > 0 && len(messages) < pollMaxBatch {
+		select {
+		case frame := <-client.outbound:
+			messages = append(messages, frame.data)
+		default:
+			break drain
+		}
+	}
+	for _, msg := range messages {
+		client.countOut(len(msg))
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": messages})
+}
+
+// pollPush 请求体和ws消息的格式相同
+func (s *Server) pollPush(c *gin.Context) {
+	client, ok := s.pollClient(c)
+	if !ok {
+		return
+	}
+	body := io.Reader(c.Request.Body)
+	if limit := s.conf.Websocket.MaxMessageSize; limit > 0 {
+		body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+	msg, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		GinJsonMsg(c, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	} else if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	client.countIn(len(msg))
+	client.handleMessage(msg, false)
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": "ok"})
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrPoolFull 工作池的队列满了
+var ErrPoolFull = errors.New("任务队列已满，请稍后重试")
+
+// workerPool 固定数量的goroutine执行请求，队列满时直接拒绝
+type workerPool struct {
+	size     int
+	jobs     chan func()
+	busy     atomic.Int64
+	rejected atomic.Int64
+}
+
+// PoolStats 工作池的使用情况
+type PoolStats struct {
+	Size      int   `json:"size"`
+	Busy      int64 `json:"busy"`      // 正在执行的任务数
+	Queued    int   `json:"queued"`    // 排队中的任务数
+	QueueSize int   `json:"queueSize"` // 队列容量
+	Rejected  int64 `json:"rejected"`  // 因为队列满被拒绝的次数
+}
+
+func newWorkerPool(size int, queueSize int) *workerPool {
+	if queueSize <= 0 {
+		queueSize = size * 10
+	}
+	p := &workerPool{size: size, jobs: make(chan func(), queueSize)}
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for job := range p.jobs {
+		p.busy.Add(1)
+		job()
+		p.busy.Add(-1)
+	}
+}
+
+// submit 提交任务，队列满时返回false
+func (p *workerPool) submit(job func()) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		p.rejected.Add(1)
+		return false
+	}
+}
+
+func (p *workerPool) stats() PoolStats {
+	return PoolStats{
+		Size:      p.size,
+		Busy:      p.busy.Load(),
+		Queued:    len(p.jobs),
+		QueueSize: cap(p.jobs),
+		Rejected:  p.rejected.Load(),
+	}
+}
+
+// runQuery 发送请求到客户端，配置了工作池时在池里执行，否则直接在当前goroutine执行
+func (s *Server) runQuery(ctx context.Context, client *Clients, msg Message) (string, error) {
+	if s.pool == nil {
+		return client.query(ctx, msg)
+	}
+	var res string
+	var err error
+	done := make(chan struct{})
+	if !s.pool.submit(func() {
+		defer close(done)
+		res, err = client.query(ctx, msg)
+	}) {
+		return "", ErrPoolFull
+	}
+	<-done
+	return res, err
+}
+
+// PoolStats 返回工作池的使用情况，没有启用工作池时ok为false
+func (s *Server) PoolStats() (stats PoolStats, ok bool) {
+	if s.pool == nil {
+		return PoolStats{}, false
+	}
+	return s.pool.stats(), true
+}
+
+// getPool 查看工作池的使用情况
+func (s *Server) getPool(c *gin.Context) {
+	stats, ok := s.PoolStats()
+	if !ok {
+		GinJsonMsg(c, http.StatusNotFound, "没有启用工作池")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": stats})
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"JsRpc/config"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolFullReturns503(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.WorkerPool = config.WorkerPoolConfig{Size: 1, QueueSize: 1} })
+	hangingClient(t, s, "g")
+	// 一个在执行，一个在排队，第三个直接被拒绝
+	running := goAsync(s, "/go?group=g&action=hang")
+	waitFor(t, "第一个请求开始执行", func() bool { stats, _ := s.PoolStats(); return stats.Busy == 1 })
+	queued := goAsync(s, "/go?group=g&action=hang")
+	waitFor(t, "第二个请求排队", func() bool { stats, _ := s.PoolStats(); return stats.Queued == 1 })
+
+	w := serveRequest(s, http.MethodGet, "/go?group=g&action=hang", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("队列满时返回%d，期望503：%s", w.Code, w.Body.String())
+	}
+	if _, err := s.Call(testContext(t), "g", "", "hang", ""); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("队列满时Call应该返回ErrPoolFull，得到 %v", err)
+	}
+	body := decodeBody(t, serveRequest(s, http.MethodGet, "/pool", ""))
+	stats := body["data"].(map[string]interface{})
+	if stats["size"] != float64(1) || stats["busy"] != float64(1) || stats["queued"] != float64(1) ||
+		stats["queueSize"] != float64(1) || stats["rejected"] != float64(2) {
+		t.Fatalf("/pool = %v", stats)
+	}
+	for _, inflight := range s.Inflight() {
+		s.Release(inflight.MessageId)
+	}
+	<-running
+	waitFor(t, "排队的请求开始执行", func() bool { return len(s.Inflight()) == 1 })
+	s.Release(s.Inflight()[0].MessageId)
+	<-queued
+}
+
+func TestPoolDisabled(t *testing.T) {
+	s := newTestServer(t, nil)
+	if _, ok := s.PoolStats(); ok {
+		t.Fatal("没有配置WorkerPool.Size时不应该启用工作池")
+	}
+	if w := serveRequest(s, http.MethodGet, "/pool", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("没有启用工作池时/pool返回%d，期望404", w.Code)
+	}
+}
+
+// BenchmarkConcurrentCalls 每次同时发起5000个调用，对比每个请求一个goroutine和工作池的p99延迟和内存
+func BenchmarkConcurrentCalls(b *testing.B) {
+	const concurrent = 5000
+	for _, pool := range []config.WorkerPoolConfig{{}, {Size: 256, QueueSize: concurrent}} {
+		b.Run(fmt.Sprintf("pool=%d", pool.Size), func(b *testing.B) {
+			s := newTestServer(b, func(conf *config.ConfStruct) { conf.WorkerPool = pool })
+			for i := 0; i < 8; i++ {
+				startFake(b, s, wsPeer{group: "g", clientId: fmt.Sprint(i)}, map[string]func(string) string{
+					"hello": func(param string) string { return param },
+				})
+			}
+			ctx := testContext(b)
+			latencies := make([]time.Duration, 0, concurrent*b.N)
+			var mu sync.Mutex
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < concurrent; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						start := time.Now()
+						if _, err := s.Call(ctx, "g", "", "hello", "1"); err != nil {
+							b.Error(err)
+							return
+						}
+						d := time.Since(start)
+						mu.Lock()
+						latencies = append(latencies, d)
+						mu.Unlock()
+					}()
+				}
+				wg.Wait()
+			}
+			b.StopTimer()
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			if len(latencies) > 0 {
+				b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds())/1000, "p99-ms")
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuorumResponse quorum模式下每个客户端的返回
+type QuorumResponse struct {
+	ClientId string `json:"clientId"`
+	Data     string `json:"data,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Agree    bool   `json:"agree"` // 是否和多数结果一致
+}
+
+// majority 返回超过半数的结果，没有时ok为false
+func majority(results []broadcastResult) (string, bool) {
+	counts := make(map[string]int)
+	for _, r := range results {
+		if r.err == nil {
+			counts[r.res]++
+		}
+	}
+	for res, n := range counts {
+		if n*2 > len(results) {
+			return res, true
+		}
+	}
+	return "", false
+}
+
+// quorumResult /go的quorum模式：同一个请求发给n个客户端，返回超过半数一致的结果
+// 和多数结果不一致的客户端记一次suspect，没有多数结果时返回409
+func (s *Server) quorumResult(c *gin.Context, group string, msg Message, n int, p ApiParam) {
+	if p.ClientId != "" {
+		GinJsonMsg(c, http.StatusBadRequest, "指定clientId时不能使用quorum")
+		return
+	}
+	clients := s.groupClients(namespace(c), group, "")
+	if len(clients) < n {
+		GinJsonMsg(c, http.StatusBadRequest, "在线客户端数量"+strconv.Itoa(len(clients))+"不足quorum="+strconv.Itoa(n))
+		return
+	}
+	rand.Shuffle(len(clients), func(i, j int) { clients[i], clients[j] = clients[j], clients[i] })
+	results := broadcast(withCaller(c.Request.Context(), httpCaller(c)), clients[:n], msg)
+
+	for _, r := range results {
+		if errors.Is(r.err, ErrRejected) { // 被hook拒绝时和普通请求一样返回403
+			queryResult(c, "", r.err)
+			return
+		}
+	}
+	winner, ok := majority(results)
+	responses := make([]QuorumResponse, 0, n)
+	var agreed *Clients
+	agree := 0
+	for _, r := range results {
+		resp := QuorumResponse{ClientId: r.client.clientId, Data: r.res}
+		if r.err != nil {
+			resp.Data, resp.Error = "", r.err.Error()
+		} else if ok && r.res == winner {
+			resp.Agree = true
+			agree++
+			if agreed == nil {
+				agreed = r.client
+			}
+		} else if ok {
+			r.client.suspectCount.Add(1)
+		}
+		responses = append(responses, resp)
+	}
+	quorum := gin.H{"total": n, "agree": agree, "responses": responses}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict, "group": group, "data": "没有超过半数一致的结果", "quorum": quorum})
+		return
+	}
+	data, ok := resultData(c, winner, p)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": group, "clientId": agreed.clientId, "data": data, "quorum": quorum})
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// 代码里的+、换行、反引号和中文注释不管用哪种Content-Type提交都原样到达客户端
+func TestExecjsCodeRoundTrip(t *testing.T) {
+	s := newTestServer(t, nil)
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"_execjs": func(code string) string { return code },
+	})
+	code := "// 计算 a+b\nvar a = 1, b = 2;\r\nvar s = `${a+b} %2B +`;\n\t/* 中文注释 */ s + '&=?'"
+	jsonBody, _ := json.Marshal(map[string]string{"group": "g", "code": code})
+
+	tests := []struct {
+		name        string
+		target      string
+		body        string
+		contentType string
+	}{
+		{"text/javascript", "/execjs?group=g", code, "text/javascript"},
+		{"application/javascript", "/execjs?group=g&clientId=c", code, "application/javascript"},
+		{"text/plain", "/execjs?group=g", code, "text/plain; charset=utf-8"},
+		{"表单", "/execjs", formBody(map[string]string{"group": "g", "code": code}), formType},
+		{"json", "/execjs", string(jsonBody), "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(s, http.MethodPost, tt.target, tt.body, "Content-Type", tt.contentType)
+			if body := decodeBody(t, w); body["data"] != code {
+				t.Fatalf("客户端收到的代码不一致：%v", body)
+			}
+		})
+	}
+}
+
+func TestExecjsRawCodeLimits(t *testing.T) {
+	s := limitServer(t)
+	tests := []struct {
+		name string
+		code string
+		want int
+	}{
+		{"刚好", "12345", http.StatusOK},
+		{"code超长", "123456", http.StatusRequestEntityTooLarge},
+		{"请求体超长", strings.Repeat("1", 2048), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		w := serveRequest(s, http.MethodPost, "/execjs?group=g", tt.code, "Content-Type", "text/javascript")
+		if w.Code != tt.want {
+			t.Errorf("%s：期望%d，得到%d %s", tt.name, tt.want, w.Code, w.Body)
+		}
+	}
+	// 代码为空时和表单一样提示
+	if w := serveRequest(s, http.MethodPost, "/execjs?group=g", "", "Content-Type", "text/plain"); w.Code != http.StatusBadRequest {
+		t.Errorf("空代码：期望400，得到%d", w.Code)
+	}
+}
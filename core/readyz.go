@@ -0,0 +1,167 @@
+package core
+
+import (
+	"JsRpc/config"
+	"JsRpc/utils"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultProbeTimeout  = 3 // 秒
+	defaultProbeInterval = 5 // 秒
+	maxProbeEntries      = 1000
+	probeCode            = "1+1"
+	probeExpect          = "2"
+)
+
+// ProbeResult 一次/readyz探测的结果，Cached表示在ProbeInterval内返回的是上次的结果
+type ProbeResult struct {
+	Group     string    `json:"group"`
+	ClientId  string    `json:"clientId,omitempty"`
+	Ok        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	ElapsedMs int64     `json:"elapsedMs"`
+	Time      time.Time `json:"time"`
+	Cached    bool      `json:"cached"`
+}
+
+// probeStore 每个group最近一次探测的结果，同一个group同时只有一个探测在执行
+type probeStore struct {
+	mu      sync.Mutex
+	entries map[string]*probeEntry
+}
+
+type probeEntry struct {
+	mu   sync.Mutex
+	last ProbeResult
+}
+
+func (p *probeStore) entry(key string, interval time.Duration) *probeEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries == nil {
+		p.entries = make(map[string]*probeEntry)
+	}
+	if e, ok := p.entries[key]; ok {
+		return e
+	}
+	if len(p.entries) >= maxProbeEntries { // 删除已经过了间隔的结果，避免随意的group名占满内存
+		for k, e := range p.entries {
+			if e.mu.TryLock() {
+				if time.Since(e.last.Time) >= interval {
+					delete(p.entries, k)
+				}
+				e.mu.Unlock()
+			}
+		}
+	}
+	e := &probeEntry{}
+	p.entries[key] = e
+	return e
+}
+
+func (s *Server) probeTimeout() time.Duration {
+	seconds := defaultProbeTimeout
+	if s.conf.Readyz.ProbeTimeout > 0 {
+		seconds = s.conf.Readyz.ProbeTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (s *Server) probeInterval() time.Duration {
+	seconds := defaultProbeInterval
+	if s.conf.Readyz.ProbeInterval > 0 {
+		seconds = s.conf.Readyz.ProbeInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Probe 让group里的一个客户端执行1+1，拿到正确结果才算就绪
+// 同一个group在ProbeInterval内只会真正探测一次，其余的返回上次的结果
+func (s *Server) Probe(ns string, group string) ProbeResult {
+	client := s.getRandomClient(ns, group, "")
+	if client == nil {
+		return ProbeResult{Group: group, Error: ErrNoClient.Error(), Time: time.Now()}
+	}
+	interval := s.probeInterval()
+	e := s.probes.entry(scopedGroup(ns, group), interval)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.last.Time.IsZero() && time.Since(e.last.Time) < interval {
+		res := e.last
+		res.Cached = true
+		return res
+	}
+	e.last = s.runProbe(client)
+	e.last.Group = group
+	return e.last
+}
+
+// runProbe 不经过hook、不记录历史，也不算在客户端的requests里，单独统计在probes
+func (s *Server) runProbe(client *Clients) ProbeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), s.probeTimeout())
+	defer cancel()
+	res := ProbeResult{ClientId: client.clientId, Time: time.Now()}
+	client.probes.Add(1)
+	data, err := client.roundTrip(ctx, Message{Action: "_execjs", Param: utils.NewJsCode(probeCode).String()})
+	if res, ok := utils.ParseJsResult(data); ok && err == nil {
+		if data = res.Text(); !res.Ok {
+			err = errors.New(res.Error)
+		}
+	}
+	res.ElapsedMs = time.Since(res.Time).Milliseconds()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout):
+		res.Error = ErrTimeout.Error()
+	case err != nil:
+		res.Error = err.Error()
+	case data != probeExpect:
+		res.Error = "unexpected result: " + utils.Preview(data, defaultPreviewLength)
+	default:
+		res.Ok = true
+	}
+	if !res.Ok {
+		client.probeFailures.Add(1)
+	}
+	return res
+}
+
+// readyz 不带参数时只表示服务在运行；带group时检查有没有健康的客户端，probe=true时真正执行一次探测
+func (s *Server) readyz(c *gin.Context) {
+	group := c.Query("group")
+	probe := c.Query("probe") == "true"
+	if group == "" {
+		if probe {
+			GinJsonMsg(c, http.StatusBadRequest, "probe需要group参数")
+			return
+		}
+		c.String(http.StatusOK, "ok")
+		return
+	}
+	if err := config.CheckGroupPattern(group); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !probe {
+		for _, client := range s.groupClients(namespace(c), group, "") {
+			if client.healthy() {
+				c.String(http.StatusOK, "ok")
+				return
+			}
+		}
+		GinJsonMsg(c, http.StatusServiceUnavailable, "没有健康的客户端")
+		return
+	}
+	res := s.Probe(namespace(c), group)
+	status := http.StatusOK
+	if !res.Ok {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{"status": status, "data": res})
+}
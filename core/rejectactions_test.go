@@ -0,0 +1,108 @@
+package core
+
+import (
+	"JsRpc/config"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// registeredFake 上报了方法列表的客户端，每个方法返回自己的clientId
+func registeredFake(t *testing.T, s *Server, group string, clientId string, actions ...string) {
+	t.Helper()
+	handlers := map[string]func(string) string{actionListActions: actionList(actions...)}
+	for _, action := range actions {
+		handlers[action] = func(string) string { return clientId }
+	}
+	startFake(t, s, wsPeer{group: group, clientId: clientId}, handlers)
+}
+
+func rejectServer(t *testing.T) *Server {
+	return newTestServer(t, func(conf *config.ConfStruct) {
+		conf.Routing.RejectUnregisteredActions = []string{"g", "w*"}
+	})
+}
+
+func TestRejectUnregisteredActions(t *testing.T) {
+	s := rejectServer(t)
+	registeredFake(t, s, "g", "c1", "a")
+	registeredFake(t, s, "g", "c2", "b")
+
+	// 先选注册了这个action的客户端
+	for i := 0; i < 10; i++ {
+		for action, want := range map[string]string{"a": "c1", "b": "c2"} {
+			if body := decodeBody(t, serveRequest(s, http.MethodGet, "/go?group=g&action="+action, "")); body["data"] != want {
+				t.Fatalf("%s应该发给%s，得到 %v", action, want, body)
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		clientId string
+		actions  []interface{}
+	}{
+		{"都没有注册", "/go?group=g&action=z", "", []interface{}{"a", "b"}},
+		{"指定的客户端没有注册", "/go?group=g&clientId=c1&action=b", "c1", []interface{}{"a"}},
+		{"通配符group", "/go?group=w*&action=z", "", nil},
+	}
+	registeredFake(t, s, "w1", "c1", "x")
+	tests[2].actions = []interface{}{"x"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			w := serveRequest(s, http.MethodGet, tt.target, "")
+			body := decodeBody(t, w)
+			if w.Code != http.StatusNotFound || body["reason"] != NoClientAction || body["action"] == nil {
+				t.Fatalf("期望404 %s，得到%d %v", NoClientAction, w.Code, body)
+			}
+			actions, _ := body["actions"].([]interface{})
+			if len(actions) != len(tt.actions) {
+				t.Fatalf("actions = %v，期望 %v", actions, tt.actions)
+			}
+			for i := range actions {
+				if actions[i] != tt.actions[i] {
+					t.Fatalf("actions = %v，期望 %v", actions, tt.actions)
+				}
+			}
+			if clientId, _ := body["clientId"].(string); clientId != tt.clientId {
+				t.Fatalf("clientId = %q，期望%q", clientId, tt.clientId)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("应该马上拒绝，用了%v", elapsed)
+			}
+		})
+	}
+}
+
+// 有没上报方法列表的旧版客户端时不能拒绝，发给它试试
+func TestRejectUnregisteredWithLegacyClient(t *testing.T) {
+	s := rejectServer(t)
+	registeredFake(t, s, "g", "c1", "a")
+	startFake(t, s, wsPeer{group: "g", clientId: "legacy"}, map[string]func(string) string{
+		"z": func(string) string { return "legacy" },
+	})
+	if fc := s.Client("g", "legacy"); len(fc.actionList()) != 0 {
+		t.Fatalf("旧版客户端不应该有方法列表：%v", fc.actionList())
+	}
+	for i := 0; i < 5; i++ {
+		if body := decodeBody(t, serveRequest(s, http.MethodGet, "/go?group=g&action=z", "")); body["data"] != "legacy" {
+			t.Fatalf("应该发给没有方法列表的客户端，得到 %v", body)
+		}
+	}
+	// 已注册的action仍然优先发给注册了它的客户端
+	if body := decodeBody(t, serveRequest(s, http.MethodGet, "/go?group=g&clientId=c1&action=a", "")); body["data"] != "c1" {
+		t.Fatalf("/go = %v", body)
+	}
+}
+
+// 没有配置RejectUnregisteredActions的group不会返回action_not_registered
+func TestRejectUnregisteredNotConfigured(t *testing.T) {
+	s := rejectServer(t)
+	registeredFake(t, s, "other", "c1", "a")
+	w := serveRequest(s, http.MethodGet, "/go?group=other&clientId=c1&action=z", "")
+	if body := decodeBody(t, w); body["reason"] == NoClientAction {
+		t.Fatalf("没有配置的group不应该拒绝：%v", body)
+	}
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// envListenFds 平滑重启时告诉新进程继承了哪些监听，值是逗号分隔的地址，第i个地址对应fd 3+i
+	envListenFds = "JSRPC_LISTEN_FDS"
+	// envReadyFd 新进程开始监听后往这个fd写readyMessage并关闭，旧进程收到后才开始排空
+	envReadyFd   = "JSRPC_READY_FD"
+	readyMessage = "ready"
+	// actionReconnect 旧进程退出前让客户端马上重连，重连会连到新进程
+	actionReconnect = "_reconnect"
+
+	defaultDrainTimeout    = 30
+	defaultReconnectSpread = 5
+	childReadyTimeout      = 30 * time.Second
+)
+
+// 监听的类型，/version里分类展示
+const (
+	listenHttp  = "http"
+	listenHttps = "https"
+	listenGrpc  = "grpc"
+)
+
+// listenerEntry 当前进程的监听，重启时把fd交给新进程
+type listenerEntry struct {
+	kind string
+	addr string
+	ln   net.Listener
+}
+
+// inheritedFile 从旧进程继承的一个监听，取出后file为nil
+type inheritedFile struct {
+	addr string
+	file *os.File
+}
+
+var inherited struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files []inheritedFile // 按旧进程listeners的顺序，同一个地址可能有多个
+}
+
+func loadInherited() {
+	inherited.once.Do(func() {
+		value := os.Getenv(envListenFds)
+		if value == "" {
+			return
+		}
+		_ = os.Unsetenv(envListenFds) // 不再传给之后启动的进程
+		for i, a := range strings.Split(value, ",") {
+			inherited.files = append(inherited.files, inheritedFile{addr: a, file: os.NewFile(uintptr(3+i), "listener:"+a)})
+		}
+	})
+}
+
+// inheritedListener 取出从旧进程继承的addr的监听，没有时返回nil。
+// index是这个监听在listeners里的序号，配置没变时和旧进程的序号一一对应，重复的地址也不会拿错；
+// 对应的序号不是这个地址时(配置改了)用第一个还没取出的同地址的监听
+func inheritedListener(index int, addr string) (net.Listener, error) {
+	loadInherited()
+	inherited.mu.Lock()
+	var f *os.File
+	if index < len(inherited.files) && inherited.files[index].addr == addr {
+		f, inherited.files[index].file = inherited.files[index].file, nil
+	}
+	for i := 0; f == nil && i < len(inherited.files); i++ {
+		if inherited.files[i].addr == addr {
+			f, inherited.files[i].file = inherited.files[i].file, nil
+		}
+	}
+	inherited.mu.Unlock()
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close() // FileListener复制了fd
+	log.Info("继承旧进程的监听：", addr)
+	return net.FileListener(f)
+}
+
+// closeInherited 新配置里不再使用的继承监听直接关闭
+func closeInherited() {
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	for i, inh := range inherited.files {
+		if inh.file != nil {
+			log.Warn("新配置没有使用继承的监听，关闭：", inh.addr)
+			_ = inh.file.Close()
+			inherited.files[i].file = nil
+		}
+	}
+}
+
+// notifyReady 作为平滑重启的新进程启动成功时通知旧进程
+func notifyReady() {
+	value := os.Getenv(envReadyFd)
+	if value == "" {
+		return
+	}
+	_ = os.Unsetenv(envReadyFd)
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	_, _ = f.WriteString(readyMessage)
+	_ = f.Close()
+}
+
+// listen 优先使用重启时从旧进程继承的监听，没有时新建
+func (s *Server) listen(kind, addr string) (net.Listener, error) {
+	s.mu.Lock()
+	index := len(s.listeners)
+	s.mu.Unlock()
+	ln, err := inheritedListener(index, addr)
+	if ln == nil && err == nil {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.listeners = append(s.listeners, listenerEntry{kind: kind, addr: addr, ln: ln})
+	s.mu.Unlock()
+	return ln, nil
+}
+
+// ListenAddrs 按类型列出实际监听的地址，配置的端口为0时是系统分配的端口
+func (s *Server) ListenAddrs() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make(map[string][]string)
+	for _, e := range s.listeners {
+		addrs[e.kind] = append(addrs[e.kind], e.ln.Addr().String())
+	}
+	return addrs
+}
+
+func (s *Server) restartTimeouts() (drain, spread time.Duration) {
+	drain, spread = defaultDrainTimeout*time.Second, defaultReconnectSpread*time.Second
+	if s.conf.Restart.DrainTimeout > 0 {
+		drain = time.Duration(s.conf.Restart.DrainTimeout) * time.Second
+	}
+	if s.conf.Restart.ReconnectSpread > 0 {
+		spread = time.Duration(s.conf.Restart.ReconnectSpread) * time.Second
+	}
+	return
+}
+
+// Drain 新进程接管监听后由旧进程调用：停止接收请求，在DrainTimeout内等正在执行的请求完成，
+// 再在ReconnectSpread内分批通知ws客户端重连，最后关闭服务
+func (s *Server) Drain(ctx context.Context) error {
+	drain, spread := s.restartTimeouts()
+	s.stopOnce.Do(func() { close(s.stop) }) // 定时任务不再发起新请求，SSE连接结束
+	drainCtx, cancel := context.WithTimeout(ctx, drain)
+	if err := s.stopListening(drainCtx); err != nil {
+		log.Warn("等待http请求完成超时：", err)
+	}
+	s.waitQueries(drainCtx)
+	cancel()
+	if n := s.notifyReconnect(ctx, spread); n > 0 {
+		log.Info("已通知", n, "个客户端重连到新进程")
+		select { // 让最后通知的客户端有时间收到消息
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	return s.Shutdown(ctx)
+}
+
+// waitQueries 等待还在执行的请求完成，包括gRPC和定时任务发起的
+func (s *Server) waitQueries(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for s.queries.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Warn("等待请求完成超时，还有", s.queries.Load(), "个请求没有完成")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// notifyReconnect 给ws客户端发_reconnect，平均分布在spread内，避免新进程同时收到所有连接
+func (s *Server) notifyReconnect(ctx context.Context, spread time.Duration) int {
+	var clients []*Clients
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		if client, ok := value.(*Clients); ok && client.conn() != nil {
+			clients = append(clients, client)
+		}
+		return true
+	})
+	if len(clients) == 0 {
+		return 0
+	}
+	step := spread / time.Duration(len(clients))
+	sent := 0
+	for i, client := range clients {
+		if i > 0 && step > 0 {
+			select {
+			case <-ctx.Done():
+				return sent
+			case <-time.After(step):
+			}
+		}
+		if err := client.sendControl(actionReconnect, ""); err != nil {
+			log.Error(client.clientGroup+"->"+client.clientId, " 发送重连通知失败:", err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
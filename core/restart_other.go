@@ -0,0 +1,8 @@
+//go:build !unix
+
+package core
+
+// WatchRestart 平滑重启依赖SIGUSR2和fd继承，只支持unix系统，其它系统返回的chan不会关闭
+func (s *Server) WatchRestart() <-chan struct{} {
+	return nil
+}
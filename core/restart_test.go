@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"JsRpc/config"
+)
+
+// waitReconnect 等客户端收到_reconnect，返回收到的时间
+func (fc *fakeClient) waitReconnect(t *testing.T) time.Time {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case name := <-fc.controls:
+			if name == actionReconnect {
+				return time.Now()
+			}
+		case <-deadline:
+			t.Error("客户端没有收到", actionReconnect)
+			return time.Now()
+		}
+	}
+}
+
+// 排空时先等正在执行的请求完成，再在ReconnectSpread内分批通知客户端重连
+func TestDrainWaitsForQueriesThenNotifies(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.Restart = config.RestartConfig{DrainTimeout: 5, ReconnectSpread: 1}
+	})
+	slow := startFake(t, s, wsPeer{group: "g", clientId: "c1"}, map[string]func(string) string{
+		"slow": func(string) string {
+			time.Sleep(300 * time.Millisecond)
+			return "ok"
+		},
+	})
+	other := startFake(t, s, wsPeer{group: "g", clientId: "c2"}, nil)
+
+	type result struct {
+		res string
+		err error
+		at  time.Time
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := s.Call(testContext(t), "g", "c1", "slow", "")
+		done <- result{res, err, time.Now()}
+	}()
+	waitFor(t, "请求开始执行", func() bool { return s.queries.Load() == 1 })
+
+	drained := make(chan error, 1)
+	go func() { drained <- s.Drain(testContext(t)) }()
+	// 分别等两个客户端，记录各自收到通知的时间
+	times := make(chan time.Time, 2)
+	for _, fc := range []*fakeClient{slow, other} {
+		go func(fc *fakeClient) { times <- fc.waitReconnect(t) }(fc)
+	}
+	first, second := <-times, <-times
+	r := <-done
+	if r.err != nil || r.res != "ok" {
+		t.Fatalf("排空期间的请求应该正常完成，得到 %q, %v", r.res, r.err)
+	}
+	if first.Before(r.at) {
+		t.Fatal("请求完成前就通知了客户端重连")
+	}
+	// 1秒内通知2个客户端，间隔500ms
+	if gap := second.Sub(first); gap < 400*time.Millisecond {
+		t.Fatalf("重连通知没有分批，间隔%v", gap)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "排空后断开所有客户端", func() bool { return slow.ws.isClosed() && other.ws.isClosed() })
+}
+
+// 请求在DrainTimeout内没有完成时不再等待，照常通知重连
+func TestDrainTimeoutStopsWaiting(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.Restart = config.RestartConfig{DrainTimeout: 1, ReconnectSpread: 1}
+	})
+	hangingClient(t, s, "g")
+	// 卡住的客户端不再读消息，用另一个客户端观察重连通知
+	fc := startFake(t, s, wsPeer{group: "other", clientId: "c"}, nil)
+	go func() { _, _ = s.Call(context.Background(), "g", "c", "hang", "") }()
+	waitFor(t, "请求开始执行", func() bool { return s.queries.Load() == 1 })
+
+	start := time.Now()
+	go func() { _ = s.Drain(testContext(t)) }()
+	at := fc.waitReconnect(t)
+	if waited := at.Sub(start); waited < 900*time.Millisecond || waited > 3*time.Second {
+		t.Fatalf("应该等DrainTimeout后通知重连，实际等了%v", waited)
+	}
+}
+
+// ctx取消后不再通知剩下的客户端
+func TestNotifyReconnectStopsOnCancel(t *testing.T) {
+	s := newTestServer(t, nil)
+	clients := []*fakeClient{
+		startFake(t, s, wsPeer{group: "g", clientId: "c1"}, nil),
+		startFake(t, s, wsPeer{group: "g", clientId: "c2"}, nil),
+		startFake(t, s, wsPeer{group: "g", clientId: "c3"}, nil),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if sent := s.notifyReconnect(ctx, 30*time.Second); sent != 1 {
+		t.Fatalf("间隔10秒时ctx取消前只应该通知1个，通知了%d个", sent)
+	}
+	received := 0
+	for _, fc := range clients {
+		for len(fc.controls) > 0 {
+			if <-fc.controls == actionReconnect {
+				received++
+			}
+		}
+	}
+	if received != 1 {
+		t.Fatalf("%d个客户端收到了重连通知", received)
+	}
+}
+
+// 重启时BasicListen里重复的地址各自拿到旧进程对应的监听，不会少一个
+func TestInheritedListenersByIndex(t *testing.T) {
+	var files []inheritedFile
+	var want []string
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ln.(*net.TCPListener).File()
+		_ = ln.Close() // f复制了fd，监听还在
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, inheritedFile{addr: "127.0.0.1:0", file: f})
+		want = append(want, ln.Addr().String())
+	}
+	loadInherited()
+	inherited.mu.Lock()
+	inherited.files = files
+	inherited.mu.Unlock()
+	t.Cleanup(func() {
+		closeInherited()
+		inherited.mu.Lock()
+		inherited.files = nil
+		inherited.mu.Unlock()
+	})
+
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.BasicListen = config.ListenList{"127.0.0.1:0", "127.0.0.1:0"}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	got := s.ListenAddrs()[listenHttp]
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("应该按顺序继承%v，得到%v", want, got)
+	}
+	for _, addr := range got {
+		if list := getJson(t, "http://"+addr+"/list"); list == nil {
+			t.Fatalf("%s没有提供服务", addr)
+		}
+	}
+}
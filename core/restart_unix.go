@@ -0,0 +1,118 @@
+//go:build unix
+
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchRestart 收到SIGUSR2时启动新进程并把监听交给它，新进程启动成功后旧进程排空退出，完成后返回的chan关闭
+// 新进程启动失败时旧进程继续提供服务
+func (s *Server) WatchRestart() <-chan struct{} {
+	done := make(chan struct{})
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			if err := s.startChild(); err != nil {
+				log.Error("平滑重启失败，继续使用当前进程：", err)
+				continue
+			}
+			signal.Stop(usr2)
+			drain, spread := s.restartTimeouts()
+			ctx, cancel := context.WithTimeout(context.Background(), drain+spread+5*time.Second)
+			if err := s.Drain(ctx); err != nil {
+				log.Error(err)
+			}
+			cancel()
+			log.Info("- EXIT - 已交给新进程，旧进程退出")
+			close(done)
+			return
+		}
+	}()
+	return done
+}
+
+// startChild 用同样的参数启动新进程，监听的fd从3开始依次传过去，最后一个fd是启动成功的通知管道
+func (s *Server) startChild() error {
+	s.mu.Lock()
+	entries := slices.Clone(s.listeners)
+	s.mu.Unlock()
+	if len(entries) == 0 {
+		return errors.New("没有可以交给新进程的监听")
+	}
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	addrs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		fl, ok := e.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return errors.New("监听不支持传给新进程：" + e.addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		addrs = append(addrs, e.addr)
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), envListenFds+"="+strings.Join(addrs, ","),
+		envReadyFd+"="+strconv.Itoa(3+len(addrs)))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	_ = readyW.Close() // 只保留新进程里的写端，新进程退出时读端能收到EOF
+	files = files[:len(files)-1]
+	log.Info("新进程已启动，pid:", cmd.Process.Pid, "，等待它开始监听")
+
+	result := make(chan error, 1)
+	go func() {
+		msg, _ := io.ReadAll(ready)
+		if string(msg) != readyMessage {
+			result <- errors.New("新进程启动失败")
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(childReadyTimeout):
+		err = errors.New("等待新进程启动超时")
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	return cmd.Process.Release()
+}
@@ -1,28 +1,149 @@
 package core
 
 import (
+	"JsRpc/config"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"net/http"
 )
 
-func setJsRpcRouters(router *gin.Engine) {
-	// 核心部分的的路由组
-	router.GET("/", index)
+// jsRpcRoute 可以通过RouterReplace改名或禁用的路由
+type jsRpcRoute struct {
+	path    string
+	methods []string
+	handler gin.HandlerFunc
+}
+
+var (
+	get, post, getPost = []string{http.MethodGet}, []string{http.MethodPost}, []string{http.MethodGet, http.MethodPost}
+	getDelete          = []string{http.MethodGet, http.MethodDelete}
+
+	// 配置了AdminToken时需要校验token的路由
+	adminPaths = map[string]bool{
+		"/navigate":               true,
+		"/inflight":               true,
+		"/inflight/release":       true,
+		"/throttle":               true,
+		"/bans":                   true,
+		"/sessions":               true,
+		"/tags":                   true,
+		"/refreshActions":         true,
+		"/admin/settings":         true,
+		"/admin/broadcastControl": true,
+		"/stats":                  true,
+	}
+	// 浏览器客户端使用的路由，通过token参数确定namespace，不经过NamespaceAuth
+	clientPaths = map[string]bool{
+		"/ws":            true,
+		"/wst":           true,
+		"/jsrpc.js":      true,
+		"/poll/register": true,
+		"/poll/pull":     true,
+		"/poll/push":     true,
+	}
+)
 
-	page := router.Group("/page")
-	{
-		page.GET("/cookie", GetCookie)
-		page.GET("/html", GetHtml)
+func (s *Server) jsRpcRoutes() []jsRpcRoute {
+	return []jsRpcRoute{
+		{"/page/cookie", get, s.GetCookie},
+		{"/page/html", get, s.GetHtml},
+		{"/page/storage", get, s.GetStorage},
+		{"/page/info", get, s.GetPageInfo},
+		{"/go", getPost, s.getResult},
+		{"/ws", get, s.ws},
+		{"/wst", get, s.wsTest},
+		{"/ws/caller", get, s.wsCaller},
+		{"/jsrpc.js", get, s.jsRpcScript},
+		{"/poll/register", post, s.pollRegister},
+		{"/poll/pull", get, s.pollPull},
+		{"/poll/push", post, s.pollPush},
+		{"/execjs", getPost, s.execjs},
+		{"/snippet", getPost, s.snippet},
+		{"/pipeline", post, s.pipeline},
+		{"/navigate", getPost, s.navigate},
+		{"/list", get, s.getList},
+		{"/details", get, s.getClientDetails},
+		{"/inflight", get, s.getInflight},
+		{"/history", get, s.getHistory},
+		{"/refreshActions", getPost, s.refreshActionsApi},
+		{"/throttle", getPost, s.throttle},
+		{"/bans", getDelete, s.getBans},
+		{"/sessions", getDelete, s.getSessions},
+		{"/tags", getPost, s.tagsApi},
+		{"/events", get, s.streamEvents},
+		{"/events/pull", get, s.pullEvents},
+		{"/schedules", get, s.getSchedules},
+		{"/schedules/:name/:op", getPost, s.scheduleOp},
+		{"/pool", get, s.getPool},
+		{"/stats", get, s.getStats},
+		{"/readyz", get, s.readyz},
+		{"/admin/settings", getPost, s.adminSettings},
+		{"/admin/broadcastControl", post, s.broadcastControl},
+		{"/inflight/release", getPost, s.releaseInflight},
 	}
+}
+
+// checkRouterReplace 启动时检查RouterReplace里的原路径，避免写错了没有生效
+func (s *Server) checkRouterReplace(replace map[string]string) error {
+	for origin := range replace {
+		found := false
+		for _, r := range s.jsRpcRoutes() {
+			if r.path == origin {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("RouterReplace中的路由 %q 不存在", origin)
+		}
+	}
+	return nil
+}
 
-	rpc := router.Group("/")
-	{
-		rpc.GET("go", getResult)
-		rpc.POST("go", getResult)
-		rpc.GET("ws", ws)
-		rpc.GET("wst", wsTest)
-		rpc.GET("execjs", execjs)
-		rpc.POST("execjs", execjs)
-		rpc.GET("list", getList)
+// setJsRpcRouters 注册接口，categories不为空时只注册这些类别的路由(ListenRoutes)，没有注册的访问时404
+func (s *Server) setJsRpcRouters(router *gin.Engine, categories ...string) {
+	filter := routeFilter(categories)
+	// 核心部分的的路由
+	router.GET("/healthz", healthz)
+	if filter.allow(config.RouteDashboard) {
+		router.GET("/", index)
+		router.GET("/version", s.getVersion)
+		router.GET(apiV2Prefix+"/version", ApiV2(), s.getVersion)
+	}
+	if filter.allow(config.RouteAdmin) {
+		s.setDebugRouters(router)
 	}
 
+	for _, r := range s.jsRpcRoutes() {
+		path := r.path
+		if newPath, ok := s.conf.RouterReplace[r.path]; ok {
+			path = newPath
+		}
+		if r.path == "/execjs" && s.conf.Security.DisableExecjs {
+			path = ""
+		}
+		if r.path == "/wst" && s.conf.Websocket.DisableTest {
+			path = ""
+		}
+		s.activeRoutes[r.path] = path
+		if path == "" || !filter.allow(routeCategory(r.path)) { // 禁用的路由不注册，访问时直接404
+			continue
+		}
+		handlers := []gin.HandlerFunc{r.handler}
+		if adminPaths[r.path] {
+			handlers = append([]gin.HandlerFunc{s.AdminAuth()}, handlers...)
+		}
+		if !clientPaths[r.path] {
+			handlers = append([]gin.HandlerFunc{s.NamespaceAuth()}, handlers...)
+		}
+		if jsonpPaths[r.path] {
+			handlers = append([]gin.HandlerFunc{s.Jsonp()}, handlers...)
+		}
+		for _, method := range r.methods {
+			router.Handle(method, path, handlers...)
+			if v2Paths[r.path] {
+				router.Handle(method, apiV2Prefix+path, append([]gin.HandlerFunc{ApiV2()}, handlers...)...)
+			}
+		}
+	}
 }
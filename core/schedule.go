@@ -0,0 +1,209 @@
+package core
+
+import (
+	"JsRpc/config"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// EventScheduleFailed 定时任务执行失败，通过/events推送
+const EventScheduleFailed = "schedule_failed"
+
+// ScheduleStatus 定时任务的最近一次执行结果
+type ScheduleStatus struct {
+	Name     string    `json:"name"`
+	Group    string    `json:"group"`
+	Action   string    `json:"action"`
+	Interval int       `json:"interval"`
+	Paused   bool      `json:"paused"`
+	Runs     int64     `json:"runs"`
+	LastRun  time.Time `json:"lastRun,omitempty"`
+	ClientId string    `json:"clientId,omitempty"` // 最近一次执行的客户端
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"failures"` // 连续失败次数
+}
+
+// schedule 一个定时任务，trigger用来立即执行
+type schedule struct {
+	conf    config.ScheduleConfig
+	trigger chan struct{}
+
+	mu     sync.Mutex
+	status ScheduleStatus
+}
+
+func checkSchedules(list []config.ScheduleConfig, disableExecjs bool) error {
+	names := make(map[string]bool)
+	for _, sc := range list {
+		switch {
+		case sc.Name == "":
+			return fmt.Errorf("定时任务需要Name")
+		case names[sc.Name]:
+			return fmt.Errorf("定时任务 %s 重复", sc.Name)
+		case sc.Group == "":
+			return fmt.Errorf("定时任务 %s 需要Group", sc.Name)
+		case sc.Action == "" && sc.Code == "":
+			return fmt.Errorf("定时任务 %s 需要Action或Code", sc.Name)
+		case sc.Code != "" && disableExecjs:
+			return fmt.Errorf("定时任务 %s 使用了Code，但是已经禁用了execjs", sc.Name)
+		case sc.Interval <= 0:
+			return fmt.Errorf("定时任务 %s 的Interval需要大于0", sc.Name)
+		}
+		names[sc.Name] = true
+	}
+	return nil
+}
+
+func newSchedule(sc config.ScheduleConfig) *schedule {
+	action := sc.Action
+	if sc.Code != "" {
+		action = "_execjs"
+	}
+	return &schedule{
+		conf:    sc,
+		trigger: make(chan struct{}, 1),
+		status:  ScheduleStatus{Name: sc.Name, Group: sc.Group, Action: action, Interval: sc.Interval},
+	}
+}
+
+// startSchedules 每个定时任务一个goroutine，Shutdown时退出
+func (s *Server) startSchedules() {
+	for _, sch := range s.schedules {
+		go s.runSchedule(sch)
+	}
+}
+
+func (s *Server) runSchedule(sch *schedule) {
+	ticker := time.NewTicker(time.Duration(sch.conf.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			sch.mu.Lock()
+			paused := sch.status.Paused
+			sch.mu.Unlock()
+			if paused {
+				continue
+			}
+		case <-sch.trigger:
+		}
+		s.execSchedule(sch)
+	}
+}
+
+// execSchedule 和普通请求一样选择客户端并经过hook、历史记录
+func (s *Server) execSchedule(sch *schedule) {
+	sc := sch.conf
+	msg := Message{Action: sc.Action, Param: sc.Param}
+	if sc.Code != "" {
+		msg = Message{Action: "_execjs", Param: sc.Code}
+	}
+	clientId, res, err := s.callSchedule(sc, msg)
+
+	sch.mu.Lock()
+	st := &sch.status
+	st.Runs++
+	st.LastRun = time.Now()
+	st.ClientId = clientId
+	if err != nil {
+		st.Error = err.Error()
+		st.Failures++
+	} else {
+		st.Result, st.Error, st.Failures = res, "", 0
+	}
+	status := *st
+	sch.mu.Unlock()
+	if err != nil {
+		log.Warning("定时任务 ", sc.Name, " 执行失败(连续", status.Failures, "次): ", err)
+		s.broadcastEvent(ClientEvent{Type: EventScheduleFailed, Namespace: sc.Namespace, Group: sc.Group, Time: status.LastRun, Schedule: &status})
+	}
+}
+
+func (s *Server) callSchedule(sc config.ScheduleConfig, msg Message) (clientId string, res string, err error) {
+	if msg.Param, err = s.expandTemplate(msg.Param); err != nil {
+		return "", "", err
+	}
+	client, err := s.getActionClient(sc.Namespace, sc.Group, sc.ClientId, msg.Action)
+	if err != nil {
+		return "", "", err
+	}
+	timeout := time.Duration(sc.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Duration(s.Settings().DefaultTimeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(withCallerIp(context.Background(), "schedule:"+sc.Name), timeout)
+	defer cancel()
+	res, err = s.runQuery(ctx, client, msg)
+	return client.clientId, res, err
+}
+
+func (sch *schedule) snapshot() ScheduleStatus {
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	return sch.status
+}
+
+// Schedules 返回所有定时任务的状态，按名字排序
+func (s *Server) Schedules() []ScheduleStatus {
+	list := make([]ScheduleStatus, 0, len(s.schedules))
+	for _, sch := range s.schedules {
+		list = append(list, sch.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *Server) getSchedules(c *gin.Context) {
+	list := make([]ScheduleStatus, 0)
+	for _, st := range s.Schedules() {
+		if visible(c, s.schedules[st.Name].conf.Namespace) {
+			list = append(list, st)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": list})
+}
+
+// scheduleOp /schedules/:name/result 查看结果，pause、resume、trigger需要AdminToken
+func (s *Server) scheduleOp(c *gin.Context) {
+	sch, ok := s.schedules[c.Param("name")]
+	if !ok || !visible(c, sch.conf.Namespace) {
+		GinJsonMsg(c, http.StatusNotFound, "没有这个定时任务")
+		return
+	}
+	op := c.Param("op")
+	if op != "result" {
+		if c.Request.Method != http.MethodPost {
+			GinJsonMsg(c, http.StatusMethodNotAllowed, op+"需要使用post")
+			return
+		}
+		if s.AdminAuth()(c); c.IsAborted() || !requireAdminGroup(c, sch.conf.Group) {
+			return
+		}
+	}
+	switch op {
+	case "result":
+	case "pause", "resume":
+		sch.mu.Lock()
+		sch.status.Paused = op == "pause"
+		sch.mu.Unlock()
+	case "trigger":
+		select {
+		case sch.trigger <- struct{}{}:
+		default: // 已经有一次在等待执行
+		}
+	default:
+		GinJsonMsg(c, http.StatusNotFound, "不支持的操作："+op)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": sch.snapshot()})
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"context"
+)
+
+type scriptErrorKey struct{}
+
+// scriptErrorSlot 接收客户端返回的异常，请求结束后由调用方读取
+type scriptErrorSlot struct {
+	err *protocol.JsError
+}
+
+// withScriptError /execjs需要区分页面抛出的异常和正常的字符串结果，其它接口仍然只拿到异常的文字
+func withScriptError(ctx context.Context) (context.Context, *scriptErrorSlot) {
+	slot := &scriptErrorSlot{}
+	return context.WithValue(ctx, scriptErrorKey{}, slot), slot
+}
+
+func reportScriptError(ctx context.Context, jsErr *protocol.JsError) {
+	if slot, ok := ctx.Value(scriptErrorKey{}).(*scriptErrorSlot); ok {
+		slot.err = jsErr
+	}
+}
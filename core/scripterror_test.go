@@ -0,0 +1,62 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// throwingClient action是throw或者代码里有throw时像页面一样返回异常
+func throwingClient(t *testing.T, s *Server) {
+	t.Helper()
+	startReplier(t, s, wsPeer{group: "g", clientId: "c"}, func(req protocol.Envelope) protocol.Envelope {
+		if req.Action != "throw" && !strings.Contains(req.Param, "throw") {
+			return handlerReplies(nil)(req)
+		}
+		res := protocol.Response(req.Id, req.Action, "ReferenceError: foo is not defined")
+		res.Error = &protocol.JsError{Name: "ReferenceError", Message: "foo is not defined", Stack: "at <anonymous>:1:1"}
+		return res
+	})
+}
+
+func TestExecjsReportsScriptError(t *testing.T) {
+	s := newTestServer(t, nil)
+	throwingClient(t, s)
+
+	body := decodeBody(t, serveRequest(s, http.MethodGet, "/execjs?group=g&code="+url.QueryEscape("throw foo"), ""))
+	jsErr, _ := body["error"].(map[string]interface{})
+	if body["code"] != float64(500) || jsErr["name"] != "ReferenceError" || jsErr["message"] != "foo is not defined" ||
+		jsErr["stack"] != "at <anonymous>:1:1" || body["data"] != nil {
+		t.Fatalf("页面抛出异常时应该返回error，得到 %v", body)
+	}
+
+	// legacy=true时和以前一样把异常的文字当作结果
+	body = decodeBody(t, serveRequest(s, http.MethodGet, "/execjs?legacy=true&group=g&code="+url.QueryEscape("throw foo"), ""))
+	if body["status"] != "200" || body["data"] != "ReferenceError: foo is not defined" || body["error"] != nil {
+		t.Fatalf("legacy = %v", body)
+	}
+}
+
+// 正常的字符串结果不受影响，即使内容看起来像异常
+func TestExecjsPlainResult(t *testing.T) {
+	s := newTestServer(t, nil)
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"_execjs": func(string) string { return "ReferenceError: 只是一个字符串" },
+	})
+	body := decodeBody(t, serveRequest(s, http.MethodGet, "/execjs?group=g&code=1", ""))
+	if body["status"] != "200" || body["data"] != "ReferenceError: 只是一个字符串" || body["error"] != nil {
+		t.Fatalf("普通结果 = %v", body)
+	}
+}
+
+// 其它接口仍然只拿到异常的文字
+func TestGoKeepsScriptErrorText(t *testing.T) {
+	s := newTestServer(t, nil)
+	throwingClient(t, s)
+	body := decodeBody(t, serveRequest(s, http.MethodGet, "/go?group=g&action=throw", ""))
+	if body["data"] != "ReferenceError: foo is not defined" || body["error"] != nil {
+		t.Fatalf("/go = %v", body)
+	}
+}
@@ -0,0 +1,452 @@
+package core
+
+import (
+	"JsRpc/config"
+	"JsRpc/utils"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoClient 没有找到对应的group或clientId
+var ErrNoClient = errors.New("client not found")
+
+// Server 一个完整的JsRpc服务，可以嵌入到其它go程序里使用，同一进程里的多个Server互不影响
+type Server struct {
+	conf         config.ConfStruct
+	upGrader     websocket.Upgrader
+	hlSyncMap    sync.Map          // clientKey(namespace, group, clientId) : *Clients
+	activeRoutes map[string]string // 原路径 -> 当前路径，空字符串表示已禁用
+	router       *gin.Engine
+	events       eventBus
+
+	mu          sync.Mutex
+	httpServers []*http.Server
+	grpcServer  *grpc.Server
+	hooks       []Hook
+	history     *historyRing // 为nil表示关闭了请求记录
+	historyDB   *historyDB   // 启用sqlite时不为nil
+	pool        *workerPool  // 配置了WorkerPool.Size时不为nil
+	bans        *banList
+	pageEvents  pageEvents
+	schedules   map[string]*schedule
+	stop        chan struct{} // 关闭后后台任务退出
+	stopOnce    sync.Once
+	sessions    sessionStore
+	probes      probeStore
+	namespaces  namespaceIndex
+	listVersion atomic.Int64 // /list和/details的版本号
+	listEpoch   string       // 启动时间，和版本号一起组成ETag
+	started     time.Time
+	queries     atomic.Int64 // 正在执行的请求数，/stats里展示
+	settings    atomic.Pointer[RuntimeSettings]
+	listeners   []listenerEntry   // 平滑重启时交给新进程
+	fallbacks   map[string]string // 改用了备用地址的BasicListen地址 -> 备用地址
+	emptyQueue  emptyQueue
+	traffic     sync.Map // scopedGroup(namespace, group) : *trafficCounter
+	callers     callerStats
+}
+
+// NewServer 按配置创建服务，路由配置有误时返回错误
+func NewServer(conf config.ConfStruct) (*Server, error) {
+	if conf.DefaultTimeOut <= 0 {
+		return nil, errors.New("DefaultTimeOut需要大于0，为0时所有请求都会立即超时")
+	}
+	if conf.CloseWebLog {
+		// 将默认的日志输出器设置为空
+		gin.DefaultWriter = utils.LogWriter{}
+	} else if conf.Log.File != "" { // gin的日志和logrus写到同一个文件
+		gin.DefaultWriter = log.StandardLogger().Out
+	}
+	if conf.Log.File != "" {
+		gin.DefaultErrorWriter = log.StandardLogger().Out
+	}
+	gin.SetMode(getGinMode(conf.Mode))
+	if conf.PanicWebhook != "" {
+		SetPanicReporter(WebhookPanicReporter(conf.PanicWebhook))
+	}
+	s := &Server{
+		conf:         conf,
+		upGrader:     newUpgrader(conf.Websocket),
+		activeRoutes: make(map[string]string),
+		fallbacks:    make(map[string]string),
+		started:      time.Now(),
+	}
+	s.listEpoch = strconv.FormatInt(s.started.UnixNano(), 36)
+	s.initSettings()
+	if err := s.checkRouterReplace(conf.RouterReplace); err != nil {
+		return nil, err
+	}
+	for _, name := range conf.Hooks {
+		switch name {
+		case "logging":
+			s.AddHook(LoggingHook{PreviewLength: s.previewLength()})
+		default:
+			return nil, fmt.Errorf("不支持的hook：%s", name)
+		}
+	}
+	if err := s.initBans(); err != nil {
+		return nil, err
+	}
+	if err := s.initNamespaces(); err != nil {
+		return nil, err
+	}
+	if err := s.checkGroupAdminTokens(); err != nil {
+		return nil, err
+	}
+	if err := checkSchedules(conf.Schedules, conf.Security.DisableExecjs); err != nil {
+		return nil, err
+	}
+	s.schedules = make(map[string]*schedule, len(conf.Schedules))
+	s.stop = make(chan struct{})
+	for _, sc := range conf.Schedules {
+		s.schedules[sc.Name] = newSchedule(sc)
+	}
+	switch conf.Sessions.OnLost {
+	case "", SessionOnLostRepin, SessionOnLostError:
+	default:
+		return nil, fmt.Errorf("Sessions.OnLost不支持：%s", conf.Sessions.OnLost)
+	}
+	if conf.WorkerPool.Size > 0 {
+		s.pool = newWorkerPool(conf.WorkerPool.Size, conf.WorkerPool.QueueSize)
+	}
+	router, err := s.setupRouters()
+	if err != nil {
+		return nil, err
+	}
+	s.setJsRpcRouters(router)
+	s.router = router
+	if !conf.History.Disable {
+		s.history = newHistoryRing(historySize(conf.History.Size, defaultHistorySize))
+		if conf.History.Sqlite.IsEnable {
+			db, err := openHistoryDB(conf.History.Sqlite)
+			if err != nil {
+				return nil, errors.New("打开请求记录数据库失败：" + err.Error())
+			}
+			s.historyDB = db
+		}
+	}
+	return s, nil
+}
+
+// Handler 返回注册了全部接口的gin路由，可以挂到自己的http服务上
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Call 调用客户端的action并等待返回，clientId为空时从group里随机选一个
+func (s *Server) Call(ctx context.Context, group, clientId, action, param string) (string, error) {
+	client, err := s.getActionClient("", group, clientId, action)
+	if err != nil {
+		return "", err
+	}
+	return s.runQuery(ctx, client, Message{Action: action, Param: param})
+}
+
+// Client 返回指定的客户端，可以直接调用Query，没有时返回nil
+func (s *Server) Client(group, clientId string) *Clients {
+	value, ok := s.hlSyncMap.Load(clientKey("", group, clientId))
+	if !ok {
+		return nil
+	}
+	client, _ := value.(*Clients)
+	return client
+}
+
+// Clients 返回group下的客户端信息，group为空时返回全部
+func (s *Server) Clients(group string) []ClientDetail {
+	details := make([]ClientDetail, 0)
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		client, ok := value.(*Clients)
+		if ok && (group == "" || client.clientGroup == group) {
+			details = append(details, client.detail())
+		}
+		return true
+	})
+	return details
+}
+
+// Shutdown 停止监听并断开所有客户端
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	errs := []error{s.stopListening(ctx)}
+	// ws连接已经被劫持，http.Server.Shutdown不会关闭它们
+	s.hlSyncMap.Range(func(_, value interface{}) bool {
+		if client, ok := value.(*Clients); ok {
+			client.goingAway()
+		}
+		return true
+	})
+	if s.historyDB != nil {
+		if err := s.historyDB.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stopListening 停止监听，等待正在处理的http和gRPC请求完成，ws连接不受影响
+func (s *Server) stopListening(ctx context.Context) error {
+	s.mu.Lock()
+	servers := s.httpServers
+	s.httpServers = nil
+	s.listeners = nil
+	s.mu.Unlock()
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.stopGrpc(ctx)
+	return errors.Join(errs...)
+}
+
+func getGinMode(mode string) string {
+	switch mode {
+	case "release":
+		return gin.ReleaseMode
+	case "debug":
+		return gin.DebugMode
+	case "test":
+		return gin.TestMode
+	}
+	return gin.ReleaseMode // 默认就是release模式
+}
+
+func (s *Server) setupRouters() (*gin.Engine, error) {
+	router := gin.New()
+	if s.conf.AccessLog.IsEnable { // 用自己的访问日志代替gin默认的
+		router.Use(AccessLog(s.conf.AccessLog))
+	} else {
+		router.Use(gin.Logger())
+	}
+	router.Use(Recovery())
+	// gin默认信任所有代理，这里只信任配置的地址，其它来源伪造的代理头会被忽略
+	if err := router.SetTrustedProxies(s.conf.TrustedProxies); err != nil {
+		return nil, errors.New("TrustedProxies配置错误：" + err.Error())
+	}
+	if len(s.conf.RemoteIPHeaders) > 0 {
+		router.RemoteIPHeaders = s.conf.RemoteIPHeaders
+	}
+	router.Use(s.BanCheck())
+	router.Use(s.BodyLimit())
+	if s.conf.Gzip.IsEnable {
+		router.Use(Gzip(s.conf.Gzip))
+	}
+	if s.conf.Cors.IsEnable { // 是否开启cors中间件
+		router.Use(CorsMiddleWare(s.conf.Cors))
+	}
+	return router, nil
+}
+
+// setupHttpRouters 构建明文http监听使用的路由，启用https时按HttpMode决定提供哪些接口，categories不为空时只提供这些类别的接口
+func (s *Server) setupHttpRouters(categories []string) (*gin.Engine, error) {
+	conf := s.conf
+	if !conf.HttpsServices.IsEnable && len(categories) == 0 {
+		return s.router, nil
+	}
+	router, err := s.setupRouters()
+	if err != nil {
+		return nil, err
+	}
+	mode := config.HttpModeServe // 没有启用https时HttpMode不生效
+	if conf.HttpsServices.IsEnable {
+		mode = conf.HttpsServices.HttpMode
+	}
+	switch mode {
+	case config.HttpModeRedirect:
+		router.Use(tlsHandler(conf.HttpsServices.HttpsListen)) // 必须在注册路由之前Use才会生效
+		s.setJsRpcRouters(router, categories...)
+	case config.HttpModeHealthz:
+		router.GET("/healthz", healthz)
+	default:
+		s.setJsRpcRouters(router, categories...)
+	}
+	return router, nil
+}
+
+// serve 在已经监听好的端口上启动http服务，Shutdown时一起关闭
+func (s *Server) serve(ln net.Listener, handler http.Handler) {
+	// 请求头也要在握手超时内收完，否则慢速连接在到达ws升级之前就一直占着
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: s.upGrader.HandshakeTimeout}
+	s.mu.Lock()
+	s.httpServers = append(s.httpServers, srv)
+	s.mu.Unlock()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(err)
+		}
+	}()
+}
+
+// Start 按配置监听http、https和gRPC，不会阻塞。BasicListen最先监听，全部地址都监听成功后才启动服务和定时任务，
+// 有地址失败时关闭已经监听的端口并返回错误
+func (s *Server) Start() error {
+	conf := s.conf
+	var sb strings.Builder
+	sb.WriteString("当前监听地址：")
+	sb.WriteString(strings.Join(conf.BasicListen, ","))
+
+	sb.WriteString(" ssl启用状态：")
+	sb.WriteString(strconv.FormatBool(conf.HttpsServices.IsEnable))
+
+	var tlsConf *tls.Config
+	var certManager *autocert.Manager
+	var reloader *certReloader
+	if conf.HttpsServices.IsEnable {
+		sb.WriteString(" https监听地址：")
+		sb.WriteString(conf.HttpsServices.HttpsListen)
+		sb.WriteString(" http处理方式：")
+		sb.WriteString(conf.HttpsServices.HttpMode)
+		// https使用独立的路由，不受http跳转中间件影响
+		if conf.HttpsServices.AutoCert.IsEnable {
+			sb.WriteString(" 自动证书域名：")
+			sb.WriteString(strings.Join(conf.HttpsServices.AutoCert.Domains, ","))
+			if err := checkAutoCert(conf); err != nil {
+				return errors.New("自动证书配置错误：" + err.Error())
+			}
+			if conf.HttpsServices.PemPath != "" || conf.HttpsServices.KeyPath != "" {
+				log.Warning("启用了AutoCert，忽略PemPath/KeyPath")
+			}
+			certManager = newAutoCertManager(conf.HttpsServices.AutoCert)
+			tlsConf = certManager.TLSConfig()
+		} else {
+			// 证书有问题时不能只提供http，和监听失败一样退出
+			var err error
+			if reloader, err = newCertReloader(conf.HttpsServices.PemPath, conf.HttpsServices.KeyPath); err != nil {
+				return &ListenError{Err: errors.New("https证书加载失败：" + err.Error())}
+			}
+			tlsConf = &tls.Config{GetCertificate: reloader.GetCertificate}
+		}
+	}
+	if conf.Grpc.IsEnable {
+		sb.WriteString(" grpc监听地址：")
+		sb.WriteString(conf.Grpc.Listen)
+	}
+
+	// 按ListenRoutes分开构建路由，没有配置的地址共用一个
+	handlers := make(map[string]http.Handler, len(conf.BasicListen))
+	for _, addr := range conf.BasicListen {
+		router, err := s.setupHttpRouters(conf.ListenRoutes[addr])
+		if err != nil {
+			return err
+		}
+		var handler http.Handler = router
+		if certManager != nil {
+			handler = certManager.HTTPHandler(handler) // HTTP-01验证走明文监听，其余请求交给原路由
+		}
+		handlers[addr] = handler
+	}
+	lns, err := s.listenAll(tlsConf)
+	if err != nil {
+		return err
+	}
+	log.Infoln(sb.String())
+	log.Infoln(upgraderSummary(s.upGrader))
+
+	// 到这里所有端口都已经监听成功
+	if reloader != nil {
+		reloader.watch(s.stop)
+	}
+	if lns.https != nil {
+		s.serve(lns.https, s.router)
+	}
+	if lns.grpc != nil {
+		s.serveGrpc(lns.grpc)
+	}
+	for i, ln := range lns.basic {
+		s.serve(ln, handlers[conf.BasicListen[i]])
+	}
+	s.startSchedules()
+	go s.sweepSessions()
+	closeInherited()
+	notifyReady()
+	log.Infoln("实际监听地址：" + s.listenSummary())
+	return nil
+}
+
+// startListeners Start里监听好、还没有启动服务的端口
+type startListeners struct {
+	basic []net.Listener // 和BasicListen一一对应(可能是备用地址)，同一个地址可以配置多次，比如多个:0
+	https net.Listener   // 已经包装成tls
+	grpc  net.Listener
+}
+
+// listenAll 先监听BasicListen，再监听https和gRPC，有地址失败时关闭已经监听的端口，返回ListenError
+func (s *Server) listenAll(tlsConf *tls.Config) (startListeners, error) {
+	conf := s.conf
+	lns := startListeners{basic: make([]net.Listener, 0, len(conf.BasicListen))}
+	var errs []error
+	fallbacks := slices.Clone([]string(conf.FallbackListen))
+	for _, addr := range conf.BasicListen {
+		ln, err := s.listenBasic(addr, &fallbacks)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lns.basic = append(lns.basic, ln)
+	}
+	if len(errs) == 0 && tlsConf != nil {
+		ln, err := s.listen(listenHttps, conf.HttpsServices.HttpsListen)
+		if err != nil {
+			errs = append(errs, errors.New("https监听失败："+err.Error()))
+		} else {
+			lns.https = tls.NewListener(ln, tlsConf)
+		}
+	}
+	if len(errs) == 0 && conf.Grpc.IsEnable {
+		ln, err := s.listen(listenGrpc, conf.Grpc.Listen)
+		if err != nil {
+			errs = append(errs, errors.New("grpc监听失败："+err.Error()))
+		} else {
+			lns.grpc = ln
+		}
+	}
+	if len(errs) > 0 {
+		s.closeListeners()
+		return lns, &ListenError{Err: errors.Join(errs...)}
+	}
+	return lns, nil
+}
+
+// closeListeners 启动失败时关闭已经监听的端口
+func (s *Server) closeListeners() {
+	s.mu.Lock()
+	listeners := s.listeners
+	s.listeners = nil
+	s.mu.Unlock()
+	for _, e := range listeners {
+		_ = e.ln.Close()
+	}
+}
+
+// listenSummary 按类型列出绑定后的地址，配置的端口为0或者地址为空时能看到系统分配的端口
+func (s *Server) listenSummary() string {
+	addrs := s.ListenAddrs()
+	kinds := make([]string, 0, len(addrs))
+	for kind := range addrs {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		parts = append(parts, kind+"="+strings.Join(addrs[kind], ","))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,322 @@
+package core
+
+import (
+	"JsRpc/config"
+	"JsRpc/protocol"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	// gorilla开启压缩后关闭连接时会用标准库log打印reader close error
+	stdlog.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestServer 创建不监听端口的Server，edit可以修改默认配置
+func newTestServer(t testing.TB, edit func(conf *config.ConfStruct)) *Server {
+	t.Helper()
+	conf := config.ConfStruct{DefaultTimeOut: 5, CloseWebLog: true, Mode: "test"}
+	if edit != nil {
+		edit(&conf)
+	}
+	s, err := NewServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	return s
+}
+
+// testContext 测试结束时取消
+func testContext(t testing.TB) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// waitFor 最多等3秒直到cond成立
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时：", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// serveRequest 不经过网络直接调用s的路由，headers是成对的头名和值
+func serveRequest(s *Server, method string, target string, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	return w
+}
+
+// decodeBody 把json响应解析成map
+func decodeBody(t testing.TB, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("响应不是json：%s", w.Body.String())
+	}
+	return body
+}
+
+// fakeClient 在fakeWs上模拟旧版格式的浏览器客户端，handlers里没有的action返回action not found
+type fakeClient struct {
+	ws       *fakeWs
+	client   *Clients
+	done     chan struct{} // serveWs返回后关闭
+	controls chan string   // 收到的控制消息名，满了就丢弃
+}
+
+// startFake 让s在fakeWs上接入一个客户端，等到注册完成并回复过_listActions。
+// handlers里有_listActions时按它回复方法列表
+func startFake(t testing.TB, s *Server, peer wsPeer, handlers map[string]func(param string) string) *fakeClient {
+	t.Helper()
+	return startReplier(t, s, peer, handlerReplies(handlers))
+}
+
+// handlerReplies 按action调用handlers，返回普通的结果
+func handlerReplies(handlers map[string]func(param string) string) func(req protocol.Envelope) protocol.Envelope {
+	return func(req protocol.Envelope) protocol.Envelope {
+		data := "action not found"
+		if handler, ok := handlers[req.Action]; ok {
+			data = handler(req.Param)
+		}
+		return protocol.Response(req.Id, req.Action, data)
+	}
+}
+
+// startReplier 和startFake相同，每个请求的返回由reply生成，可以带上异常等字段
+func startReplier(t testing.TB, s *Server, peer wsPeer, reply func(req protocol.Envelope) protocol.Envelope) *fakeClient {
+	t.Helper()
+	if peer.ip == "" {
+		peer.ip = "127.0.0.1"
+	}
+	fc := &fakeClient{ws: newFakeWs(), done: make(chan struct{}), controls: make(chan string, 64)}
+	listed := make(chan struct{})
+	go func() {
+		defer close(fc.done)
+		s.serveWs(peer, fc.ws)
+	}()
+	go fc.respond(reply, listed)
+	t.Cleanup(func() {
+		_ = fc.ws.Close()
+		<-fc.done
+	})
+	select {
+	case <-listed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("客户端没有收到_listActions")
+	}
+	value, ok := s.hlSyncMap.Load(clientKey(peer.namespace, peer.group, peer.clientId))
+	if !ok {
+		t.Fatal("客户端没有注册")
+	}
+	fc.client = value.(*Clients)
+	waitFor(t, "_listActions处理完", func() bool { return fc.client.pendingCount() == 0 })
+	return fc
+}
+
+// respond 读服务端写给客户端的消息，回复请求，控制消息不回复只记录到controls
+func (fc *fakeClient) respond(reply func(req protocol.Envelope) protocol.Envelope, listed chan struct{}) {
+	codec := protocol.CodecFor(protocol.VersionMessageId, protocol.EncodingJson)
+	for {
+		var frame fakeFrame
+		select {
+		case frame = <-fc.ws.written:
+		case <-fc.ws.closed:
+			return
+		}
+		env, err := protocol.Decode(frame.data)
+		if err != nil {
+			continue
+		}
+		if env.Type != protocol.TypeRequest || env.Id == "" {
+			select {
+			case fc.controls <- env.Name():
+			default:
+			}
+			continue
+		}
+		res, _ := codec.Encode(reply(env))
+		fc.ws.push(string(res))
+		if env.Action == actionListActions && listed != nil {
+			close(listed)
+			listed = nil
+		}
+	}
+}
+
+// actionList handlers回复_listActions时用的方法列表
+func actionList(names ...string) func(string) string {
+	return func(string) string {
+		data, _ := json.Marshal(names)
+		return string(data)
+	}
+}
+
+// wsClientIp 通过真实的http服务连接ws，返回服务端记录的客户端ip
+func wsClientIp(t *testing.T, s *Server, header http.Header) string {
+	t.Helper()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?group=g&clientId=c", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	waitFor(t, "客户端注册", func() bool { return s.Client("g", "c") != nil })
+	return s.Client("g", "c").clientIp
+}
+
+func TestTrustedProxyHeaders(t *testing.T) {
+	header := http.Header{"X-Forwarded-For": {"1.2.3.4"}}
+	tests := []struct {
+		name    string
+		trusted []string
+		want    string
+	}{
+		{"没有配置代理时忽略转发头", nil, "127.0.0.1"},
+		{"来自不信任的地址时忽略转发头", []string{"10.0.0.1"}, "127.0.0.1"},
+		{"来自信任的代理时使用转发头", []string{"127.0.0.1"}, "1.2.3.4"},
+		{"信任的网段", []string{"127.0.0.0/8"}, "1.2.3.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(conf *config.ConfStruct) { conf.TrustedProxies = tt.trusted })
+			if got := wsClientIp(t, s, header); got != tt.want {
+				t.Fatalf("clientIp = %s，期望%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteIPHeaders(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.TrustedProxies = []string{"127.0.0.1"}
+		conf.RemoteIPHeaders = []string{"X-Real-IP"}
+	})
+	header := http.Header{"X-Forwarded-For": {"1.2.3.4"}, "X-Real-Ip": {"5.6.7.8"}}
+	if got := wsClientIp(t, s, header); got != "5.6.7.8" {
+		t.Fatalf("配置了RemoteIPHeaders时clientIp = %s，期望5.6.7.8", got)
+	}
+}
+
+func TestBadTrustedProxies(t *testing.T) {
+	_, err := NewServer(config.ConfStruct{DefaultTimeOut: 5, CloseWebLog: true, TrustedProxies: []string{"not-an-ip"}})
+	if err == nil || !strings.Contains(err.Error(), "TrustedProxies") {
+		t.Fatalf("TrustedProxies格式错误时应该返回错误，得到 %v", err)
+	}
+}
+
+func TestNewServerRejectsZeroTimeout(t *testing.T) {
+	if _, err := NewServer(config.ConfStruct{CloseWebLog: true}); err == nil {
+		t.Fatal("DefaultTimeOut为0时应该返回错误")
+	}
+}
+
+func TestCall(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.DefaultTimeOut = 1 })
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"hello": func(param string) string { return "hi " + param },
+		"slow":  func(string) string { time.Sleep(1500 * time.Millisecond); return "late" },
+	})
+	ctx := testContext(t)
+
+	if res, err := s.Call(ctx, "g", "c", "hello", "1"); err != nil || res != "hi 1" {
+		t.Fatalf("指定clientId调用 = %q, %v", res, err)
+	}
+	if res, err := s.Call(ctx, "g", "", "hello", "2"); err != nil || res != "hi 2" {
+		t.Fatalf("不指定clientId调用 = %q, %v", res, err)
+	}
+	if _, err := s.Call(ctx, "none", "", "hello", ""); !errors.Is(err, ErrNoClient) {
+		t.Fatalf("group不存在时应该返回ErrNoClient，得到 %v", err)
+	}
+	if _, err := s.Call(ctx, "g", "missing", "hello", ""); !errors.Is(err, ErrNoClient) {
+		t.Fatalf("clientId不存在时应该返回ErrNoClient，得到 %v", err)
+	}
+	if _, err := s.Call(ctx, "g", "c", "slow", ""); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("客户端没有及时返回时应该返回ErrTimeout，得到 %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.Call(cancelled, "g", "c", "hello", ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ctx取消后应该返回context.Canceled，得到 %v", err)
+	}
+}
+
+func TestClientsFiltersByGroup(t *testing.T) {
+	s := newTestServer(t, nil)
+	startFake(t, s, wsPeer{group: "a", clientId: "1"}, nil)
+	startFake(t, s, wsPeer{group: "a", clientId: "2"}, nil)
+	startFake(t, s, wsPeer{group: "b", clientId: "1"}, nil)
+	if n := len(s.Clients("a")); n != 2 {
+		t.Fatalf("group a有%d个客户端，期望2", n)
+	}
+	if n := len(s.Clients("")); n != 3 {
+		t.Fatalf("全部客户端%d个，期望3", n)
+	}
+	if s.Clients("none") == nil {
+		t.Fatal("没有客户端时应该返回空切片，json里是[]")
+	}
+	if s.Client("b", "1") == nil || s.Client("b", "2") != nil {
+		t.Fatal("Client查找结果不对")
+	}
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	s1 := newTestServer(t, nil)
+	s2 := newTestServer(t, nil)
+	startFake(t, s1, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"hello": func(string) string { return "from s1" },
+	})
+	if s2.Client("g", "c") != nil || len(s2.Clients("")) != 0 {
+		t.Fatal("一个Server的客户端不应该出现在另一个Server里")
+	}
+	if _, err := s2.Call(testContext(t), "g", "", "hello", ""); !errors.Is(err, ErrNoClient) {
+		t.Fatalf("s2调用应该返回ErrNoClient，得到 %v", err)
+	}
+	if res, err := s1.Call(testContext(t), "g", "", "hello", ""); err != nil || res != "from s1" {
+		t.Fatalf("s1调用 = %q, %v", res, err)
+	}
+}
+
+func TestShutdownDisconnectsClients(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	if err := s.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fc.done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Shutdown后ws连接没有断开")
+	}
+	if s.Client("g", "c") != nil {
+		t.Fatal("Shutdown后客户端应该下线")
+	}
+	if err := s.Shutdown(testContext(t)); err != nil {
+		t.Fatalf("重复Shutdown返回 %v", err)
+	}
+}
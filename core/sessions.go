@@ -0,0 +1,207 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSessionTTL        = 600
+	defaultSessionMaxEntries = 10000
+	sessionSweepInterval     = 30 * time.Second
+
+	SessionOnLostRepin = "repin" // 绑定的客户端断开后重新选择一个
+	SessionOnLostError = "error" // 绑定的客户端断开后返回409，同时删除绑定
+)
+
+// ErrSessionLost session绑定的客户端已经断开
+var ErrSessionLost = errors.New("session绑定的客户端已断开")
+
+// SessionPin session到客户端的绑定，每次使用后重新计算过期时间
+type SessionPin struct {
+	Namespace string    `json:"namespace,omitempty"`
+	Group     string    `json:"group"`
+	Session   string    `json:"session"`
+	ClientId  string    `json:"clientId"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"lastUsed"`
+	Hits      int64     `json:"hits"`
+}
+
+type sessionStore struct {
+	mu   sync.Mutex
+	pins map[string]*SessionPin // clientKey(namespace, group, session)
+}
+
+func (s *Server) sessionTTL() time.Duration {
+	ttl := s.conf.Sessions.TTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// selectClient 所有调用入口共用的选择客户端，选不到时返回nil，由调用方用noClient查明原因；
+// action不允许调用时返回ErrActionForbidden，选中的客户端上报过方法列表但没有注册action时返回*NoClientError
+func (s *Server) selectClient(ns, group, clientId, session, action string, selector map[string]string) (*Clients, error) {
+	if action != "" {
+		if err := s.checkAction(group, action); err != nil {
+			return nil, err
+		}
+	}
+	client, err := s.routeClient(ns, group, clientId, session, action, selector)
+	if client == nil || err != nil {
+		return client, err
+	}
+	if e := s.unregisteredAction(client, action); e != nil {
+		return nil, e
+	}
+	return client, nil
+}
+
+// routeClient 传了session且没有指定clientId时按session选择，action为空时不检查客户端是否注册了方法
+// selector不为空时只选择标签匹配的客户端
+func (s *Server) routeClient(ns, group, clientId, session, action string, selector map[string]string) (*Clients, error) {
+	if session != "" && clientId == "" {
+		return s.sessionClient(ns, group, session, action, selector)
+	}
+	if clientId == "" {
+		return s.findClient(ns, group, action, selector), nil
+	}
+	client := s.getRandomClient(ns, group, clientId)
+	if client == nil || !client.matchTags(selector) {
+		return nil, nil
+	}
+	return client, nil
+}
+
+// sessionClient 按session选择客户端，第一次按正常策略选择后记录下来，后面的请求都发给同一个客户端
+func (s *Server) sessionClient(ns string, group string, session string, action string, selector map[string]string) (*Clients, error) {
+	st := &s.sessions
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.pins == nil {
+		st.pins = make(map[string]*SessionPin)
+	}
+	key := clientKey(ns, group, session)
+	now := time.Now()
+	if pin, ok := st.pins[key]; ok && now.Sub(pin.LastUsed) < s.sessionTTL() {
+		client := s.getRandomClient(ns, group, pin.ClientId)
+		if client != nil && (action == "" || client.hasAction(action)) && client.matchTags(selector) {
+			pin.LastUsed = now
+			pin.Hits++
+			return client, nil
+		}
+		delete(st.pins, key)
+		if s.conf.Sessions.OnLost == SessionOnLostError {
+			return nil, ErrSessionLost
+		}
+	}
+	client := s.findClient(ns, group, action, selector)
+	if client == nil {
+		return nil, nil
+	}
+	maxEntries := s.conf.Sessions.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultSessionMaxEntries
+	}
+	if len(st.pins) >= maxEntries {
+		st.evictOldest()
+	}
+	st.pins[key] = &SessionPin{Namespace: ns, Group: group, Session: session, ClientId: client.clientId, Created: now, LastUsed: now, Hits: 1}
+	return client, nil
+}
+
+// evictOldest 满了以后删除最久没用的绑定，调用方持有锁
+func (st *sessionStore) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, pin := range st.pins {
+		if oldestKey == "" || pin.LastUsed.Before(oldest) {
+			oldestKey, oldest = key, pin.LastUsed
+		}
+	}
+	delete(st.pins, oldestKey)
+}
+
+// sweepSessions 定时清理过期的绑定
+func (s *Server) sweepSessions() {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			ttl := s.sessionTTL()
+			s.sessions.mu.Lock()
+			for key, pin := range s.sessions.pins {
+				if now.Sub(pin.LastUsed) >= ttl {
+					delete(s.sessions.pins, key)
+				}
+			}
+			s.sessions.mu.Unlock()
+		}
+	}
+}
+
+// Sessions 返回没有过期的绑定，group为空时返回全部
+func (s *Server) Sessions(group string) []SessionPin {
+	ttl := s.sessionTTL()
+	now := time.Now()
+	s.sessions.mu.Lock()
+	list := make([]SessionPin, 0, len(s.sessions.pins))
+	for _, pin := range s.sessions.pins {
+		if (group == "" || pin.Group == group) && now.Sub(pin.LastUsed) < ttl {
+			list = append(list, *pin)
+		}
+	}
+	s.sessions.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].LastUsed.After(list[j].LastUsed) })
+	return list
+}
+
+// DeleteSession 删除一个绑定，下次请求重新选择客户端
+func (s *Server) DeleteSession(ns string, group string, session string) bool {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	key := clientKey(ns, group, session)
+	if _, ok := s.sessions.pins[key]; !ok {
+		return false
+	}
+	delete(s.sessions.pins, key)
+	return true
+}
+
+// getSessions 查看session绑定，DELETE /sessions?group=xx&session=xx 删除绑定
+func (s *Server) getSessions(c *gin.Context) {
+	group := c.Query("group")
+	if c.Request.Method == http.MethodDelete {
+		session := c.Query("session")
+		if group == "" || session == "" {
+			GinJsonMsg(c, http.StatusBadRequest, "需要传入group和session")
+			return
+		}
+		if !requireAdminGroup(c, group) {
+			return
+		}
+		if !s.DeleteSession(c.Query("namespace"), group, session) {
+			GinJsonMsg(c, http.StatusNotFound, "没有找到这个session")
+			return
+		}
+		GinJsonMsg(c, http.StatusOK, "已删除")
+		return
+	}
+	list := make([]SessionPin, 0)
+	for _, pin := range s.Sessions(group) {
+		if canAdminGroup(c, pin.Group) {
+			list = append(list, pin)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": list})
+}
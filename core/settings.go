@@ -0,0 +1,115 @@
+package core
+
+import (
+	"JsRpc/config"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const maxSettingSeconds = 3600
+
+// RuntimeSettings /admin/settings可以在运行中修改的配置，立即生效，重启后恢复配置文件里的值
+type RuntimeSettings struct {
+	DefaultTimeout int    `json:"defaultTimeout"` // 请求超时秒数
+	PingInterval   int    `json:"pingInterval"`   // ws心跳ping的间隔秒数，负数不ping
+	StatusMaxAge   int    `json:"statusMaxAge"`   // 客户端上报的状态多少秒后过期，过期后不算健康
+	LogLevel       string `json:"logLevel"`
+}
+
+// settingsPatch 只修改传了的字段
+type settingsPatch struct {
+	DefaultTimeout *int    `json:"defaultTimeout"`
+	PingInterval   *int    `json:"pingInterval"`
+	StatusMaxAge   *int    `json:"statusMaxAge"`
+	LogLevel       *string `json:"logLevel"`
+}
+
+// initSettings 按配置文件算出生效的值
+func (s *Server) initSettings() {
+	settings := &RuntimeSettings{
+		DefaultTimeout: config.DefaultTimeout,
+		PingInterval:   defaultPingInterval,
+		StatusMaxAge:   defaultStatusMaxAge,
+		LogLevel:       log.GetLevel().String(),
+	}
+	if s.conf.DefaultTimeOut > 0 {
+		settings.DefaultTimeout = s.conf.DefaultTimeOut
+	}
+	if s.conf.Websocket.PingInterval != 0 {
+		settings.PingInterval = s.conf.Websocket.PingInterval
+	}
+	if s.conf.Routing.StatusMaxAge > 0 {
+		settings.StatusMaxAge = s.conf.Routing.StatusMaxAge
+	}
+	s.settings.Store(settings)
+}
+
+// Settings 当前生效的运行时配置
+func (s *Server) Settings() RuntimeSettings {
+	return *s.settings.Load()
+}
+
+// UpdateSettings 校验通过后一次性替换，返回修改前的值
+func (s *Server) UpdateSettings(patch settingsPatch) (RuntimeSettings, RuntimeSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.Settings()
+	next := prev
+	if patch.DefaultTimeout != nil {
+		if *patch.DefaultTimeout <= 0 || *patch.DefaultTimeout > maxSettingSeconds {
+			return prev, prev, fmt.Errorf("defaultTimeout需要在1-%d之间", maxSettingSeconds)
+		}
+		next.DefaultTimeout = *patch.DefaultTimeout
+	}
+	if patch.PingInterval != nil {
+		if *patch.PingInterval == 0 || *patch.PingInterval > maxSettingSeconds {
+			return prev, prev, fmt.Errorf("pingInterval需要在1-%d之间，负数表示不ping", maxSettingSeconds)
+		}
+		next.PingInterval = *patch.PingInterval
+	}
+	if patch.StatusMaxAge != nil {
+		if *patch.StatusMaxAge <= 0 || *patch.StatusMaxAge > maxSettingSeconds {
+			return prev, prev, fmt.Errorf("statusMaxAge需要在1-%d之间", maxSettingSeconds)
+		}
+		next.StatusMaxAge = *patch.StatusMaxAge
+	}
+	var level log.Level
+	if patch.LogLevel != nil {
+		var err error
+		if level, err = log.ParseLevel(*patch.LogLevel); err != nil {
+			return prev, prev, fmt.Errorf("logLevel无效：%s", *patch.LogLevel)
+		}
+		next.LogLevel = level.String()
+	}
+	s.settings.Store(&next)
+	if patch.LogLevel != nil {
+		log.SetLevel(level)
+	}
+	return prev, next, nil
+}
+
+// adminSettings GET查看当前生效的值，POST传json修改部分字段
+func (s *Server) adminSettings(c *gin.Context) {
+	if !requireGlobalAdmin(c) {
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		c.JSON(http.StatusOK, gin.H{"status": 200, "data": s.Settings()})
+		return
+	}
+	var patch settingsPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	prev, next, err := s.UpdateSettings(patch)
+	if err != nil {
+		GinJsonMsg(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Warnf("管理接口修改了运行时配置 ip:%s 修改前:%+v 修改后:%+v", c.ClientIP(), prev, next)
+	c.JSON(http.StatusOK, gin.H{"status": 200, "data": next, "previous": prev})
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSignSkew             = 30 // 秒
+	defaultMaxSignatureFailures = 3
+)
+
+// signSecret 客户端所在group配置的共享密钥，为空时不签名
+func (c *Clients) signSecret() string {
+	if c.server == nil {
+		return ""
+	}
+	return c.server.conf.Security.Hmac.Secrets[c.clientGroup]
+}
+
+func (s *Server) signSkew() time.Duration {
+	return time.Duration(orDefault(s.conf.Security.Hmac.MaxSkew, defaultSignSkew)) * time.Second
+}
+
+// verifyResponse 配置了共享密钥的group检查客户端发来的每条消息(返回、上报和控制消息)的签名，
+// 失败的丢弃，Bans.Window内达到MaxFailures次时断开并封禁ip
+func (c *Clients) verifyResponse(env protocol.Envelope) bool {
+	secret := c.signSecret()
+	if secret == "" || env.Name() == actionRegistered { // 旧版客户端对注册回执返回的action not found
+		return true
+	}
+	err := env.Verify(secret, time.Now(), c.server.signSkew())
+	if err == nil {
+		return true
+	}
+	log.Warning(c.clientGroup+"->"+c.clientId, " 返回的签名验证失败，已丢弃 action:", env.Name(), " ", err)
+	if c.server.strike(c.getIp(), strikeSignature) {
+		c.close()
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"JsRpc/config"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	actionStatus = "_status" // 客户端主动上报的忙碌状态和负载
+
+	defaultStatusMaxAge = 30 // 秒
+	busyWeight          = 0.1
+)
+
+// ClientStatus 客户端上报的忙碌状态，load在0-1之间
+type ClientStatus struct {
+	Busy      bool      `json:"busy"`
+	Load      float64   `json:"load"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	AgeMs     int64     `json:"ageMs"` // /details展示时距离上报过了多久
+	Stale     bool      `json:"stale"` // 超过Routing.StatusMaxAge，选择客户端时忽略
+}
+
+func (c *Clients) handleStatus(raw string) {
+	status := &ClientStatus{}
+	if err := json.Unmarshal([]byte(raw), status); err != nil {
+		log.Error("_status格式错误:", err)
+		return
+	}
+	status.UpdatedAt = time.Now()
+	c.mu.Lock()
+	c.status = status
+	c.mu.Unlock()
+	if c.server != nil {
+		c.server.updateHealth(c)
+	}
+}
+
+func (c *Clients) getStatus() *ClientStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status == nil {
+		return nil
+	}
+	status := *c.status
+	status.AgeMs = time.Since(status.UpdatedAt).Milliseconds()
+	status.Stale = c.statusStale(status.UpdatedAt)
+	return &status
+}
+
+func (c *Clients) statusStale(updatedAt time.Time) bool {
+	maxAge := defaultStatusMaxAge
+	if c.server != nil {
+		maxAge = c.server.Settings().StatusMaxAge
+	}
+	return time.Since(updatedAt) > time.Duration(maxAge)*time.Second
+}
+
+// currentStatus 没有上报过或者已经过期时返回不忙、负载0
+func (c *Clients) currentStatus() (busy bool, load float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status == nil || c.statusStale(c.status.UpdatedAt) {
+		return false, 0
+	}
+	return c.status.Busy, c.status.Load
+}
+
+// weight 选择客户端时的权重，负载越高越小，忙碌时只剩busyWeight
+func (c *Clients) weight() float64 {
+	busy, load := c.currentStatus()
+	if busy {
+		return busyWeight
+	}
+	if load < 0 {
+		load = 0
+	}
+	w := 1 - load
+	if w < busyWeight {
+		w = busyWeight
+	}
+	return w
+}
+
+// pickClient 按客户端上报的状态选一个：skip模式跳过忙碌的客户端(全都忙时不跳过)，默认按负载加权随机
+func (s *Server) pickClient(candidates []*Clients) *Clients {
+	if len(candidates) == 0 {
+		return nil
+	}
+	if s.conf.Routing.BusyMode == config.BusyModeSkip {
+		idle := make([]*Clients, 0, len(candidates))
+		for _, client := range candidates {
+			if busy, _ := client.currentStatus(); !busy {
+				idle = append(idle, client)
+			}
+		}
+		if len(idle) > 0 {
+			candidates = idle
+		}
+	}
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, client := range candidates {
+		weights[i] = client.weight()
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return candidates[i]
+		}
+		r -= w
+	}
+	return candidates[len(candidates)-1]
+}
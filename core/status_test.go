@@ -0,0 +1,103 @@
+package core
+
+import (
+	"JsRpc/config"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// reportStatus 模拟客户端主动上报_status
+func (fc *fakeClient) reportStatus(t *testing.T, busy bool, load float64) {
+	t.Helper()
+	data, _ := json.Marshal(map[string]interface{}{"busy": busy, "load": load})
+	msg, _ := json.Marshal(map[string]string{"action": actionStatus, "message_id": "", "response_data": string(data)})
+	fc.ws.push(string(msg))
+	waitFor(t, "状态更新", func() bool {
+		status := fc.client.getStatus()
+		return status != nil && status.Busy == busy && status.Load == load
+	})
+}
+
+// routedTo 不指定clientId调用n次，返回每个客户端被选中的次数
+func routedTo(t *testing.T, s *Server, n int) map[string]int {
+	t.Helper()
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		res, err := s.Call(testContext(t), "g", "", "who", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[res]++
+	}
+	return counts
+}
+
+func startNamed(t *testing.T, s *Server, clientId string) *fakeClient {
+	return startFake(t, s, wsPeer{group: "g", clientId: clientId}, map[string]func(string) string{
+		"who": func(string) string { return clientId },
+	})
+}
+
+func TestBusySkipShiftsRouting(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.Routing.BusyMode = config.BusyModeSkip })
+	a, b := startNamed(t, s, "a"), startNamed(t, s, "b")
+
+	a.reportStatus(t, true, 0.9)
+	if counts := routedTo(t, s, 50); counts["a"] != 0 {
+		t.Fatalf("skip模式下忙碌的a不应该被选中：%v", counts)
+	}
+	a.reportStatus(t, false, 0)
+	if counts := routedTo(t, s, 100); counts["a"] == 0 || counts["b"] == 0 {
+		t.Fatalf("a恢复空闲后两个客户端都应该被选中：%v", counts)
+	}
+	a.reportStatus(t, true, 1)
+	b.reportStatus(t, true, 1)
+	if counts := routedTo(t, s, 100); counts["a"] == 0 || counts["b"] == 0 {
+		t.Fatalf("全都忙时不跳过：%v", counts)
+	}
+}
+
+func TestBusyWeightShiftsRouting(t *testing.T) {
+	s := newTestServer(t, nil)
+	a, _ := startNamed(t, s, "a"), startNamed(t, s, "b")
+	a.reportStatus(t, true, 0)
+	// a的权重是0.1，b是1，期望a约占9%
+	if counts := routedTo(t, s, 300); counts["a"] == 0 || counts["a"] > 75 {
+		t.Fatalf("忙碌的a应该很少被选中但不是完全不选：%v", counts)
+	}
+	a.reportStatus(t, false, 0)
+	if counts := routedTo(t, s, 300); counts["a"] < 100 {
+		t.Fatalf("a恢复空闲后应该和b差不多：%v", counts)
+	}
+}
+
+func TestStaleStatusIgnored(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) {
+		conf.Routing.BusyMode = config.BusyModeSkip
+		conf.Routing.StatusMaxAge = 1
+	})
+	a, _ := startNamed(t, s, "a"), startNamed(t, s, "b")
+	a.reportStatus(t, true, 1)
+	a.client.mu.Lock()
+	a.client.status.UpdatedAt = time.Now().Add(-2 * time.Second)
+	a.client.mu.Unlock()
+	if counts := routedTo(t, s, 100); counts["a"] == 0 {
+		t.Fatalf("过期的忙碌状态应该被忽略：%v", counts)
+	}
+
+	details := decodeBody(t, serveRequest(s, http.MethodGet, "/details", ""))["data"].(map[string]interface{})
+	for _, item := range details["g"].([]interface{}) {
+		client := item.(map[string]interface{})
+		if client["clientId"] != "a" {
+			continue
+		}
+		status, _ := client["status"].(map[string]interface{})
+		if status["busy"] != true || status["stale"] != true || status["ageMs"].(float64) < 2000 {
+			t.Fatalf("/details里的status = %v", status)
+		}
+		return
+	}
+	t.Fatal("/details里没有a")
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"JsRpc/config"
+	"JsRpc/protocol"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const streamBufferSize = 16 // 每个请求缓存的流式返回部分数
+
+// ErrStreamOverflow 流式返回的部分太多，调用方来不及读，缓存满了
+var ErrStreamOverflow = errors.New("stream buffer full")
+
+// StreamPart 客户端流式返回的一部分，Final的是最后一部分
+type StreamPart struct {
+	Seq   int    `json:"seq"`
+	Data  string `json:"data"`
+	Final bool   `json:"final"`
+}
+
+type streamKey struct{}
+
+// withStream 客户端流式返回时每收到一部分就写入parts，不支持流式的客户端只写入一个Final的部分
+func withStream(ctx context.Context, parts chan<- StreamPart) context.Context {
+	return context.WithValue(ctx, streamKey{}, parts)
+}
+
+func streamFromContext(ctx context.Context) chan<- StreamPart {
+	parts, _ := ctx.Value(streamKey{}).(chan<- StreamPart)
+	return parts
+}
+
+// GQueryStream GQueryFunc的流式版本，结果按部分写入parts，结束后关闭parts
+func (c *Clients) GQueryStream(WriteData Message, parts chan<- StreamPart) error {
+	defer close(parts)
+	_, err := c.QueryMessage(withStream(context.Background(), parts), WriteData)
+	return err
+}
+
+// deliverPart 把流式返回的一部分交给对应的请求，最后一部分到达时请求结束
+// 在读循环里执行，不能阻塞：中间部分放不进缓存时请求直接失败，不能丢掉一部分让合并的结果出错
+func (c *Clients) deliverPart(messageId string, part protocol.Part, data string) bool {
+	c.mu.Lock()
+	var target *pendingRequest
+	for _, req := range c.actionData {
+		if req.messageId == messageId {
+			target = req
+			break
+		}
+	}
+	if target == nil {
+		c.mu.Unlock()
+		return false
+	}
+	p := StreamPart{Seq: part.Seq, Data: data, Final: part.Final}
+	if !part.Final {
+		select {
+		case target.parts <- p:
+			c.mu.Unlock()
+			return true
+		default:
+		}
+		// 调用方读得太慢，缓存满了
+		target.done = true
+		target.err = ErrStreamOverflow
+		c.removePendingLocked(target)
+		c.mu.Unlock()
+		target.result <- ""
+		return false
+	}
+	// 先占住请求，roundTrip超时时removePending返回false，会一直读到最后一部分，所以这里不用超时
+	target.done = true
+	c.removePendingLocked(target)
+	c.mu.Unlock()
+	target.parts <- p
+	return true
+}
+
+// joinParts 不是流式调用时，客户端的多个部分按Websocket.StreamJoin合并成一个结果
+func (c *Clients) joinParts(parts []StreamPart) string {
+	if c.server != nil && c.server.conf.Websocket.StreamJoin == config.StreamJoinLast {
+		return parts[len(parts)-1].Data
+	}
+	slices.SortStableFunc(parts, func(a, b StreamPart) int { return a.Seq - b.Seq })
+	var sb strings.Builder
+	for _, p := range parts {
+		sb.WriteString(p.Data)
+	}
+	return sb.String()
+}
+
+// streamResult /go?stream=true，按NDJSON逐行返回每一部分，最后一行final为true；超时或出错时最后一行带error
+func (s *Server) streamResult(c *gin.Context, client *Clients, msg Message) {
+	ctx, ok := requestContext(c)
+	if !ok {
+		return
+	}
+	parts := make(chan StreamPart, streamBufferSize)
+	errc := make(chan error, 1)
+	ctx = withStream(ctx, parts)
+	go func() {
+		defer close(parts)
+		_, err := s.runQuery(ctx, client, msg)
+		errc <- err
+	}()
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(http.StatusOK)
+		}
+	}
+	for part := range parts {
+		start()
+		line, _ := json.Marshal(gin.H{"seq": part.Seq, "data": part.Data, "final": part.Final,
+			"group": client.clientGroup, "clientId": client.clientId})
+		if _, err := c.Writer.Write(append(line, '\n')); err != nil {
+			log.Warn("流式返回写入失败:", err)
+		}
+		c.Writer.Flush()
+	}
+	err := <-errc
+	if err == nil {
+		return
+	}
+	if !started {
+		// 还没开始返回时和普通请求一样按错误类型返回状态码
+		if _, ok := queryResult(c, "", err); !ok {
+			return
+		}
+		start()
+	}
+	line, _ := json.Marshal(gin.H{"error": err.Error(), "final": true, "group": client.clientGroup, "clientId": client.clientId})
+	_, _ = c.Writer.Write(append(line, '\n'))
+	c.Writer.Flush()
+}
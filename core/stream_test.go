@@ -0,0 +1,108 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 中间部分放不进缓存时请求马上失败，不会丢掉一部分也不会阻塞读循环
+func TestStreamOverflowFailsRequest(t *testing.T) {
+	c := &Clients{clientGroup: "g", clientId: "c"}
+	req, err := c.addPending(&pendingRequest{messageId: "m", action: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < streamBufferSize; i++ {
+		if !c.deliverPart("m", protocol.Part{Seq: i}, "x") {
+			t.Fatalf("第%d部分没有交付", i)
+		}
+	}
+	start := time.Now()
+	if c.deliverPart("m", protocol.Part{Seq: streamBufferSize}, "x") {
+		t.Fatal("缓存满了不应该交付成功")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("缓存满了不应该等待，用了%v", elapsed)
+	}
+	if res := <-req.result; res != "" || !errors.Is(req.err, ErrStreamOverflow) {
+		t.Fatalf("result = %q, err = %v", res, req.err)
+	}
+	if c.pendingCount() != 0 || c.deliverPart("m", protocol.Part{Seq: 99, Final: true}, "x") {
+		t.Fatal("失败的请求应该已经移除")
+	}
+}
+
+// 缓存满了时最后一部分等到调用方读出空位再交付，交付前已经占住请求，超时移除不会成功
+func TestStreamFinalPartNotDropped(t *testing.T) {
+	c := &Clients{clientGroup: "g", clientId: "c"}
+	req, err := c.addPending(&pendingRequest{messageId: "m", action: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < streamBufferSize; i++ {
+		c.deliverPart("m", protocol.Part{Seq: i}, "x")
+	}
+	delivered := make(chan bool, 1)
+	go func() { delivered <- c.deliverPart("m", protocol.Part{Seq: streamBufferSize, Final: true}, "end") }()
+	waitFor(t, "最后一部分占住请求", func() bool { return c.pendingCount() == 0 })
+	if c.removePending(req) {
+		t.Fatal("最后一部分已经在交付，超时移除不应该成功")
+	}
+	time.Sleep(enqueueTimeout + 100*time.Millisecond) // 以前等1秒后丢掉最后一部分
+	for i := 0; i < streamBufferSize; i++ {
+		<-req.parts
+	}
+	if last := <-req.parts; !last.Final || last.Data != "end" {
+		t.Fatalf("最后一部分 = %+v", last)
+	}
+	if !<-delivered {
+		t.Fatal("最后一部分应该交付成功")
+	}
+}
+
+// 调用方一直不读流式结果时请求失败返回，通道占用、并发数和等待中的请求都释放
+func TestStreamSlowReaderReleases(t *testing.T) {
+	s := newTestServer(t, nil)
+	var fc *fakeClient
+	fc = startReplier(t, s, wsPeer{group: "g", clientId: "c"}, func(req protocol.Envelope) protocol.Envelope {
+		if req.Action != "parts" {
+			return protocol.Response(req.Id, req.Action, "action not found")
+		}
+		codec := protocol.CodecFor(protocol.VersionMessageId, protocol.EncodingJson)
+		for i := 0; i < 2*streamBufferSize; i++ {
+			part := protocol.Response(req.Id, req.Action, fmt.Sprint(i))
+			part.Part = &protocol.Part{Seq: i}
+			data, _ := codec.Encode(part)
+			fc.ws.push(string(data))
+		}
+		last := protocol.Response(req.Id, req.Action, "end")
+		last.Part = &protocol.Part{Seq: 2 * streamBufferSize, Final: true}
+		return last
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := fc.client.Query(withStream(ctx, make(chan StreamPart)), "parts", "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrStreamOverflow) && !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Query = %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("调用方不读时Query没有返回")
+	}
+	waitFor(t, "请求释放", func() bool {
+		return fc.client.pendingCount() == 0 && s.queries.Load() == 0
+	})
+	if res, err := fc.client.Query(testContext(t), "hello", ""); err != nil || res != "action not found" {
+		t.Fatalf("之后的请求 = %q, %v", res, err)
+	}
+}
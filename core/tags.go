@@ -0,0 +1,141 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxTags      = 16 // 每个客户端最多的标签数
+	maxTagLength = 64 // 标签key和value的最大长度
+)
+
+// parseTags 解析 region=us,account=alice 格式的标签，空字符串返回nil
+func parseTags(raw string) (map[string]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("标签格式错误：%q，应为key=value", pair)
+		}
+		tags[k] = v
+	}
+	return tags, checkTags(tags)
+}
+
+func checkTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("标签最多%d个", maxTags)
+	}
+	for k, v := range tags {
+		if k == "" {
+			return fmt.Errorf("标签key不能为空")
+		}
+		if len(k) > maxTagLength || len(v) > maxTagLength {
+			return fmt.Errorf("标签%s超过最大长度%d", k, maxTagLength)
+		}
+	}
+	return nil
+}
+
+func (c *Clients) setTags(tags map[string]string) {
+	c.mu.Lock()
+	c.tags = tags
+	c.mu.Unlock()
+	c.tagsChanged()
+}
+
+// mergeTags _hello里上报的标签覆盖同名的标签
+func (c *Clients) mergeTags(tags map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	merged := make(map[string]string, len(c.tags)+len(tags))
+	for k, v := range c.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	if err := checkTags(merged); err != nil {
+		return err
+	}
+	c.tags = merged
+	c.tagsChanged()
+	return nil
+}
+
+// tagsChanged 标签会显示在/details里，变化时增加版本号
+func (c *Clients) tagsChanged() {
+	if c.server != nil {
+		c.server.bumpVersion()
+	}
+}
+
+func (c *Clients) getTags() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tags := make(map[string]string, len(c.tags))
+	for k, v := range c.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// matchTags 客户端的标签包含selector里的全部键值
+func (c *Clients) matchTags(selector map[string]string) bool {
+	if len(selector) == 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range selector {
+		if tag, ok := c.tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+	return true
+}
+
+// findClient 从group里选择注册了action并且标签匹配的客户端，action为空时不检查方法
+func (s *Server) findClient(ns string, group string, action string, selector map[string]string) *Clients {
+	candidates := make([]*Clients, 0)
+	for _, client := range s.groupClients(ns, group, "") {
+		if (action == "" || client.hasAction(action)) && client.matchTags(selector) {
+			candidates = append(candidates, client)
+		}
+	}
+	return s.pickClient(candidates)
+}
+
+// tagsApi 查看客户端的标签，post时用tags参数替换全部标签，tags为空表示清空
+func (s *Server) tagsApi(c *gin.Context) {
+	group, clientId := c.Query("group"), c.Query("clientId")
+	if group == "" || clientId == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入group和clientId")
+		return
+	}
+	if !requireAdminGroup(c, group) {
+		return
+	}
+	client := s.getRandomClient(namespace(c), group, clientId)
+	if client == nil {
+		s.noClientResponse(c, group, clientId)
+		return
+	}
+	if c.Request.Method == http.MethodPost {
+		tags, err := parseTags(c.DefaultQuery("tags", c.PostForm("tags")))
+		if err != nil {
+			GinJsonMsg(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		client.setTags(tags)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": group, "clientId": clientId, "data": client.getTags()})
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"JsRpc/utils"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// actionSuperseded 同一个clientId的新连接接管后发给旧连接，客户端收到后不再重连
+const actionSuperseded = "_superseded"
+
+// ErrSuperseded 页面刷新后新连接接管了客户端，旧连接上还没返回的请求失败
+var ErrSuperseded = fmt.Errorf("%w: superseded by a new connection", errClientClosed)
+
+// connWriter 一个ws连接的写循环，同一时间只有一个写循环在取发送队列
+type connWriter struct {
+	ws        wsConn
+	supersede chan struct{} // 关闭后写循环发出_superseded，关闭连接并退出
+	exited    chan struct{} // 写循环退出后关闭
+}
+
+// startWriter 启动这个连接的写循环，记录下来，新连接接管时先停止它
+func (c *Clients) startWriter(ws wsConn, done <-chan struct{}) {
+	w := &connWriter{ws: ws, supersede: make(chan struct{}), exited: make(chan struct{})}
+	c.mu.Lock()
+	c.writer = w
+	c.mu.Unlock()
+	go c.writeLoop(w, done)
+}
+
+// takeover 页面刷新后同一个group->clientId在旧连接还在时又连上来：旧连接马上停止收发并收到_superseded，
+// 发送队列清空，等待中的请求除了resendOnReconnect和缓存的都返回ErrSuperseded，然后新连接接着使用原来的Clients，
+// hlSyncMap里的记录不变，调用方不会看到客户端下线。ip换成新连接的，注册的方法等新页面重新上报
+func (s *Server) takeover(ns string, group string, clientId string, ip string, ws wsConn, compression bool) (*Clients, bool) {
+	value, ok := s.hlSyncMap.Load(clientKey(ns, group, clientId))
+	if !ok {
+		return nil, false
+	}
+	client, _ := value.(*Clients)
+	if client == nil || client.transport != transportWs {
+		return nil, false
+	}
+	client.mu.Lock()
+	oldWs, writer := client.clientWs, client.writer
+	if oldWs == nil { // 在等待重连，由rebindClient处理
+		client.mu.Unlock()
+		return nil, false
+	}
+	select {
+	case <-client.closed:
+		client.mu.Unlock()
+		return nil, false
+	default:
+	}
+	client.clientWs = nil // 旧连接的读循环退出时dropClient不再处理它
+	client.writer = nil
+	client.mu.Unlock()
+
+	if writer != nil && writer.ws == oldWs {
+		close(writer.supersede)
+		<-writer.exited
+	} else {
+		_ = oldWs.Close()
+	}
+	// 队列里的消息是按旧页面协商的格式编码的，请求要么失败，要么重新发送
+	for len(client.outbound) > 0 {
+		<-client.outbound
+	}
+	client.failPending(ErrSuperseded, true)
+
+	client.mu.Lock()
+	client.clientWs = ws
+	client.clientIp = ip
+	client.compression = compression
+	client.protoVersion.Store(0) // 新页面的脚本版本可能不同，等它重新发_hello
+	client.msgpack.Store(false)
+	client.actions = nil
+	client.mu.Unlock()
+	client.actionsChanged()
+	utils.LogPrint(group+"->"+clientId, ip, "被新连接接管")
+	return client, true
+}
+
+// supersedeOther 同一个key上是另一种连接方式(ws和长轮询)的客户端时没法接着使用原来的Clients：
+// 旧客户端马上下线，ws连接收到_superseded，等待中的请求返回ErrSuperseded，然后才能存入新的客户端
+func (s *Server) supersedeOther(key string, transport string) {
+	value, ok := s.hlSyncMap.Load(key)
+	if !ok {
+		return
+	}
+	old, _ := value.(*Clients)
+	if old == nil || old.transport == transport {
+		return
+	}
+	old.mu.Lock()
+	oldWs, writer := old.clientWs, old.writer
+	old.clientWs = nil // 旧连接的读循环退出时dropClient不再处理它
+	old.writer = nil
+	old.mu.Unlock()
+	if writer != nil && writer.ws == oldWs {
+		close(writer.supersede)
+		<-writer.exited
+	} else if oldWs != nil {
+		_ = oldWs.Close()
+	}
+	old.failPending(ErrSuperseded, false)
+	utils.LogPrint(old.clientGroup+"->"+old.clientId, old.getIp(), "被另一种连接方式接管")
+	if old.transport == transportPoll { // 由watchPollClient发出下线事件
+		old.closeOnce.Do(func() { close(old.closed) })
+		s.hlSyncMap.CompareAndDelete(key, old)
+		return
+	}
+	s.removeClient(old)
+}
+
+// writeSuperseded 写循环退出前告诉旧连接它被接管了，然后关闭连接让读循环退出
+func (c *Clients) writeSuperseded(ws wsConn) {
+	codec := c.codec()
+	env := protocol.NewControl(actionSuperseded, "同一个clientId的新连接已接管")
+	if secret := c.signSecret(); secret != "" {
+		env.Sign(secret, time.Now())
+	}
+	data, err := codec.Encode(env)
+	if err == nil {
+		kind := websocket.TextMessage
+		if codec.Encoding() == protocol.EncodingMsgpack {
+			kind = websocket.BinaryMessage
+		}
+		_ = ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err = ws.WriteMessage(kind, data); err == nil {
+			msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "superseded")
+			err = ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		}
+	}
+	if err != nil {
+		log.Warning(c.clientGroup+"->"+c.clientId, " 发送_superseded失败:", err)
+	}
+	_ = ws.Close()
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"JsRpc/config"
+	"JsRpc/protocol"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// waitControl 等连接收到控制消息name。连接关闭后respond可能已经退出，没有被它读走的消息直接从written里找
+func (fc *fakeClient) waitControl(t *testing.T, name string) {
+	t.Helper()
+	waitFor(t, "收到"+name, func() bool {
+		for {
+			select {
+			case got := <-fc.controls:
+				if got == name {
+					return true
+				}
+			case frame := <-fc.ws.written:
+				if env, err := protocol.Decode(frame.data); err == nil && env.Name() == name {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	})
+}
+
+// 页面连续刷新：每次新连接接管后请求只发给最新的连接，旧连接收到_superseded并关闭，hlSyncMap里的记录一直都在
+func TestRapidReconnects(t *testing.T) {
+	s := newTestServer(t, nil)
+	first := startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"who": func(string) string { return "0" },
+	})
+	client := first.client
+	prev := first
+	for i := 1; i <= 10; i++ {
+		i := i
+		fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+			"who": func(string) string { return fmt.Sprint(i) },
+		})
+		if fc.client != client {
+			t.Fatal("接管后应该继续使用原来的Clients")
+		}
+		select {
+		case <-prev.done:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("第%d次重连后旧连接的处理没有退出", i)
+		}
+		if !prev.ws.isClosed() {
+			t.Fatalf("第%d次重连后旧连接没有关闭", i)
+		}
+		prev.waitControl(t, actionSuperseded)
+		if got := s.Client("g", "c"); got != client {
+			t.Fatalf("第%d次重连后旧连接退出时删掉了记录", i)
+		}
+		for j := 0; j < 3; j++ {
+			res, err := s.Call(testContext(t), "g", "c", "who", "")
+			if err != nil || res != fmt.Sprint(i) {
+				t.Fatalf("第%d次重连后请求发到了%q：%v", i, res, err)
+			}
+		}
+		prev = fc
+	}
+	if n := len(s.Clients("g")); n != 1 {
+		t.Fatalf("应该只有1个客户端，得到%d个", n)
+	}
+}
+
+// 旧连接收到_superseded，还在等待的请求返回ErrSuperseded，不会等到超时
+func TestTakeoverSupersedesOld(t *testing.T) {
+	s := newTestServer(t, nil)
+	hang := make(chan struct{})
+	t.Cleanup(func() { close(hang) })
+	old := startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"never": func(string) string { <-hang; return "late" },
+	})
+	failed := make(chan error, 1)
+	go func() {
+		_, err := s.Call(testContext(t), "g", "c", "never", "")
+		failed <- err
+	}()
+	waitFor(t, "请求发给旧连接", func() bool { return old.client.pendingCount() == 1 })
+
+	startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	select {
+	case err := <-failed:
+		if !errors.Is(err, ErrSuperseded) {
+			t.Fatalf("旧连接上的请求应该返回ErrSuperseded，得到 %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("旧连接上的请求没有结束")
+	}
+	old.waitControl(t, actionSuperseded)
+}
+
+// 新连接接管或者在等待重连时连上来，ip换成新连接的，旧页面注册的方法不再生效
+func TestRebindUpdatesIpAndActions(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace bool // 旧连接先断开，新连接在等待重连时连上来
+	}{
+		{"接管", false},
+		{"等待重连时连上来", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(conf *config.ConfStruct) {
+				conf.Websocket.ReconnectGrace = 30
+				conf.Bans.MaxMalformed = 1
+				conf.Routing.RejectUnregisteredActions = []string{"g"}
+			})
+			old := startFake(t, s, wsPeer{group: "g", clientId: "c", ip: "10.0.0.1"}, map[string]func(string) string{
+				actionListActions: actionList("a"),
+				"a":               func(string) string { return "old" },
+			})
+			if tt.grace {
+				_ = old.ws.Close()
+				waitFor(t, "客户端进入等待重连", old.client.inGrace)
+			}
+			// 新页面是旧版脚本，不上报方法列表
+			fc := startFake(t, s, wsPeer{group: "g", clientId: "c", ip: "10.0.0.2"}, map[string]func(string) string{
+				"b": func(string) string { return "new" },
+			})
+			if fc.client != old.client {
+				t.Fatal("应该继续使用原来的Clients")
+			}
+			if ip := fc.client.getIp(); ip != "10.0.0.2" {
+				t.Fatalf("ip = %s，应该是新连接的ip", ip)
+			}
+			if details := s.Clients("g"); len(details) != 1 || details[0].ClientIp != "10.0.0.2" {
+				t.Fatalf("/details里的ip不对：%+v", details)
+			}
+			if actions := fc.client.actionList(); actions != nil {
+				t.Fatalf("旧页面注册的方法还在：%v", actions)
+			}
+			// 旧页面没有注册b，新页面没有上报方法列表，不能拒绝
+			if body := decodeBody(t, serveRequest(s, http.MethodGet, "/go?group=g&action=b", "")); body["data"] != "new" {
+				t.Fatalf("/go = %v", body)
+			}
+
+			fc.ws.push("not json")
+			waitFor(t, "格式错误的消息封禁新连接的ip", func() bool {
+				banned, _ := s.banned("10.0.0.2")
+				return banned
+			})
+			if banned, _ := s.banned("10.0.0.1"); banned {
+				t.Fatal("封禁了旧连接的ip")
+			}
+		})
+	}
+}
+
+// 同一个key换了连接方式(ws和长轮询)时旧客户端下线，不会留在后台收发，清理时也不会删掉新客户端
+func TestCrossTransportSupersedes(t *testing.T) {
+	key := clientKey("", "g", "c")
+	closed := func(c *Clients) bool {
+		select {
+		case <-c.closed:
+			return true
+		default:
+			return false
+		}
+	}
+	t.Run("ws后长轮询", func(t *testing.T) {
+		s := newTestServer(t, nil)
+		fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+		if w := serveRequest(s, http.MethodPost, "/poll/register?group=g&clientId=c", ""); w.Code != http.StatusOK {
+			t.Fatalf("长轮询注册返回%d：%s", w.Code, w.Body.String())
+		}
+		fc.waitControl(t, actionSuperseded)
+		<-fc.done
+		if !closed(fc.client) || !fc.ws.isClosed() {
+			t.Fatal("旧的ws客户端应该下线")
+		}
+		value, ok := s.hlSyncMap.Load(key)
+		if !ok || value.(*Clients).transport != transportPoll {
+			t.Fatal("记录应该是新的长轮询客户端")
+		}
+		if details := s.Clients("g"); len(details) != 1 {
+			t.Fatalf("列表里有%d个客户端", len(details))
+		}
+	})
+	t.Run("长轮询后ws", func(t *testing.T) {
+		s := newTestServer(t, nil)
+		if w := serveRequest(s, http.MethodPost, "/poll/register?group=g&clientId=c", ""); w.Code != http.StatusOK {
+			t.Fatalf("长轮询注册返回%d：%s", w.Code, w.Body.String())
+		}
+		value, _ := s.hlSyncMap.Load(key)
+		old := value.(*Clients)
+		fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, echoHandlers())
+		if fc.client == old || !closed(old) {
+			t.Fatal("旧的长轮询客户端应该下线")
+		}
+		time.Sleep(100 * time.Millisecond) // 等watchPollClient清理旧客户端
+		if value, ok := s.hlSyncMap.Load(key); !ok || value.(*Clients) != fc.client {
+			t.Fatal("旧客户端的清理删掉了新的ws客户端")
+		}
+		if res, err := fc.client.Query(testContext(t), "hello", "hi"); err != nil || res != "hi" {
+			t.Fatalf("新客户端的请求 = %q, %v", res, err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrThrottled 等待发送的时间超过了Throttle.MaxWait
+var ErrThrottled = errors.New("throttled：请求太快，超过了客户端的QPS限制")
+
+const defaultThrottleMaxWait = 1000 // 毫秒
+
+// tokenBucket 令牌桶，容量为max(1, qps)
+type tokenBucket struct {
+	mu       sync.Mutex
+	override float64 // 通过/throttle单独设置的qps，0表示使用配置
+	tokens   float64
+	last     time.Time
+}
+
+// maxQps 客户端生效的qps，0不限制
+func (c *Clients) maxQps() float64 {
+	c.bucket.mu.Lock()
+	override := c.bucket.override
+	c.bucket.mu.Unlock()
+	if override > 0 || c.server == nil {
+		return override
+	}
+	conf := c.server.conf.Throttle
+	if qps, ok := conf.Groups[c.clientGroup]; ok {
+		return qps
+	}
+	return conf.MaxQps
+}
+
+func (c *Clients) throttleMaxWait() time.Duration {
+	ms := defaultThrottleMaxWait
+	if c.server != nil && c.server.conf.Throttle.MaxWait > 0 {
+		ms = c.server.conf.Throttle.MaxWait
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// pace 按qps控制发送速度，需要等待时在锁外sleep，等待超过MaxWait时返回ErrThrottled
+func (c *Clients) pace(ctx context.Context) error {
+	qps := c.maxQps()
+	if qps <= 0 {
+		return nil
+	}
+	burst := qps
+	if burst < 1 {
+		burst = 1
+	}
+	b := &c.bucket
+	b.mu.Lock()
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * qps
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / qps * float64(time.Second))
+		if wait > c.throttleMaxWait() {
+			b.mu.Unlock()
+			c.throttledCount.Add(1)
+			return ErrThrottled
+		}
+	}
+	b.tokens-- // 先占用令牌，等待期间后来的请求排在后面
+	b.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed:
+		return errClientClosed
+	}
+}
+
+// setThrottle 单独设置客户端的qps，0恢复使用配置
+func (c *Clients) setThrottle(qps float64) {
+	c.bucket.mu.Lock()
+	c.bucket.override = qps
+	c.bucket.mu.Unlock()
+}
+
+// throttle 单独设置某个客户端的qps
+func (s *Server) throttle(c *gin.Context) {
+	group, clientId := c.Query("group"), c.Query("clientId")
+	if group == "" || clientId == "" {
+		GinJsonMsg(c, http.StatusBadRequest, "需要传入group和clientId")
+		return
+	}
+	if !requireAdminGroup(c, group) {
+		return
+	}
+	qps, err := strconv.ParseFloat(c.DefaultQuery("maxQps", c.PostForm("maxQps")), 64)
+	if err != nil || qps < 0 {
+		GinJsonMsg(c, http.StatusBadRequest, "maxQps需要是不小于0的数字，0恢复使用配置")
+		return
+	}
+	client := s.getRandomClient(namespace(c), group, clientId)
+	if client == nil {
+		s.noClientResponse(c, group, clientId)
+		return
+	}
+	client.setThrottle(qps)
+	c.JSON(http.StatusOK, gin.H{"status": 200, "group": group, "clientId": clientId, "data": gin.H{"maxQps": client.maxQps()}})
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TrafficStats 收发的消息数和字节数，字节数是ws消息(或长轮询的一条消息)的长度
+type TrafficStats struct {
+	MsgsIn   int64 `json:"msgsIn"`
+	MsgsOut  int64 `json:"msgsOut"`
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+}
+
+// TrafficDetail 总数和每分钟的速率，速率按最近1到2分钟计算，刚开始统计时按1分钟算
+type TrafficDetail struct {
+	TrafficStats
+	PerMinute TrafficStats `json:"perMinute"`
+}
+
+type trafficMark struct {
+	stats TrafficStats
+	at    time.Time
+}
+
+// trafficCounter 收发时只做原子加法，计算速率的快照在读取时才更新
+type trafficCounter struct {
+	msgsIn, msgsOut, bytesIn, bytesOut atomic.Int64
+	since                              atomic.Int64 // 第一次收发的时间(unix纳秒)
+
+	mu    sync.Mutex
+	marks [2]trafficMark // 较早和较新的快照，较新的超过1分钟时轮换
+}
+
+func (t *trafficCounter) start() {
+	if t.since.Load() == 0 {
+		t.since.CompareAndSwap(0, time.Now().UnixNano())
+	}
+}
+
+func (t *trafficCounter) addIn(n int) {
+	t.start()
+	t.msgsIn.Add(1)
+	t.bytesIn.Add(int64(n))
+}
+
+func (t *trafficCounter) addOut(n int) {
+	t.start()
+	t.msgsOut.Add(1)
+	t.bytesOut.Add(int64(n))
+}
+
+func (t *trafficCounter) totals() TrafficStats {
+	return TrafficStats{
+		MsgsIn:   t.msgsIn.Load(),
+		MsgsOut:  t.msgsOut.Load(),
+		BytesIn:  t.bytesIn.Load(),
+		BytesOut: t.bytesOut.Load(),
+	}
+}
+
+func (t *trafficCounter) snapshot(now time.Time) TrafficDetail {
+	d := TrafficDetail{TrafficStats: t.totals()}
+	since := t.since.Load()
+	if since == 0 {
+		return d
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.marks[1].at.IsZero() {
+		t.marks[0].at = time.Unix(0, since)
+		t.marks[1] = t.marks[0]
+	}
+	if now.Sub(t.marks[1].at) >= time.Minute {
+		t.marks[0], t.marks[1] = t.marks[1], trafficMark{stats: d.TrafficStats, at: now}
+	}
+	base := t.marks[0]
+	minutes := max(now.Sub(base.at).Minutes(), 1)
+	rate := func(cur, prev int64) int64 { return int64(float64(cur-prev) / minutes) }
+	d.PerMinute = TrafficStats{
+		MsgsIn:   rate(d.MsgsIn, base.stats.MsgsIn),
+		MsgsOut:  rate(d.MsgsOut, base.stats.MsgsOut),
+		BytesIn:  rate(d.BytesIn, base.stats.BytesIn),
+		BytesOut: rate(d.BytesOut, base.stats.BytesOut),
+	}
+	return d
+}
+
+// countIn 客户端发来一条消息，同时计入group的累计，客户端下线重新连接后group的数据不会丢
+func (c *Clients) countIn(n int) {
+	c.traffic.addIn(n)
+	if c.groupTraffic != nil {
+		c.groupTraffic.addIn(n)
+	}
+}
+
+func (c *Clients) countOut(n int) {
+	c.traffic.addOut(n)
+	if c.groupTraffic != nil {
+		c.groupTraffic.addOut(n)
+	}
+}
+
+// groupTraffic group的累计计数，key和emptyQueue一样是scopedGroup
+func (s *Server) groupTraffic(ns string, group string) *trafficCounter {
+	value, _ := s.traffic.LoadOrStore(scopedGroup(ns, group), &trafficCounter{})
+	return value.(*trafficCounter)
+}
+
+// Traffic 每个group累计的收发统计，/stats里展示
+func (s *Server) Traffic() map[string]TrafficDetail {
+	now := time.Now()
+	res := make(map[string]TrafficDetail)
+	s.traffic.Range(func(key, value interface{}) bool {
+		res[key.(string)] = value.(*trafficCounter).snapshot(now)
+		return true
+	})
+	return res
+}
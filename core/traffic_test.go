@@ -0,0 +1,80 @@
+package core
+
+import (
+	"JsRpc/protocol"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrafficCounters(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	base := fc.client.traffic.totals()
+	if base.MsgsIn == 0 || base.MsgsOut == 0 {
+		t.Fatalf("注册时的_listActions应该已经计入：%+v", base)
+	}
+
+	report := "_pageInfohl^_^" + `{"url":"https://example.com","title":"标题"}`
+	fc.ws.push(report)
+	control, err := fc.client.codec().Encode(protocol.NewControl("_test", "中文数据"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.client.sendControl("_test", "中文数据"); err != nil {
+		t.Fatal(err)
+	}
+	want := TrafficStats{
+		MsgsIn:   base.MsgsIn + 1,
+		MsgsOut:  base.MsgsOut + 1,
+		BytesIn:  base.BytesIn + int64(len(report)),
+		BytesOut: base.BytesOut + int64(len(control)),
+	}
+	waitFor(t, "计数更新", func() bool { return fc.client.traffic.totals() == want })
+
+	// /details里是当前客户端的计数，刚开始统计时速率按1分钟算
+	body := decodeBody(t, serveRequest(s, http.MethodGet, "/details", ""))
+	clients, _ := body["data"].(map[string]interface{})["g"].([]interface{})
+	if len(clients) != 1 {
+		t.Fatalf("/details = %v", body)
+	}
+	traffic, _ := clients[0].(map[string]interface{})["traffic"].(map[string]interface{})
+	perMinute, _ := traffic["perMinute"].(map[string]interface{})
+	if traffic["bytesIn"] != float64(want.BytesIn) || traffic["msgsOut"] != float64(want.MsgsOut) || perMinute["bytesIn"] != float64(want.BytesIn) {
+		t.Fatalf("/details里的traffic = %v，期望 %+v", traffic, want)
+	}
+
+	// 客户端重新连接后自己的计数重新开始，group的累计保留
+	_ = fc.ws.Close()
+	<-fc.done
+	waitFor(t, "客户端下线", func() bool { return s.Client("g", "c") == nil })
+	fresh := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	if got := fresh.client.traffic.totals(); got.MsgsIn >= want.MsgsIn {
+		t.Fatalf("新连接的计数应该重新开始：%+v", got)
+	}
+	group := s.Traffic()["g"]
+	if group.MsgsIn <= want.MsgsIn || group.BytesOut <= want.BytesOut {
+		t.Fatalf("group的累计应该包括下线前的计数：%+v，下线前 %+v", group, want)
+	}
+}
+
+func TestTrafficPerMinute(t *testing.T) {
+	var counter trafficCounter
+	t0 := time.Now()
+	counter.since.Store(t0.UnixNano())
+	counter.addIn(600)
+
+	// 不到1分钟时按1分钟算
+	if d := counter.snapshot(t0.Add(30 * time.Second)); d.PerMinute.BytesIn != 600 || d.PerMinute.MsgsIn != 1 {
+		t.Fatalf("30秒时的速率 %+v", d.PerMinute)
+	}
+	if d := counter.snapshot(t0.Add(61 * time.Second)); d.PerMinute.BytesIn != 590 {
+		t.Fatalf("61秒时的速率 %+v", d.PerMinute)
+	}
+	counter.addIn(1200)
+	// 只按最近一次快照之后的增量计算
+	d := counter.snapshot(t0.Add(121 * time.Second))
+	if d.BytesIn != 1800 || d.PerMinute.BytesIn != 1200 {
+		t.Fatalf("121秒时 %+v", d)
+	}
+}
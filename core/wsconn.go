@@ -0,0 +1,15 @@
+package core
+
+import "time"
+
+// wsConn 客户端和调用端使用的ws连接，*websocket.Conn实现了它，测试里可以换成内存里的假连接。
+// 读只在连接自己的读循环里进行，写只在writeLoop(调用端是加锁的reply)里进行
+type wsConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetReadLimit(limit int64)
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
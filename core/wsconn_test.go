@@ -0,0 +1,306 @@
+package core
+
+import (
+	"JsRpc/config"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errFakeClosed = errors.New("fake ws closed")
+
+type fakeFrame struct {
+	kind int
+	data []byte
+}
+
+// fakeWs 内存里的wsConn：服务端写的消息进written，客户端发的消息通过push放进incoming
+type fakeWs struct {
+	incoming  chan fakeFrame
+	written   chan fakeFrame
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu        sync.Mutex
+	writeErr  error         // 不为nil时WriteMessage返回它
+	pingErr   error         // 不为nil时发送ping返回它
+	block     chan struct{} // 不为nil时WriteMessage阻塞到它或连接关闭
+	deadlines []time.Time   // 每次WriteMessage前设置的写超时
+	pings     int
+	onPong    func(string) error
+	readLimit int64
+}
+
+func newFakeWs() *fakeWs {
+	return &fakeWs{
+		incoming: make(chan fakeFrame, 64),
+		written:  make(chan fakeFrame, 1024),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (f *fakeWs) ReadMessage() (int, []byte, error) {
+	select {
+	case frame := <-f.incoming:
+		return frame.kind, frame.data, nil
+	case <-f.closed:
+		return 0, nil, errFakeClosed
+	}
+}
+
+func (f *fakeWs) WriteMessage(kind int, data []byte) error {
+	f.mu.Lock()
+	block, err := f.block, f.writeErr
+	f.mu.Unlock()
+	if block != nil {
+		select {
+		case <-block:
+		case <-f.closed:
+			return errFakeClosed
+		}
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case <-f.closed:
+		return errFakeClosed
+	case f.written <- fakeFrame{kind, data}:
+		return nil
+	}
+}
+
+func (f *fakeWs) WriteControl(kind int, data []byte, deadline time.Time) error {
+	if kind != websocket.PingMessage {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pings++
+	return f.pingErr
+}
+
+func (f *fakeWs) SetWriteDeadline(t time.Time) error {
+	f.mu.Lock()
+	f.deadlines = append(f.deadlines, t)
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeWs) SetReadLimit(limit int64) {
+	f.mu.Lock()
+	f.readLimit = limit
+	f.mu.Unlock()
+}
+
+func (f *fakeWs) SetPongHandler(h func(string) error) {
+	f.mu.Lock()
+	f.onPong = h
+	f.mu.Unlock()
+}
+
+func (f *fakeWs) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakeWs) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// push 模拟客户端发来一条文本消息
+func (f *fakeWs) push(data string) {
+	select {
+	case f.incoming <- fakeFrame{websocket.TextMessage, []byte(data)}:
+	case <-f.closed:
+	}
+}
+
+func (f *fakeWs) set(fn func(f *fakeWs)) {
+	f.mu.Lock()
+	fn(f)
+	f.mu.Unlock()
+}
+
+var _ wsConn = (*fakeWs)(nil)
+var _ wsConn = (*websocket.Conn)(nil)
+
+func TestWriteFailureCountsAndDrops(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	fc.ws.set(func(f *fakeWs) { f.writeErr = errors.New("broken pipe") })
+
+	start := time.Now()
+	_, err := fc.client.Query(testContext(t), "hello", "1")
+	if !errors.Is(err, ErrNoChannel) {
+		t.Fatalf("写入失败后请求应该马上返回ErrNoChannel，得到 %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("写入失败后等了%v，应该不用等到超时", time.Since(start))
+	}
+	if got := fc.client.failCount.Load(); got != 1 {
+		t.Fatalf("failCount = %d，期望1", got)
+	}
+	waitFor(t, "写入失败后客户端下线", func() bool { return s.Client("g", "c") == nil })
+	if !fc.ws.isClosed() {
+		t.Fatal("写入失败后应该关闭连接")
+	}
+}
+
+func TestPingFailureClosesConnection(t *testing.T) {
+	s := newTestServer(t, func(conf *config.ConfStruct) { conf.Websocket.PingInterval = 1 })
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	fc.ws.set(func(f *fakeWs) { f.pingErr = errors.New("ping failed") })
+
+	waitFor(t, "ping失败后客户端下线", func() bool { return s.Client("g", "c") == nil })
+	if !fc.ws.isClosed() {
+		t.Fatal("ping失败后应该关闭连接")
+	}
+	fc.ws.mu.Lock()
+	pings := fc.ws.pings
+	fc.ws.mu.Unlock()
+	if pings == 0 {
+		t.Fatal("没有发送ping")
+	}
+	if fc.client.lastPing.Load() == 0 {
+		t.Fatal("没有记录lastPing")
+	}
+}
+
+func TestPongUpdatesLastPong(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	fc.ws.mu.Lock()
+	onPong := fc.ws.onPong
+	fc.ws.mu.Unlock()
+	if onPong == nil {
+		t.Fatal("没有设置pong handler")
+	}
+	_ = onPong("")
+	if fc.client.lastPong.Load() == 0 {
+		t.Fatal("收到pong后没有记录lastPong")
+	}
+}
+
+func TestKickDuringBlockedWrite(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	fc.ws.set(func(f *fakeWs) { f.block = make(chan struct{}) }) // 写循环卡在WriteMessage里
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := fc.client.Query(testContext(t), "hello", "1")
+			errs <- err
+		}()
+	}
+	waitFor(t, "请求进入等待", func() bool { return fc.client.pendingCount() == 2 })
+	fc.client.close()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrNoChannel) {
+				t.Fatalf("断开后请求应该返回ErrNoChannel，得到 %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("写入卡住时断开客户端，请求没有马上返回")
+		}
+	}
+	waitFor(t, "客户端下线", func() bool { return s.Client("g", "c") == nil })
+	if err := fc.client.send(outboundFrame{data: []byte("x")}); !errors.Is(err, ErrNoChannel) {
+		t.Fatalf("下线后send应该返回ErrNoChannel，得到 %v", err)
+	}
+}
+
+func TestWriteDeadlineSetBeforeEachWrite(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, map[string]func(string) string{
+		"hello": func(param string) string { return "hi " + param },
+	})
+	before := time.Now()
+	res, err := fc.client.Query(testContext(t), "hello", "1")
+	if err != nil || res != "hi 1" {
+		t.Fatalf("Query = %q, %v", res, err)
+	}
+	fc.ws.mu.Lock()
+	deadlines := append([]time.Time(nil), fc.ws.deadlines...)
+	fc.ws.mu.Unlock()
+	if len(deadlines) == 0 {
+		t.Fatal("写入前没有设置写超时")
+	}
+	last := deadlines[len(deadlines)-1]
+	if d := last.Sub(before); d < writeTimeout-time.Second || d > writeTimeout+time.Second {
+		t.Fatalf("写超时是%v之后，期望约%v", d, writeTimeout)
+	}
+}
+
+func TestStalledWriterDoesNotBlockCaller(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	fc.ws.set(func(f *fakeWs) { f.block = make(chan struct{}) })
+	// 写循环卡住后队列会被填满，之后的发送在enqueueTimeout后失败，不会一直阻塞
+	start := time.Now()
+	var err error
+	for i := 0; i < outboundQueueSize+2 && err == nil; i++ {
+		err = fc.client.send(outboundFrame{data: []byte("x")})
+	}
+	if !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("队列满了应该返回ErrWriteFailed，得到 %v", err)
+	}
+	if d := time.Since(start); d > enqueueTimeout+time.Second {
+		t.Fatalf("发送阻塞了%v", d)
+	}
+}
+
+func TestStalledWriterKeepsHeartbeatAndFailsSecondRequest(t *testing.T) {
+	s := newTestServer(t, nil)
+	fc := startFake(t, s, wsPeer{group: "g", clientId: "c"}, nil)
+	fc.ws.set(func(f *fakeWs) { f.block = make(chan struct{}) })
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := fc.client.Query(testContext(t), "hello", "1")
+		first <- err
+	}()
+	waitFor(t, "第一个请求进入等待", func() bool { return fc.client.pendingCount() == 1 })
+	for i := 0; i < outboundQueueSize; i++ {
+		if err := fc.client.send(outboundFrame{data: []byte("x")}); err != nil {
+			t.Fatalf("队列还没满时send失败：%v", err)
+		}
+	}
+
+	// 读循环不经过写锁，写卡住时pong照样记录
+	fc.ws.mu.Lock()
+	onPong := fc.ws.onPong
+	fc.ws.mu.Unlock()
+	start := time.Now()
+	_ = onPong("")
+	if pong := fc.client.lastPong.Load(); pong < start.UnixNano() {
+		t.Fatal("写卡住时没有记录pong")
+	}
+
+	// 第二个请求在enqueueTimeout后失败，不用等第一个请求的写超时
+	_, err := fc.client.Query(testContext(t), "hello", "2")
+	if !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("队列满时第二个请求应该返回ErrWriteFailed，得到 %v", err)
+	}
+	if d := time.Since(start); d > enqueueTimeout+time.Second {
+		t.Fatalf("第二个请求等了%v才失败", d)
+	}
+	if fc.client.pendingCount() != 1 {
+		t.Fatalf("失败的请求应该从等待列表移除，剩余%d", fc.client.pendingCount())
+	}
+	select {
+	case err := <-first:
+		t.Fatalf("第一个请求不应该受影响，已经返回 %v", err)
+	default:
+	}
+}
@@ -0,0 +1,60 @@
+// gRPC调用示例：go run ./examples/grpc_client -addr 127.0.0.1:12090 -group zzz -action hello -param 123
+package main
+
+import (
+	"JsRpc/jsrpcpb"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:12090", "gRPC服务地址")
+	group := flag.String("group", "zzz", "客户端分组")
+	action := flag.String("action", "hello", "调用的action")
+	param := flag.String("param", "", "参数")
+	flag.Parse()
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer conn.Close()
+	c := jsrpcpb.NewJsRpcClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	clients, err := c.ListClients(ctx, &jsrpcpb.ListClientsRequest{Group: *group})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, client := range clients.Clients {
+		fmt.Println("在线客户端:", client.ClientId, client.ClientIp, client.PageUrl)
+	}
+
+	res, err := c.Call(ctx, &jsrpcpb.CallRequest{Group: *group, Action: *action, Param: *param, TimeoutMs: 10000})
+	switch status.Code(err) {
+	case codes.OK:
+		fmt.Printf("%s 返回(%dms): %s\n", res.ClientId, res.ElapsedMs, res.Data)
+	case codes.DeadlineExceeded:
+		fmt.Println("调用超时")
+	case codes.NotFound, codes.FailedPrecondition, codes.Unavailable:
+		reason := ""
+		for _, detail := range status.Convert(err).Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				reason = info.Reason
+			}
+		}
+		fmt.Println("没有可用的客户端:", reason, status.Convert(err).Message())
+	default:
+		log.Fatalln(err)
+	}
+}
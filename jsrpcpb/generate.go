@@ -0,0 +1,4 @@
+// Package jsrpcpb gRPC接口的生成代码，修改jsrpc.proto后执行go generate
+package jsrpcpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative jsrpc.proto
@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.27.1
+// source: jsrpc.proto
+
+package jsrpcpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	JsRpc_Call_FullMethodName        = "/jsrpc.JsRpc/Call"
+	JsRpc_ListClients_FullMethodName = "/jsrpc.JsRpc/ListClients"
+	JsRpc_WatchEvents_FullMethodName = "/jsrpc.JsRpc/WatchEvents"
+)
+
+// JsRpcClient is the client API for JsRpc service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// JsRpc 调用浏览器里注册的方法，和http接口共用同一批客户端
+type JsRpcClient interface {
+	// Call 超时返回DEADLINE_EXCEEDED，没有客户端返回UNAVAILABLE
+	Call(ctx context.Context, in *CallRequest, opts ...grpc.CallOption) (*CallResponse, error)
+	ListClients(ctx context.Context, in *ListClientsRequest, opts ...grpc.CallOption) (*ListClientsResponse, error)
+	// WatchEvents 推送客户端上线和下线
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ClientEvent], error)
+}
+
+type jsRpcClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewJsRpcClient(cc grpc.ClientConnInterface) JsRpcClient {
+	return &jsRpcClient{cc}
+}
+
+func (c *jsRpcClient) Call(ctx context.Context, in *CallRequest, opts ...grpc.CallOption) (*CallResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CallResponse)
+	err := c.cc.Invoke(ctx, JsRpc_Call_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jsRpcClient) ListClients(ctx context.Context, in *ListClientsRequest, opts ...grpc.CallOption) (*ListClientsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListClientsResponse)
+	err := c.cc.Invoke(ctx, JsRpc_ListClients_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jsRpcClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ClientEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &JsRpc_ServiceDesc.Streams[0], JsRpc_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchEventsRequest, ClientEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type JsRpc_WatchEventsClient = grpc.ServerStreamingClient[ClientEvent]
+
+// JsRpcServer is the server API for JsRpc service.
+// All implementations must embed UnimplementedJsRpcServer
+// for forward compatibility.
+//
+// JsRpc 调用浏览器里注册的方法，和http接口共用同一批客户端
+type JsRpcServer interface {
+	// Call 超时返回DEADLINE_EXCEEDED，没有客户端返回UNAVAILABLE
+	Call(context.Context, *CallRequest) (*CallResponse, error)
+	ListClients(context.Context, *ListClientsRequest) (*ListClientsResponse, error)
+	// WatchEvents 推送客户端上线和下线
+	WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[ClientEvent]) error
+	mustEmbedUnimplementedJsRpcServer()
+}
+
+// UnimplementedJsRpcServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedJsRpcServer struct{}
+
+func (UnimplementedJsRpcServer) Call(context.Context, *CallRequest) (*CallResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Call not implemented")
+}
+func (UnimplementedJsRpcServer) ListClients(context.Context, *ListClientsRequest) (*ListClientsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListClients not implemented")
+}
+func (UnimplementedJsRpcServer) WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[ClientEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedJsRpcServer) mustEmbedUnimplementedJsRpcServer() {}
+func (UnimplementedJsRpcServer) testEmbeddedByValue()               {}
+
+// UnsafeJsRpcServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to JsRpcServer will
+// result in compilation errors.
+type UnsafeJsRpcServer interface {
+	mustEmbedUnimplementedJsRpcServer()
+}
+
+func RegisterJsRpcServer(s grpc.ServiceRegistrar, srv JsRpcServer) {
+	// If the following call pancis, it indicates UnimplementedJsRpcServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&JsRpc_ServiceDesc, srv)
+}
+
+func _JsRpc_Call_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CallRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JsRpcServer).Call(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: JsRpc_Call_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JsRpcServer).Call(ctx, req.(*CallRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JsRpc_ListClients_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListClientsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JsRpcServer).ListClients(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: JsRpc_ListClients_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JsRpcServer).ListClients(ctx, req.(*ListClientsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JsRpc_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(JsRpcServer).WatchEvents(m, &grpc.GenericServerStream[WatchEventsRequest, ClientEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type JsRpc_WatchEventsServer = grpc.ServerStreamingServer[ClientEvent]
+
+// JsRpc_ServiceDesc is the grpc.ServiceDesc for JsRpc service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var JsRpc_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "jsrpc.JsRpc",
+	HandlerType: (*JsRpcServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Call",
+			Handler:    _JsRpc_Call_Handler,
+		},
+		{
+			MethodName: "ListClients",
+			Handler:    _JsRpc_ListClients_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _JsRpc_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "jsrpc.proto",
+}
@@ -4,14 +4,68 @@ import (
 	"JsRpc/config"
 	"JsRpc/core"
 	"JsRpc/utils"
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
 )
 
+// exitListenFailed 监听地址全部失败或者https证书加载失败时的退出码，其它启动错误是1
+const exitListenFailed = 3
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subCommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+	if runAsService() { // 由windows服务管理器启动
+		return
+	}
 	utils.PrintJsRpc() // 开屏打印
 
+	server, err := startServer("")
+	if errors.Is(err, core.ErrListen) {
+		log.Errorln(err)
+		os.Exit(exitListenFailed) // 和配置错误等区分开，方便守护进程报警
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	restarted := server.WatchRestart()                 // kill -USR2 平滑重启
+	utils.CloseTerminal(restarted, stopServer(server)) // 安全退出
+}
+
+// startServer 读取配置、初始化日志并启动监听，defaultLogFile是没有配置Log.File时使用的日志文件
+func startServer(defaultLogFile string) (*core.Server, error) {
 	baseConf := config.ReadConf()       // 读取日志信息
 	utils.InitLogger(baseConf.CloseLog) // 初始化日志
-	core.InitAPI(baseConf)              // 初始化api部分
+	if err := baseConf.Validate(); err != nil {
+		return nil, errors.New("配置文件有误：\n" + err.Error())
+	}
+	if baseConf.Log.File == "" {
+		baseConf.Log.File = defaultLogFile
+	}
+	if baseConf.Log.File != "" {
+		if err := utils.LogToFile(baseConf.Log.File); err != nil {
+			return nil, err
+		}
+	}
+	server, err := core.NewServer(baseConf)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.Start(); err != nil { // 启动http/https监听
+		return nil, err
+	}
+	return server, nil
+}
 
-	utils.CloseTerminal() // 安全退出
+// stopServer Ctrl+C、SIGTERM和服务停止都走这里：断开ws客户端，等待正在处理的请求
+func stopServer(server *core.Server) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Error(err)
+		}
+	}
 }
@@ -0,0 +1,314 @@
+// Package mockclient 模拟注入了JsEnv的浏览器客户端，用于没有浏览器的集成测试
+package mockclient
+
+import (
+	"JsRpc/protocol"
+	"JsRpc/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// Action 一个action的模拟返回，按 NoReply > Parts > Error > Json > Echo > Response 的顺序生效
+type Action struct {
+	Parts    []string        `json:"parts"`    // 分多次流式返回，每两部分之间等待Delay毫秒
+	Response string          `json:"response"` // 返回内容，支持 {{param}} 模板
+	Echo     bool            `json:"echo"`     // 原样返回param
+	Json     json.RawMessage `json:"json"`     // 返回固定的json
+	Error    string          `json:"error"`    // 模拟js执行出错，返回错误信息和结构化的error
+	NoReply  bool            `json:"noReply"`  // 不返回，用于模拟超时
+	Delay    int             `json:"delay"`    // 返回前等待的毫秒数
+}
+
+type Options struct {
+	Server    string            `json:"server"` // ws地址，如 ws://127.0.0.1:12080/ws，也可以写http地址
+	Group     string            `json:"group"`
+	ClientId  string            `json:"clientId"`
+	Actions   map[string]Action `json:"actions"`
+	Reconnect int               `json:"reconnect"` // 断线重连间隔秒数，默认3秒
+	Tags      map[string]string `json:"tags"`      // 通过_hello上报的标签
+	Protocol  int               `json:"protocol"`  // 上报的协议版本，默认是最新的版本，2模拟旧版JsEnv
+	Encoding  string            `json:"encoding"`  // 请求的编码，msgpack需要服务端开启Websocket.Msgpack
+	Secret    string            `json:"secret"`    // group在Security.Hmac里的共享密钥，验证请求的签名并签名返回
+}
+
+// LoadOptions 从json文件读取配置
+func LoadOptions(path string) (Options, error) {
+	var opts Options
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return opts, err
+	}
+	err = json.Unmarshal(data, &opts)
+	return opts, err
+}
+
+// wsURL 拼出带group和clientId的ws地址
+func (o Options) wsURL() (string, error) {
+	u, err := url.Parse(o.Server)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/ws"
+	}
+	q := u.Query()
+	q.Set("group", o.Group)
+	if o.ClientId != "" {
+		q.Set("clientId", o.ClientId)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Run 连接服务端并按配置回复请求，断线后自动重连，直到ctx结束
+func Run(ctx context.Context, opts Options) error {
+	if opts.Group == "" {
+		return errors.New("mockclient: group不能为空")
+	}
+	addr, err := opts.wsURL()
+	if err != nil {
+		return err
+	}
+	interval := time.Duration(opts.Reconnect) * time.Second
+	if interval <= 0 {
+		interval = 3 * time.Second
+	}
+	for {
+		err := serve(ctx, addr, opts)
+		if errors.Is(err, errReconnect) {
+			log.Info("服务端要求重连")
+			continue
+		}
+		if errors.Is(err, errSuperseded) {
+			log.Info("mock client已被同一个clientId的新连接接管，不再重连")
+			return nil
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Warning("mock client断开连接，", interval, "后重连: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+var (
+	// errReconnect 服务端平滑重启时发来_reconnect，马上重连
+	errReconnect = errors.New("server asked to reconnect")
+	// errSuperseded 同一个clientId的新连接接管了，不再重连
+	errSuperseded = errors.New("superseded by a new connection")
+)
+
+func serve(ctx context.Context, addr string, opts Options) error {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, addr, nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { _ = ws.Close() })
+	defer stop()
+	utils.LogPrint("mock client已连接", addr)
+	version := opts.Protocol
+	if version <= 0 {
+		version = protocol.VersionTyped
+	}
+	hello, _ := json.Marshal(map[string]interface{}{"protocolVersion": version, "scriptVersion": "mock-client",
+		"tags": opts.Tags, "encoding": opts.Encoding})
+	// 还不知道服务端的版本，按旧版格式上报；签名后是message_id为空的json返回
+	helloEnv := protocol.Response("", "_hello", string(hello))
+	if opts.Secret != "" {
+		helloEnv.Sign(opts.Secret, time.Now())
+	}
+	helloFrame, err := protocol.CodecFor(protocol.VersionMessageId, protocol.EncodingJson).Encode(helloEnv)
+	if err != nil {
+		return err
+	}
+	if err := ws.WriteMessage(websocket.TextMessage, helloFrame); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	replies := make(chan wsFrame, 16)
+	go func() { // 回复统一从这里写，延迟回复时不阻塞读取
+		for {
+			select {
+			case <-done:
+				return
+			case frame := <-replies:
+				if err := ws.WriteMessage(frame.kind, frame.data); err != nil {
+					_ = ws.Close()
+				}
+			}
+		}
+	}()
+	// 收到注册回执前按旧版格式回复，之后用双方都支持的版本
+	codec := protocol.CodecFor(protocol.VersionMessageId, protocol.EncodingJson)
+	skew := 30 * time.Second // 签名时间戳允许的偏差，注册回执里有服务端的配置
+	send := func(codec protocol.Codec, env protocol.Envelope) {
+		if opts.Secret != "" && env.Type == protocol.TypeResponse {
+			env.Sign(opts.Secret, time.Now())
+		}
+		data, err := codec.Encode(env)
+		if err != nil {
+			log.Error("mock client编码失败:", err)
+			return
+		}
+		frame := wsFrame{websocket.TextMessage, data}
+		if codec.Encoding() == protocol.EncodingMsgpack {
+			frame.kind = websocket.BinaryMessage
+		}
+		select {
+		case replies <- frame:
+		case <-done:
+		}
+	}
+
+	for {
+		kind, raw, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var msg protocol.Envelope
+		if kind == websocket.BinaryMessage {
+			msg, err = protocol.DecodeMsgpack(raw)
+		} else {
+			msg, err = protocol.Decode(raw)
+		}
+		if err != nil || msg.Type == protocol.TypeResponse {
+			log.Error("mock client消息格式错误:", string(raw))
+			continue
+		}
+		// 控制消息也要验证，旧版格式的控制消息是没有message_id的请求
+		if opts.Secret != "" {
+			if err := msg.Verify(opts.Secret, time.Now(), skew); err != nil {
+				log.Error("mock client丢弃签名验证失败的消息 action:", msg.Name(), " ", err)
+				continue
+			}
+		}
+		param := msg.Param
+		if msg.Control != nil {
+			param = msg.Control.Data
+		}
+		switch msg.Name() {
+		case "_registered": // 服务端的注册回执
+			var receipt struct {
+				ProtocolVersion int `json:"protocolVersion"`
+				SignMaxSkew     int `json:"signMaxSkew"`
+			}
+			_ = json.Unmarshal([]byte(param), &receipt)
+			codec = protocol.CodecFor(min(version, receipt.ProtocolVersion), protocol.EncodingJson)
+			if receipt.SignMaxSkew > 0 {
+				skew = time.Duration(receipt.SignMaxSkew) * time.Second
+			}
+			continue
+		case "_encoding": // 服务端同意的编码
+			codec = protocol.CodecFor(codec.Version(), param)
+			continue
+		case "_reconnect":
+			return errReconnect
+		case "_superseded":
+			return errSuperseded
+		case "_rejected":
+			log.Error("服务端拒绝了连接:", param)
+			continue
+		case "_listActions":
+			names := make([]string, 0, len(opts.Actions))
+			for name := range opts.Actions {
+				names = append(names, name)
+			}
+			list, _ := json.Marshal(names)
+			send(codec, protocol.Response(msg.Id, msg.Action, string(list)))
+			continue
+		}
+		if msg.Type == protocol.TypeControl { // /admin/broadcastControl发来的自定义控制消息
+			log.Info("mock client收到控制消息:", msg.Name(), " ", param)
+			continue
+		}
+		action, ok := opts.Actions[msg.Action]
+		if !ok {
+			send(codec, protocol.Response(msg.Id, msg.Action, "action not found"))
+			continue
+		}
+		if action.NoReply {
+			continue
+		}
+		if len(action.Parts) > 0 {
+			sendParts(codec, msg, action, send)
+			continue
+		}
+		res := protocol.Response(msg.Id, msg.Action, reply(action, param))
+		if msg.Await {
+			res.Data = awaitReply(action, res.Data)
+		} else if action.Error != "" {
+			res.Error = &protocol.JsError{Name: "Error", Message: action.Error}
+		}
+		if action.Delay <= 0 {
+			send(codec, res)
+			continue
+		}
+		delayed := codec // 延迟回复在另一个goroutine里编码
+		time.AfterFunc(time.Duration(action.Delay)*time.Millisecond, func() { send(delayed, res) })
+	}
+}
+
+// sendParts 按顺序发送流式返回的每一部分，最后一部分final为true
+func sendParts(codec protocol.Codec, msg protocol.Envelope, action Action, send func(protocol.Codec, protocol.Envelope)) {
+	delay := time.Duration(action.Delay) * time.Millisecond
+	go func() {
+		for i, data := range action.Parts {
+			if i > 0 {
+				time.Sleep(delay)
+			}
+			res := protocol.Response(msg.Id, msg.Action, data)
+			res.Part = &protocol.Part{Seq: i, Final: i == len(action.Parts)-1}
+			send(codec, res)
+		}
+	}()
+}
+
+// awaitReply 请求带await时和JsEnv一样返回 {ok, data/error}，Error模拟Promise被reject
+func awaitReply(action Action, data string) string {
+	res := map[string]interface{}{"ok": true, "data": data}
+	switch {
+	case action.Error != "":
+		res = map[string]interface{}{"ok": false, "error": action.Error}
+	case len(action.Json) > 0:
+		res["data"] = action.Json
+	}
+	b, _ := json.Marshal(res)
+	return string(b)
+}
+
+type wsFrame struct {
+	kind int
+	data []byte
+}
+
+func reply(action Action, param string) string {
+	switch {
+	case action.Error != "":
+		return action.Error
+	case len(action.Json) > 0:
+		return string(action.Json)
+	case action.Echo:
+		return param
+	}
+	return strings.ReplaceAll(action.Response, "{{param}}", param)
+}
@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	EncodingJson    = "json"
+	EncodingMsgpack = "msgpack" // 版本3的信封用MessagePack编码，通过ws的二进制帧发送
+)
+
+// msgpackEnvelope 和Envelope的字段相同，Args在json里是原始的数组，msgpack里转换成msgpack的数组，客户端不用再解析一次json
+type msgpackEnvelope struct {
+	Version int         `msgpack:"v"`
+	Type    Type        `msgpack:"type"`
+	Id      string      `msgpack:"id,omitempty"`
+	Action  string      `msgpack:"action,omitempty"`
+	Param   string      `msgpack:"param,omitempty"`
+	Args    interface{} `msgpack:"args,omitempty"`
+	Data    string      `msgpack:"data,omitempty"`
+	Chunk   *Chunk      `msgpack:"chunk,omitempty"`
+	Part    *Part       `msgpack:"part,omitempty"`
+	Control *Control    `msgpack:"control,omitempty"`
+	Ts      int64       `msgpack:"ts,omitempty"`
+	Sig     string      `msgpack:"sig,omitempty"`
+	Await   bool        `msgpack:"await,omitempty"`
+	Error   *JsError    `msgpack:"error,omitempty"`
+
+	ParamEncoding    string `msgpack:"paramEncoding,omitempty"`
+	ResponseEncoding string `msgpack:"responseEncoding,omitempty"`
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Version() int { return VersionTyped }
+
+func (msgpackCodec) Encoding() string { return EncodingMsgpack }
+
+func (msgpackCodec) Encode(env Envelope) ([]byte, error) {
+	if err := env.checkTyped(); err != nil {
+		return nil, err
+	}
+	m := msgpackEnvelope{Version: VersionTyped, Type: env.Type, Id: env.Id, Action: env.Action, Param: env.Param,
+		Data: env.Data, Chunk: env.Chunk, Part: env.Part, Control: env.Control, Ts: env.Ts, Sig: env.Sig,
+		Await: env.Await, Error: env.Error, ParamEncoding: env.ParamEncoding, ResponseEncoding: env.ResponseEncoding}
+	if len(env.Args) > 0 {
+		if err := json.Unmarshal(env.Args, &m.Args); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json") // Chunk和Control沿用json的字段名
+	if err := enc.Encode(&m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeMsgpack 解析ws二进制帧里的消息
+func DecodeMsgpack(msg []byte) (Envelope, error) {
+	var m msgpackEnvelope
+	dec := msgpack.NewDecoder(bytes.NewReader(msg))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&m); err != nil {
+		return Envelope{}, err
+	}
+	env := Envelope{Version: m.Version, Type: m.Type, Id: m.Id, Action: m.Action, Param: m.Param,
+		Data: m.Data, Chunk: m.Chunk, Part: m.Part, Control: m.Control, Ts: m.Ts, Sig: m.Sig,
+		Await: m.Await, Error: m.Error, ParamEncoding: m.ParamEncoding, ResponseEncoding: m.ResponseEncoding}
+	if m.Args != nil {
+		args, err := json.Marshal(m.Args)
+		if err != nil {
+			return env, err
+		}
+		env.Args = args
+	}
+	return env, env.checkTyped()
+}
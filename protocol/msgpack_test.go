@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTripMsgpack(t *testing.T) {
+	codec := CodecFor(VersionTyped, EncodingMsgpack)
+	if codec.Version() != VersionTyped || codec.Encoding() != EncodingMsgpack {
+		t.Fatalf("CodecFor = 版本%d %s", codec.Version(), codec.Encoding())
+	}
+	for _, tt := range roundTripCases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := codec.Encode(tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeMsgpack(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.env
+			want.Version = VersionTyped
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("得到 %+v\n期望 %+v", got, want)
+			}
+		})
+	}
+	if _, err := DecodeMsgpack([]byte(`{"v":3}`)); err == nil {
+		t.Fatal("json不应该能按msgpack解析")
+	}
+}
+
+// benchPayload 模拟size字节的页面html，带引号、换行和中文，json需要转义
+func benchPayload(size int) string {
+	unit := "<div class=\"item\" data-id='1'>\n\t价格：¥12.50 & \"库存\"</div>\n"
+	return strings.ToValidUTF8(strings.Repeat(unit, size/len(unit)+1)[:size], "")
+}
+
+// 500KB的返回编码再解码一次，wire-B/op是线路上的字节数，和 ./JsRpc bench-codec 测的相同
+func BenchmarkCodec(b *testing.B) {
+	payload := benchPayload(500 * 1024)
+	env := Response("b2f3c1d4", "getHtml", payload)
+	for _, encoding := range []string{EncodingJson, EncodingMsgpack} {
+		b.Run(encoding, func(b *testing.B) {
+			codec := CodecFor(VersionTyped, encoding)
+			decode := Decode
+			if encoding == EncodingMsgpack {
+				decode = DecodeMsgpack
+			}
+			b.SetBytes(int64(len(payload)))
+			var wire int
+			for i := 0; i < b.N; i++ {
+				data, err := codec.Encode(env)
+				if err != nil {
+					b.Fatal(err)
+				}
+				got, err := decode(data)
+				if err != nil || got.Data != payload {
+					b.Fatal("解码结果不一致：", err)
+				}
+				wire = len(data)
+			}
+			b.ReportMetric(float64(wire), "wire-B/op")
+		})
+	}
+}
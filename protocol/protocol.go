@@ -0,0 +1,312 @@
+// Package protocol 服务端和客户端之间消息的编解码，各个协议版本都转换成Envelope
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// VersionLegacy 只有 action+"hl^_^"+data 的旧版客户端
+	VersionLegacy = 1
+	// VersionMessageId 请求和返回是带message_id的json，大结果分片发送
+	VersionMessageId = 2
+	// VersionTyped 带type字段的信封，控制消息放在control里，不再靠下划线开头的action区分
+	VersionTyped = 3
+
+	legacySeparator = "hl^_^"
+)
+
+// Type 消息的类型
+type Type string
+
+const (
+	TypeRequest  Type = "request"  // 服务端发给客户端的调用
+	TypeResponse Type = "response" // 客户端返回的结果
+	TypeControl  Type = "control"  // 注册回执、上报、重连通知等
+)
+
+// Envelope 一条消息，Version是它实际使用的格式
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    Type            `json:"type"`
+	Id      string          `json:"id,omitempty"` // 请求和返回对应的message_id
+	Action  string          `json:"action,omitempty"`
+	Param   string          `json:"param,omitempty"`
+	Args    json.RawMessage `json:"args,omitempty"` // 多个参数时的json数组
+	Data    string          `json:"data,omitempty"` // 返回的内容
+	Chunk   *Chunk          `json:"chunk,omitempty"`
+	Part    *Part           `json:"part,omitempty"`
+	Control *Control        `json:"control,omitempty"`
+	Ts      int64           `json:"ts,omitempty"`    // 签名的毫秒时间戳，见Sign
+	Sig     string          `json:"sig,omitempty"`   // 配置了共享密钥时的HMAC签名
+	Await   bool            `json:"await,omitempty"` // 请求的结果是Promise时等待它完成，见README的awaitPromise
+	Error   *JsError        `json:"error,omitempty"` // 客户端执行时抛出的异常，Data里仍是异常的文字，兼容旧版服务端
+	// 请求的Param是base64编码的二进制，客户端解码成Uint8Array再传给方法
+	ParamEncoding string `json:"paramEncoding,omitempty"`
+	// 客户端把返回的二进制(Uint8Array、ArrayBuffer)用base64编码后放在Data里
+	ResponseEncoding string `json:"responseEncoding,omitempty"`
+}
+
+// EncodingBase64 ParamEncoding和ResponseEncoding目前只支持base64
+const EncodingBase64 = "base64"
+
+// JsError 客户端返回的异常信息
+type JsError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Stack   string `json:"stack,omitempty"`
+}
+
+// Control 控制消息，Name沿用旧版的action名，如 _registered、_hello
+type Control struct {
+	Name string `json:"name"`
+	Data string `json:"data,omitempty"`
+}
+
+// Chunk 结果太大时分片返回的一片
+type Chunk struct {
+	Seq   int    `json:"seq"`
+	Total int    `json:"total"`
+	Data  string `json:"data"`
+}
+
+// Part 流式返回的一部分，同一个id可以有多个，Final的是最后一个。和Chunk不同，每一部分都是完整的结果
+type Part struct {
+	Seq   int  `json:"seq"`
+	Final bool `json:"final"`
+}
+
+// Request 服务端发给客户端的调用
+func Request(id, action, param string, args json.RawMessage) Envelope {
+	return Envelope{Type: TypeRequest, Id: id, Action: action, Param: param, Args: args}
+}
+
+// Response 客户端返回的结果
+func Response(id, action, data string) Envelope {
+	return Envelope{Type: TypeResponse, Id: id, Action: action, Data: data}
+}
+
+// NewControl 控制消息
+func NewControl(name, data string) Envelope {
+	return Envelope{Type: TypeControl, Control: &Control{Name: name, Data: data}}
+}
+
+// Name 控制消息返回Control.Name，其它返回Action
+func (e Envelope) Name() string {
+	if e.Control != nil {
+		return e.Control.Name
+	}
+	return e.Action
+}
+
+// Codec 按协商的版本和编码编码发给对方的消息
+type Codec interface {
+	Version() int
+	Encoding() string // EncodingMsgpack时通过ws的二进制帧发送
+	Encode(env Envelope) ([]byte, error)
+}
+
+// CodecFor 协商出的版本对应的编码，VersionTyped以下都使用兼容旧版的json，msgpack只用于VersionTyped
+func CodecFor(version int, encoding string) Codec {
+	switch {
+	case version >= VersionTyped && encoding == EncodingMsgpack:
+		return msgpackCodec{}
+	case version >= VersionTyped:
+		return typedCodec{}
+	}
+	return legacyCodec{}
+}
+
+type typedCodec struct{}
+
+func (typedCodec) Version() int { return VersionTyped }
+
+func (typedCodec) Encoding() string { return EncodingJson }
+
+func (typedCodec) Encode(env Envelope) ([]byte, error) {
+	if err := env.checkTyped(); err != nil {
+		return nil, err
+	}
+	env.Version = VersionTyped
+	return json.Marshal(env)
+}
+
+// legacyFrame 旧版的json格式，请求、控制消息、返回和分片共用
+type legacyFrame struct {
+	Action       string          `json:"action"`
+	MessageId    string          `json:"message_id"`
+	Param        *string         `json:"param,omitempty"`
+	Args         json.RawMessage `json:"args,omitempty"`
+	ResponseData *string         `json:"response_data,omitempty"`
+	Seq          int             `json:"seq,omitempty"`
+	Total        int             `json:"total,omitempty"`
+	Chunk        string          `json:"chunk,omitempty"`
+	Final        *bool           `json:"final,omitempty"` // 流式返回，有这个字段时seq是部分的序号
+	Ts           int64           `json:"ts,omitempty"`
+	Sig          string          `json:"sig,omitempty"`
+	Type         Type            `json:"type,omitempty"` // 签过名时才有，旧版格式的控制消息和请求一样，签名要用到type
+	Await        bool            `json:"await,omitempty"`
+	Error        *JsError        `json:"error,omitempty"`
+
+	ParamEncoding    string `json:"paramEncoding,omitempty"`
+	ResponseEncoding string `json:"responseEncoding,omitempty"`
+}
+
+type legacyCodec struct{}
+
+func (legacyCodec) Version() int { return VersionMessageId }
+
+func (legacyCodec) Encoding() string { return EncodingJson }
+
+// Encode 控制消息和请求一样是 {action, message_id, param}；没有message_id的返回使用分隔符格式，
+// 签过名的上报没法放进分隔符格式，用message_id为空的json返回
+func (legacyCodec) Encode(env Envelope) ([]byte, error) {
+	if err := env.check(); err != nil {
+		return nil, err
+	}
+	frame := legacyFrame{Action: env.Action, MessageId: env.Id, Ts: env.Ts, Sig: env.Sig}
+	if env.Sig != "" {
+		frame.Type = env.Type
+	}
+	switch {
+	case env.Type == TypeControl:
+		frame.Action, frame.Param = env.Control.Name, &env.Control.Data
+	case env.Type == TypeRequest:
+		frame.Param, frame.Args, frame.Await = &env.Param, env.Args, env.Await
+		frame.ParamEncoding, frame.ResponseEncoding = env.ParamEncoding, env.ResponseEncoding
+	case env.Chunk != nil:
+		frame.Seq, frame.Total, frame.Chunk = env.Chunk.Seq, env.Chunk.Total, env.Chunk.Data
+	case env.Part != nil:
+		frame.ResponseData, frame.Seq, frame.Final = &env.Data, env.Part.Seq, &env.Part.Final
+	case env.Id == "" && env.Sig == "":
+		return []byte(env.Action + legacySeparator + env.Data), nil
+	default:
+		frame.ResponseData, frame.Error = &env.Data, env.Error
+	}
+	return json.Marshal(frame)
+}
+
+// check 编码前检查必须的字段
+func (e Envelope) check() error {
+	switch e.Type {
+	case TypeRequest:
+		if e.Action == "" {
+			return errors.New("protocol: 请求缺少action")
+		}
+	case TypeResponse:
+		if e.Chunk != nil && (e.Id == "" || e.Chunk.Total <= 0) {
+			return errors.New("protocol: 分片缺少id或total")
+		}
+		if e.Part != nil && e.Id == "" {
+			return errors.New("protocol: 流式返回缺少id")
+		}
+	case TypeControl:
+		if e.Control == nil || e.Control.Name == "" {
+			return errors.New("protocol: 控制消息缺少name")
+		}
+	default:
+		return fmt.Errorf("protocol: 未知的消息类型%q", e.Type)
+	}
+	return nil
+}
+
+// wireFrame 新版和旧版json格式的字段合在一起，只解析一次；chunk在新版里是对象，旧版里是字符串
+type wireFrame struct {
+	Version      int             `json:"v"`
+	Type         Type            `json:"type"`
+	Id           string          `json:"id"`
+	Action       string          `json:"action"`
+	Param        *string         `json:"param"`
+	Args         json.RawMessage `json:"args"`
+	Data         string          `json:"data"`
+	Control      *Control        `json:"control"`
+	Chunk        json.RawMessage `json:"chunk"`
+	Part         *Part           `json:"part"`
+	MessageId    string          `json:"message_id"`
+	ResponseData *string         `json:"response_data"`
+	Seq          int             `json:"seq"`
+	Total        int             `json:"total"`
+	Final        *bool           `json:"final"`
+	Ts           int64           `json:"ts"`
+	Sig          string          `json:"sig"`
+	Await        bool            `json:"await"`
+	Error        *JsError        `json:"error"`
+
+	ParamEncoding    string `json:"paramEncoding"`
+	ResponseEncoding string `json:"responseEncoding"`
+}
+
+// Decode 按消息本身的格式解析：带v和type的是新版信封，其它按旧版解析。
+// 客户端在收到注册回执前发出的消息仍是旧格式，所以不按连接协商的版本解析。
+// 旧版消息分不出是返回还是上报，都当作返回，由调用方按action判断
+func Decode(msg []byte) (Envelope, error) {
+	var frame wireFrame
+	if len(msg) > 0 && msg[0] == '{' && json.Unmarshal(msg, &frame) == nil {
+		if frame.Version >= VersionTyped {
+			return frame.typed()
+		}
+		if env, ok := frame.legacy(); ok {
+			return env, nil
+		}
+	}
+	// 不是认识的json时按分隔符格式解析，返回的内容本身可能以{开头
+	action, data, ok := strings.Cut(string(msg), legacySeparator)
+	if !ok || action == "" {
+		return Envelope{}, errors.New("protocol: 无法识别的消息")
+	}
+	return Envelope{Version: VersionLegacy, Type: TypeResponse, Action: action, Data: data}, nil
+}
+
+func (f wireFrame) typed() (Envelope, error) {
+	env := Envelope{Version: f.Version, Type: f.Type, Id: f.Id, Action: f.Action, Args: f.Args, Data: f.Data, Part: f.Part, Control: f.Control,
+		Ts: f.Ts, Sig: f.Sig, Await: f.Await, Error: f.Error, ParamEncoding: f.ParamEncoding, ResponseEncoding: f.ResponseEncoding}
+	if f.Param != nil {
+		env.Param = *f.Param
+	}
+	if len(f.Chunk) > 0 && string(f.Chunk) != "null" {
+		env.Chunk = &Chunk{}
+		if err := json.Unmarshal(f.Chunk, env.Chunk); err != nil {
+			return env, err
+		}
+	}
+	return env, env.checkTyped()
+}
+
+func (f wireFrame) legacy() (Envelope, bool) {
+	env := Envelope{Version: VersionMessageId, Id: f.MessageId, Action: f.Action, Ts: f.Ts, Sig: f.Sig, Await: f.Await}
+	switch {
+	case f.Total > 0 && f.MessageId != "":
+		var chunk string
+		if len(f.Chunk) > 0 && json.Unmarshal(f.Chunk, &chunk) != nil {
+			return env, false
+		}
+		env.Type, env.Chunk = TypeResponse, &Chunk{Seq: f.Seq, Total: f.Total, Data: chunk}
+	case f.ResponseData != nil:
+		env.Type, env.Data, env.Error = TypeResponse, *f.ResponseData, f.Error
+		if f.Final != nil && f.MessageId != "" {
+			env.Part = &Part{Seq: f.Seq, Final: *f.Final}
+		}
+	case f.Param != nil && f.Action != "" && f.Type == TypeControl:
+		env.Type, env.Control = TypeControl, &Control{Name: f.Action, Data: *f.Param}
+	case f.Param != nil && f.Action != "":
+		env.Type, env.Param, env.Args = TypeRequest, *f.Param, f.Args
+		env.ParamEncoding, env.ResponseEncoding = f.ParamEncoding, f.ResponseEncoding
+	default:
+		return env, false
+	}
+	return env, true
+}
+
+// checkTyped 新版格式里返回都要带id，上报改用控制消息
+func (e Envelope) checkTyped() error {
+	if err := e.check(); err != nil {
+		return err
+	}
+	if e.Type == TypeResponse && e.Id == "" {
+		return errors.New("protocol: 返回缺少id")
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roundTripCases 各种消息，legacy是按旧版格式编码再解析后得到的信封，为空时和原消息相同
+var roundTripCases = []struct {
+	name   string
+	env    Envelope
+	legacy *Envelope
+}{
+	{name: "请求", env: Request("1", "hello", "中文 & \"q\"\n", nil)},
+	{name: "请求带args", env: Request("2", "add", "", json.RawMessage(`["a",1,{"b":null}]`))},
+	{name: "请求二进制参数", env: Envelope{Type: TypeRequest, Id: "3", Action: "upload", Param: "AAEC", Await: true,
+		ParamEncoding: EncodingBase64, ResponseEncoding: EncodingBase64}},
+	{name: "返回", env: Response("4", "hello", `{"json":"开头"}`)},
+	{name: "空返回", env: Response("5", "hello", "")},
+	{name: "返回异常", env: Envelope{Type: TypeResponse, Id: "6", Action: "boom", Data: "TypeError: x",
+		Error: &JsError{Name: "TypeError", Message: "x", Stack: "at <anonymous>:1:1"}}},
+	{name: "分片", env: Envelope{Type: TypeResponse, Id: "7", Action: "big", Chunk: &Chunk{Seq: 2, Total: 3, Data: "hl^_^片"}}},
+	{name: "流式", env: Envelope{Type: TypeResponse, Id: "8", Action: "stream", Data: "part", Part: &Part{Seq: 1, Final: true}}},
+	{name: "签名的返回", env: Envelope{Type: TypeResponse, Id: "9", Action: "hello", Data: "x", Ts: 1700000000000, Sig: "abcd"}},
+	// 旧版的控制消息和请求格式相同，解析后是请求
+	{name: "控制消息", env: NewControl("_registered", `{"protocolVersion":3}`),
+		legacy: &Envelope{Type: TypeRequest, Action: "_registered", Param: `{"protocolVersion":3}`}},
+	{name: "控制消息没有data", env: NewControl("_reconnect", ""),
+		legacy: &Envelope{Type: TypeRequest, Action: "_reconnect"}},
+}
+
+func TestRoundTripTyped(t *testing.T) {
+	codec := CodecFor(VersionTyped, EncodingJson)
+	for _, tt := range roundTripCases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := codec.Encode(tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode(data)
+			if err != nil {
+				t.Fatalf("解析%s失败：%v", data, err)
+			}
+			want := tt.env
+			want.Version = VersionTyped
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("编码 %s\n得到 %+v\n期望 %+v", data, got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripLegacy(t *testing.T) {
+	codec := CodecFor(VersionMessageId, EncodingJson)
+	for _, tt := range roundTripCases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := codec.Encode(tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode(data)
+			if err != nil {
+				t.Fatalf("解析%s失败：%v", data, err)
+			}
+			want := tt.env
+			if tt.legacy != nil {
+				want = *tt.legacy
+			}
+			want.Version = VersionMessageId
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("编码 %s\n得到 %+v\n期望 %+v", data, got, want)
+			}
+		})
+	}
+}
+
+// 旧版客户端依赖的格式不能变
+func TestLegacyWireFormat(t *testing.T) {
+	codec := CodecFor(VersionLegacy, EncodingJson)
+	tests := []struct {
+		env  Envelope
+		want string
+	}{
+		{Request("1", "hello", "x", nil), `{"action":"hello","message_id":"1","param":"x"}`},
+		{NewControl("_reconnect", ""), `{"action":"_reconnect","message_id":"","param":""}`},
+		{Response("", "_pageInfo", `{"url":"u"}`), `_pageInfohl^_^{"url":"u"}`},
+		{Response("1", "hello", "x"), `{"action":"hello","message_id":"1","response_data":"x"}`},
+		{Envelope{Type: TypeResponse, Id: "1", Action: "big", Chunk: &Chunk{Seq: 0, Total: 2, Data: "ab"}},
+			`{"action":"big","message_id":"1","total":2,"chunk":"ab"}`},
+	}
+	for _, tt := range tests {
+		data, err := codec.Encode(tt.env)
+		if err != nil || string(data) != tt.want {
+			t.Errorf("Encode(%+v) = %s, %v\n期望 %s", tt.env, data, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeLegacyFormats(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want Envelope
+	}{
+		{"hellohl^_^hi", Envelope{Version: VersionLegacy, Type: TypeResponse, Action: "hello", Data: "hi"}},
+		// 分隔符格式的内容可以是json
+		{`_pageInfohl^_^{"url":"u"}`, Envelope{Version: VersionLegacy, Type: TypeResponse, Action: "_pageInfo", Data: `{"url":"u"}`}},
+		// 内容里再出现分隔符时只切第一个
+		{"ahl^_^bhl^_^c", Envelope{Version: VersionLegacy, Type: TypeResponse, Action: "a", Data: "bhl^_^c"}},
+		// 以{开头但不是认识的json时按分隔符格式解析
+		{`{broken}hl^_^x`, Envelope{Version: VersionLegacy, Type: TypeResponse, Action: "{broken}", Data: "x"}},
+		{`{"action":"_status","message_id":"","response_data":"{}"}`,
+			Envelope{Version: VersionMessageId, Type: TypeResponse, Action: "_status", Data: "{}"}},
+	}
+	for _, tt := range tests {
+		got, err := Decode([]byte(tt.msg))
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%s) = %+v, %v\n期望 %+v", tt.msg, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, msg := range []string{
+		"",
+		"no separator",
+		"hl^_^没有action",
+		`{}`,
+		`{"action":"a","message_id":"1"}`,
+		`{"v":3,"type":"response","action":"a","data":"x"}`, // 新版返回必须带id
+		`{"v":3,"type":"control"}`,
+		`{"v":3,"type":"unknown"}`,
+		`{"v":3,"type":"response","id":"1","chunk":"旧版的chunk是字符串"}`,
+	} {
+		if env, err := Decode([]byte(msg)); err == nil {
+			t.Errorf("Decode(%s)应该失败，得到 %+v", msg, env)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   Envelope
+		typed bool // 只有新版格式拒绝
+	}{
+		{"请求缺少action", Request("1", "", "x", nil), false},
+		{"控制消息缺少name", NewControl("", "x"), false},
+		{"控制消息缺少control", Envelope{Type: TypeControl}, false},
+		{"未知类型", Envelope{Type: "push", Action: "a"}, false},
+		{"分片缺少total", Envelope{Type: TypeResponse, Id: "1", Chunk: &Chunk{}}, false},
+		{"分片缺少id", Envelope{Type: TypeResponse, Chunk: &Chunk{Total: 1}}, false},
+		{"流式缺少id", Envelope{Type: TypeResponse, Part: &Part{}}, false},
+		{"返回缺少id", Response("", "_pageInfo", "x"), true},
+	}
+	for _, tt := range tests {
+		if _, err := CodecFor(VersionTyped, EncodingJson).Encode(tt.env); err == nil || !strings.HasPrefix(err.Error(), "protocol: ") {
+			t.Errorf("%s：新版格式应该返回protocol错误，得到 %v", tt.name, err)
+		}
+		_, err := CodecFor(VersionMessageId, EncodingJson).Encode(tt.env)
+		if tt.typed && err != nil {
+			t.Errorf("%s：旧版格式应该能编码，得到 %v", tt.name, err)
+		} else if !tt.typed && err == nil {
+			t.Errorf("%s：旧版格式也应该拒绝", tt.name)
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		version  int
+		encoding string
+		want     int
+	}{
+		{VersionLegacy, EncodingJson, VersionMessageId},
+		{VersionMessageId, EncodingMsgpack, VersionMessageId}, // msgpack只用于版本3
+		{VersionTyped, EncodingJson, VersionTyped},
+		{VersionTyped + 1, "", VersionTyped},
+	}
+	for _, tt := range tests {
+		codec := CodecFor(tt.version, tt.encoding)
+		if codec.Version() != tt.want || codec.Encoding() != EncodingJson {
+			t.Errorf("CodecFor(%d, %q) = 版本%d %s", tt.version, tt.encoding, codec.Version(), codec.Encoding())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBadSignature     = errors.New("protocol: 签名错误")
+	ErrSignatureExpired = errors.New("protocol: 签名的时间戳超出允许的偏差")
+)
+
+// signedPayload 请求签名param，控制消息签名Control.Data，返回签名data，分片签名这一片的内容
+func (e Envelope) signedPayload() string {
+	switch {
+	case e.Type == TypeRequest:
+		return e.Param
+	case e.Control != nil:
+		return e.Control.Data
+	case e.Chunk != nil:
+		return e.Chunk.Data
+	}
+	return e.Data
+}
+
+// signEscaper 字段里的\和换行转义，字段用换行连接，内容里的换行不能把一个字段的内容挪到另一个字段
+var signEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// signedText 签名的内容，依次是 type action id payload ts await paramEncoding responseEncoding 和异常的name message stack，
+// 每个字段按signEscaper转义后用换行连接。await是1或0，没有异常时后三个字段为空
+func (e Envelope) signedText() string {
+	await := "0"
+	if e.Await {
+		await = "1"
+	}
+	var jsErr JsError
+	if e.Error != nil {
+		jsErr = *e.Error
+	}
+	fields := []string{string(e.Type), e.Name(), e.Id, e.signedPayload(), strconv.FormatInt(e.Ts, 10), await,
+		e.ParamEncoding, e.ResponseEncoding, jsErr.Name, jsErr.Message, jsErr.Stack}
+	for i, field := range fields {
+		fields[i] = signEscaper.Replace(field)
+	}
+	return strings.Join(fields, "\n")
+}
+
+// Signature HMAC-SHA256(secret, signedText) 的十六进制，客户端按同样的内容计算，控制消息的action是Control.Name
+func (e Envelope) Signature(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(e.signedText()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign 设置毫秒时间戳和签名。请求带args时param改成args的json文本，客户端验证后用param里的参数，
+// 这样msgpack把args转换成数组也不影响签名
+func (e *Envelope) Sign(secret string, now time.Time) {
+	if e.Type == TypeRequest && len(e.Args) > 0 {
+		e.Param = string(e.Args)
+	}
+	e.Ts = now.UnixMilli()
+	e.Sig = e.Signature(secret)
+}
+
+// Verify 检查签名，时间戳和now相差超过skew时返回ErrSignatureExpired，防止重放
+func (e Envelope) Verify(secret string, now time.Time, skew time.Duration) error {
+	if e.Sig == "" || !hmac.Equal([]byte(e.Sig), []byte(e.Signature(secret))) {
+		return ErrBadSignature
+	}
+	if d := now.Sub(time.UnixMilli(e.Ts)); d > skew || d < -skew {
+		return ErrSignatureExpired
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	skew := 30 * time.Second
+	signed := func(env Envelope) Envelope {
+		env.Sign("secret", now)
+		return env
+	}
+
+	for _, env := range []Envelope{
+		Request("1", "hello", "x", nil),
+		Response("1", "hello", "结果"),
+		NewControl("_status", `{"busy":true}`),
+		{Type: TypeResponse, Id: "1", Action: "big", Chunk: &Chunk{Seq: 1, Total: 2, Data: "片"}},
+	} {
+		env := signed(env)
+		if env.Ts != now.UnixMilli() || len(env.Sig) != 64 {
+			t.Fatalf("签名后ts=%d sig=%q", env.Ts, env.Sig)
+		}
+		if err := env.Verify("secret", now.Add(skew), skew); err != nil {
+			t.Fatalf("%+v 验证失败：%v", env, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(env *Envelope)
+		secret string
+		at     time.Time
+		want   error
+	}{
+		{"密钥不同", func(*Envelope) {}, "other", now, ErrBadSignature},
+		{"没有签名", func(env *Envelope) { env.Sig = "" }, "secret", now, ErrBadSignature},
+		{"改了param", func(env *Envelope) { env.Param = "y" }, "secret", now, ErrBadSignature},
+		{"改了action", func(env *Envelope) { env.Action = "bye" }, "secret", now, ErrBadSignature},
+		{"改了id", func(env *Envelope) { env.Id = "2" }, "secret", now, ErrBadSignature},
+		{"改了时间戳", func(env *Envelope) { env.Ts++ }, "secret", now, ErrBadSignature},
+		{"改了type", func(env *Envelope) { env.Type = TypeResponse }, "secret", now, ErrBadSignature},
+		{"加上await", func(env *Envelope) { env.Await = true }, "secret", now, ErrBadSignature},
+		{"加上paramEncoding", func(env *Envelope) { env.ParamEncoding = EncodingBase64 }, "secret", now, ErrBadSignature},
+		{"加上responseEncoding", func(env *Envelope) { env.ResponseEncoding = EncodingBase64 }, "secret", now, ErrBadSignature},
+		{"重放", func(*Envelope) {}, "secret", now.Add(skew + time.Millisecond), ErrSignatureExpired},
+		{"时间戳在未来", func(*Envelope) {}, "secret", now.Add(-skew - time.Millisecond), ErrSignatureExpired},
+	}
+	for _, tt := range tests {
+		env := signed(Request("1", "hello", "x", nil))
+		tt.tamper(&env)
+		if err := env.Verify(tt.secret, tt.at, skew); !errors.Is(err, tt.want) {
+			t.Errorf("%s：期望%v，得到%v", tt.name, tt.want, err)
+		}
+	}
+}
+
+// 返回里的异常也签名，内容里的换行不能把一个字段的内容挪到另一个字段
+func TestSignError(t *testing.T) {
+	now := time.Now()
+	env := Response("1", "hello", "x")
+	env.Error = &JsError{Name: "TypeError", Message: "a\nb", Stack: "c"}
+	env.Sign("secret", now)
+	for name, tamper := range map[string]func(env *Envelope){
+		"去掉异常":      func(env *Envelope) { env.Error = nil },
+		"改了message": func(env *Envelope) { env.Error = &JsError{Name: "TypeError", Message: "a", Stack: "b\nc"} },
+		"挪到返回里":     func(env *Envelope) { env.Data, env.Error = "x\nTypeError", &JsError{Message: "a\nb", Stack: "c"} },
+	} {
+		tampered := env
+		tamper(&tampered)
+		if err := tampered.Verify("secret", now, time.Minute); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s：期望%v，得到%v", name, ErrBadSignature, err)
+		}
+	}
+	if err := env.Verify("secret", now, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 旧版格式的控制消息和请求长得一样，签过名时带上type，客户端解析后仍是控制消息，签名能验证
+func TestSignLegacyControl(t *testing.T) {
+	env := NewControl("_reconnect", "x")
+	env.Sign("secret", time.Now())
+	data, err := CodecFor(VersionMessageId, EncodingJson).Encode(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != TypeControl || got.Name() != "_reconnect" {
+		t.Fatalf("解析成了%+v", got)
+	}
+	if err := got.Verify("secret", time.Now(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 带args的请求签名args的json文本，msgpack把args转换成数组后客户端仍能验证param
+func TestSignArgs(t *testing.T) {
+	env := Request("1", "add", "", json.RawMessage(`[1,2]`))
+	env.Sign("secret", time.Now())
+	if env.Param != "[1,2]" {
+		t.Fatalf("param应该是args的json，得到%q", env.Param)
+	}
+	data, err := CodecFor(VersionTyped, EncodingMsgpack).Encode(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeMsgpack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := got.Verify("secret", time.Now(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 开启Security.Hmac后每条消息额外的开销，和 ./JsRpc bench-codec 最后一行测的相同
+func BenchmarkSign(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		env  Envelope
+	}{
+		{"request", Request("b2f3c1d4", "hello", "param", nil)},
+		{"500KB", Response("b2f3c1d4", "getHtml", benchPayload(500*1024))},
+	} {
+		b.Run(bench.name+"/sign", func(b *testing.B) {
+			b.SetBytes(int64(len(bench.env.signedPayload())))
+			for i := 0; i < b.N; i++ {
+				env := bench.env
+				env.Sign("secret", time.Now())
+			}
+		})
+		b.Run(bench.name+"/verify", func(b *testing.B) {
+			env := bench.env
+			env.Sign("secret", time.Now())
+			b.SetBytes(int64(len(env.signedPayload())))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := env.Verify("secret", time.Now(), time.Minute); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
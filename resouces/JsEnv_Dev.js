@@ -1,16 +1,65 @@
 function Hlclient(wsURL) {
+    var _this = this;
     this.wsURL = wsURL;
     this.handlers = {
         _execjs: function (resolve, param) {
-            var res = eval(param)
+            try {
+                var res = eval(param)
+            } catch (e) {
+                resolve.error(e) // 服务端能区分异常和正常的字符串结果
+                return
+            }
             if (!res) {
                 resolve("没有返回值")
             } else {
                 resolve(res)
             }
 
+        },
+        _getCookie: function (resolve) {
+            resolve(document.cookie)
+        },
+        _getHtml: function (resolve, param) {
+            if (!param || !param.selector) {
+                resolve(document.documentElement.outerHTML)
+                return
+            }
+            var els = param.all ? Array.from(document.querySelectorAll(param.selector))
+                : [document.querySelector(param.selector)].filter(Boolean)
+            resolve(JSON.stringify(els.map(function (e) {
+                return e.outerHTML
+            })))
+        },
+        _getStorage: function (resolve, param) {
+            try {
+                var s = window[(param.type || "local") + "Storage"], d = {}
+                if (param.key) {
+                    d = s.getItem(param.key)
+                } else {
+                    for (var i = 0; i < s.length; i++) {
+                        d[s.key(i)] = s.getItem(s.key(i))
+                    }
+                }
+                resolve(JSON.stringify({ok: true, data: d}))
+            } catch (e) {
+                resolve(JSON.stringify({ok: false, error: String(e)}))
+            }
+        },
+        _listActions: function (resolve) {
+            resolve(Object.keys(_this.handlers))
+        },
+        _getPageInfo: function (resolve) {
+            resolve(JSON.stringify(getPageInfo()))
+        },
+        _navigate: function (resolve, param) {
+            resolve("ok")
+            setTimeout(function () {
+                location.href = param
+            }, 100)
         }
     };
+    // 自定义控制消息的处理方法，通过onControl注册
+    this.controlHandlers = {};
     this.socket = undefined;
     if (!wsURL) {
         throw new Error('wsURL can not be empty!!')
@@ -21,9 +70,17 @@ function Hlclient(wsURL) {
 Hlclient.prototype.connect = function () {
     console.log('begin of connect to wsURL: ' + this.wsURL);
     var _this = this;
+    this.protocolVersion = 0; // 收到注册回执前按旧版格式发送
+    this.encoding = "json";
     try {
         this.socket = new WebSocket(this.wsURL);
+        this.socket.binaryType = "arraybuffer";
         this.socket.onmessage = function (e) {
+            if (typeof e.data !== "string") {
+                // 协商了msgpack后服务端用二进制帧发送
+                _this.handlerRequest(Hlclient.msgpack.decode(new Uint8Array(e.data)))
+                return
+            }
             _this.handlerRequest(e.data)
         }
     } catch (e) {
@@ -40,6 +97,8 @@ Hlclient.prototype.connect = function () {
     }
     this.socket.addEventListener('open', (event) => {
         console.log("rpc连接成功");
+        _this.sendResult("_hello", getHello());
+        _this.sendResult("_pageInfo", getPageInfo());
     });
     this.socket.addEventListener('error', (event) => {
         console.error('rpc连接出错,请检查是否打开服务端:', event.error);
@@ -59,52 +118,474 @@ Hlclient.prototype.regAction = function (func_name, func) {
     }
     console.log("register func_name: " + func_name);
     this.handlers[func_name] = func;
+    this.reportActions("_registerActions", [func_name]);
+    return true
+
+}
+
+// onControl 注册自定义控制消息的处理方法 func(data)，data是json时已经解析过；控制消息不需要返回结果
+Hlclient.prototype.onControl = function (name, func) {
+    if (typeof name !== 'string' || typeof func !== 'function') {
+        throw new Error("name must be string and func must be function");
+    }
+    this.controlHandlers[name] = func;
     return true
+}
+
+Hlclient.prototype.unregAction = function (func_name) {
+    delete this.handlers[func_name];
+    this.reportActions("_unregisterActions", [func_name]);
+    return true
+}
 
+// 上报页面是否忙碌和负载(0-1)，没有指定clientId调用时服务端会少选或者跳过忙碌的客户端
+Hlclient.prototype.reportStatus = function (busy, load) {
+    this.sendResult("_status", {busy: !!busy, load: load || 0})
+}
+
+// 主动推送页面事件(token刷新、出现验证码等)，调用方通过/events/pull或/events获取
+Hlclient.prototype.pushEvent = function (name, data) {
+    this.sendResult("_event", {name: name, data: data})
+}
+
+// 连接后再注册或移除的方法主动告诉服务端，连接时服务端会用_listActions获取全部方法
+Hlclient.prototype.reportActions = function (action, names) {
+    if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+        this.sendResult(action, names)
+    }
 }
 
 //收到消息后这里处理，
 Hlclient.prototype.handlerRequest = function (requestJson) {
     var _this = this;
+    var result = requestJson
     try {
-        var result = JSON.parse(requestJson)
+        if (typeof requestJson === "string") {
+            result = JSON.parse(requestJson)
+        }
     } catch (error) {
         console.log("catch error", requestJson);
         result = transjson(requestJson)
     }
     //console.log(result)
+    if (result && result["v"] >= 3) {
+        // 新版消息，控制消息的名字和内容在control里，签名验证要用到ts和sig
+        var control = result["control"] || {}
+        result = result["type"] === "control" ? {type: "control", action: control.name, param: control.data, ts: result["ts"], sig: result["sig"], isControl: true}
+            : {type: result["type"], action: result["action"], message_id: result["id"], param: result["param"], args: result["args"], ts: result["ts"], sig: result["sig"],
+                await: result["await"], paramEncoding: result["paramEncoding"], responseEncoding: result["responseEncoding"]}
+    }
     if (!result['action']) {
         this.sendResult('', 'need request param {action}');
         return
     }
     var action = result["action"]
+    var messageId = result["message_id"]
+    if (Hlclient.secret && !result.verified) {
+        // 配置了共享密钥时只处理签名正确、时间戳没有过期的消息，控制消息也一样，防止伪造的_reconnect、_superseded
+        var skew = ((this.server && this.server.signMaxSkew) || 30) * 1000
+        // 旧版格式签过名的消息也带type，区分控制消息和请求
+        this.sign({
+            type: result["type"] || "request", action: action, id: messageId, data: result["param"], ts: result["ts"], await: result["await"],
+            paramEncoding: result["paramEncoding"], responseEncoding: result["responseEncoding"]
+        }).then(function (sig) {
+            if (sig !== result["sig"] || Math.abs(Date.now() - result["ts"]) > skew) {
+                console.error("消息的签名验证失败，已丢弃:", action)
+                return
+            }
+            result.verified = true
+            if (Array.isArray(result["args"])) {
+                result["args"] = JSON.parse(result["param"]) // 签名的是param里args的json文本
+            }
+            _this.handlerRequest(result)
+        })
+        return
+    }
+    if (action === "_rejected") {
+        console.error("服务端拒绝了连接:", result["param"])
+        return
+    }
+    if (action === "_reconnect") {
+        // 服务端平滑重启，马上重连到新进程，不等onclose里的10秒
+        this.socket.onclose = null;
+        this.socket.close();
+        this.connect();
+        return
+    }
+    if (action === "_superseded") {
+        // 同一个clientId的新连接(通常是刷新后的页面)接管了，不再重连，避免两个页面互相抢
+        console.warn("rpc连接已被新连接接管:", result["param"])
+        this.socket.onclose = null;
+        return
+    }
+    if (action === "_encoding") {
+        // 服务端同意的编码，之后按这个编码发送
+        this.encoding = result["param"]
+        return
+    }
+    if (action === "_registered") {
+        // 服务端的注册回执，里面有协议版本、消息大小上限等，不需要返回
+        this.server = JSON.parse(result["param"])
+        // 双方都支持时改用带type字段的新版格式
+        this.protocolVersion = Math.min(Hlclient.protocolVersion, this.server.protocolVersion || 1)
+        return
+    }
+    var controlHandler = this.controlHandlers[action]
+    if (result.isControl || (controlHandler && !messageId)) {
+        // 管理接口/admin/broadcastControl发来的自定义控制消息，不需要返回
+        if (controlHandler) {
+            var data = result["param"]
+            try {
+                data = JSON.parse(data)
+            } catch (e) {}
+            try {
+                controlHandler(data)
+            } catch (e) {
+                console.log("control error: " + e);
+            }
+        }
+        return
+    }
     var theHandler = this.handlers[action];
     if (!theHandler) {
-        this.sendResult(action, 'action not found');
+        this.sendResult(action, 'action not found', messageId);
         return
     }
+    // 结果分多次返回时先多次调用resolve.part(部分结果)，最后调用resolve(最后一部分)
+    var seq = 0
+    var resolve = function (response) {
+        if (seq > 0) {
+            _this.sendPart(action, response, messageId, seq, true)
+            return
+        }
+        _this.sendResult(action, response, messageId);
+    }
+    resolve.part = function (response) {
+        if (!messageId) { // 旧版服务端不带message_id，不支持流式返回
+            return
+        }
+        _this.sendPart(action, response, messageId, seq++, false)
+    }
+    // 执行出错时调用，返回里带上异常的name、message和stack，旧版服务端仍然拿到异常的文字
+    resolve.error = function (e) {
+        _this.sendResult(action, String(e), messageId, jsError(e))
+    }
+    if (result["await"]) {
+        resolve = awaitResolve(resolve)
+    }
+    if (result["responseEncoding"] === "base64") {
+        resolve = base64Resolve(resolve)
+    }
     try {
+        if (Array.isArray(result["args"])) {
+            // 多个参数时按位置传给方法 handler(resolve, arg1, arg2...)
+            theHandler.apply(this, [resolve].concat(result["args"]))
+            return
+        }
         if (!result["param"]) {
-            theHandler(function (response) {
-                _this.sendResult(action, response);
-            })
+            theHandler(resolve)
             return
         }
         var param = result["param"]
+        if (result["paramEncoding"] === "base64") {
+            // 二进制参数，方法拿到的是Uint8Array
+            theHandler(resolve, base64ToBytes(param))
+            return
+        }
         try {
             param = JSON.parse(param)
         } catch (e) {}
-        theHandler(function (response) {
-            _this.sendResult(action, response);
-        }, param)
+        theHandler(resolve, param)
 
     } catch (e) {
         console.log("error: " + e);
-        _this.sendResult(action, e);
+        resolve.error(e);
+    }
+}
+
+// sendPart 发送流式返回的一部分，每一部分是完整的一段结果，不再分片
+Hlclient.prototype.sendPart = function (action, e, messageId, seq, final) {
+    e = resultText(e)
+    if (this.protocolVersion >= 3) {
+        this.sendResponse({v: 3, type: "response", id: messageId, action: action, data: e, part: {seq: seq, final: final}})
+        return
+    }
+    this.sendResponse({action: action, message_id: messageId, response_data: e, seq: seq, final: final})
+}
+
+Hlclient.prototype.sendResult = function (action, e, messageId, error) {
+    e = resultText(e)
+    var typed = this.protocolVersion >= 3
+    if (typed && !messageId) {
+        // 新版格式里没有message_id的都是上报，作为控制消息发送
+        this.sendResponse({v: 3, type: "control", control: {name: action, data: e}})
+        return
+    }
+    if (e.length > Hlclient.chunkSize) {
+        // 结果太大时分片发送，服务端收齐后再拼起来
+        messageId = messageId || Date.now() + "_" + Math.random().toString(36).slice(2)
+        var chunks = [], pos = 0
+        while (pos < e.length) {
+            var end = Math.min(pos + Hlclient.chunkSize, e.length)
+            var code = e.charCodeAt(end - 1)
+            if (end < e.length && code >= 0xD800 && code <= 0xDBFF) {
+                end-- // 不要把emoji这类代理对拆到两个分片里
+            }
+            chunks.push(e.slice(pos, end))
+            pos = end
+        }
+        for (var i = 0; i < chunks.length; i++) {
+            if (typed) {
+                this.sendResponse({
+                    v: 3, type: "response", id: messageId, action: action,
+                    chunk: {seq: i, total: chunks.length, data: chunks[i]}
+                })
+                continue
+            }
+            this.sendResponse({
+                action: action,
+                message_id: messageId,
+                seq: i,
+                total: chunks.length,
+                chunk: chunks[i]
+            })
+        }
+        return
+    }
+    if (typed) {
+        this.sendResponse({v: 3, type: "response", id: messageId, action: action, data: e, error: error})
+        return
+    }
+    if (messageId || Hlclient.secret) {
+        // 带上请求的message_id，同一个action并发调用时服务端也不会对错结果；签名的上报也用json，分隔符格式带不了签名
+        this.sendResponse({action: action, message_id: messageId || "", response_data: e, error: error})
+        return
+    }
+    this.send(action + atob("aGxeX14") + e);
+}
+
+// sendResponse 发送返回和上报，配置了共享密钥时先签名。签名是异步的，排队发送保证分片和流式返回的顺序
+Hlclient.prototype.sendResponse = function (msg) {
+    var _this = this
+    var typed = msg.v >= 3
+    var send = function () {
+        typed ? _this.sendTyped(msg) : _this.send(JSON.stringify(msg))
+    }
+    if (!Hlclient.secret) {
+        send()
+        return
     }
+    var control = msg.control // 上报的控制消息按名字和内容签名
+    var id = (typed ? msg.id : msg.message_id) || ""
+    var data = control ? control.data : typed ? (msg.chunk ? msg.chunk.data : msg.data) : (msg.chunk !== undefined ? msg.chunk : msg.response_data)
+    msg.ts = Date.now()
+    this.signing = (this.signing || Promise.resolve()).then(function () {
+        return _this.sign({
+            type: typed ? msg.type : "response", action: control ? control.name : msg.action, id: id, data: data, ts: msg.ts,
+            await: msg.await, paramEncoding: msg.paramEncoding, responseEncoding: msg.responseEncoding, error: msg.error
+        })
+    }).then(function (sig) {
+        msg.sig = sig
+        send()
+    }).catch(function (e) {
+        console.error("签名失败:", e)
+    })
 }
 
-Hlclient.prototype.sendResult = function (action, e) {
+// sign HMAC-SHA256签名，内容和服务端protocol.Envelope.Signature一致：依次是type action id 参数或返回 毫秒时间戳
+// await(1或0) paramEncoding responseEncoding 和异常的name message stack，每个字段里的\和换行转义后用换行连接。
+// 需要crypto.subtle，只有https页面和localhost可用
+Hlclient.prototype.sign = function (msg) {
+    var encoder = new TextEncoder()
+    if (this.hmacSecret !== Hlclient.secret) {
+        this.hmacSecret = Hlclient.secret
+        this.hmacKey = crypto.subtle.importKey("raw", encoder.encode(Hlclient.secret), {name: "HMAC", hash: "SHA-256"}, false, ["sign"])
+    }
+    var error = msg.error || {}
+    var fields = [msg.type, msg.action, msg.id, msg.data, msg.ts, msg.await ? "1" : "0", msg.paramEncoding, msg.responseEncoding,
+        error.name, error.message, error.stack].map(function (field) {
+        return String(field === undefined || field === null ? "" : field).replace(/\\/g, "\\\\").replace(/\n/g, "\\n")
+    })
+    return this.hmacKey.then(function (key) {
+        return crypto.subtle.sign("HMAC", key, encoder.encode(fields.join("\n")))
+    }).then(function (buf) {
+        return Array.prototype.map.call(new Uint8Array(buf), function (b) {
+            return ("0" + b.toString(16)).slice(-2)
+        }).join("")
+    })
+}
+
+// sendTyped 发送新版格式的消息，协商了msgpack时用二进制帧
+Hlclient.prototype.sendTyped = function (msg) {
+    if (this.encoding === "msgpack") {
+        this.send(Hlclient.msgpack.encode(msg))
+        return
+    }
+    this.send(JSON.stringify(msg))
+}
+
+// 超过这个长度的结果分片发送
+Hlclient.chunkSize = 512 * 1024
+
+// 不能使用websocket的环境改用http长轮询，用法 new HlPollClient("http://127.0.0.1:12080", "zzz")
+// 服务端配置了Namespaces时传入token
+function HlPollClient(baseURL, group, clientId, token) {
+    this.baseURL = String(baseURL).replace(/\/+$/, "");
+    this.group = group;
+    this.clientId = clientId || "";
+    this.token = token || "";
+    Hlclient.call(this, this.baseURL)
+}
+
+HlPollClient.prototype = Object.create(Hlclient.prototype);
+HlPollClient.prototype.constructor = HlPollClient;
+
+HlPollClient.prototype.url = function (path) {
+    var url = this.baseURL + path + "?group=" + encodeURIComponent(this.group) + "&clientId=" + encodeURIComponent(this.clientId)
+    if (this.token) {
+        url += "&token=" + encodeURIComponent(this.token)
+    }
+    return url
+}
+
+HlPollClient.prototype.connect = function () {
+    var _this = this;
+    fetch(this.url("/poll/register"), {method: "POST"}).then(function (r) {
+        return r.json()
+    }).then(function (res) {
+        _this.clientId = res.data.clientId;
+        _this.server = res.data;
+        _this.protocolVersion = Math.min(Hlclient.protocolVersion, res.data.protocolVersion || 1);
+        console.log("rpc轮询连接成功");
+        _this.sendResult("_hello", getHello());
+        _this.sendResult("_pageInfo", getPageInfo());
+        _this.pull()
+    }).catch(function (e) {
+        console.log("轮询注册失败,10s后重试", e);
+        setTimeout(function () {
+            _this.connect()
+        }, 10000)
+    })
+}
+
+HlPollClient.prototype.reportActions = function (action, names) {
+    if (this.server) { // 已经注册成功
+        this.sendResult(action, names)
+    }
+}
+
+HlPollClient.prototype.pull = function () {
+    var _this = this;
+    fetch(this.url("/poll/pull")).then(function (r) {
+        if (r.status === 404) {
+            throw new Error("客户端已下线")
+        }
+        return r.json()
+    }).then(function (res) {
+        res.data.forEach(function (msg) {
+            _this.handlerRequest(JSON.stringify(msg))
+        });
+        _this.pull()
+    }).catch(function (e) {
+        console.log("轮询断开,重新注册", e);
+        setTimeout(function () {
+            _this.connect()
+        }, 3000)
+    })
+}
+
+HlPollClient.prototype.send = function (msg) {
+    fetch(this.url("/poll/push"), {method: "POST", body: msg})
+}
+
+// 协议版本，和服务端的core.ProtocolVersion对应
+Hlclient.protocolVersion = 3
+Hlclient.scriptVersion = "1.0"
+// 页面上有msgpack库时设置成{encode, decode}(如@msgpack/msgpack的MessagePack)，服务端开启Websocket.Msgpack后改用msgpack编码
+Hlclient.msgpack = null
+// 客户端标签，调用方可以用 selector=region=us 只选择这些客户端，也可以写在连接地址的tags参数里
+Hlclient.tags = {}
+// 服务端Security.Hmac.Secrets里这个group的共享密钥，设置后验证请求的签名并签名返回
+Hlclient.secret = ""
+
+// awaitResolve 服务端要求awaitPromise时，结果是Promise就等它完成，成功和失败都按 {ok, data/error} 的json返回
+function awaitResolve(resolve) {
+    var wrapped = function (response) {
+        Promise.resolve(response).then(function (data) {
+            var text
+            try {
+                text = JSON.stringify({ok: true, data: data})
+            } catch (e) {
+                text = JSON.stringify({ok: true, data: String(data)})
+            }
+            resolve(text)
+        }, function (e) {
+            resolve(awaitError(e))
+        })
+    }
+    wrapped.part = resolve.part
+    wrapped.error = function (e) {
+        resolve(awaitError(e))
+    }
+    return wrapped
+}
+
+// jsError 异常对象转成 {name, message, stack}，throw的不是Error时name为空
+function jsError(e) {
+    var isError = e !== null && typeof e === "object"
+    return {
+        name: isError && e.name ? String(e.name) : "",
+        message: isError && e.message !== undefined ? String(e.message) : String(e),
+        stack: isError && e.stack ? String(e.stack) : ""
+    }
+}
+
+// base64Resolve 服务端要求responseEncoding=base64时，把Uint8Array、ArrayBuffer等二进制结果编码成base64返回，
+// 字符串按每个字符一个字节处理。出错时仍返回异常的文字
+function base64Resolve(resolve) {
+    var wrapped = function (response) {
+        resolve(bytesToBase64(response))
+    }
+    wrapped.part = resolve.part
+    wrapped.error = resolve.error
+    return wrapped
+}
+
+function base64ToBytes(text) {
+    var binary = atob(text || "")
+    var bytes = new Uint8Array(binary.length)
+    for (var i = 0; i < binary.length; i++) {
+        bytes[i] = binary.charCodeAt(i)
+    }
+    return bytes
+}
+
+function bytesToBase64(data) {
+    var bytes
+    if (data instanceof ArrayBuffer) {
+        bytes = new Uint8Array(data)
+    } else if (ArrayBuffer.isView(data)) {
+        bytes = new Uint8Array(data.buffer, data.byteOffset, data.byteLength)
+    } else {
+        var text = String(data === undefined || data === null ? "" : data)
+        bytes = new Uint8Array(text.length)
+        for (var j = 0; j < text.length; j++) {
+            bytes[j] = text.charCodeAt(j) & 0xff
+        }
+    }
+    var binary = ""
+    for (var i = 0; i < bytes.length; i += 0x8000) {
+        binary += String.fromCharCode.apply(null, bytes.subarray(i, i + 0x8000))
+    }
+    return btoa(binary)
+}
+
+function awaitError(e) {
+    return JSON.stringify({ok: false, error: String(e && e.message || e)})
+}
+
+// resultText 对象转成json，其它转成字符串
+function resultText(e) {
     if (typeof e === 'object' && e !== null) {
         try {
             e = JSON.stringify(e)
@@ -112,7 +593,29 @@ Hlclient.prototype.sendResult = function (action, e) {
             console.log(v)//不是json无需操作
         }
     }
-    this.send(action + atob("aGxeX14") + e);
+    return String(e)
+}
+
+function getHello() {
+    return {
+        protocolVersion: Hlclient.protocolVersion,
+        encoding: Hlclient.msgpack ? "msgpack" : undefined,
+        scriptVersion: Hlclient.scriptVersion,
+        userAgent: navigator.userAgent,
+        pageUrl: location.href,
+        tags: Hlclient.tags
+    }
+}
+
+function getPageInfo() {
+    return {
+        url: location.href,
+        title: document.title,
+        readyState: document.readyState,
+        userAgent: navigator.userAgent,
+        screenWidth: screen.width,
+        screenHeight: screen.height
+    }
 }
 
 function transjson(formdata) {
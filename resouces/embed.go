@@ -0,0 +1,7 @@
+// Package resouces 浏览器端注入的js代码
+package resouces
+
+import _ "embed"
+
+//go:embed JsEnv_Dev.js
+var JsEnv string
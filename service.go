@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const defaultServiceName = "JsRpc"
+
+// serviceOptions install/uninstall/start/stop子命令的参数
+type serviceOptions struct {
+	Name       string
+	ConfigPath string // 绝对路径，服务启动时的工作目录不是当前目录
+	Systemd    bool
+}
+
+func serviceCommand(name string, run func(opts serviceOptions) error) func(args []string) int {
+	return func(args []string) int {
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		opts := serviceOptions{}
+		fs.StringVar(&opts.Name, "name", defaultServiceName, "服务名")
+		fs.StringVar(&opts.ConfigPath, "c", "config.yaml", "服务使用的配置文件，install时使用")
+		fs.BoolVar(&opts.Systemd, "systemd", false, "linux下生成systemd服务")
+		_ = fs.Parse(args)
+		var err error
+		if opts.ConfigPath, err = filepath.Abs(opts.ConfigPath); err == nil {
+			err = run(opts)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, name, "失败:", err)
+			return 1
+		}
+		fmt.Println(name, "成功")
+		return 0
+	}
+}
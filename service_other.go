@@ -0,0 +1,87 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const systemdDir = "/etc/systemd/system"
+
+func runAsService() bool {
+	return false
+}
+
+// installService 只支持生成systemd服务，停止时systemd发送SIGTERM，和Ctrl+C的退出流程一样
+func installService(opts serviceOptions) error {
+	if !opts.Systemd {
+		return errors.New("windows以外的系统请使用 install --systemd")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	path := unitPath(opts.Name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s已经存在", path)
+	}
+	if err := os.WriteFile(path, []byte(systemdUnit(opts.Name, exe, opts.ConfigPath)), 0644); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", unitName(opts.Name))
+}
+
+func uninstallService(opts serviceOptions) error {
+	_ = systemctl("disable", "--now", unitName(opts.Name))
+	if err := os.Remove(unitPath(opts.Name)); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+func startService(opts serviceOptions) error {
+	return systemctl("start", unitName(opts.Name))
+}
+
+func stopService(opts serviceOptions) error {
+	return systemctl("stop", unitName(opts.Name))
+}
+
+func unitName(name string) string {
+	return strings.ToLower(name) + ".service"
+}
+
+func unitPath(name string) string {
+	return filepath.Join(systemdDir, unitName(name))
+}
+
+// systemdUnit 工作目录是配置文件所在目录，配置里的相对路径(证书、日志等)按这个目录解析
+func systemdUnit(name, exe, configPath string) string {
+	return fmt.Sprintf(`[Unit]
+Description=%s 浏览器远程调用服务
+After=network.target
+
+[Service]
+ExecStart=%q -c %q
+WorkingDirectory=%s
+Restart=on-failure
+KillSignal=SIGTERM
+
+[Install]
+WantedBy=multi-user.target
+`, name, exe, configPath, filepath.Dir(configPath))
+}
+
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
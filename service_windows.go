@@ -0,0 +1,143 @@
+//go:build windows
+
+package main
+
+import (
+	"JsRpc/utils"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// runAsService 由服务管理器启动时按服务运行，返回true表示已经运行结束
+func runAsService() bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	// 服务没有终端，没有配置Log.File时日志写到程序目录下
+	exe, _ := os.Executable()
+	if err := svc.Run(defaultServiceName, &jsrpcService{logFile: filepath.Join(filepath.Dir(exe), "jsrpc.log")}); err != nil {
+		log.Error("服务运行失败:", err)
+	}
+	return true
+}
+
+type jsrpcService struct {
+	logFile string
+}
+
+// Execute 服务的停止和系统关机走和Ctrl+C一样的退出流程
+func (j *jsrpcService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	server, err := startServer(j.logFile)
+	if err != nil {
+		log.Error(err)
+		return true, 1
+	}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			log.Println("- EXIT - 服务停止")
+			ctx, cancel := context.WithTimeout(context.Background(), utils.ShutdownTimeout)
+			stopServer(server)(ctx)
+			cancel()
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+func installService(opts serviceOptions) error {
+	if opts.Systemd {
+		return errors.New("--systemd只能在linux下使用")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(opts.Name); err == nil {
+		s.Close()
+		return fmt.Errorf("服务%s已经存在", opts.Name)
+	}
+	s, err := m.CreateService(opts.Name, exe, mgr.Config{
+		DisplayName: opts.Name,
+		Description: "JsRpc 浏览器远程调用服务",
+		StartType:   mgr.StartAutomatic,
+	}, "-c", opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return nil
+}
+
+func uninstallService(opts serviceOptions) error {
+	return withService(opts.Name, func(s *mgr.Service) error {
+		if status, err := s.Query(); err == nil && status.State != svc.Stopped {
+			if err := stopAndWait(s); err != nil {
+				return err
+			}
+		}
+		return s.Delete()
+	})
+}
+
+func startService(opts serviceOptions) error {
+	return withService(opts.Name, func(s *mgr.Service) error {
+		return s.Start()
+	})
+}
+
+func stopService(opts serviceOptions) error {
+	return withService(opts.Name, stopAndWait)
+}
+
+func withService(name string, fn func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("打开服务%s失败: %w", name, err)
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+// stopAndWait 发送停止命令，等服务退出完成
+func stopAndWait(s *mgr.Service) error {
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(utils.ShutdownTimeout + 5*time.Second)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return errors.New("等待服务停止超时")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,8 +1,130 @@
 package utils
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
 
-func ConcatCode(code string) string {
-	// 拼接页面元素的js
-	return fmt.Sprintf("(function(){return %s;})()", code)
+// JsCode 生成在页面执行的代码。调用方的输入用With声明成变量，值经过json编码，任意字符串都是安全的字面量；
+// 代码包在try里，结果统一是 {"ok":true,"data":...} 或 {"ok":false,"error":"..."} 的json字符串，用ParseJsResult解析
+type JsCode struct {
+	vars []string
+	body string
+}
+
+// NewJsCode expr是返回结果的表达式，里面只能通过With声明的变量引用调用方的输入
+func NewJsCode(expr string) *JsCode {
+	return &JsCode{body: "return " + strings.TrimSpace(expr)} // return后面换行会返回undefined
+}
+
+// NewJsBlock 多条语句，自己return结果
+func NewJsBlock(body string) *JsCode {
+	return &JsCode{body: body}
+}
+
+// With 声明变量，name由调用方写死，不能来自用户输入
+func (b *JsCode) With(name string, value interface{}) *JsCode {
+	literal, err := json.Marshal(value) // 已经转义了引号、反斜杠、换行和U+2028/U+2029
+	if err != nil {
+		literal = []byte("null")
+	}
+	b.vars = append(b.vars, "var "+name+"="+string(literal)+";")
+	return b
+}
+
+func (b *JsCode) String() string {
+	return "(function(){" + strings.Join(b.vars, "") +
+		"try{var r=(function(){" + b.body + "\n})();return JSON.stringify({ok:true,data:r})}" +
+		"catch(e){return JSON.stringify({ok:false,error:String(e&&e.message||e)})}})()"
+}
+
+// JsResult JsCode执行后的结果
+type JsResult struct {
+	Ok    bool
+	Data  json.RawMessage
+	Error string
+}
+
+// Text 结果是字符串时返回字符串本身，其它类型返回json文本，没有返回值时为空
+func (r JsResult) Text() string {
+	var text string
+	if json.Unmarshal(r.Data, &text) == nil {
+		return text
+	}
+	if string(r.Data) == "null" {
+		return ""
+	}
+	return string(r.Data)
+}
+
+// ParseJsResult raw不是JsCode生成的结果时(超时、客户端内置方法的返回)第二个返回值为false
+func ParseJsResult(raw string) (JsResult, bool) {
+	var res struct {
+		Ok    *bool           `json:"ok"`
+		Data  json.RawMessage `json:"data"`
+		Error string          `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(raw), &res); err != nil || res.Ok == nil {
+		return JsResult{}, false
+	}
+	return JsResult{Ok: *res.Ok, Data: res.Data, Error: res.Error}, true
+}
+
+// SelectorCode 按css选择器取元素的outerHTML数组，没找到时是空数组
+func SelectorCode(selector string, all bool) string {
+	pick := "[document.querySelector(s)].filter(Boolean)"
+	if all {
+		pick = "Array.from(document.querySelectorAll(s))"
+	}
+	return NewJsCode(pick+".map(function(e){return e.outerHTML})").With("s", selector).String()
+}
+
+// StorageCode 读取localStorage/sessionStorage，沙箱iframe等页面访问storage会抛异常，返回ok为false
+func StorageCode(storageType string, key string) string {
+	read := "var d={};for(var i=0;i<s.length;i++){var k=s.key(i);d[k]=s.getItem(k)}"
+	if key != "" {
+		read = "var d=s.getItem(k)"
+	}
+	return NewJsBlock("var s=window[t];"+read+";return d").
+		With("t", storageType+"Storage").With("k", key).String()
+}
+
+// EscapeJsString 转义后可以安全放进js的单引号、双引号或反引号字符串里(不含两边的引号)
+func EscapeJsString(s string) string {
+	b, _ := json.Marshal(s) // 已经处理了 \ " 控制字符和行分隔符
+	escaped := string(b[1 : len(b)-1])
+	return strings.NewReplacer("'", `\'`, "`", "\\`", "$", `\$`).Replace(escaped)
+}
+
+// JsStringLiteral 生成带双引号的js字符串字面量
+func JsStringLiteral(s string) string {
+	return `"` + EscapeJsString(s) + `"`
+}
+
+// PageInfoCode 获取当前页面基础信息的json字符串
+const PageInfoCode = "JSON.stringify({url:location.href,title:document.title,readyState:document.readyState," +
+	"userAgent:navigator.userAgent,screenWidth:screen.width,screenHeight:screen.height})"
+
+// RenderSnippet 把代码片段里的{{param}}替换成转义后的参数，其它占位符视为错误
+func RenderSnippet(tpl string, param string) (string, error) {
+	var sb strings.Builder
+	for {
+		start := strings.Index(tpl, "{{")
+		if start < 0 {
+			sb.WriteString(tpl)
+			return sb.String(), nil
+		}
+		end := strings.Index(tpl[start:], "}}")
+		if end < 0 {
+			return "", fmt.Errorf("代码片段的占位符没有闭合：%q", tpl[start:])
+		}
+		name := strings.TrimSpace(tpl[start+2 : start+end])
+		if name != "param" {
+			return "", fmt.Errorf("不支持的占位符：{{%s}}", name)
+		}
+		sb.WriteString(tpl[:start])
+		sb.WriteString(EscapeJsString(param))
+		tpl = tpl[start+end+2:]
+	}
 }
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// hostileInputs 能打断拼接出来的js的输入
+var hostileInputs = []string{
+	"",
+	`"`,
+	`'`,
+	"`${alert(1)}`",
+	`\`,
+	`\"`,
+	`";alert(1);//`,
+	`');alert(1);//`,
+	"a\nb\r\nc",
+	"\u2028\u2029",
+	"</script><script>alert(1)</script>",
+	"中文 #main > .item[data-x=\"1\"]",
+	"\x00\x1f",
+	"😀",
+	`{"ok":false,"error":"伪造"}`,
+}
+
+// varLiteral 取出With("s", ...)生成的字面量，确认它是一个完整的json字符串，后面紧跟着try
+func varLiteral(t *testing.T, code string) string {
+	t.Helper()
+	rest, ok := strings.CutPrefix(code, "(function(){var s=")
+	if !ok {
+		t.Fatalf("代码开头不对：%s", code)
+	}
+	dec := json.NewDecoder(strings.NewReader(rest))
+	var value string
+	if err := dec.Decode(&value); err != nil {
+		t.Fatalf("字面量不是json字符串：%v\n%s", err, code)
+	}
+	literal := rest[:dec.InputOffset()]
+	if !strings.HasPrefix(rest[len(literal):], ";try{var r=") {
+		t.Fatalf("字面量后面应该是try：%s", code)
+	}
+	return literal
+}
+
+func TestJsCodeWithHostileInput(t *testing.T) {
+	for _, input := range hostileInputs {
+		code := NewJsCode("s").With("s", input).String()
+		literal := varLiteral(t, code)
+		var got string
+		_ = json.Unmarshal([]byte(literal), &got)
+		if got != input {
+			t.Errorf("字面量 %s 解析后是%q，期望%q", literal, got, input)
+		}
+		if strings.ContainsAny(literal, "\n\r\u2028\u2029<>") {
+			t.Errorf("字面量里有没转义的字符：%s", literal)
+		}
+	}
+}
+
+func TestJsCodeShape(t *testing.T) {
+	code := NewJsCode("\n  document.title  \n").With("n", 1).With("o", map[string]int{"a": 1}).String()
+	want := `(function(){var n=1;var o={"a":1};try{var r=(function(){return document.title` + "\n" +
+		`})();return JSON.stringify({ok:true,data:r})}catch(e){return JSON.stringify({ok:false,error:String(e&&e.message||e)})}})()`
+	if code != want {
+		t.Fatalf("得到\n%s\n期望\n%s", code, want)
+	}
+	// 不能编码的值当作null
+	if code := NewJsCode("x").With("x", func() {}).String(); !strings.HasPrefix(code, "(function(){var x=null;") {
+		t.Fatalf("不能编码的值：%s", code)
+	}
+}
+
+// 有node时实际执行生成的代码，结果应该原样返回输入
+func TestJsCodeRunsInNode(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("没有node")
+	}
+	var sb strings.Builder
+	for _, input := range hostileInputs {
+		sb.WriteString("console.log(" + NewJsCode("s").With("s", input).String() + ");\n")
+	}
+	sb.WriteString("console.log(" + NewJsCode("missing.x").String() + ");\n")
+	out, err := exec.Command(node, "-e", sb.String()).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node执行失败：%v\n%s", err, out)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) != len(hostileInputs)+1 {
+		t.Fatalf("node输出了%d行：\n%s", len(lines), out)
+	}
+	for i, input := range hostileInputs {
+		res, ok := ParseJsResult(lines[i])
+		if !ok || !res.Ok || res.Text() != input {
+			t.Errorf("输入%q执行结果是 %s", input, lines[i])
+		}
+	}
+	if res, ok := ParseJsResult(lines[len(hostileInputs)]); !ok || res.Ok || !strings.Contains(res.Error, "missing") {
+		t.Errorf("抛出异常时应该返回ok为false：%s", lines[len(hostileInputs)])
+	}
+}
+
+func TestParseJsResult(t *testing.T) {
+	tests := []struct {
+		raw   string
+		ok    bool // 是JsCode的结果
+		jsOk  bool
+		text  string
+		error string
+	}{
+		{`{"ok":true,"data":"文本"}`, true, true, "文本", ""},
+		{`{"ok":true,"data":{"a":[1,2]}}`, true, true, `{"a":[1,2]}`, ""},
+		{`{"ok":true,"data":null}`, true, true, "", ""},
+		{`{"ok":true}`, true, true, "", ""},
+		{`{"ok":true,"data":12.5}`, true, true, "12.5", ""},
+		{`{"ok":false,"error":"x is not defined"}`, true, false, "", "x is not defined"},
+		{"黑脸怪：timeout", false, false, "", ""},
+		{`{"a":1}`, false, false, "", ""},
+		{`plain`, false, false, "", ""},
+		{``, false, false, "", ""},
+	}
+	for _, tt := range tests {
+		res, ok := ParseJsResult(tt.raw)
+		if ok != tt.ok || res.Ok != tt.jsOk || res.Text() != tt.text || res.Error != tt.error {
+			t.Errorf("ParseJsResult(%q) = %+v, %v", tt.raw, res, ok)
+		}
+	}
+}
+
+func TestSelectorAndStorageCode(t *testing.T) {
+	for _, input := range hostileInputs {
+		for _, code := range []string{SelectorCode(input, false), SelectorCode(input, true)} {
+			var got string
+			_ = json.Unmarshal([]byte(varLiteral(t, code)), &got)
+			if got != input {
+				t.Errorf("选择器%q变成了%q", input, got)
+			}
+		}
+		code := StorageCode("local", input)
+		if !strings.Contains(code, "var k="+string(mustJson(t, input))+";") {
+			t.Errorf("storage的key没有编码：%s", code)
+		}
+	}
+	if code := StorageCode("session", ""); !strings.Contains(code, `var t="sessionStorage";`) || !strings.Contains(code, "s.key(i)") {
+		t.Errorf("没有key时应该读取全部：%s", code)
+	}
+}
+
+func mustJson(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// unescapeJs 还原EscapeJsString额外加的 \' \` \$ 后按json解析
+func unescapeJs(t *testing.T, escaped string) string {
+	t.Helper()
+	var sb strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '\\' && i+1 < len(escaped) {
+			if next := escaped[i+1]; next == '\'' || next == '`' || next == '$' {
+				sb.WriteByte(next)
+			} else {
+				sb.WriteString(escaped[i : i+2])
+			}
+			i++
+			continue
+		}
+		sb.WriteByte(escaped[i])
+	}
+	var s string
+	if err := json.Unmarshal([]byte(`"`+sb.String()+`"`), &s); err != nil {
+		t.Fatalf("%s 无法还原：%v", escaped, err)
+	}
+	return s
+}
+
+func TestEscapeJsString(t *testing.T) {
+	for _, input := range hostileInputs {
+		escaped := EscapeJsString(input)
+		if got := unescapeJs(t, escaped); got != input {
+			t.Errorf("EscapeJsString(%q) = %s，还原后是%q", input, escaped, got)
+		}
+		// 引号前面都有奇数个反斜杠
+		for i := 0; i < len(escaped); i++ {
+			if c := escaped[i]; c == '"' || c == '\'' || c == '`' || c == '$' {
+				n := 0
+				for j := i - 1; j >= 0 && escaped[j] == '\\'; j-- {
+					n++
+				}
+				if n%2 == 0 {
+					t.Errorf("EscapeJsString(%q) = %s，第%d个字符没有转义", input, escaped, i)
+				}
+			}
+		}
+		if literal := JsStringLiteral(input); literal != `"`+escaped+`"` {
+			t.Errorf("JsStringLiteral(%q) = %s", input, literal)
+		}
+	}
+}
+
+func TestRenderSnippet(t *testing.T) {
+	code, err := RenderSnippet("document.querySelector('{{ param }}').innerText + `{{param}}`", "a'b`${c}")
+	if err != nil || code != "document.querySelector('a\\'b\\`\\${c}').innerText + `a\\'b\\`\\${c}`" {
+		t.Fatalf("RenderSnippet = %s, %v", code, err)
+	}
+	for _, tpl := range []string{"{{param", "{{other}}", "x{{ }}"} {
+		if _, err := RenderSnippet(tpl, "p"); err == nil {
+			t.Errorf("RenderSnippet(%q)应该失败", tpl)
+		}
+	}
+}
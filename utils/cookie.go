@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ParseCookie 把document.cookie拆成 name -> value，值里可以带'='，url编码的值会解码
+func ParseCookie(raw string) map[string]string {
+	cookies := make(map[string]string)
+	for _, part := range strings.Split(raw, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		if decoded, err := url.PathUnescape(value); err == nil {
+			value = decoded
+		}
+		cookies[strings.TrimSpace(name)] = value
+	}
+	return cookies
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExtractJson 按路径从json里取值，支持 a.b.0 这样的点路径和 $.a.b[0] 这样的JSONPath写法
+func ExtractJson(raw string, path string) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.UseNumber() // 大整数不要变成浮点
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("返回的内容不是json：%s", Preview(raw, 200))
+	}
+	return ExtractValue(value, path, raw)
+}
+
+// ExtractValue 从已经解析好的值里按路径取值，raw只用于报错时展示
+func ExtractValue(value interface{}, path string, raw string) (interface{}, error) {
+	for _, key := range splitPath(path) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("路径%q中的%q不存在，原始返回：%s", path, key, Preview(raw, 200))
+			}
+			value = next
+		case map[string]string:
+			next, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("路径%q中的%q不存在，原始返回：%s", path, key, Preview(raw, 200))
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("路径%q中的下标%q无效，原始返回：%s", path, key, Preview(raw, 200))
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("路径%q在%q处已经不是对象或数组，原始返回：%s", path, key, Preview(raw, 200))
+		}
+	}
+	return value, nil
+}
+
+// splitPath 把 $.a.b[0] / a.b.0 拆成 [a b 0]
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.NewReplacer("[", ".", "]", "", "'", "", `"`, "").Replace(path)
+	var keys []string
+	for _, key := range strings.Split(path, ".") {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"os"
+	"unicode/utf8"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -19,6 +22,22 @@ func InitLogger(closeLog bool) {
 	})
 }
 
+// LogToFile 日志追加写到文件，不再输出到终端，文件里不需要颜色
+func LogToFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(f)
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors:   true,
+		FullTimestamp:   true,
+		TimestampFormat: "2006-01-02 15:04:05",
+		DisableQuote:    true,
+	})
+	return nil
+}
+
 type LogWriter struct{}
 
 func (w LogWriter) Write(p []byte) (n int, err error) {
@@ -27,6 +46,22 @@ func (w LogWriter) Write(p []byte) (n int, err error) {
 
 func LogPrint(p ...interface{}) {
 	if isPrint {
-		log.Infoln(p)
+		log.Infoln(p...)
+	}
+}
+
+// Preview 日志和错误信息里展示的内容，超过n个字符时截断并加上...
+// 按字符而不是字节截断，不会把中文切成乱码；n<=0时不截断
+func Preview(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos] + "..."
+		}
+		i++
 	}
+	return s
 }
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPreview(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"短字符串不截断", "hello", 10, "hello"},
+		{"刚好n个字符", "hello", 5, "hello"},
+		{"ascii截断", "hello world", 5, "hello..."},
+		{"中文按字符截断", "你好世界，黑脸怪", 4, "你好世界..."},
+		{"中文刚好n个字符", "你好世界", 4, "你好世界"},
+		{"中英混合", "a你b好c", 3, "a你b..."},
+		{"emoji不会被切开", "😀😀😀", 2, "😀😀..."},
+		{"n为0不截断", "你好", 0, "你好"},
+		{"负数不截断", "你好", -1, "你好"},
+		{"空字符串", "", 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Preview(tt.s, tt.n)
+			if got != tt.want {
+				t.Fatalf("Preview(%q, %d) = %q，期望%q", tt.s, tt.n, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Fatalf("Preview(%q, %d)切开了多字节字符：%q", tt.s, tt.n, got)
+			}
+		})
+	}
+}
+
+func TestPreviewLongChinese(t *testing.T) {
+	s := strings.Repeat("中", 1000)
+	got := Preview(s, 100)
+	if utf8.RuneCountInString(strings.TrimSuffix(got, "...")) != 100 || !utf8.ValidString(got) {
+		t.Fatalf("长中文截断后%d个字符", utf8.RuneCountInString(got))
+	}
+}
+
+func TestPreviewInvalidUtf8(t *testing.T) {
+	// 客户端返回的可能不是合法的utf8，截断时不能panic
+	s := "ab\xff\xfecd"
+	if got := Preview(s, 3); got != "ab\xff..." {
+		t.Fatalf("Preview = %q", got)
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExpandPlaceholders 展开参数里的服务端占位符：{{timestamp_ms}}、{{uuid}}、{{date:2006-01-02}}、{{env:FOO}}
+// vars是额外的占位符(如pipeline的prev)，\{{ 表示字面的{{，env返回false表示该环境变量不允许读取，未知占位符返回错误
+func ExpandPlaceholders(s string, vars map[string]string, env func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	now := time.Now()
+	var sb strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+		if start > 0 && s[start-1] == '\\' {
+			sb.WriteString(s[:start-1])
+			sb.WriteString("{{")
+			s = s[start+2:]
+			continue
+		}
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			return "", fmt.Errorf("占位符没有闭合：%q", s[start:])
+		}
+		name := strings.TrimSpace(s[start+2 : start+end])
+		sb.WriteString(s[:start])
+		kind, arg, _ := strings.Cut(name, ":")
+		v, isVar := vars[name]
+		switch {
+		case isVar:
+			sb.WriteString(v)
+		case name == "timestamp_ms":
+			sb.WriteString(strconv.FormatInt(now.UnixMilli(), 10))
+		case name == "timestamp":
+			sb.WriteString(strconv.FormatInt(now.Unix(), 10))
+		case name == "uuid":
+			sb.WriteString(GetUUID())
+		case kind == "date" && arg != "":
+			sb.WriteString(now.Format(arg))
+		case kind == "env" && arg != "" && env != nil:
+			v, ok := env(arg)
+			if !ok {
+				return "", fmt.Errorf("不允许读取环境变量：%s", arg)
+			}
+			sb.WriteString(v)
+		default:
+			return "", fmt.Errorf("不支持的占位符：{{%s}}", name)
+		}
+		s = s[start+end+2:]
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	env := func(name string) (string, bool) {
+		if name == "ALLOWED" {
+			return "值", true
+		}
+		return "", false
+	}
+	vars := map[string]string{"prev": "上一步的结果"}
+	today := time.Now().Format("2006-01-02")
+	tests := []struct {
+		name    string
+		in      string
+		want    string // 为空时用pattern匹配
+		pattern string
+		wantErr bool
+	}{
+		{"没有占位符原样返回", `{"a":1}`, `{"a":1}`, "", false},
+		{"单个右括号", `a}}b`, `a}}b`, "", false},
+		{"毫秒时间戳", `t={{timestamp_ms}}`, "", `^t=\d{13}$`, false},
+		{"秒时间戳", `{{timestamp}}`, "", `^\d{10}$`, false},
+		{"uuid", `{{uuid}}`, "", `^[0-9a-f-]{32,36}$`, false},
+		{"日期格式", `{{date:2006-01-02}}`, today, "", false},
+		{"两边有空格", `{{ uuid }}`, "", `^[0-9a-f-]{32,36}$`, false},
+		{"允许的环境变量", `{{env:ALLOWED}}`, "值", "", false},
+		{"不允许的环境变量", `{{env:HOME}}`, "", "", true},
+		{"额外变量", `结果:{{prev}}`, "结果:上一步的结果", "", false},
+		{"转义的括号", `\{{uuid}}`, "{{uuid}}", "", false},
+		{"转义后继续展开", `\{{x {{date:2006}}`, "{{x " + today[:4], "", false},
+		{"中文和emoji", `你好😀{{date:2006}}世界`, "你好😀" + today[:4] + "世界", "", false},
+		{"占位符前是多字节字符", `中{{timestamp}}`, "", `^中\d{10}$`, false},
+		{"未知占位符", `{{nope}}`, "", "", true},
+		{"日期缺少格式", `{{date:}}`, "", "", true},
+		{"没有闭合", `{{uuid`, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandPlaceholders(tt.in, vars, env)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ExpandPlaceholders(%q)应该返回错误，得到%q", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandPlaceholders(%q)出错：%v", tt.in, err)
+			}
+			if tt.pattern != "" {
+				if !regexp.MustCompile(tt.pattern).MatchString(got) {
+					t.Fatalf("ExpandPlaceholders(%q) = %q，不匹配%s", tt.in, got, tt.pattern)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Fatalf("ExpandPlaceholders(%q) = %q，期望%q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPlaceholdersNilEnv(t *testing.T) {
+	if _, err := ExpandPlaceholders("{{env:ALLOWED}}", nil, nil); err == nil {
+		t.Fatal("没有提供env时env占位符应该返回错误")
+	}
+	got, err := ExpandPlaceholders("{{timestamp_ms}}", nil, nil)
+	if ms, _ := strconv.ParseInt(got, 10, 64); err != nil || time.Since(time.UnixMilli(ms)) > time.Minute {
+		t.Fatalf("时间戳不是当前时间：%q %v", got, err)
+	}
+}
@@ -9,13 +9,20 @@ import (
 	"time"
 )
 
-func CloseTerminal() {
-	// 等待中断信号以优雅地关闭服务器（设置 5 秒的超时时间）
-	quit := make(chan os.Signal)
+// ShutdownTimeout 退出时最多等待多久
+const ShutdownTimeout = 5 * time.Second
+
+// CloseTerminal 等待中断信号，然后在5秒内调用shutdown优雅地关闭服务；done关闭表示服务已经自己退出(如平滑重启)，直接返回
+func CloseTerminal(done <-chan struct{}, shutdown func(ctx context.Context)) {
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	select {
+	case <-quit:
+	case <-done:
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
 	defer cancel()
-	log.Println("- EXIT - [Ctrl+C] The project will automatically close after 3 seconds")
-	time.Sleep(time.Second * 3)
+	log.Println("- EXIT - [Ctrl+C] The project is shutting down")
+	shutdown(ctx)
 }